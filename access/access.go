@@ -2,6 +2,7 @@ package access
 
 import(
   "github.com/jinzhu/gorm"
+  "github.com/wscherfel/fitlogic-backend/common"
   "github.com/wscherfel/fitlogic-backend/models"
 )
 
@@ -19,45 +20,77 @@ func NewCounterMeasureDAO(db *gorm.DB) *CounterMeasureDAO {
 	}
 }
 
-// Create will create single models.CounterMeasure in database.
-func (dao *CounterMeasureDAO) Create(m *models.CounterMeasure) (error) {
-	if err := dao.db.Create(m).Error; err != nil {
-		return err
-	}
-	return nil
+// Create will create single models.CounterMeasure in database,
+// entry of audit log is written with it
+func (dao *CounterMeasureDAO) Create(m *models.CounterMeasure, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+		describe(entry, models.EntityCounterMeasure, m.ID, m.OrganizationID, common.Diff(nil, m))
+		return nil
+	})
 }
 
-// Update will update a record of models.CounterMeasure in DB
-func (dao *CounterMeasureDAO) Update(m *models.CounterMeasure, id uint) (*models.CounterMeasure, error) {
+// Update will update a record of models.CounterMeasure in DB,
+// entry of audit log is written with it
+func (dao *CounterMeasureDAO) Update(m *models.CounterMeasure, id uint, entry *models.AuditEntry) (*models.CounterMeasure, error) {
 	oldVal, err := dao.ReadByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := dao.db.Model(&oldVal).Updates(m).Error; err != nil {
+	before := *oldVal
+	err = audited(dao.db, entry, func(tx *gorm.DB) error {
+		if err := tx.Model(&oldVal).Updates(m).Error; err != nil {
+			return err
+		}
+		describe(entry, models.EntityCounterMeasure, id, before.OrganizationID, common.Diff(&before, oldVal))
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return oldVal, nil
 }
 
-// Delete will soft-delete a single models.CounterMeasure
-func (dao *CounterMeasureDAO) Delete(m *models.CounterMeasure) (error) {
-	if err := dao.db.Delete(m).Error; err != nil {
-		return err
-	}
-	return nil
+// Delete will soft-delete a single models.CounterMeasure,
+// entry of audit log is written with it
+func (dao *CounterMeasureDAO) Delete(m *models.CounterMeasure, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		if err := tx.Delete(m).Error; err != nil {
+			return err
+		}
+		describe(entry, models.EntityCounterMeasure, m.ID, m.OrganizationID, common.Diff(m, nil))
+		return nil
+	})
 }
 
 // GetAll will return all records of models.CounterMeasure in database
-func (dao *CounterMeasureDAO) GetAll() ([]models.CounterMeasure, error) {
+// that are visible to viewer
+func (dao *CounterMeasureDAO) GetAll(viewer *models.User) ([]models.CounterMeasure, error) {
 	m := []models.CounterMeasure{}
-	if err := dao.db.Find(&m).Error; err != nil {
+	cond, args := visibleCounterMeasuresCondition(viewer)
+	if err := scoped(dao.db, cond, args).Find(&m).Error; err != nil {
 		return nil, err
 	}
 
 	return m, nil
 }
 
+// List will return one page of records of models.CounterMeasure visible
+// to viewer that match list query and number of all matching records
+func (dao *CounterMeasureDAO) List(viewer *models.User, q common.ListQuery) ([]models.CounterMeasure, int, error) {
+	m := []models.CounterMeasure{}
+	cond, args := visibleCounterMeasuresCondition(viewer)
+	total, err := listQuery(scoped(dao.db, cond, args), &models.CounterMeasure{}, &m, q, counterMeasureListFields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return m, total, nil
+}
+
 // AddRisksAssociation will add
 // an association to model given by parameter
 func (dao *CounterMeasureDAO) AddRisksAssociation(m *models.CounterMeasure, asocVal *models.Risk) (*models.CounterMeasure, error) {
@@ -83,7 +116,22 @@ func (dao *CounterMeasureDAO) RemoveRisksAssociation(m *models.CounterMeasure, a
 func (dao *CounterMeasureDAO) GetAllAssociatedRisks(m *models.CounterMeasure) ([]models.Risk, error) {
 	retVal := []models.Risk{}
 
-	dao.db.Model(&m).Association("Risks").Find(&retVal)
+	if err := dao.db.Model(&m).Association("Risks").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
+	return retVal, nil
+}
+
+// GetAllAssociatedVisibleRisks will get all
+// an association from model given by parameter that is visible to viewer
+func (dao *CounterMeasureDAO) GetAllAssociatedVisibleRisks(viewer *models.User, m *models.CounterMeasure) ([]models.Risk, error) {
+	retVal := []models.Risk{}
+
+	cond, args := visibleRisksCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.Model(&m).Association("Risks").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
 	return retVal, nil
 }
 
@@ -91,7 +139,19 @@ func (dao *CounterMeasureDAO) GetAllAssociatedRisks(m *models.CounterMeasure) ([
 func (dao *CounterMeasureDAO) ReadByID(id uint) (*models.CounterMeasure, error) {
 	m := &models.CounterMeasure{}
 	if err := dao.db.First(&m, id).Error; err != nil {
-		return nil, err
+		return nil, notFound(err, ResourceCounterMeasure)
+	}
+
+	return m, nil
+}
+
+// ReadVisibleByID will find models.CounterMeasure by ID given by parameter
+// if it is visible to viewer
+func (dao *CounterMeasureDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.CounterMeasure, error) {
+	m := &models.CounterMeasure{}
+	cond, args := visibleCounterMeasuresCondition(viewer)
+	if err := scoped(dao.db, cond, args).First(&m, id).Error; err != nil {
+		return nil, notFound(err, ResourceCounterMeasure)
 	}
 
 	return m, nil
@@ -110,12 +170,16 @@ func NewUserDAO(db *gorm.DB) *UserDAO {
 	}
 }
 
-// Create will create single models.User in database.
-func (dao *UserDAO) Create(m *models.User) (error) {
-	if err := dao.db.Create(m).Error; err != nil {
-		return err
-	}
-	return nil
+// Create will create single models.User in database,
+// entry of audit log is written with it
+func (dao *UserDAO) Create(m *models.User, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+		describe(entry, models.EntityUser, m.ID, m.OrganizationID, common.Diff(nil, m))
+		return nil
+	})
 }
 
 // Read will find all DB records matching
@@ -128,37 +192,119 @@ func (dao *UserDAO) Read(m *models.User) ([]models.User, error) {
 	return retVal, nil
 }
 
-// Update will update a record of models.User in DB
-func (dao *UserDAO) Update(m *models.User, id uint) (*models.User, error) {
+// Update will update a record of models.User in DB,
+// entry of audit log is written with it
+func (dao *UserDAO) Update(m *models.User, id uint, entry *models.AuditEntry) (*models.User, error) {
 	oldVal, err := dao.ReadByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := dao.db.Model(&oldVal).Updates(m).Error; err != nil {
+	before := *oldVal
+	version := baseVersion(m.Version, oldVal.Version)
+	m.Version = version + 1
+	err = audited(dao.db, entry, func(tx *gorm.DB) error {
+		query := tx.Model(&oldVal).Where("version = ?", version).Updates(m)
+		if err := versionResult(query); err != nil {
+			return err
+		}
+		describe(entry, models.EntityUser, id, before.OrganizationID, common.Diff(&before, oldVal))
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return oldVal, nil
 }
 
-// Delete will soft-delete a single models.User
-func (dao *UserDAO) Delete(m *models.User) (error) {
-	if err := dao.db.Delete(m).Error; err != nil {
-		return err
+// UpdatePassword will replace stored password of models.User with hash given
+// by parameter, it is not a change of the user, so neither version of
+// the record nor audit log is written
+func (dao *UserDAO) UpdatePassword(id uint, hash string) (error) {
+	query := dao.db.Model(&models.User{}).Where("id = ?", id).UpdateColumn("password", hash)
+	if query.Error != nil {
+		return query.Error
+	}
+	if query.RowsAffected == 0 {
+		return &ErrNotFound{Resource: ResourceUser}
 	}
 	return nil
 }
 
+// UpdateAll will update all fields of a record of models.User in DB,
+// zero values included, entry of audit log is written with it
+func (dao *UserDAO) UpdateAll(m *models.User, id uint, entry *models.AuditEntry) (*models.User, error) {
+	oldVal, err := dao.ReadByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	before := *oldVal
+	version := baseVersion(m.Version, oldVal.Version)
+	err = audited(dao.db, entry, func(tx *gorm.DB) error {
+		query := tx.Model(&oldVal).Where("version = ?", version).Updates(map[string]interface{}{
+			"organization_id": m.OrganizationID,
+			"name": m.Name,
+			"email": m.Email,
+			"password": m.Password,
+			"role": m.Role,
+			"skills": m.Skills,
+			"status": m.Status,
+			"version": version + 1,
+		})
+		if err := versionResult(query); err != nil {
+			return err
+		}
+		describe(entry, models.EntityUser, id, before.OrganizationID, common.Diff(&before, oldVal))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return oldVal, nil
+}
+
+// Delete will soft-delete a single models.User,
+// entry of audit log is written with it
+func (dao *UserDAO) Delete(m *models.User, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		if m.Version != 0 {
+			if err := versionResult(tx.Where("version = ?", m.Version).Delete(m)); err != nil {
+				return err
+			}
+		} else if err := tx.Delete(m).Error; err != nil {
+			return err
+		}
+		describe(entry, models.EntityUser, m.ID, m.OrganizationID, common.Diff(m, nil))
+		return nil
+	})
+}
+
 // GetAll will return all records of models.User in database
-func (dao *UserDAO) GetAll() ([]models.User, error) {
+// that are visible to viewer
+func (dao *UserDAO) GetAll(viewer *models.User) ([]models.User, error) {
 	m := []models.User{}
-	if err := dao.db.Find(&m).Error; err != nil {
+	cond, args := visibleUsersCondition(viewer)
+	if err := scoped(dao.db, cond, args).Find(&m).Error; err != nil {
 		return nil, err
 	}
 
 	return m, nil
 }
 
+// List will return one page of records of models.User visible
+// to viewer that match list query and number of all matching records
+func (dao *UserDAO) List(viewer *models.User, q common.ListQuery) ([]models.User, int, error) {
+	m := []models.User{}
+	cond, args := visibleUsersCondition(viewer)
+	total, err := listQuery(scoped(dao.db, cond, args), &models.User{}, &m, q, userListFields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return m, total, nil
+}
+
 // AddProjectsAssociation will add
 // an association to model given by parameter
 func (dao *UserDAO) AddProjectsAssociation(m *models.User, asocVal *models.Project) (*models.User, error) {
@@ -184,7 +330,22 @@ func (dao *UserDAO) RemoveProjectsAssociation(m *models.User, asocVal *models.Pr
 func (dao *UserDAO) GetAllAssociatedProjects(m *models.User) ([]models.Project, error) {
 	retVal := []models.Project{}
 
-	dao.db.Model(&m).Association("Projects").Find(&retVal)
+	if err := dao.db.Model(&m).Association("Projects").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
+	return retVal, nil
+}
+
+// GetAllAssociatedVisibleProjects will get all
+// an association from model given by parameter that is visible to viewer
+func (dao *UserDAO) GetAllAssociatedVisibleProjects(viewer *models.User, m *models.User) ([]models.Project, error) {
+	retVal := []models.Project{}
+
+	cond, args := visibleProjectsCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.Model(&m).Association("Projects").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
 	return retVal, nil
 }
 
@@ -213,7 +374,22 @@ func (dao *UserDAO) RemoveRisksAssociation(m *models.User, asocVal *models.Risk)
 func (dao *UserDAO) GetAllAssociatedRisks(m *models.User) ([]models.Risk, error) {
 	retVal := []models.Risk{}
 
-	dao.db.Model(&m).Association("Risks").Find(&retVal)
+	if err := dao.db.Model(&m).Association("Risks").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
+	return retVal, nil
+}
+
+// GetAllAssociatedVisibleRisks will get all
+// an association from model given by parameter that is visible to viewer
+func (dao *UserDAO) GetAllAssociatedVisibleRisks(viewer *models.User, m *models.User) ([]models.Risk, error) {
+	retVal := []models.Risk{}
+
+	cond, args := visibleRisksCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.Model(&m).Association("Risks").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
 	return retVal, nil
 }
 
@@ -232,7 +408,19 @@ func (dao *UserDAO) ReadByEmail(m string) ([]models.User, error) {
 func (dao *UserDAO) ReadByID(id uint) (*models.User, error) {
 	m := &models.User{}
 	if err := dao.db.First(&m, id).Error; err != nil {
-		return nil, err
+		return nil, notFound(err, ResourceUser)
+	}
+
+	return m, nil
+}
+
+// ReadVisibleByID will find models.User by ID given by parameter
+// if it is visible to viewer
+func (dao *UserDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.User, error) {
+	m := &models.User{}
+	cond, args := visibleUsersCondition(viewer)
+	if err := scoped(dao.db, cond, args).First(&m, id).Error; err != nil {
+		return nil, notFound(err, ResourceUser)
 	}
 
 	return m, nil
@@ -251,45 +439,179 @@ func NewProjectDAO(db *gorm.DB) *ProjectDAO {
 	}
 }
 
-// Create will create single models.Project in database.
-func (dao *ProjectDAO) Create(m *models.Project) (error) {
-	if err := dao.db.Create(m).Error; err != nil {
-		return err
+// Create will create single models.Project in database with membership
+// of its manager, who owns it, entry of audit log is written with it
+func (dao *ProjectDAO) Create(m *models.Project, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+		changes := common.Diff(nil, m)
+		if m.ManagerID != 0 {
+			roles, err := saveRoles(tx, m.ID, managerMemberships(m, 0))
+			if err != nil {
+				return err
+			}
+			changes["Roles"] = roles
+		}
+		describe(entry, models.EntityProject, m.ID, m.OrganizationID, changes)
+		return nil
+	})
+}
+
+// managerMemberships will return membership of manager of project, who
+// owns it, and membership of previous manager, who stays in it as editor
+func managerMemberships(m *models.Project, previousManagerID uint) []models.Membership {
+	memberships := []models.Membership{{UserID: m.ManagerID, ProjectID: m.ID, Role: models.ProjectRoleOwner}}
+	if previousManagerID != 0 && previousManagerID != m.ManagerID {
+		memberships = append(memberships, models.Membership{
+			UserID: previousManagerID,
+			ProjectID: m.ID,
+			Role: models.ProjectRoleEditor,
+		})
 	}
-	return nil
+	return memberships
 }
 
-// Update will update a record of models.Project in DB
-func (dao *ProjectDAO) Update(m *models.Project, id uint) (*models.Project, error) {
+// saveRoles will save memberships in project with projectID in transaction
+// tx and return change of roles of their users by IDs of users, users who
+// were not members have no old role
+func saveRoles(tx *gorm.DB, projectID uint, memberships []models.Membership) (models.Change, error) {
+	old, new := map[uint]string{}, map[uint]string{}
+	for i := range memberships {
+		memberships[i].ProjectID = projectID
+		previous := models.Membership{}
+		err := tx.Where("user_id = ? AND project_id = ?", memberships[i].UserID, projectID).First(&previous).Error
+		if err == nil {
+			old[memberships[i].UserID] = previous.Role
+		} else if !gorm.IsRecordNotFoundError(err) {
+			return models.Change{}, err
+		}
+		if err := tx.Save(&memberships[i]).Error; err != nil {
+			return models.Change{}, err
+		}
+		new[memberships[i].UserID] = memberships[i].Role
+	}
+	return roleChange(old, new), nil
+}
+
+// Update will update a record of models.Project in DB, new manager becomes
+// owner of project, entry of audit log is written with it
+func (dao *ProjectDAO) Update(m *models.Project, id uint, entry *models.AuditEntry) (*models.Project, error) {
 	oldVal, err := dao.ReadByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := dao.db.Model(&oldVal).Updates(m).Error; err != nil {
+	before := *oldVal
+	version := baseVersion(m.Version, oldVal.Version)
+	m.Version = version + 1
+	err = audited(dao.db, entry, func(tx *gorm.DB) error {
+		query := tx.Model(&oldVal).Where("version = ?", version).Updates(m)
+		if err := versionResult(query); err != nil {
+			return err
+		}
+		changes := common.Diff(&before, oldVal)
+		if oldVal.ManagerID != 0 && oldVal.ManagerID != before.ManagerID {
+			roles, err := saveRoles(tx, id, managerMemberships(oldVal, before.ManagerID))
+			if err != nil {
+				return err
+			}
+			changes["Roles"] = roles
+		}
+		describe(entry, models.EntityProject, id, before.OrganizationID, changes)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return oldVal, nil
 }
 
-// Delete will soft-delete a single models.Project
-func (dao *ProjectDAO) Delete(m *models.Project) (error) {
-	if err := dao.db.Delete(m).Error; err != nil {
-		return err
+// UpdateAll will update all fields of a record of models.Project in DB,
+// zero values included, new manager becomes owner of project,
+// entry of audit log is written with it
+func (dao *ProjectDAO) UpdateAll(m *models.Project, id uint, entry *models.AuditEntry) (*models.Project, error) {
+	oldVal, err := dao.ReadByID(id)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	before := *oldVal
+	version := baseVersion(m.Version, oldVal.Version)
+	err = audited(dao.db, entry, func(tx *gorm.DB) error {
+		query := tx.Model(&oldVal).Where("version = ?", version).Updates(map[string]interface{}{
+			"start": m.Start,
+			"end": m.End,
+			"is_finished": m.IsFinished,
+			"manager_id": m.ManagerID,
+			"organization_id": m.OrganizationID,
+			"name": m.Name,
+			"description": m.Description,
+			"version": version + 1,
+		})
+		if err := versionResult(query); err != nil {
+			return err
+		}
+		changes := common.Diff(&before, oldVal)
+		if oldVal.ManagerID != 0 && oldVal.ManagerID != before.ManagerID {
+			roles, err := saveRoles(tx, id, managerMemberships(oldVal, before.ManagerID))
+			if err != nil {
+				return err
+			}
+			changes["Roles"] = roles
+		}
+		describe(entry, models.EntityProject, id, before.OrganizationID, changes)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return oldVal, nil
+}
+
+// Delete will soft-delete a single models.Project,
+// entry of audit log is written with it
+func (dao *ProjectDAO) Delete(m *models.Project, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		if m.Version != 0 {
+			if err := versionResult(tx.Where("version = ?", m.Version).Delete(m)); err != nil {
+				return err
+			}
+		} else if err := tx.Delete(m).Error; err != nil {
+			return err
+		}
+		describe(entry, models.EntityProject, m.ID, m.OrganizationID, common.Diff(m, nil))
+		return nil
+	})
 }
 
 // GetAll will return all records of models.Project in database
-func (dao *ProjectDAO) GetAll() ([]models.Project, error) {
+// that are visible to viewer
+func (dao *ProjectDAO) GetAll(viewer *models.User) ([]models.Project, error) {
 	m := []models.Project{}
-	if err := dao.db.Find(&m).Error; err != nil {
+	cond, args := visibleProjectsCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.Find(&m).Error; err != nil {
 		return nil, err
 	}
 
 	return m, nil
 }
 
+// List will return one page of records of models.Project visible
+// to viewer that match list query and number of all matching records
+func (dao *ProjectDAO) List(viewer *models.User, q common.ListQuery) ([]models.Project, int, error) {
+	m := []models.Project{}
+	cond, args := visibleProjectsCondition(viewer)
+	total, err := listQuery(scoped(dao.db, cond, args), &models.Project{}, &m, q, projectListFields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return m, total, nil
+}
+
 // AddUsersAssociation will add
 // an association to model given by parameter
 func (dao *ProjectDAO) AddUsersAssociation(m *models.Project, asocVal *models.User) (*models.Project, error) {
@@ -315,7 +637,9 @@ func (dao *ProjectDAO) RemoveUsersAssociation(m *models.Project, asocVal *models
 func (dao *ProjectDAO) GetAllAssociatedUsers(m *models.Project) ([]models.User, error) {
 	retVal := []models.User{}
 
-	dao.db.Model(&m).Association("Users").Find(&retVal)
+	if err := dao.db.Model(&m).Association("Users").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
 	return retVal, nil
 }
 
@@ -339,12 +663,98 @@ func (dao *ProjectDAO) RemoveRisksAssociation(m *models.Project, asocVal *models
 	return m, nil
 }
 
+// AddRisksAssociations will add associations to all risks given by parameter
+// in one transaction, none of them is added if any fails,
+// entry of audit log is written with it
+func (dao *ProjectDAO) AddRisksAssociations(m *models.Project, risks []models.Risk, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		ids := []uint{}
+		for i := range risks {
+			if err := tx.Model(m).Association("Risks").Append(&risks[i]).Error; err != nil {
+				return err
+			}
+			ids = append(ids, risks[i].ID)
+		}
+		describe(entry, models.EntityProject, m.ID, m.OrganizationID, models.Changes{"Risks": {New: ids}})
+		return nil
+	})
+}
+
+// RemoveRisksAssociations will remove associations to all risks given by
+// parameter in one transaction, none of them is removed if any fails,
+// entry of audit log is written with it
+func (dao *ProjectDAO) RemoveRisksAssociations(m *models.Project, risks []models.Risk, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		ids := []uint{}
+		for i := range risks {
+			if err := tx.Model(m).Association("Risks").Delete(&risks[i]).Error; err != nil {
+				return err
+			}
+			ids = append(ids, risks[i].ID)
+		}
+		describe(entry, models.EntityProject, m.ID, m.OrganizationID, models.Changes{"Risks": {Old: ids}})
+		return nil
+	})
+}
+
+// AddMemberships will save all memberships in project given by parameter
+// in one transaction, none of them is saved if any fails,
+// entry of audit log is written with it
+func (dao *ProjectDAO) AddMemberships(m *models.Project, memberships []models.Membership, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		roles, err := saveRoles(tx, m.ID, memberships)
+		if err != nil {
+			return err
+		}
+		ids := []uint{}
+		for _, membership := range memberships {
+			ids = append(ids, membership.UserID)
+		}
+		describe(entry, models.EntityProject, m.ID, m.OrganizationID,
+			models.Changes{"Users": {New: ids}, "Roles": roles})
+		return nil
+	})
+}
+
+// RemoveMemberships will delete all memberships in project given by parameter
+// in one transaction, none of them is deleted if any fails,
+// entry of audit log is written with it
+func (dao *ProjectDAO) RemoveMemberships(m *models.Project, memberships []models.Membership, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		ids := []uint{}
+		for i := range memberships {
+			memberships[i].ProjectID = m.ID
+			if err := tx.Delete(&memberships[i]).Error; err != nil {
+				return err
+			}
+			ids = append(ids, memberships[i].UserID)
+		}
+		describe(entry, models.EntityProject, m.ID, m.OrganizationID, models.Changes{"Users": {Old: ids}})
+		return nil
+	})
+}
+
 // GetAllAssociatedRisks will get all
 // an association from model given by parameter
 func (dao *ProjectDAO) GetAllAssociatedRisks(m *models.Project) ([]models.Risk, error) {
 	retVal := []models.Risk{}
 
-	dao.db.Model(&m).Association("Risks").Find(&retVal)
+	if err := dao.db.Model(&m).Association("Risks").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
+	return retVal, nil
+}
+
+// GetAllAssociatedVisibleRisks will get all
+// an association from model given by parameter that is visible to viewer
+func (dao *ProjectDAO) GetAllAssociatedVisibleRisks(viewer *models.User, m *models.Project) ([]models.Risk, error) {
+	retVal := []models.Risk{}
+
+	cond, args := visibleRisksCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.Model(&m).Association("Risks").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
 	return retVal, nil
 }
 
@@ -352,7 +762,20 @@ func (dao *ProjectDAO) GetAllAssociatedRisks(m *models.Project) ([]models.Risk,
 func (dao *ProjectDAO) ReadByID(id uint) (*models.Project, error) {
 	m := &models.Project{}
 	if err := dao.db.First(&m, id).Error; err != nil {
-		return nil, err
+		return nil, notFound(err, ResourceProject)
+	}
+
+	return m, nil
+}
+
+// ReadVisibleByID will find models.Project by ID given by parameter
+// if it is visible to viewer
+func (dao *ProjectDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.Project, error) {
+	m := &models.Project{}
+	cond, args := visibleProjectsCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.First(&m, id).Error; err != nil {
+		return nil, notFound(err, ResourceProject)
 	}
 
 	return m, nil
@@ -371,45 +794,214 @@ func NewRiskDAO(db *gorm.DB) *RiskDAO {
 	}
 }
 
-// Create will create single models.Risk in database.
-func (dao *RiskDAO) Create(m *models.Risk) (error) {
-	if err := dao.db.Create(m).Error; err != nil {
-		return err
-	}
-	return nil
+// Create will create single models.Risk in database, entry of audit log
+// and the first version of risk are written with it
+func (dao *RiskDAO) Create(m *models.Risk, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+		if err := createRiskVersion(tx, m, entry); err != nil {
+			return err
+		}
+		describe(entry, models.EntityRisk, m.ID, m.OrganizationID, common.Diff(nil, m))
+		return nil
+	})
 }
 
-// Update will update a record of models.Risk in DB
-func (dao *RiskDAO) Update(m *models.Risk, id uint) (*models.Risk, error) {
+// Update will update a record of models.Risk in DB, scores are computed
+// from the updated record, entry of audit log and next version of risk
+// are written with it
+func (dao *RiskDAO) Update(m *models.Risk, id uint, entry *models.AuditEntry) (*models.Risk, error) {
 	oldVal, err := dao.ReadByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := dao.db.Model(&oldVal).Updates(m).Error; err != nil {
+	before := *oldVal
+	version := baseVersion(m.Version, oldVal.Version)
+	m.Version = version + 1
+	err = audited(dao.db, entry, func(tx *gorm.DB) error {
+		query := tx.Model(&oldVal).Where("version = ?", version).Updates(m)
+		if err := versionResult(query); err != nil {
+			return err
+		}
+		// zero values of m are skipped, so scores of m need not match
+		// the stored fields they are computed from
+		oldVal.ComputeScores()
+		err := tx.Model(&oldVal).Updates(map[string]interface{}{
+			"risk": oldVal.Risk,
+			"exposure": oldVal.Exposure,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := createRiskVersion(tx, oldVal, entry); err != nil {
+			return err
+		}
+		describe(entry, models.EntityRisk, id, before.OrganizationID, common.Diff(&before, oldVal))
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return oldVal, nil
 }
 
-// Delete will soft-delete a single models.Risk
-func (dao *RiskDAO) Delete(m *models.Risk) (error) {
-	if err := dao.db.Delete(m).Error; err != nil {
-		return err
+// UpdateStatus will set status of models.Risk with reason of the change,
+// empty reason is stored as well, entry of audit log and next version
+// of risk are written with it
+func (dao *RiskDAO) UpdateStatus(m *models.Risk, status string, reason string, entry *models.AuditEntry) (*models.Risk, error) {
+	before := *m
+	err := audited(dao.db, entry, func(tx *gorm.DB) error {
+		query := tx.Model(&m).Where("version = ?", m.Version).Updates(map[string]interface{}{
+			"status": status,
+			"status_reason": reason,
+			"version": m.Version + 1,
+		})
+		if err := versionResult(query); err != nil {
+			return err
+		}
+		if err := createRiskVersion(tx, m, entry); err != nil {
+			return err
+		}
+		describe(entry, models.EntityRisk, m.ID, m.OrganizationID, common.Diff(&before, m))
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	return m, nil
+}
+
+// Revert will set values of models.Risk to values of its older state
+// given by parameter, status, owner and organization of risk are kept,
+// entry of audit log and next version of risk are written with it
+func (dao *RiskDAO) Revert(m *models.Risk, old *models.Risk, entry *models.AuditEntry) (*models.Risk, error) {
+	before := *m
+	err := audited(dao.db, entry, func(tx *gorm.DB) error {
+		query := tx.Model(&m).Where("version = ?", m.Version).Updates(map[string]interface{}{
+			"value": old.Value,
+			"cost": old.Cost,
+			"probability": old.Probability,
+			"risk": old.Risk,
+			"exposure": old.Exposure,
+			"name": old.Name,
+			"description": old.Description,
+			"category": old.Category,
+			"threat": old.Threat,
+			"trigger": old.Trigger,
+			"impact": old.Impact,
+			"start": old.Start,
+			"end": old.End,
+			"version": m.Version + 1,
+		})
+		if err := versionResult(query); err != nil {
+			return err
+		}
+		if err := createRiskVersion(tx, m, entry); err != nil {
+			return err
+		}
+		describe(entry, models.EntityRisk, m.ID, m.OrganizationID, common.Diff(&before, m))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// UpdateAll will update all fields of a record of models.Risk in DB,
+// zero values included, entry of audit log and next version of risk
+// are written with it
+func (dao *RiskDAO) UpdateAll(m *models.Risk, id uint, entry *models.AuditEntry) (*models.Risk, error) {
+	oldVal, err := dao.ReadByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	before := *oldVal
+	version := baseVersion(m.Version, oldVal.Version)
+	err = audited(dao.db, entry, func(tx *gorm.DB) error {
+		query := tx.Model(&oldVal).Where("version = ?", version).Updates(map[string]interface{}{
+			"value": m.Value,
+			"cost": m.Cost,
+			"probability": m.Probability,
+			"risk": m.Risk,
+			"exposure": m.Exposure,
+			"organization_id": m.OrganizationID,
+			"name": m.Name,
+			"description": m.Description,
+			"category": m.Category,
+			"threat": m.Threat,
+			"status": m.Status,
+			"status_reason": m.StatusReason,
+			"trigger": m.Trigger,
+			"impact": m.Impact,
+			"start": m.Start,
+			"end": m.End,
+			"user_id": m.UserID,
+			"version": version + 1,
+		})
+		if err := versionResult(query); err != nil {
+			return err
+		}
+		if err := createRiskVersion(tx, oldVal, entry); err != nil {
+			return err
+		}
+		describe(entry, models.EntityRisk, id, before.OrganizationID, common.Diff(&before, oldVal))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return oldVal, nil
+}
+
+// Delete will soft-delete a single models.Risk,
+// entry of audit log is written with it
+func (dao *RiskDAO) Delete(m *models.Risk, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		if m.Version != 0 {
+			if err := versionResult(tx.Where("version = ?", m.Version).Delete(m)); err != nil {
+				return err
+			}
+		} else if err := tx.Delete(m).Error; err != nil {
+			return err
+		}
+		describe(entry, models.EntityRisk, m.ID, m.OrganizationID, common.Diff(m, nil))
+		return nil
+	})
 }
 
 // GetAll will return all records of models.Risk in database
-func (dao *RiskDAO) GetAll() ([]models.Risk, error) {
+// that are visible to viewer
+func (dao *RiskDAO) GetAll(viewer *models.User) ([]models.Risk, error) {
 	m := []models.Risk{}
-	if err := dao.db.Find(&m).Error; err != nil {
+	cond, args := visibleRisksCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.Find(&m).Error; err != nil {
 		return nil, err
 	}
 
 	return m, nil
 }
 
+// List will return one page of records of models.Risk visible
+// to viewer that match list query and number of all matching records
+func (dao *RiskDAO) List(viewer *models.User, q common.ListQuery) ([]models.Risk, int, error) {
+	m := []models.Risk{}
+	cond, args := visibleRisksCondition(viewer)
+	total, err := listQuery(scoped(dao.db, cond, args), &models.Risk{}, &m, q, riskListFields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return m, total, nil
+}
+
 // AddProjectsAssociation will add
 // an association to model given by parameter
 func (dao *RiskDAO) AddProjectsAssociation(m *models.Risk, asocVal *models.Project) (*models.Risk, error) {
@@ -435,7 +1027,87 @@ func (dao *RiskDAO) RemoveProjectsAssociation(m *models.Risk, asocVal *models.Pr
 func (dao *RiskDAO) GetAllAssociatedProjects(m *models.Risk) ([]models.Project, error) {
 	retVal := []models.Project{}
 
-	dao.db.Model(&m).Association("Projects").Find(&retVal)
+	if err := dao.db.Model(&m).Association("Projects").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
+	return retVal, nil
+}
+
+// GetAllAssociatedVisibleProjects will get all
+// an association from model given by parameter that is visible to viewer
+func (dao *RiskDAO) GetAllAssociatedVisibleProjects(viewer *models.User, m *models.Risk) ([]models.Project, error) {
+	retVal := []models.Project{}
+
+	cond, args := visibleProjectsCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.Model(&m).Association("Projects").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
+	return retVal, nil
+}
+
+// AddCounterMeasuresAssociation will add
+// an association to model given by parameter
+func (dao *RiskDAO) AddCounterMeasuresAssociation(m *models.Risk, asocVal *models.CounterMeasure) (*models.Risk, error) {
+	if err := dao.db.Model(&m).Association("CounterMeasures").Append(asocVal).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// RemoveCounterMeasuresAssociation will remove
+// an association from model given by parameter
+func (dao *RiskDAO) RemoveCounterMeasuresAssociation(m *models.Risk, asocVal *models.CounterMeasure) (*models.Risk, error) {
+	if err := dao.db.Model(&m).Association("CounterMeasures").Delete(asocVal).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// AddCounterMeasuresAssociations will add associations to all countermeasures
+// given by parameter in one transaction, none of them is added if any fails,
+// entry of audit log is written with it
+func (dao *RiskDAO) AddCounterMeasuresAssociations(m *models.Risk, cms []models.CounterMeasure, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		ids := []uint{}
+		for i := range cms {
+			if err := tx.Model(m).Association("CounterMeasures").Append(&cms[i]).Error; err != nil {
+				return err
+			}
+			ids = append(ids, cms[i].ID)
+		}
+		describe(entry, models.EntityRisk, m.ID, m.OrganizationID, models.Changes{"CounterMeasures": {New: ids}})
+		return nil
+	})
+}
+
+// RemoveCounterMeasuresAssociations will remove associations to all
+// countermeasures given by parameter in one transaction, none of them
+// is removed if any fails, entry of audit log is written with it
+func (dao *RiskDAO) RemoveCounterMeasuresAssociations(m *models.Risk, cms []models.CounterMeasure, entry *models.AuditEntry) (error) {
+	return audited(dao.db, entry, func(tx *gorm.DB) error {
+		ids := []uint{}
+		for i := range cms {
+			if err := tx.Model(m).Association("CounterMeasures").Delete(&cms[i]).Error; err != nil {
+				return err
+			}
+			ids = append(ids, cms[i].ID)
+		}
+		describe(entry, models.EntityRisk, m.ID, m.OrganizationID, models.Changes{"CounterMeasures": {Old: ids}})
+		return nil
+	})
+}
+
+// GetAllAssociatedCounterMeasures will get all
+// an association from model given by parameter
+func (dao *RiskDAO) GetAllAssociatedCounterMeasures(m *models.Risk) ([]models.CounterMeasure, error) {
+	retVal := []models.CounterMeasure{}
+
+	if err := dao.db.Model(&m).Association("CounterMeasures").Find(&retVal).Error; err != nil {
+		return nil, err
+	}
 	return retVal, nil
 }
 
@@ -443,7 +1115,20 @@ func (dao *RiskDAO) GetAllAssociatedProjects(m *models.Risk) ([]models.Project,
 func (dao *RiskDAO) ReadByID(id uint) (*models.Risk, error) {
 	m := &models.Risk{}
 	if err := dao.db.First(&m, id).Error; err != nil {
-		return nil, err
+		return nil, notFound(err, ResourceRisk)
+	}
+
+	return m, nil
+}
+
+// ReadVisibleByID will find models.Risk by ID given by parameter
+// if it is visible to viewer
+func (dao *RiskDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.Risk, error) {
+	m := &models.Risk{}
+	cond, args := visibleRisksCondition(viewer)
+	query := scoped(dao.db, cond, args)
+	if err := query.First(&m, id).Error; err != nil {
+		return nil, notFound(err, ResourceRisk)
 	}
 
 	return m, nil
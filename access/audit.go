@@ -0,0 +1,91 @@
+package access
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// Changes of users, projects, risks and countermeasures are written to audit
+// log by their DAOs in the transaction of the change, so no change is made
+// without its entry. Callers give DAOs an entry with actor and action, DAOs
+// fill in the changed entity, its organization and changes of its fields.
+// Nil entry means the change is not audited, e.g. default admin created
+// when fitlogic starts
+
+// AuditDAO is a data access object to a database containing models.AuditEntries,
+// the log is append-only so entries cannot be updated or deleted
+type AuditDAO struct {
+	db *gorm.DB
+}
+
+// NewAuditDAO creates a new Data Access Object for the
+// models.AuditEntry model.
+func NewAuditDAO(db *gorm.DB) *AuditDAO {
+	return &AuditDAO{
+		db: db,
+	}
+}
+
+// Create will create single models.AuditEntry in database.
+func (dao *AuditDAO) Create(m *models.AuditEntry) error {
+	if err := dao.db.Create(m).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// List will return one page of entries of audit log visible to viewer that
+// match list query and number of all matching entries, the newest entries
+// are first unless other order is requested
+func (dao *AuditDAO) List(viewer *models.User, q common.ListQuery) ([]models.AuditEntry, int, error) {
+	m := []models.AuditEntry{}
+	cond, args := visibleAuditEntriesCondition(viewer)
+	total, err := listQuery(scoped(dao.db, cond, args), &models.AuditEntry{}, &m, q, auditListFields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return m, total, nil
+}
+
+// audited will make change in a transaction and write entry of audit log
+// in the same transaction, the change is rolled back with
+// common.ErrAuditFailed when the entry cannot be written. The entry is
+// filled in by change, nil entry is not written
+func audited(db *gorm.DB, entry *models.AuditEntry, change func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if err := change(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if entry != nil {
+		if err := tx.Create(entry).Error; err != nil {
+			tx.Rollback()
+			return common.ErrAuditFailed
+		}
+	}
+	return tx.Commit().Error
+}
+
+// describe will fill in entity changed by action of entry of audit log,
+// nil entry is left as it is
+func describe(entry *models.AuditEntry, entityType string, entityID uint, organizationID uint,
+	changes models.Changes) {
+	if entry == nil {
+		return
+	}
+	entry.EntityType = entityType
+	entry.EntityID = entityID
+	entry.OrganizationID = organizationID
+	entry.Changes = changes
+}
+
+// roleChange will return change of roles of members of project by IDs of
+// their users, old roles are left out when no user had one
+func roleChange(old, new map[uint]string) models.Change {
+	if len(old) == 0 {
+		return models.Change{New: new}
+	}
+	return models.Change{Old: old, New: new}
+}
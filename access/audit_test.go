@@ -0,0 +1,263 @@
+package access
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// auditData are records changes of audit tests are made to
+type auditData struct {
+	admin   *models.User
+	project *models.Project
+	risk    *models.Risk
+	cm      *models.CounterMeasure
+}
+
+// newAuditData will create admin of Default organization with a project,
+// risk and countermeasure, creating the records is not audited
+func newAuditData(t *testing.T, repos Repositories) *auditData {
+	t.Helper()
+
+	org, err := repos.Organizations.ReadByName(models.DefaultOrganizationName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &auditData{
+		admin: &models.User{Name: "admin", Email: "admin@fitlogic.test", Role: models.RoleAdmin,
+			OrganizationID: org.ID},
+		project: &models.Project{Name: "project", OrganizationID: org.ID},
+		risk:    &models.Risk{Name: "risk", OrganizationID: org.ID, Status: models.RiskStatusIdentified},
+		cm:      &models.CounterMeasure{Name: "cm", OrganizationID: org.ID},
+	}
+	for _, err := range []error{
+		repos.Users.Create(d.admin, nil),
+		repos.Projects.Create(d.project, nil),
+		repos.Risks.Create(d.risk, nil),
+		repos.CounterMeasures.Create(d.cm, nil),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+// entry will return entry of audit log of action made by admin
+func (d *auditData) entry(action string) *models.AuditEntry {
+	return &models.AuditEntry{ActorID: d.admin.ID, Action: action}
+}
+
+// auditLog will return entries of audit log in order they were written
+func auditLog(t *testing.T, repos Repositories, viewer *models.User) []models.AuditEntry {
+	t.Helper()
+
+	q := common.ListQuery{Limit: 1000, Sort: []common.SortField{{Field: "id"}}}
+	entries, _, err := repos.Audit.List(viewer, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestChangesAreAudited(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testChangesAreAudited(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testChangesAreAudited(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testChangesAreAudited(t *testing.T, repos Repositories) {
+	d := newAuditData(t, repos)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	created := &models.Risk{Name: "created", OrganizationID: d.admin.OrganizationID, Impact: 2}
+	must(repos.Risks.Create(created, d.entry("risk.create")))
+	_, err := repos.Risks.Update(&models.Risk{Name: "renamed"}, d.risk.ID, d.entry("risk.update"))
+	must(err)
+	risk, err := repos.Risks.ReadVisibleByID(d.admin, d.risk.ID)
+	must(err)
+	_, err = repos.Risks.UpdateStatus(risk, models.RiskStatusAnalysed, "", d.entry("risk.transition"))
+	must(err)
+	must(repos.Risks.AddCounterMeasuresAssociations(risk, []models.CounterMeasure{*d.cm}, d.entry("risk.assigncms")))
+	must(repos.Projects.AddRisksAssociations(d.project, []models.Risk{*risk}, d.entry("project.assignrisks")))
+	must(repos.Projects.AddMemberships(d.project, []models.Membership{{UserID: d.admin.ID,
+		Role: models.ProjectRoleEditor}}, d.entry("project.assignusers")))
+	must(repos.Projects.AddMemberships(d.project, []models.Membership{{UserID: d.admin.ID,
+		Role: models.ProjectRoleOwner}}, d.entry("project.assignusers")))
+	_, err = repos.Users.Update(&models.User{Password: "secret"}, d.admin.ID, d.entry("user.changepassword"))
+	must(err)
+	must(repos.CounterMeasures.Delete(d.cm, d.entry("cm.delete")))
+
+	expected := []string{
+		fmt.Sprintf("risk.create risk %d map[Impact:{<nil> 2} Name:{<nil> created} OrganizationID:{<nil> %d}]",
+			created.ID, d.admin.OrganizationID),
+		fmt.Sprintf("risk.update risk %d map[Name:{risk renamed}]", d.risk.ID),
+		fmt.Sprintf("risk.transition risk %d map[Status:{identified analysed}]", d.risk.ID),
+		fmt.Sprintf("risk.assigncms risk %d map[CounterMeasures:{<nil> [%d]}]", d.risk.ID, d.cm.ID),
+		fmt.Sprintf("project.assignrisks project %d map[Risks:{<nil> [%d]}]", d.project.ID, d.risk.ID),
+		fmt.Sprintf("project.assignusers project %d map[Roles:{<nil> map[%d:editor]} Users:{<nil> [%d]}]",
+			d.project.ID, d.admin.ID, d.admin.ID),
+		fmt.Sprintf("project.assignusers project %d map[Roles:{map[%d:editor] map[%d:owner]} Users:{<nil> [%d]}]",
+			d.project.ID, d.admin.ID, d.admin.ID, d.admin.ID),
+		fmt.Sprintf("user.changepassword user %d map[Password:{%s %s}]", d.admin.ID, common.RedactedValue,
+			common.RedactedValue),
+		fmt.Sprintf("cm.delete cm %d map[Name:{cm <nil>} OrganizationID:{%d <nil>}]", d.cm.ID,
+			d.admin.OrganizationID),
+	}
+	entries := auditLog(t, repos, d.admin)
+	if len(entries) != len(expected) {
+		t.Fatalf("audit log has %d entries, expected %d: %v", len(entries), len(expected), entries)
+	}
+	for i, e := range entries {
+		got := fmt.Sprint(e.Action, " ", e.EntityType, " ", e.EntityID, " ", e.Changes)
+		if got != expected[i] {
+			t.Errorf("entry %d is %q, expected %q", i, got, expected[i])
+		}
+		if e.ActorID != d.admin.ID || e.OrganizationID != d.admin.OrganizationID {
+			t.Errorf("entry %d has actor %d in organization %d", i, e.ActorID, e.OrganizationID)
+		}
+	}
+}
+
+func TestRehashOfPasswordIsNotAudited(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testRehashOfPasswordIsNotAudited(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testRehashOfPasswordIsNotAudited(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testRehashOfPasswordIsNotAudited(t *testing.T, repos Repositories) {
+	d := newAuditData(t, repos)
+	if err := repos.Users.UpdatePassword(d.admin.ID, "rehashed"); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repos.Users.ReadByID(d.admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Password != "rehashed" || stored.Version != d.admin.Version {
+		t.Errorf("user has password %q and version %d, expected rehashed and %d", stored.Password,
+			stored.Version, d.admin.Version)
+	}
+	if entries := auditLog(t, repos, d.admin); len(entries) != 0 {
+		t.Errorf("rehash of password is audited: %v", entries)
+	}
+	if err := repos.Users.UpdatePassword(d.admin.ID+100, "rehashed"); err == nil {
+		t.Error("password of missing user is updated")
+	}
+}
+
+func TestMembershipsOfManagersAreAudited(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testMembershipsOfManagersAreAudited(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testMembershipsOfManagersAreAudited(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testMembershipsOfManagersAreAudited(t *testing.T, repos Repositories) {
+	d := newAuditData(t, repos)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	manager := &models.User{Name: "manager", Email: "manager@fitlogic.test", Role: models.RoleManager,
+		OrganizationID: d.admin.OrganizationID}
+	must(repos.Users.Create(manager, nil))
+
+	project := &models.Project{Name: "led", OrganizationID: d.admin.OrganizationID, ManagerID: d.admin.ID}
+	must(repos.Projects.Create(project, d.entry("project.create")))
+	_, err := repos.Projects.Update(&models.Project{ManagerID: manager.ID, Version: project.Version},
+		project.ID, d.entry("project.update"))
+	must(err)
+
+	for userID, role := range map[uint]string{
+		d.admin.ID: models.ProjectRoleEditor,
+		manager.ID: models.ProjectRoleOwner,
+	} {
+		membership, err := repos.Memberships.Read(userID, project.ID)
+		must(err)
+		if membership.Role != role {
+			t.Errorf("user %d is %s of project, expected %s", userID, membership.Role, role)
+		}
+	}
+	expected := []string{
+		fmt.Sprintf("project.create map[%d:owner]", d.admin.ID),
+		fmt.Sprintf("project.update {map[%d:owner] map[%d:editor %d:owner]}", d.admin.ID, d.admin.ID,
+			manager.ID),
+	}
+	entries := auditLog(t, repos, d.admin)
+	if len(entries) != len(expected) {
+		t.Fatalf("audit log has %d entries, expected %d: %v", len(entries), len(expected), entries)
+	}
+	roles := fmt.Sprint(entries[0].Action, " ", entries[0].Changes["Roles"].New)
+	if roles != expected[0] {
+		t.Errorf("entry 0 records roles %q, expected %q", roles, expected[0])
+	}
+	roles = fmt.Sprint(entries[1].Action, " ", entries[1].Changes["Roles"])
+	if roles != expected[1] {
+		t.Errorf("entry 1 records roles %q, expected %q", roles, expected[1])
+	}
+}
+
+func TestFailedAuditRollsBackChange(t *testing.T) {
+	forEachDB(t, testFailedAuditRollsBackChange)
+}
+
+func testFailedAuditRollsBackChange(t *testing.T, driver string) {
+	db := newMigratedDB(t, driver)
+	repos := NewRepositories(db)
+	d := newAuditData(t, repos)
+	// entries cannot be written without the table
+	if err := db.DropTable(&models.AuditEntry{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.LogMode(false)
+
+	changes := map[string]error{
+		"create": repos.Risks.Create(&models.Risk{Name: "created", OrganizationID: d.admin.OrganizationID},
+			d.entry("risk.create")),
+		"assign": repos.Risks.AddCounterMeasuresAssociations(d.risk, []models.CounterMeasure{*d.cm},
+			d.entry("risk.assigncms")),
+		"delete": repos.Projects.Delete(d.project, d.entry("project.delete")),
+	}
+	_, changes["update"] = repos.Risks.Update(&models.Risk{Name: "renamed"}, d.risk.ID, d.entry("risk.update"))
+	for name, err := range changes {
+		if err != common.ErrAuditFailed {
+			t.Errorf("%s gives %v, expected %v", name, err, common.ErrAuditFailed)
+		}
+	}
+
+	risks, total, err := repos.Risks.List(d.admin, common.ListQuery{Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || risks[0].Name != "risk" || risks[0].Version != d.risk.Version {
+		t.Errorf("risks are %v, expected only unchanged risk", risks)
+	}
+	cms, err := repos.Risks.GetAllAssociatedCounterMeasures(d.risk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cms) != 0 {
+		t.Errorf("risk has countermeasures %v, expected none", cms)
+	}
+	if _, err := repos.Projects.ReadVisibleByID(d.admin, d.project.ID); err != nil {
+		t.Errorf("project is not readable after failed delete: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package access
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Tables of migration 1 are created from frozen copies of models as they
+// were when the migration was written, so it creates the same schema in
+// every version of fitlogic and later migrations change it from there.
+// Its steps converting data use them as well. These types and values must
+// never change, a change of schema is a new migration
+
+// roles of users stored by the baseline, lower is more privileged
+const (
+	baselineRoleSuperAdmin = 0
+	baselineRoleAdmin      = 1
+)
+
+// roles of users in projects stored by the baseline
+const (
+	baselineProjectRoleOwner  = "owner"
+	baselineProjectRoleEditor = "editor"
+)
+
+// statuses of risks stored by the baseline
+const (
+	baselineRiskStatusIdentified = "identified"
+	baselineRiskStatusAnalysed   = "analysed"
+	baselineRiskStatusMonitored  = "monitored"
+)
+
+// baselineRiskStatuses are all statuses of lifecycle of risks
+var baselineRiskStatuses = []string{
+	baselineRiskStatusIdentified, baselineRiskStatusAnalysed, "mitigating", baselineRiskStatusMonitored,
+	"closed", "occurred",
+}
+
+// baselineOrganizationName is the organization records created before
+// there were organizations are moved into
+const baselineOrganizationName = "Default"
+
+type baselineOrganization struct {
+	gorm.Model
+
+	Name        string `gorm:"unique"`
+	Description string
+}
+
+func (baselineOrganization) TableName() string { return "organizations" }
+
+type baselineUser struct {
+	gorm.Model
+
+	OrganizationID uint `gorm:"index"`
+
+	Name     string
+	Email    string `gorm:"unique"`
+	Password string
+	Role     int
+	Skills   string
+	Status   string
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineProject struct {
+	gorm.Model
+
+	Start      string
+	End        string
+	IsFinished bool
+	ManagerID  uint
+
+	OrganizationID uint   `gorm:"unique_index:idx_projects_organization_name"`
+	Name           string `gorm:"unique_index:idx_projects_organization_name"`
+	Description    string
+}
+
+func (baselineProject) TableName() string { return "projects" }
+
+type baselineMembership struct {
+	UserID    uint `gorm:"primary_key;auto_increment:false"`
+	ProjectID uint `gorm:"primary_key;auto_increment:false"`
+	Role      string
+	CreatedAt time.Time
+}
+
+func (baselineMembership) TableName() string { return "user_projects" }
+
+type baselineRisk struct {
+	gorm.Model
+
+	Value       float64
+	Cost        int
+	Probability float64
+	Risk        float64
+	Exposure    float64
+
+	OrganizationID uint   `gorm:"unique_index:idx_risks_organization_name"`
+	Name           string `gorm:"unique_index:idx_risks_organization_name"`
+	Description    string
+	Category       string
+	Threat         string
+	Status         string
+	StatusReason   string
+	Trigger        string
+	Impact         float64
+
+	Start string
+	End   string
+
+	UserID uint
+}
+
+func (baselineRisk) TableName() string { return "risks" }
+
+// computeScores will compute scores of risk like the baseline did, risk
+// score is probability times impact and exposure probability times cost
+func (r *baselineRisk) computeScores() {
+	r.Risk = r.Probability * r.Impact
+	r.Exposure = r.Probability * float64(r.Cost)
+}
+
+type baselineRiskProject struct {
+	RiskID    uint `gorm:"primary_key;auto_increment:false"`
+	ProjectID uint `gorm:"primary_key;auto_increment:false"`
+}
+
+func (baselineRiskProject) TableName() string { return "risk_projects" }
+
+type baselineCounterMeasure struct {
+	gorm.Model
+
+	OrganizationID uint `gorm:"index"`
+
+	Name        string
+	Description string
+	Cost        int
+}
+
+func (baselineCounterMeasure) TableName() string { return "counter_measures" }
+
+type baselineRiskCounterMeasure struct {
+	RiskID           uint `gorm:"primary_key;auto_increment:false"`
+	CounterMeasureID uint `gorm:"primary_key;auto_increment:false"`
+}
+
+func (baselineRiskCounterMeasure) TableName() string { return "risk_counter_measures" }
+
+type baselineSession struct {
+	gorm.Model
+
+	UserID           uint
+	RefreshTokenHash string `gorm:"unique_index"`
+	ExpiresAt        time.Time
+	Revoked          bool
+}
+
+func (baselineSession) TableName() string { return "sessions" }
+
+type baselineAuditEntry struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+
+	ActorID        uint `gorm:"index"`
+	OrganizationID uint `gorm:"index"`
+	Action         string
+	EntityType     string `gorm:"index:idx_audit_entries_entity"`
+	EntityID       uint   `gorm:"index:idx_audit_entries_entity"`
+
+	Changes string `gorm:"type:text"`
+}
+
+func (baselineAuditEntry) TableName() string { return "audit_entries" }
+
+type baselineRiskVersion struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+
+	RiskID  uint `gorm:"unique_index:idx_risk_versions_risk_version"`
+	Version uint `gorm:"unique_index:idx_risk_versions_risk_version"`
+	ActorID uint
+
+	// JSON of baselineRisk
+	Data string `gorm:"type:text"`
+}
+
+func (baselineRiskVersion) TableName() string { return "risk_versions" }
+
+// baselineModels are models whose tables are created by the baseline
+var baselineModels = []interface{}{
+	&baselineOrganization{}, &baselineUser{}, &baselineProject{}, &baselineRisk{}, &baselineSession{},
+	&baselineMembership{}, &baselineCounterMeasure{}, &baselineAuditEntry{}, &baselineRiskVersion{},
+	&baselineRiskProject{}, &baselineRiskCounterMeasure{},
+}
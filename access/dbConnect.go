@@ -1,13 +1,81 @@
 package access
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/viper"
+)
+
+// supported values of Database.Driver in configuration
+const (
+	DriverSqlite   = "sqlite3"
+	DriverPostgres = "postgres"
+	DriverMysql    = "mysql"
 )
 
-// ConnectToDb will return connected DB, this is the only place
-// programmer would have to rewrite if customer wants DB with some other
-// technology (postgres or mysql)
-func ConnectToDb() (*gorm.DB, error) {
-	return gorm.Open("sqlite3", "fitlogic.db")
+// DBConfig describes connection to DB, it is read from Database
+// section of configuration file
+type DBConfig struct {
+	// one of sqlite3, postgres or mysql
+	Driver string
+	// data source name in format of the driver, e.g. file name for sqlite3,
+	// "host=localhost user=fitlogic dbname=fitlogic sslmode=disable" for postgres
+	// or "fitlogic:secret@tcp(localhost:3306)/fitlogic?parseTime=true" for mysql
+	DSN string
+	// the highest number of open connections, 0 is unlimited
+	MaxOpenConns int
+	// the highest number of idle connections in pool
+	MaxIdleConns int
+	// connections are closed after this time, 0 keeps them open
+	ConnMaxLifetime time.Duration
+	// how long connecting is retried when DB is not up yet, 0 tries once
+	ConnectTimeout time.Duration
+}
+
+// ReadDBConfig will return DB configuration from viper, missing
+// values are replaced by defaults that keep the sqlite3 file fitlogic.db
+func ReadDBConfig() DBConfig {
+	viper.SetDefault("Database.Driver", DriverSqlite)
+	viper.SetDefault("Database.DSN", "fitlogic.db")
+	viper.SetDefault("Database.MaxIdleConns", 2)
+
+	return DBConfig{
+		Driver:          viper.GetString("Database.Driver"),
+		DSN:             viper.GetString("Database.DSN"),
+		MaxOpenConns:    viper.GetInt("Database.MaxOpenConns"),
+		MaxIdleConns:    viper.GetInt("Database.MaxIdleConns"),
+		ConnMaxLifetime: viper.GetDuration("Database.ConnMaxLifetime"),
+		ConnectTimeout:  viper.GetDuration("Database.ConnectTimeout"),
+	}
+}
+
+// ConnectToDb will return DB connected as described by config,
+// connecting is retried every second until ConnectTimeout passes
+func ConnectToDb(config DBConfig) (*gorm.DB, error) {
+	switch config.Driver {
+	case DriverSqlite, DriverPostgres, DriverMysql:
+	default:
+		return nil, fmt.Errorf("Unsupported database driver %q, use sqlite3, postgres or mysql", config.Driver)
+	}
+
+	deadline := time.Now().Add(config.ConnectTimeout)
+	db, err := gorm.Open(config.Driver, config.DSN)
+	for err != nil && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		db, err = gorm.Open(config.Driver, config.DSN)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db.DB().SetMaxOpenConns(config.MaxOpenConns)
+	db.DB().SetMaxIdleConns(config.MaxIdleConns)
+	db.DB().SetConnMaxLifetime(config.ConnMaxLifetime)
+
+	return db, nil
 }
@@ -0,0 +1,93 @@
+package access
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// envTestPostgresDSN is environment variable with DSN of Postgres DB tests
+// of DAOs and migrations run on besides SQLite, e.g. "host=localhost
+// user=postgres password=secret dbname=fitlogic_test sslmode=disable".
+// Every test creates its own schema in it and drops it at the end, tests
+// on Postgres are skipped when it is not set
+const envTestPostgresDSN = "FITLOGIC_TEST_POSTGRES_DSN"
+
+// testDrivers are drivers of DBs tests run on
+var testDrivers = []string{DriverSqlite, DriverPostgres}
+
+// forEachDB will run test as a subtest for every driver of testDrivers
+func forEachDB(t *testing.T, test func(t *testing.T, driver string)) {
+	for _, driver := range testDrivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			test(t, driver)
+		})
+	}
+}
+
+// newTestDB will return a temporary DB of driver without tables
+func newTestDB(t *testing.T, driver string) *gorm.DB {
+	t.Helper()
+
+	if driver == DriverPostgres {
+		return newPostgresTestDB(t)
+	}
+
+	db, err := ConnectToDb(DBConfig{Driver: DriverSqlite, DSN: filepath.Join(t.TempDir(), "fitlogic.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newPostgresTestDB will return connection to a new schema of Postgres DB
+// from envTestPostgresDSN, the schema is dropped when test ends
+func newPostgresTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv(envTestPostgresDSN)
+	if dsn == "" {
+		t.Skip(envTestPostgresDSN + " is not set")
+	}
+
+	// DB may be still starting when it was started for the tests
+	admin, err := ConnectToDb(DBConfig{Driver: DriverPostgres, DSN: dsn, ConnectTimeout: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("fitlogic_test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := admin.Exec("DROP SCHEMA " + schema + " CASCADE").Error; err != nil {
+			t.Error(err)
+		}
+		admin.Close()
+	})
+
+	db, err := ConnectToDb(DBConfig{Driver: DriverPostgres, DSN: dsn + " search_path=" + schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newMigratedDB will return a temporary DB of driver with all migrations applied
+func newMigratedDB(t *testing.T, driver string) *gorm.DB {
+	t.Helper()
+
+	db := newTestDB(t, driver)
+	if _, err := NewMigrator(db, Migrations).Up(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
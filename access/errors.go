@@ -0,0 +1,54 @@
+package access
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+)
+
+// resources of ErrNotFound
+const (
+	ResourceOrganization   = "organization"
+	ResourceUser           = "user"
+	ResourceProject        = "project"
+	ResourceRisk           = "risk"
+	ResourceCounterMeasure = "countermeasure"
+	ResourceMembership     = "membership"
+	ResourceSession        = "session"
+	ResourceRiskVersion    = "risk version"
+)
+
+// ErrNotFound is returned by DAOs when a record does not exist, was deleted
+// or is not visible to viewer
+type ErrNotFound struct {
+	Resource string
+}
+
+func (e *ErrNotFound) Error() string {
+	return strings.ToUpper(e.Resource[:1]) + e.Resource[1:] + " not found"
+}
+
+// ErrorCode will return code of error sent by API, e.g. PROJECT_NOT_FOUND
+func (e *ErrNotFound) ErrorCode() common.ErrorCode {
+	return common.ErrorCode{
+		Code:   strings.ToUpper(strings.Replace(e.Resource, " ", "_", -1)) + "_NOT_FOUND",
+		Status: http.StatusNotFound,
+	}
+}
+
+// IsNotFound will return whether err is ErrNotFound
+func IsNotFound(err error) bool {
+	_, ok := err.(*ErrNotFound)
+	return ok
+}
+
+// notFound will replace error of gorm about missing record by ErrNotFound
+// of resource given by parameter, other errors are returned as they are
+func notFound(err error, resource string) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return &ErrNotFound{Resource: resource}
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package access
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// MembershipDAO is a data access object to a database containing models.Memberships
+type MembershipDAO struct {
+	db *gorm.DB
+}
+
+// NewMembershipDAO creates a new Data Access Object for the
+// models.Membership model.
+func NewMembershipDAO(db *gorm.DB) *MembershipDAO {
+	return &MembershipDAO{
+		db: db,
+	}
+}
+
+// Save will create models.Membership in database or update role
+// of existing one
+func (dao *MembershipDAO) Save(m *models.Membership) error {
+	if err := dao.db.Save(m).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete will delete a single models.Membership
+func (dao *MembershipDAO) Delete(m *models.Membership) error {
+	if err := dao.db.Delete(m).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// Read will find membership of user with userID in project with projectID
+func (dao *MembershipDAO) Read(userID uint, projectID uint) (*models.Membership, error) {
+	m := &models.Membership{}
+	err := dao.db.Where("user_id = ? AND project_id = ?", userID, projectID).First(m).Error
+	if err != nil {
+		return nil, notFound(err, ResourceMembership)
+	}
+
+	return m, nil
+}
+
+// GetAllOfProject will return all memberships in project with ID given by parameter
+func (dao *MembershipDAO) GetAllOfProject(projectID uint) ([]models.Membership, error) {
+	m := []models.Membership{}
+	if err := dao.db.Where("project_id = ?", projectID).Find(&m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// BestRole will return the most privileged role user with userID has in any
+// of projects given by parameter, empty string if he is not member of any
+func (dao *MembershipDAO) BestRole(userID uint, projectIDs []uint) (string, error) {
+	if len(projectIDs) == 0 {
+		return "", nil
+	}
+
+	m := []models.Membership{}
+	err := dao.db.Where("user_id = ? AND project_id IN (?)", userID, projectIDs).Find(&m).Error
+	if err != nil {
+		return "", err
+	}
+
+	best := ""
+	for i := range m {
+		if models.ProjectRoleRank(m[i].Role) > models.ProjectRoleRank(best) {
+			best = m[i].Role
+		}
+	}
+
+	return best, nil
+}
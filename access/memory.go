@@ -0,0 +1,579 @@
+package access
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// MemoryStore keeps records of all models in memory, it backs Memory DAOs
+// in tests of handlers. It is safe for concurrent use. Records are copied
+// in and out, so changing a returned model does not change the store
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	lastIDs map[string]uint
+	// unique maps names of unique indexes to their keys and IDs of records,
+	// keys of soft-deleted records are kept like in DB
+	unique map[string]map[string]uint
+
+	organizations   map[uint]*models.Organization
+	users           map[uint]*models.User
+	projects        map[uint]*models.Project
+	risks           map[uint]*models.Risk
+	counterMeasures map[uint]*models.CounterMeasure
+	sessions        map[uint]*models.Session
+	auditEntries    map[uint]*models.AuditEntry
+	riskVersions    map[uint]*models.RiskVersion
+
+	memberships         map[memoryLink]*models.Membership
+	riskProjects        map[memoryLink]bool
+	riskCounterMeasures map[memoryLink]bool
+}
+
+// memoryLink is a row of a join table, memberships are linked from user
+// to project, risks to their projects and countermeasures
+type memoryLink struct {
+	from uint
+	to   uint
+}
+
+// NewMemoryStore creates a new MemoryStore with records of migrated DB,
+// which is the Default organization only
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		lastIDs:             map[string]uint{},
+		unique:              map[string]map[string]uint{},
+		organizations:       map[uint]*models.Organization{},
+		users:               map[uint]*models.User{},
+		projects:            map[uint]*models.Project{},
+		risks:               map[uint]*models.Risk{},
+		counterMeasures:     map[uint]*models.CounterMeasure{},
+		sessions:            map[uint]*models.Session{},
+		auditEntries:        map[uint]*models.AuditEntry{},
+		riskVersions:        map[uint]*models.RiskVersion{},
+		memberships:         map[memoryLink]*models.Membership{},
+		riskProjects:        map[memoryLink]bool{},
+		riskCounterMeasures: map[memoryLink]bool{},
+	}
+	if err := NewMemoryOrganizationDAO(s).Create(&models.Organization{Name: models.DefaultOrganizationName}); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// nextID will return ID of a new record in table, ID set by caller is kept
+func (s *MemoryStore) nextID(table string, id uint) uint {
+	if id == 0 {
+		id = s.lastIDs[table] + 1
+	}
+	if id > s.lastIDs[table] {
+		s.lastIDs[table] = id
+	}
+	return id
+}
+
+// checkUnique will return error when key of unique index is taken
+// by other record than the one with ID given by parameter
+func (s *MemoryStore) checkUnique(index string, key string, id uint) error {
+	if owner, ok := s.unique[index][key]; ok && owner != id {
+		return fmt.Errorf("UNIQUE constraint failed: %s", index)
+	}
+	return nil
+}
+
+// setUnique will move record with ID given by parameter from old key
+// of unique index to the new one
+func (s *MemoryStore) setUnique(index string, old string, key string, id uint) {
+	if s.unique[index] == nil {
+		s.unique[index] = map[string]uint{}
+	}
+	if old != key && s.unique[index][old] == id {
+		delete(s.unique[index], old)
+	}
+	s.unique[index][key] = id
+}
+
+// createModel will set ID and timestamps of a new record like gorm does
+func (s *MemoryStore) createModel(table string, m *gorm.Model) error {
+	if m.ID != 0 && s.exists(table, m.ID) {
+		return fmt.Errorf("UNIQUE constraint failed: %s.id", table)
+	}
+	m.ID = s.nextID(table, m.ID)
+	now := time.Now()
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = now
+	}
+	if m.UpdatedAt.IsZero() {
+		m.UpdatedAt = now
+	}
+	return nil
+}
+
+// exists will return whether record with ID is in table, soft-deleted
+// records included
+func (s *MemoryStore) exists(table string, id uint) bool {
+	var ok bool
+	switch table {
+	case "organizations":
+		_, ok = s.organizations[id]
+	case "users":
+		_, ok = s.users[id]
+	case "projects":
+		_, ok = s.projects[id]
+	case "risks":
+		_, ok = s.risks[id]
+	case "counter_measures":
+		_, ok = s.counterMeasures[id]
+	case "sessions":
+		_, ok = s.sessions[id]
+	}
+	return ok
+}
+
+// audit will append entry of audit log to store, nil entry is not
+// written. DAOs write it before they change records, so changes are made
+// only with their entries. Caller holds the lock
+func (s *MemoryStore) audit(entry *models.AuditEntry) error {
+	if entry == nil {
+		return nil
+	}
+	if _, ok := s.auditEntries[entry.ID]; ok {
+		return fmt.Errorf("UNIQUE constraint failed: audit_entries.id")
+	}
+	entry.ID = s.nextID("audit_entries", entry.ID)
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	record := *entry
+	record.Changes = models.Changes{}
+	for field, change := range entry.Changes {
+		record.Changes[field] = change
+	}
+	s.auditEntries[entry.ID] = &record
+	return nil
+}
+
+// createRiskVersion will store current state of risk given by parameter
+// as its next version, caller holds the lock
+func (s *MemoryStore) createRiskVersion(risk *models.Risk, entry *models.AuditEntry) {
+	last := uint(0)
+	for _, record := range s.riskVersions {
+		if record.RiskID == risk.ID && record.Version > last {
+			last = record.Version
+		}
+	}
+
+	m := &models.RiskVersion{
+		ID:        s.nextID("risk_versions", 0),
+		CreatedAt: time.Now(),
+		RiskID:    risk.ID,
+		Version:   last + 1,
+		Data:      models.RiskData{Risk: *risk},
+	}
+	if entry != nil {
+		m.ActorID = entry.ActorID
+	}
+	// associations are not part of version
+	m.Data.Projects = nil
+	m.Data.CounterMeasures = nil
+	s.riskVersions[m.ID] = m
+}
+
+// sortedIDs will return IDs that are keys of map of records in ascending
+// order, DB returns records in order of their IDs too
+func sortedIDs(records interface{}) []uint {
+	keys := reflect.ValueOf(records).MapKeys()
+	ids := make([]uint, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, uint(key.Uint()))
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// softDelete will mark record as deleted, deleting a missing
+// or deleted record does nothing like in DB
+func softDelete(m *gorm.Model) {
+	if m.DeletedAt == nil {
+		now := time.Now()
+		m.DeletedAt = &now
+	}
+}
+
+// isDeleted will return whether record was soft-deleted
+func isDeleted(m gorm.Model) bool {
+	return m.DeletedAt != nil
+}
+
+// updateNonBlank will copy fields of src that are not blank to dst like
+// gorm Updates with struct does. Embedded gorm.Model and associations
+// are left out
+func updateNonBlank(dst interface{}, src interface{}) {
+	copyFields(dst, src, true)
+}
+
+// updateAll will copy all fields of src to dst like gorm Updates with map
+// of all columns does. Embedded gorm.Model and associations are left out
+func updateAll(dst interface{}, src interface{}) {
+	copyFields(dst, src, false)
+}
+
+// copyFields will copy fields of src to dst, blank ones only when
+// skipBlank is false
+func copyFields(dst interface{}, src interface{}, skipBlank bool) {
+	d := reflect.ValueOf(dst).Elem()
+	v := reflect.ValueOf(src).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		if field.Anonymous || field.PkgPath != "" || field.Name == "ID" {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Ptr:
+			continue
+		case reflect.Struct:
+			if _, ok := value.Interface().(time.Time); !ok {
+				continue
+			}
+		}
+		if skipBlank && value.IsZero() {
+			continue
+		}
+		d.Field(i).Set(value)
+	}
+}
+
+// columnValue will return value of field of record mapped to DB column
+// given by parameter, fields of embedded structs are searched too
+func columnValue(record reflect.Value, column string) (reflect.Value, bool) {
+	for i := 0; i < record.NumField(); i++ {
+		field := record.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if value, ok := columnValue(record.Field(i), column); ok {
+				return value, true
+			}
+			continue
+		}
+		if field.PkgPath == "" && gorm.ToColumnName(field.Name) == column {
+			return record.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// compareValues will compare two values of columns or of a column and
+// a parsed filter, the second result is false when they cannot be compared
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case at.Before(bt):
+			return -1, true
+		case at.After(bt):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	if af, ok := numberValue(av); ok {
+		bf, ok := numberValue(bv)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+	if av.Kind() == reflect.String && bv.Kind() == reflect.String {
+		return strings.Compare(av.String(), bv.String()), true
+	}
+
+	return 0, false
+}
+
+// numberValue will return value of number or bool (stored as 0 or 1)
+// as float64
+func numberValue(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// memoryFilter is a filter of list query evaluated in memory
+type memoryFilter func(record reflect.Value, value interface{}) bool
+
+// memoryCondition matches conditions of filters that compare a column,
+// other conditions (subqueries) need a memoryFilter
+var memoryCondition = regexp.MustCompile(`^(?:\w+\.)?(\w+) (=|>=|<=) \?$`)
+
+// conditionFilter will return memoryFilter evaluating condition of listFilter
+func conditionFilter(cond string) (memoryFilter, error) {
+	match := memoryCondition.FindStringSubmatch(cond)
+	if match == nil {
+		return nil, fmt.Errorf("condition %q cannot be evaluated in memory", cond)
+	}
+	column, op := match[1], match[2]
+
+	return func(record reflect.Value, value interface{}) bool {
+		field, ok := columnValue(record, column)
+		if !ok {
+			return false
+		}
+		cmp, ok := compareValues(field.Interface(), value)
+		if !ok {
+			return false
+		}
+		switch op {
+		case ">=":
+			return cmp >= 0
+		case "<=":
+			return cmp <= 0
+		}
+		return cmp == 0
+	}, nil
+}
+
+// memoryOrder is a column records are sorted by
+type memoryOrder struct {
+	column string
+	desc   bool
+}
+
+// parseOrder will parse order of gorm query, e.g. "created_at desc, id",
+// columns are not qualified by table
+func parseOrder(order string) []memoryOrder {
+	orders := []memoryOrder{}
+	for _, part := range strings.Split(order, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 {
+			continue
+		}
+		column := words[0]
+		if i := strings.LastIndex(column, "."); i >= 0 {
+			column = column[i+1:]
+		}
+		orders = append(orders, memoryOrder{
+			column: column,
+			desc:   len(words) > 1 && strings.EqualFold(words[1], "desc"),
+		})
+	}
+	return orders
+}
+
+// memoryList will apply list query to records, a slice of models, the same
+// way listQuery does in DB. Filters with subqueries are given by special.
+// It returns one page of records as a slice of the same type and number
+// of all records matching filters
+func memoryList(records interface{}, q common.ListQuery, fields listFields,
+	special map[string]memoryFilter) (interface{}, int, error) {
+	type appliedFilter struct {
+		match memoryFilter
+		value interface{}
+	}
+	filters := []appliedFilter{}
+	for name, raw := range q.Filters {
+		filter, ok := fields.filters[name]
+		if !ok {
+			return nil, 0, common.ErrInvalidQueryParam
+		}
+		value, err := filter.parse(raw)
+		if err != nil {
+			return nil, 0, common.ErrInvalidQueryParam
+		}
+		match, ok := special[name]
+		if !ok {
+			match, err = conditionFilter(filter.cond)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		filters = append(filters, appliedFilter{match, value})
+	}
+
+	orders := []memoryOrder{}
+	if len(q.Sort) == 0 && fields.defaultSort != "" {
+		orders = append(orders, parseOrder(fields.defaultSort)...)
+	}
+	for _, s := range q.Sort {
+		column, ok := fields.sorts[s.Field]
+		if !ok {
+			return nil, 0, common.ErrInvalidQueryParam
+		}
+		order := parseOrder(column)[0]
+		order.desc = s.Desc
+		orders = append(orders, order)
+	}
+	orders = append(orders, memoryOrder{column: "id"})
+
+	all := reflect.ValueOf(records)
+	matched := reflect.MakeSlice(all.Type(), 0, all.Len())
+	for i := 0; i < all.Len(); i++ {
+		record := all.Index(i)
+		ok := true
+		for _, filter := range filters {
+			if !filter.match(record, filter.value) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = reflect.Append(matched, record)
+		}
+	}
+	total := matched.Len()
+
+	sort.SliceStable(matched.Interface(), func(i, j int) bool {
+		for _, order := range orders {
+			a, _ := columnValue(matched.Index(i), order.column)
+			b, _ := columnValue(matched.Index(j), order.column)
+			if !a.IsValid() || !b.IsValid() {
+				continue
+			}
+			cmp, _ := compareValues(a.Interface(), b.Interface())
+			if cmp == 0 {
+				continue
+			}
+			if order.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+
+	// negative limit means no limit like in gorm
+	start, end := q.Offset, total
+	if start > total {
+		start = total
+	}
+	if q.Limit >= 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+
+	return matched.Slice(start, end).Interface(), total, nil
+}
+
+// isMember will return whether user is member of project
+func (s *MemoryStore) isMember(userID uint, projectID uint) bool {
+	_, ok := s.memberships[memoryLink{userID, projectID}]
+	return ok
+}
+
+// organizationVisible matches visibleOrganizationsCondition
+func (s *MemoryStore) organizationVisible(viewer *models.User, m *models.Organization) bool {
+	return isSuperAdmin(viewer) || m.ID == viewer.OrganizationID
+}
+
+// userVisible matches visibleUsersCondition
+func (s *MemoryStore) userVisible(viewer *models.User, m *models.User) bool {
+	return isSuperAdmin(viewer) || m.OrganizationID == viewer.OrganizationID
+}
+
+// counterMeasureVisible matches visibleCounterMeasuresCondition
+func (s *MemoryStore) counterMeasureVisible(viewer *models.User, m *models.CounterMeasure) bool {
+	return isSuperAdmin(viewer) || m.OrganizationID == viewer.OrganizationID
+}
+
+// projectVisible matches visibleProjectsCondition
+func (s *MemoryStore) projectVisible(viewer *models.User, m *models.Project) bool {
+	if isSuperAdmin(viewer) {
+		return true
+	}
+	if m.OrganizationID != viewer.OrganizationID {
+		return false
+	}
+	if viewer.Role <= models.RoleAdmin {
+		return true
+	}
+	if viewer.Role <= models.RoleManager && m.ManagerID == viewer.ID {
+		return true
+	}
+	return s.isMember(viewer.ID, m.ID)
+}
+
+// riskVisible matches visibleRisksCondition
+func (s *MemoryStore) riskVisible(viewer *models.User, m *models.Risk) bool {
+	if isSuperAdmin(viewer) {
+		return true
+	}
+	if m.OrganizationID != viewer.OrganizationID {
+		return false
+	}
+	if viewer.Role <= models.RoleAdmin || m.UserID == viewer.ID {
+		return true
+	}
+	for link := range s.riskProjects {
+		if link.from != m.ID {
+			continue
+		}
+		project, ok := s.projects[link.to]
+		if ok && !isDeleted(project.Model) && s.projectVisible(viewer, project) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditEntryVisible matches visibleAuditEntriesCondition, projects in its
+// subqueries are not scoped by deletion
+func (s *MemoryStore) auditEntryVisible(viewer *models.User, m *models.AuditEntry) bool {
+	if isSuperAdmin(viewer) {
+		return true
+	}
+	if m.OrganizationID != viewer.OrganizationID {
+		return false
+	}
+	if viewer.Role <= models.RoleAdmin {
+		return true
+	}
+	if viewer.Role > models.RoleManager {
+		return false
+	}
+
+	managed := func(projectID uint) bool {
+		project, ok := s.projects[projectID]
+		return ok && project.ManagerID == viewer.ID
+	}
+	switch m.EntityType {
+	case models.EntityProject:
+		return managed(m.EntityID)
+	case models.EntityRisk:
+		for link := range s.riskProjects {
+			if link.from == m.EntityID && managed(link.to) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,1678 @@
+package access
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// MemoryOrganizationDAO is a data access object to models.Organizations
+// in MemoryStore
+type MemoryOrganizationDAO struct {
+	store *MemoryStore
+}
+
+// NewMemoryOrganizationDAO creates a new Data Access Object for the
+// models.Organization model in store given by parameter.
+func NewMemoryOrganizationDAO(store *MemoryStore) *MemoryOrganizationDAO {
+	return &MemoryOrganizationDAO{
+		store: store,
+	}
+}
+
+const organizationNameIndex = "organizations.name"
+
+// Create will create single models.Organization in store.
+func (dao *MemoryOrganizationDAO) Create(m *models.Organization) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(organizationNameIndex, m.Name, 0); err != nil {
+		return err
+	}
+	if err := s.createModel("organizations", &m.Model); err != nil {
+		return err
+	}
+	record := *m
+	s.organizations[m.ID] = &record
+	s.setUnique(organizationNameIndex, m.Name, m.Name, m.ID)
+	return nil
+}
+
+// Update will update a record of models.Organization in store
+func (dao *MemoryOrganizationDAO) Update(m *models.Organization, id uint) (*models.Organization, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.organizations[id]
+	if !ok || isDeleted(old.Model) {
+		return nil, &ErrNotFound{Resource: ResourceOrganization}
+	}
+	updated := *old
+	updateNonBlank(&updated, m)
+	if err := s.checkUnique(organizationNameIndex, updated.Name, id); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	s.setUnique(organizationNameIndex, old.Name, updated.Name, id)
+	*old = updated
+
+	retVal := updated
+	return &retVal, nil
+}
+
+// Delete will soft-delete a single models.Organization
+func (dao *MemoryOrganizationDAO) Delete(m *models.Organization) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.organizations[m.ID]; ok {
+		softDelete(&record.Model)
+	}
+	return nil
+}
+
+// List will return one page of records of models.Organization visible
+// to viewer that match list query and number of all matching records
+func (dao *MemoryOrganizationDAO) List(viewer *models.User, q common.ListQuery) ([]models.Organization, int, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := []models.Organization{}
+	for _, id := range sortedIDs(s.organizations) {
+		record := s.organizations[id]
+		if !isDeleted(record.Model) && s.organizationVisible(viewer, record) {
+			m = append(m, *record)
+		}
+	}
+	page, total, err := memoryList(m, q, organizationListFields, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page.([]models.Organization), total, nil
+}
+
+// ReadByID will find models.Organization by ID given by parameter
+func (dao *MemoryOrganizationDAO) ReadByID(id uint) (*models.Organization, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.organizations[id]
+	if !ok || isDeleted(record.Model) {
+		return nil, &ErrNotFound{Resource: ResourceOrganization}
+	}
+	m := *record
+	return &m, nil
+}
+
+// ReadVisibleByID will find models.Organization by ID given by parameter
+// if it is visible to viewer
+func (dao *MemoryOrganizationDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.Organization, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.organizations[id]
+	if !ok || isDeleted(record.Model) || !s.organizationVisible(viewer, record) {
+		return nil, &ErrNotFound{Resource: ResourceOrganization}
+	}
+	m := *record
+	return &m, nil
+}
+
+// ReadByName will find models.Organization by its name
+func (dao *MemoryOrganizationDAO) ReadByName(name string) (*models.Organization, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, id := range sortedIDs(s.organizations) {
+		record := s.organizations[id]
+		if !isDeleted(record.Model) && record.Name == name {
+			m := *record
+			return &m, nil
+		}
+	}
+	return nil, &ErrNotFound{Resource: ResourceOrganization}
+}
+
+// CountUsers will return number of users in organization given by parameter
+func (dao *MemoryOrganizationDAO) CountUsers(m *models.Organization) (int, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, user := range s.users {
+		if !isDeleted(user.Model) && user.OrganizationID == m.ID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MemoryUserDAO is a data access object to models.Users in MemoryStore
+type MemoryUserDAO struct {
+	store *MemoryStore
+}
+
+// NewMemoryUserDAO creates a new Data Access Object for the
+// models.User model in store given by parameter.
+func NewMemoryUserDAO(store *MemoryStore) *MemoryUserDAO {
+	return &MemoryUserDAO{
+		store: store,
+	}
+}
+
+const userEmailIndex = "users.email"
+
+// Create will create single models.User in store,
+// entry of audit log is written with it
+func (dao *MemoryUserDAO) Create(m *models.User, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(userEmailIndex, m.Email, 0); err != nil {
+		return err
+	}
+	if err := s.createModel("users", &m.Model); err != nil {
+		return err
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	describe(entry, models.EntityUser, m.ID, m.OrganizationID, common.Diff(nil, m))
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	record := *m
+	record.Projects = nil
+	record.Risks = nil
+	s.users[m.ID] = &record
+	s.setUnique(userEmailIndex, m.Email, m.Email, m.ID)
+	return nil
+}
+
+// Update will update a record of models.User in store,
+// entry of audit log is written with it
+func (dao *MemoryUserDAO) Update(m *models.User, id uint, entry *models.AuditEntry) (*models.User, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.users[id]
+	if !ok || isDeleted(old.Model) {
+		return nil, &ErrNotFound{Resource: ResourceUser}
+	}
+	if err := checkVersion(m.Version, old.Version); err != nil {
+		return nil, err
+	}
+	updated := *old
+	updateNonBlank(&updated, m)
+	updated.Version = old.Version + 1
+	if err := s.checkUnique(userEmailIndex, updated.Email, id); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	describe(entry, models.EntityUser, id, old.OrganizationID, common.Diff(old, &updated))
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	s.setUnique(userEmailIndex, old.Email, updated.Email, id)
+	*old = updated
+
+	retVal := updated
+	return &retVal, nil
+}
+
+// UpdatePassword will replace stored password of models.User with hash given
+// by parameter, it is not a change of the user, so neither version of
+// the record nor audit log is written
+func (dao *MemoryUserDAO) UpdatePassword(id uint, hash string) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.users[id]
+	if !ok || isDeleted(old.Model) {
+		return &ErrNotFound{Resource: ResourceUser}
+	}
+	old.Password = hash
+	return nil
+}
+
+// UpdateAll will update all fields of a record of models.User in store,
+// zero values included, entry of audit log is written with it
+func (dao *MemoryUserDAO) UpdateAll(m *models.User, id uint, entry *models.AuditEntry) (*models.User, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.users[id]
+	if !ok || isDeleted(old.Model) {
+		return nil, &ErrNotFound{Resource: ResourceUser}
+	}
+	if err := checkVersion(m.Version, old.Version); err != nil {
+		return nil, err
+	}
+	updated := *old
+	updateAll(&updated, m)
+	updated.Version = old.Version + 1
+	if err := s.checkUnique(userEmailIndex, updated.Email, id); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	describe(entry, models.EntityUser, id, old.OrganizationID, common.Diff(old, &updated))
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	s.setUnique(userEmailIndex, old.Email, updated.Email, id)
+	*old = updated
+
+	retVal := updated
+	return &retVal, nil
+}
+
+// Delete will soft-delete a single models.User,
+// entry of audit log is written with it
+func (dao *MemoryUserDAO) Delete(m *models.User, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.users[m.ID]
+	if ok && m.Version != 0 && (isDeleted(record.Model) || m.Version != record.Version) {
+		return common.ErrVersionMismatch
+	}
+	describe(entry, models.EntityUser, m.ID, m.OrganizationID, common.Diff(m, nil))
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	if ok {
+		softDelete(&record.Model)
+	}
+	return nil
+}
+
+// visible will return users visible to viewer ordered by ID,
+// caller holds the lock
+func (dao *MemoryUserDAO) visible(viewer *models.User) []models.User {
+	s := dao.store
+	m := []models.User{}
+	for _, id := range sortedIDs(s.users) {
+		record := s.users[id]
+		if !isDeleted(record.Model) && s.userVisible(viewer, record) {
+			m = append(m, *record)
+		}
+	}
+	return m
+}
+
+// GetAll will return all records of models.User in store
+// that are visible to viewer
+func (dao *MemoryUserDAO) GetAll(viewer *models.User) ([]models.User, error) {
+	dao.store.mu.RLock()
+	defer dao.store.mu.RUnlock()
+
+	return dao.visible(viewer), nil
+}
+
+// List will return one page of records of models.User visible
+// to viewer that match list query and number of all matching records
+func (dao *MemoryUserDAO) List(viewer *models.User, q common.ListQuery) ([]models.User, int, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, total, err := memoryList(dao.visible(viewer), q, userListFields, map[string]memoryFilter{
+		"project": func(record reflect.Value, value interface{}) bool {
+			return s.isMember(record.Interface().(models.User).ID, uint(value.(uint64)))
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page.([]models.User), total, nil
+}
+
+// GetAllAssociatedVisibleProjects will get all projects user given
+// by parameter is member of that are visible to viewer
+func (dao *MemoryUserDAO) GetAllAssociatedVisibleProjects(viewer *models.User, m *models.User) ([]models.Project, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	retVal := []models.Project{}
+	for _, id := range sortedIDs(s.projects) {
+		record := s.projects[id]
+		if !isDeleted(record.Model) && s.isMember(m.ID, id) && s.projectVisible(viewer, record) {
+			retVal = append(retVal, *record)
+		}
+	}
+	return retVal, nil
+}
+
+// GetAllAssociatedVisibleRisks will get all risks owned by user
+// given by parameter that are visible to viewer
+func (dao *MemoryUserDAO) GetAllAssociatedVisibleRisks(viewer *models.User, m *models.User) ([]models.Risk, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	retVal := []models.Risk{}
+	for _, id := range sortedIDs(s.risks) {
+		record := s.risks[id]
+		if !isDeleted(record.Model) && record.UserID == m.ID && s.riskVisible(viewer, record) {
+			retVal = append(retVal, *record)
+		}
+	}
+	return retVal, nil
+}
+
+// ReadByEmail will find all records
+// matching the value given by parameter
+func (dao *MemoryUserDAO) ReadByEmail(email string) ([]models.User, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	retVal := []models.User{}
+	for _, id := range sortedIDs(s.users) {
+		record := s.users[id]
+		if !isDeleted(record.Model) && record.Email == email {
+			retVal = append(retVal, *record)
+		}
+	}
+	return retVal, nil
+}
+
+// ReadByID will find models.User by ID given by parameter
+func (dao *MemoryUserDAO) ReadByID(id uint) (*models.User, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.users[id]
+	if !ok || isDeleted(record.Model) {
+		return nil, &ErrNotFound{Resource: ResourceUser}
+	}
+	m := *record
+	return &m, nil
+}
+
+// ReadVisibleByID will find models.User by ID given by parameter
+// if it is visible to viewer
+func (dao *MemoryUserDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.User, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.users[id]
+	if !ok || isDeleted(record.Model) || !s.userVisible(viewer, record) {
+		return nil, &ErrNotFound{Resource: ResourceUser}
+	}
+	m := *record
+	return &m, nil
+}
+
+// MemoryProjectDAO is a data access object to models.Projects in MemoryStore
+type MemoryProjectDAO struct {
+	store *MemoryStore
+}
+
+// NewMemoryProjectDAO creates a new Data Access Object for the
+// models.Project model in store given by parameter.
+func NewMemoryProjectDAO(store *MemoryStore) *MemoryProjectDAO {
+	return &MemoryProjectDAO{
+		store: store,
+	}
+}
+
+const projectNameIndex = "idx_projects_organization_name"
+
+// nameKey will return key of name of a record in unique index of names,
+// names are unique in organization
+func nameKey(organizationID uint, name string) string {
+	return fmt.Sprint(organizationID, "/", name)
+}
+
+// Create will create single models.Project in store with membership
+// of its manager, who owns it, entry of audit log is written with it
+func (dao *MemoryProjectDAO) Create(m *models.Project, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := nameKey(m.OrganizationID, m.Name)
+	if err := s.checkUnique(projectNameIndex, key, 0); err != nil {
+		return err
+	}
+	if err := s.createModel("projects", &m.Model); err != nil {
+		return err
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	changes := common.Diff(nil, m)
+	memberships := []models.Membership{}
+	if m.ManagerID != 0 {
+		memberships = managerMemberships(m, 0)
+		changes["Roles"] = s.roleChange(m.ID, memberships)
+	}
+	describe(entry, models.EntityProject, m.ID, m.OrganizationID, changes)
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	record := *m
+	record.Users = nil
+	record.Memberships = nil
+	record.Risks = nil
+	s.projects[m.ID] = &record
+	s.setUnique(projectNameIndex, key, key, m.ID)
+	for i := range memberships {
+		s.saveMembership(&memberships[i])
+	}
+	return nil
+}
+
+// Update will update a record of models.Project in store, new manager
+// becomes owner of project, entry of audit log is written with it
+func (dao *MemoryProjectDAO) Update(m *models.Project, id uint, entry *models.AuditEntry) (*models.Project, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.projects[id]
+	if !ok || isDeleted(old.Model) {
+		return nil, &ErrNotFound{Resource: ResourceProject}
+	}
+	if err := checkVersion(m.Version, old.Version); err != nil {
+		return nil, err
+	}
+	updated := *old
+	updateNonBlank(&updated, m)
+	updated.Version = old.Version + 1
+	oldKey, key := nameKey(old.OrganizationID, old.Name), nameKey(updated.OrganizationID, updated.Name)
+	if err := s.checkUnique(projectNameIndex, key, id); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	changes := common.Diff(old, &updated)
+	memberships := []models.Membership{}
+	if updated.ManagerID != 0 && updated.ManagerID != old.ManagerID {
+		memberships = managerMemberships(&updated, old.ManagerID)
+		changes["Roles"] = s.roleChange(id, memberships)
+	}
+	describe(entry, models.EntityProject, id, old.OrganizationID, changes)
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	s.setUnique(projectNameIndex, oldKey, key, id)
+	*old = updated
+	for i := range memberships {
+		s.saveMembership(&memberships[i])
+	}
+
+	retVal := updated
+	return &retVal, nil
+}
+
+// UpdateAll will update all fields of a record of models.Project in store,
+// zero values included, new manager becomes owner of project,
+// entry of audit log is written with it
+func (dao *MemoryProjectDAO) UpdateAll(m *models.Project, id uint, entry *models.AuditEntry) (*models.Project, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.projects[id]
+	if !ok || isDeleted(old.Model) {
+		return nil, &ErrNotFound{Resource: ResourceProject}
+	}
+	if err := checkVersion(m.Version, old.Version); err != nil {
+		return nil, err
+	}
+	updated := *old
+	updateAll(&updated, m)
+	updated.Version = old.Version + 1
+	oldKey, key := nameKey(old.OrganizationID, old.Name), nameKey(updated.OrganizationID, updated.Name)
+	if err := s.checkUnique(projectNameIndex, key, id); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	changes := common.Diff(old, &updated)
+	memberships := []models.Membership{}
+	if updated.ManagerID != 0 && updated.ManagerID != old.ManagerID {
+		memberships = managerMemberships(&updated, old.ManagerID)
+		changes["Roles"] = s.roleChange(id, memberships)
+	}
+	describe(entry, models.EntityProject, id, old.OrganizationID, changes)
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	s.setUnique(projectNameIndex, oldKey, key, id)
+	*old = updated
+	for i := range memberships {
+		s.saveMembership(&memberships[i])
+	}
+
+	retVal := updated
+	return &retVal, nil
+}
+
+// Delete will soft-delete a single models.Project,
+// entry of audit log is written with it
+func (dao *MemoryProjectDAO) Delete(m *models.Project, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.projects[m.ID]
+	if ok && m.Version != 0 && (isDeleted(record.Model) || m.Version != record.Version) {
+		return common.ErrVersionMismatch
+	}
+	describe(entry, models.EntityProject, m.ID, m.OrganizationID, common.Diff(m, nil))
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	if ok {
+		softDelete(&record.Model)
+	}
+	return nil
+}
+
+// List will return one page of records of models.Project visible
+// to viewer that match list query and number of all matching records
+func (dao *MemoryProjectDAO) List(viewer *models.User, q common.ListQuery) ([]models.Project, int, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := []models.Project{}
+	for _, id := range sortedIDs(s.projects) {
+		record := s.projects[id]
+		if !isDeleted(record.Model) && s.projectVisible(viewer, record) {
+			m = append(m, *record)
+		}
+	}
+	page, total, err := memoryList(m, q, projectListFields, map[string]memoryFilter{
+		"user": func(record reflect.Value, value interface{}) bool {
+			return s.isMember(uint(value.(uint64)), record.Interface().(models.Project).ID)
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page.([]models.Project), total, nil
+}
+
+// GetAllAssociatedUsers will get all
+// members of project given by parameter
+func (dao *MemoryProjectDAO) GetAllAssociatedUsers(m *models.Project) ([]models.User, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	retVal := []models.User{}
+	for _, id := range sortedIDs(s.users) {
+		record := s.users[id]
+		if !isDeleted(record.Model) && s.isMember(id, m.ID) {
+			retVal = append(retVal, *record)
+		}
+	}
+	return retVal, nil
+}
+
+// AddRisksAssociations will add associations to all risks given by parameter,
+// all of them are added at once, entry of audit log is written with it
+func (dao *MemoryProjectDAO) AddRisksAssociations(m *models.Project, risks []models.Risk, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []uint{}
+	for _, risk := range risks {
+		ids = append(ids, risk.ID)
+	}
+	describe(entry, models.EntityProject, m.ID, m.OrganizationID, models.Changes{"Risks": {New: ids}})
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	for _, risk := range risks {
+		s.riskProjects[memoryLink{risk.ID, m.ID}] = true
+	}
+	return nil
+}
+
+// RemoveRisksAssociations will remove associations to all risks given by
+// parameter, all of them are removed at once,
+// entry of audit log is written with it
+func (dao *MemoryProjectDAO) RemoveRisksAssociations(m *models.Project, risks []models.Risk, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []uint{}
+	for _, risk := range risks {
+		ids = append(ids, risk.ID)
+	}
+	describe(entry, models.EntityProject, m.ID, m.OrganizationID, models.Changes{"Risks": {Old: ids}})
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	for _, risk := range risks {
+		delete(s.riskProjects, memoryLink{risk.ID, m.ID})
+	}
+	return nil
+}
+
+// AddMemberships will save all memberships in project given by parameter
+// at once, entry of audit log is written with them
+func (dao *MemoryProjectDAO) AddMemberships(m *models.Project, memberships []models.Membership, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []uint{}
+	for _, membership := range memberships {
+		ids = append(ids, membership.UserID)
+	}
+	describe(entry, models.EntityProject, m.ID, m.OrganizationID,
+		models.Changes{"Users": {New: ids}, "Roles": s.roleChange(m.ID, memberships)})
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	for i := range memberships {
+		memberships[i].ProjectID = m.ID
+		s.saveMembership(&memberships[i])
+	}
+	return nil
+}
+
+// RemoveMemberships will delete all memberships in project given by
+// parameter at once, entry of audit log is written with them
+func (dao *MemoryProjectDAO) RemoveMemberships(m *models.Project, memberships []models.Membership, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []uint{}
+	for _, membership := range memberships {
+		ids = append(ids, membership.UserID)
+	}
+	describe(entry, models.EntityProject, m.ID, m.OrganizationID, models.Changes{"Users": {Old: ids}})
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	for _, membership := range memberships {
+		delete(s.memberships, memoryLink{membership.UserID, m.ID})
+	}
+	return nil
+}
+
+// GetAllAssociatedVisibleRisks will get all risks assigned to project
+// given by parameter that are visible to viewer
+func (dao *MemoryProjectDAO) GetAllAssociatedVisibleRisks(viewer *models.User, m *models.Project) ([]models.Risk, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	retVal := []models.Risk{}
+	for _, id := range sortedIDs(s.risks) {
+		record := s.risks[id]
+		if !isDeleted(record.Model) && s.riskProjects[memoryLink{id, m.ID}] && s.riskVisible(viewer, record) {
+			retVal = append(retVal, *record)
+		}
+	}
+	return retVal, nil
+}
+
+// ReadVisibleByID will find models.Project by ID given by parameter
+// if it is visible to viewer
+func (dao *MemoryProjectDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.Project, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.projects[id]
+	if !ok || isDeleted(record.Model) || !s.projectVisible(viewer, record) {
+		return nil, &ErrNotFound{Resource: ResourceProject}
+	}
+	m := *record
+	return &m, nil
+}
+
+// MemoryRiskDAO is a data access object to models.Risks in MemoryStore
+type MemoryRiskDAO struct {
+	store *MemoryStore
+}
+
+// NewMemoryRiskDAO creates a new Data Access Object for the
+// models.Risk model in store given by parameter.
+func NewMemoryRiskDAO(store *MemoryStore) *MemoryRiskDAO {
+	return &MemoryRiskDAO{
+		store: store,
+	}
+}
+
+const riskNameIndex = "idx_risks_organization_name"
+
+// Create will create single models.Risk in store, entry of audit log
+// and the first version of risk are written with it
+func (dao *MemoryRiskDAO) Create(m *models.Risk, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := nameKey(m.OrganizationID, m.Name)
+	if err := s.checkUnique(riskNameIndex, key, 0); err != nil {
+		return err
+	}
+	if err := s.createModel("risks", &m.Model); err != nil {
+		return err
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	describe(entry, models.EntityRisk, m.ID, m.OrganizationID, common.Diff(nil, m))
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	record := *m
+	record.Projects = nil
+	record.CounterMeasures = nil
+	s.risks[m.ID] = &record
+	s.setUnique(riskNameIndex, key, key, m.ID)
+	s.createRiskVersion(m, entry)
+	return nil
+}
+
+// Update will update a record of models.Risk in store, scores are computed
+// from the updated record, entry of audit log and next version of risk
+// are written with it
+func (dao *MemoryRiskDAO) Update(m *models.Risk, id uint, entry *models.AuditEntry) (*models.Risk, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.risks[id]
+	if !ok || isDeleted(old.Model) {
+		return nil, &ErrNotFound{Resource: ResourceRisk}
+	}
+	if err := checkVersion(m.Version, old.Version); err != nil {
+		return nil, err
+	}
+	updated := *old
+	updateNonBlank(&updated, m)
+	updated.ComputeScores()
+	updated.Version = old.Version + 1
+	oldKey, key := nameKey(old.OrganizationID, old.Name), nameKey(updated.OrganizationID, updated.Name)
+	if err := s.checkUnique(riskNameIndex, key, id); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	describe(entry, models.EntityRisk, id, old.OrganizationID, common.Diff(old, &updated))
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	s.setUnique(riskNameIndex, oldKey, key, id)
+	*old = updated
+	s.createRiskVersion(old, entry)
+
+	retVal := updated
+	return &retVal, nil
+}
+
+// UpdateStatus will set status of models.Risk with reason of the change,
+// empty reason is stored as well, entry of audit log and next version
+// of risk are written with it
+func (dao *MemoryRiskDAO) UpdateStatus(m *models.Risk, status string, reason string, entry *models.AuditEntry) (*models.Risk, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.risks[m.ID]
+	if !ok || isDeleted(record.Model) || record.Version != m.Version {
+		return nil, common.ErrVersionMismatch
+	}
+	before := *m
+	m.Status = status
+	m.StatusReason = reason
+	m.UpdatedAt = time.Now()
+	m.Version++
+	describe(entry, models.EntityRisk, m.ID, m.OrganizationID, common.Diff(&before, m))
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	record.Status = m.Status
+	record.StatusReason = m.StatusReason
+	record.UpdatedAt = m.UpdatedAt
+	record.Version = m.Version
+	s.createRiskVersion(record, entry)
+
+	return m, nil
+}
+
+// Revert will set values of models.Risk to values of its older state
+// given by parameter, status, owner and organization of risk are kept,
+// entry of audit log and next version of risk are written with it
+func (dao *MemoryRiskDAO) Revert(m *models.Risk, old *models.Risk, entry *models.AuditEntry) (*models.Risk, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.risks[m.ID]
+	if !ok || isDeleted(record.Model) || record.Version != m.Version {
+		return nil, common.ErrVersionMismatch
+	}
+	key := nameKey(record.OrganizationID, old.Name)
+	if err := s.checkUnique(riskNameIndex, key, m.ID); err != nil {
+		return nil, err
+	}
+
+	revert := func(r *models.Risk) {
+		r.Value = old.Value
+		r.Cost = old.Cost
+		r.Probability = old.Probability
+		r.Risk = old.Risk
+		r.Exposure = old.Exposure
+		r.Name = old.Name
+		r.Description = old.Description
+		r.Category = old.Category
+		r.Threat = old.Threat
+		r.Trigger = old.Trigger
+		r.Impact = old.Impact
+		r.Start = old.Start
+		r.End = old.End
+		r.UpdatedAt = time.Now()
+		r.Version++
+	}
+	before := *m
+	revert(m)
+	describe(entry, models.EntityRisk, m.ID, m.OrganizationID, common.Diff(&before, m))
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	s.setUnique(riskNameIndex, nameKey(record.OrganizationID, record.Name), key, m.ID)
+	revert(record)
+	s.createRiskVersion(record, entry)
+
+	return m, nil
+}
+
+// UpdateAll will update all fields of a record of models.Risk in store,
+// zero values included, entry of audit log and next version of risk
+// are written with it
+func (dao *MemoryRiskDAO) UpdateAll(m *models.Risk, id uint, entry *models.AuditEntry) (*models.Risk, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.risks[id]
+	if !ok || isDeleted(old.Model) {
+		return nil, &ErrNotFound{Resource: ResourceRisk}
+	}
+	if err := checkVersion(m.Version, old.Version); err != nil {
+		return nil, err
+	}
+	updated := *old
+	updateAll(&updated, m)
+	updated.Version = old.Version + 1
+	oldKey, key := nameKey(old.OrganizationID, old.Name), nameKey(updated.OrganizationID, updated.Name)
+	if err := s.checkUnique(riskNameIndex, key, id); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	describe(entry, models.EntityRisk, id, old.OrganizationID, common.Diff(old, &updated))
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	s.setUnique(riskNameIndex, oldKey, key, id)
+	*old = updated
+	s.createRiskVersion(old, entry)
+
+	retVal := updated
+	return &retVal, nil
+}
+
+// Delete will soft-delete a single models.Risk,
+// entry of audit log is written with it
+func (dao *MemoryRiskDAO) Delete(m *models.Risk, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.risks[m.ID]
+	if ok && m.Version != 0 && (isDeleted(record.Model) || m.Version != record.Version) {
+		return common.ErrVersionMismatch
+	}
+	describe(entry, models.EntityRisk, m.ID, m.OrganizationID, common.Diff(m, nil))
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	if ok {
+		softDelete(&record.Model)
+	}
+	return nil
+}
+
+// List will return one page of records of models.Risk visible
+// to viewer that match list query and number of all matching records
+func (dao *MemoryRiskDAO) List(viewer *models.User, q common.ListQuery) ([]models.Risk, int, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := []models.Risk{}
+	for _, id := range sortedIDs(s.risks) {
+		record := s.risks[id]
+		if !isDeleted(record.Model) && s.riskVisible(viewer, record) {
+			m = append(m, *record)
+		}
+	}
+	page, total, err := memoryList(m, q, riskListFields, map[string]memoryFilter{
+		"project": func(record reflect.Value, value interface{}) bool {
+			return s.riskProjects[memoryLink{record.Interface().(models.Risk).ID, uint(value.(uint64))}]
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page.([]models.Risk), total, nil
+}
+
+// linkedProjects will return projects risk is assigned to that match
+// condition, caller holds the lock
+func (dao *MemoryRiskDAO) linkedProjects(m *models.Risk, match func(p *models.Project) bool) []models.Project {
+	s := dao.store
+	retVal := []models.Project{}
+	for _, id := range sortedIDs(s.projects) {
+		record := s.projects[id]
+		if !isDeleted(record.Model) && s.riskProjects[memoryLink{m.ID, id}] && match(record) {
+			retVal = append(retVal, *record)
+		}
+	}
+	return retVal
+}
+
+// GetAllAssociatedVisibleProjects will get all projects risk given
+// by parameter is assigned to that are visible to viewer
+func (dao *MemoryRiskDAO) GetAllAssociatedVisibleProjects(viewer *models.User, m *models.Risk) ([]models.Project, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return dao.linkedProjects(m, func(p *models.Project) bool {
+		return s.projectVisible(viewer, p)
+	}), nil
+}
+
+// AddCounterMeasuresAssociations will add associations to all countermeasures
+// given by parameter, all of them are added at once,
+// entry of audit log is written with it
+func (dao *MemoryRiskDAO) AddCounterMeasuresAssociations(m *models.Risk, cms []models.CounterMeasure, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []uint{}
+	for _, cm := range cms {
+		ids = append(ids, cm.ID)
+	}
+	describe(entry, models.EntityRisk, m.ID, m.OrganizationID, models.Changes{"CounterMeasures": {New: ids}})
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	for _, cm := range cms {
+		s.riskCounterMeasures[memoryLink{m.ID, cm.ID}] = true
+	}
+	return nil
+}
+
+// RemoveCounterMeasuresAssociations will remove associations to all
+// countermeasures given by parameter, all of them are removed at once,
+// entry of audit log is written with it
+func (dao *MemoryRiskDAO) RemoveCounterMeasuresAssociations(m *models.Risk, cms []models.CounterMeasure, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []uint{}
+	for _, cm := range cms {
+		ids = append(ids, cm.ID)
+	}
+	describe(entry, models.EntityRisk, m.ID, m.OrganizationID, models.Changes{"CounterMeasures": {Old: ids}})
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	for _, cm := range cms {
+		delete(s.riskCounterMeasures, memoryLink{m.ID, cm.ID})
+	}
+	return nil
+}
+
+// GetAllAssociatedCounterMeasures will get all
+// countermeasures of risk given by parameter
+func (dao *MemoryRiskDAO) GetAllAssociatedCounterMeasures(m *models.Risk) ([]models.CounterMeasure, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	retVal := []models.CounterMeasure{}
+	for _, id := range sortedIDs(s.counterMeasures) {
+		record := s.counterMeasures[id]
+		if !isDeleted(record.Model) && s.riskCounterMeasures[memoryLink{m.ID, id}] {
+			retVal = append(retVal, *record)
+		}
+	}
+	return retVal, nil
+}
+
+// ReadVisibleByID will find models.Risk by ID given by parameter
+// if it is visible to viewer
+func (dao *MemoryRiskDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.Risk, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.risks[id]
+	if !ok || isDeleted(record.Model) || !s.riskVisible(viewer, record) {
+		return nil, &ErrNotFound{Resource: ResourceRisk}
+	}
+	m := *record
+	return &m, nil
+}
+
+// MemoryCounterMeasureDAO is a data access object to models.CounterMeasures
+// in MemoryStore
+type MemoryCounterMeasureDAO struct {
+	store *MemoryStore
+}
+
+// NewMemoryCounterMeasureDAO creates a new Data Access Object for the
+// models.CounterMeasure model in store given by parameter.
+func NewMemoryCounterMeasureDAO(store *MemoryStore) *MemoryCounterMeasureDAO {
+	return &MemoryCounterMeasureDAO{
+		store: store,
+	}
+}
+
+// Create will create single models.CounterMeasure in store,
+// entry of audit log is written with it
+func (dao *MemoryCounterMeasureDAO) Create(m *models.CounterMeasure, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.createModel("counter_measures", &m.Model); err != nil {
+		return err
+	}
+	describe(entry, models.EntityCounterMeasure, m.ID, m.OrganizationID, common.Diff(nil, m))
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	record := *m
+	record.Risks = nil
+	s.counterMeasures[m.ID] = &record
+	return nil
+}
+
+// Update will update a record of models.CounterMeasure in store,
+// entry of audit log is written with it
+func (dao *MemoryCounterMeasureDAO) Update(m *models.CounterMeasure, id uint, entry *models.AuditEntry) (*models.CounterMeasure, error) {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.counterMeasures[id]
+	if !ok || isDeleted(old.Model) {
+		return nil, &ErrNotFound{Resource: ResourceCounterMeasure}
+	}
+	updated := *old
+	updateNonBlank(&updated, m)
+	updated.UpdatedAt = time.Now()
+	describe(entry, models.EntityCounterMeasure, id, old.OrganizationID, common.Diff(old, &updated))
+	if err := s.audit(entry); err != nil {
+		return nil, err
+	}
+	*old = updated
+
+	retVal := updated
+	return &retVal, nil
+}
+
+// Delete will soft-delete a single models.CounterMeasure,
+// entry of audit log is written with it
+func (dao *MemoryCounterMeasureDAO) Delete(m *models.CounterMeasure, entry *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	describe(entry, models.EntityCounterMeasure, m.ID, m.OrganizationID, common.Diff(m, nil))
+	if err := s.audit(entry); err != nil {
+		return err
+	}
+	if record, ok := s.counterMeasures[m.ID]; ok {
+		softDelete(&record.Model)
+	}
+	return nil
+}
+
+// List will return one page of records of models.CounterMeasure visible
+// to viewer that match list query and number of all matching records
+func (dao *MemoryCounterMeasureDAO) List(viewer *models.User, q common.ListQuery) ([]models.CounterMeasure, int, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := []models.CounterMeasure{}
+	for _, id := range sortedIDs(s.counterMeasures) {
+		record := s.counterMeasures[id]
+		if !isDeleted(record.Model) && s.counterMeasureVisible(viewer, record) {
+			m = append(m, *record)
+		}
+	}
+	page, total, err := memoryList(m, q, counterMeasureListFields, map[string]memoryFilter{
+		"risk": func(record reflect.Value, value interface{}) bool {
+			return s.riskCounterMeasures[memoryLink{uint(value.(uint64)), record.Interface().(models.CounterMeasure).ID}]
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page.([]models.CounterMeasure), total, nil
+}
+
+// GetAllAssociatedVisibleRisks will get all risks countermeasure given
+// by parameter is used for that are visible to viewer
+func (dao *MemoryCounterMeasureDAO) GetAllAssociatedVisibleRisks(viewer *models.User, m *models.CounterMeasure) ([]models.Risk, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	retVal := []models.Risk{}
+	for _, id := range sortedIDs(s.risks) {
+		record := s.risks[id]
+		if !isDeleted(record.Model) && s.riskCounterMeasures[memoryLink{id, m.ID}] && s.riskVisible(viewer, record) {
+			retVal = append(retVal, *record)
+		}
+	}
+	return retVal, nil
+}
+
+// ReadVisibleByID will find models.CounterMeasure by ID given by parameter
+// if it is visible to viewer
+func (dao *MemoryCounterMeasureDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.CounterMeasure, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.counterMeasures[id]
+	if !ok || isDeleted(record.Model) || !s.counterMeasureVisible(viewer, record) {
+		return nil, &ErrNotFound{Resource: ResourceCounterMeasure}
+	}
+	m := *record
+	return &m, nil
+}
+
+// MemoryMembershipDAO is a data access object to models.Memberships
+// in MemoryStore
+type MemoryMembershipDAO struct {
+	store *MemoryStore
+}
+
+// NewMemoryMembershipDAO creates a new Data Access Object for the
+// models.Membership model in store given by parameter.
+func NewMemoryMembershipDAO(store *MemoryStore) *MemoryMembershipDAO {
+	return &MemoryMembershipDAO{
+		store: store,
+	}
+}
+
+// roleChange will return change of roles of users of memberships in
+// project with projectID, users who are not members have no old role,
+// caller holds the lock
+func (s *MemoryStore) roleChange(projectID uint, memberships []models.Membership) models.Change {
+	old, new := map[uint]string{}, map[uint]string{}
+	for _, membership := range memberships {
+		if previous, ok := s.memberships[memoryLink{membership.UserID, projectID}]; ok {
+			old[membership.UserID] = previous.Role
+		}
+		new[membership.UserID] = membership.Role
+	}
+	return roleChange(old, new)
+}
+
+// saveMembership will create or update membership, caller holds the lock
+func (s *MemoryStore) saveMembership(m *models.Membership) {
+	link := memoryLink{m.UserID, m.ProjectID}
+	if m.CreatedAt.IsZero() {
+		if old, ok := s.memberships[link]; ok {
+			m.CreatedAt = old.CreatedAt
+		} else {
+			m.CreatedAt = time.Now()
+		}
+	}
+	record := *m
+	s.memberships[link] = &record
+}
+
+// Save will create models.Membership in store or update role
+// of existing one
+func (dao *MemoryMembershipDAO) Save(m *models.Membership) error {
+	dao.store.mu.Lock()
+	defer dao.store.mu.Unlock()
+
+	dao.store.saveMembership(m)
+	return nil
+}
+
+// Delete will delete a single models.Membership
+func (dao *MemoryMembershipDAO) Delete(m *models.Membership) error {
+	dao.store.mu.Lock()
+	defer dao.store.mu.Unlock()
+
+	delete(dao.store.memberships, memoryLink{m.UserID, m.ProjectID})
+	return nil
+}
+
+// Read will find membership of user with userID in project with projectID
+func (dao *MemoryMembershipDAO) Read(userID uint, projectID uint) (*models.Membership, error) {
+	dao.store.mu.RLock()
+	defer dao.store.mu.RUnlock()
+
+	record, ok := dao.store.memberships[memoryLink{userID, projectID}]
+	if !ok {
+		return nil, &ErrNotFound{Resource: ResourceMembership}
+	}
+	m := *record
+	return &m, nil
+}
+
+// GetAllOfProject will return all memberships in project with ID given
+// by parameter ordered by IDs of users
+func (dao *MemoryMembershipDAO) GetAllOfProject(projectID uint) ([]models.Membership, error) {
+	dao.store.mu.RLock()
+	defer dao.store.mu.RUnlock()
+
+	m := []models.Membership{}
+	for link, record := range dao.store.memberships {
+		if link.to == projectID {
+			m = append(m, *record)
+		}
+	}
+	sort.Slice(m, func(i, j int) bool {
+		return m[i].UserID < m[j].UserID
+	})
+	return m, nil
+}
+
+// BestRole will return the most privileged role user with userID has in any
+// of projects given by parameter, empty string if he is not member of any
+func (dao *MemoryMembershipDAO) BestRole(userID uint, projectIDs []uint) (string, error) {
+	dao.store.mu.RLock()
+	defer dao.store.mu.RUnlock()
+
+	best := ""
+	for _, projectID := range projectIDs {
+		record, ok := dao.store.memberships[memoryLink{userID, projectID}]
+		if ok && models.ProjectRoleRank(record.Role) > models.ProjectRoleRank(best) {
+			best = record.Role
+		}
+	}
+	return best, nil
+}
+
+// MemorySessionDAO is a data access object to models.Sessions in MemoryStore
+type MemorySessionDAO struct {
+	store *MemoryStore
+}
+
+// NewMemorySessionDAO creates a new Data Access Object for the
+// models.Session model in store given by parameter.
+func NewMemorySessionDAO(store *MemoryStore) *MemorySessionDAO {
+	return &MemorySessionDAO{
+		store: store,
+	}
+}
+
+const (
+	sessionTokenIndex = "sessions.refresh_token_hash"
+	rotatedTokenIndex = "rotated_refresh_tokens.hash"
+)
+
+// Create will create single models.Session in store.
+func (dao *MemorySessionDAO) Create(m *models.Session) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(sessionTokenIndex, m.RefreshTokenHash, 0); err != nil {
+		return err
+	}
+	if err := s.createModel("sessions", &m.Model); err != nil {
+		return err
+	}
+	record := *m
+	s.sessions[m.ID] = &record
+	s.setUnique(sessionTokenIndex, m.RefreshTokenHash, m.RefreshTokenHash, m.ID)
+	return nil
+}
+
+// Rotate will replace refresh token of session by the one with hash given
+// by parameter and extend its expiration. The session is changed only when
+// it is not revoked and still has refresh token with hash old, otherwise
+// the token was rotated in the meantime and common.ErrRefreshTokenReused is
+// returned. Hash old is kept, ReadByRotatedRefreshTokenHash finds its session
+func (dao *MemorySessionDAO) Rotate(m *models.Session, old string, hash string, expiresAt time.Time) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.sessions[m.ID]
+	if !ok || isDeleted(record.Model) || record.Revoked || record.RefreshTokenHash != old {
+		return common.ErrRefreshTokenReused
+	}
+	if err := s.checkUnique(sessionTokenIndex, hash, m.ID); err != nil {
+		return err
+	}
+	if err := s.checkUnique(rotatedTokenIndex, old, 0); err != nil {
+		return err
+	}
+	record.RefreshTokenHash = hash
+	record.ExpiresAt = expiresAt
+	record.UpdatedAt = time.Now()
+	s.setUnique(sessionTokenIndex, old, hash, m.ID)
+	s.setUnique(rotatedTokenIndex, old, old, m.ID)
+
+	m.RefreshTokenHash = hash
+	m.ExpiresAt = expiresAt
+	return nil
+}
+
+// ReadByID will find models.Session by ID given by parameter
+func (dao *MemorySessionDAO) ReadByID(id uint) (*models.Session, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.sessions[id]
+	if !ok || isDeleted(record.Model) {
+		return nil, &ErrNotFound{Resource: ResourceSession}
+	}
+	m := *record
+	return &m, nil
+}
+
+// ReadByRefreshTokenHash will find models.Session by hash of its refresh token
+func (dao *MemorySessionDAO) ReadByRefreshTokenHash(hash string) (*models.Session, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.unique[sessionTokenIndex][hash]
+	if !ok || isDeleted(s.sessions[id].Model) {
+		return nil, &ErrNotFound{Resource: ResourceSession}
+	}
+	m := *s.sessions[id]
+	return &m, nil
+}
+
+// ReadByRotatedRefreshTokenHash will find models.Session whose refresh token
+// with hash given by parameter was already rotated
+func (dao *MemorySessionDAO) ReadByRotatedRefreshTokenHash(hash string) (*models.Session, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.unique[rotatedTokenIndex][hash]
+	if !ok || isDeleted(s.sessions[id].Model) {
+		return nil, &ErrNotFound{Resource: ResourceSession}
+	}
+	m := *s.sessions[id]
+	return &m, nil
+}
+
+// Revoke will revoke a single models.Session
+func (dao *MemorySessionDAO) Revoke(m *models.Session) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m.Revoked = true
+	m.UpdatedAt = time.Now()
+	if record, ok := s.sessions[m.ID]; ok && !isDeleted(record.Model) {
+		record.Revoked = true
+		record.UpdatedAt = m.UpdatedAt
+	}
+	return nil
+}
+
+// RevokeAllOfUser will revoke all sessions of user with ID given by parameter
+func (dao *MemorySessionDAO) RevokeAllOfUser(userID uint) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, record := range s.sessions {
+		if !isDeleted(record.Model) && record.UserID == userID && !record.Revoked {
+			record.Revoked = true
+			record.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+// MemoryAuditDAO is a data access object to models.AuditEntries
+// in MemoryStore, the log is append-only
+type MemoryAuditDAO struct {
+	store *MemoryStore
+}
+
+// NewMemoryAuditDAO creates a new Data Access Object for the
+// models.AuditEntry model in store given by parameter.
+func NewMemoryAuditDAO(store *MemoryStore) *MemoryAuditDAO {
+	return &MemoryAuditDAO{
+		store: store,
+	}
+}
+
+// Create will create single models.AuditEntry in store.
+func (dao *MemoryAuditDAO) Create(m *models.AuditEntry) error {
+	s := dao.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.audit(m)
+}
+
+// List will return one page of entries of audit log visible to viewer that
+// match list query and number of all matching entries, the newest entries
+// are first unless other order is requested
+func (dao *MemoryAuditDAO) List(viewer *models.User, q common.ListQuery) ([]models.AuditEntry, int, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := []models.AuditEntry{}
+	for _, id := range sortedIDs(s.auditEntries) {
+		record := s.auditEntries[id]
+		if s.auditEntryVisible(viewer, record) {
+			m = append(m, *record)
+		}
+	}
+	page, total, err := memoryList(m, q, auditListFields, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return page.([]models.AuditEntry), total, nil
+}
+
+// MemoryRiskVersionDAO is a data access object to models.RiskVersions
+// in MemoryStore, versions are created by MemoryRiskDAO
+type MemoryRiskVersionDAO struct {
+	store *MemoryStore
+}
+
+// NewMemoryRiskVersionDAO creates a new Data Access Object for the
+// models.RiskVersion model in store given by parameter.
+func NewMemoryRiskVersionDAO(store *MemoryStore) *MemoryRiskVersionDAO {
+	return &MemoryRiskVersionDAO{
+		store: store,
+	}
+}
+
+// ofRisk will return versions of risk ordered by version,
+// caller holds the lock
+func (dao *MemoryRiskVersionDAO) ofRisk(riskID uint) []models.RiskVersion {
+	m := []models.RiskVersion{}
+	for _, record := range dao.store.riskVersions {
+		if record.RiskID == riskID {
+			m = append(m, *record)
+		}
+	}
+	sort.Slice(m, func(i, j int) bool {
+		return m[i].Version < m[j].Version
+	})
+	return m
+}
+
+// GetAllOfRisk will return all versions of risk with ID given by parameter,
+// the oldest version is first
+func (dao *MemoryRiskVersionDAO) GetAllOfRisk(riskID uint) ([]models.RiskVersion, error) {
+	dao.store.mu.RLock()
+	defer dao.store.mu.RUnlock()
+
+	return dao.ofRisk(riskID), nil
+}
+
+// Read will find version of risk given by parameters
+func (dao *MemoryRiskVersionDAO) Read(riskID uint, version uint) (*models.RiskVersion, error) {
+	dao.store.mu.RLock()
+	defer dao.store.mu.RUnlock()
+
+	for _, m := range dao.ofRisk(riskID) {
+		if m.Version == version {
+			return &m, nil
+		}
+	}
+	return nil, &ErrNotFound{Resource: ResourceRiskVersion}
+}
+
+// ReadAsOf will find version of risk that was current at time given
+// by parameter
+func (dao *MemoryRiskVersionDAO) ReadAsOf(riskID uint, at time.Time) (*models.RiskVersion, error) {
+	dao.store.mu.RLock()
+	defer dao.store.mu.RUnlock()
+
+	versions := dao.ofRisk(riskID)
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].CreatedAt.After(at) {
+			return &versions[i], nil
+		}
+	}
+	return nil, &ErrNotFound{Resource: ResourceRiskVersion}
+}
+
+// MemorySearchDAO searches risks, projects and users in MemoryStore
+// the same way SearchDAO does with LIKE
+type MemorySearchDAO struct {
+	store *MemoryStore
+}
+
+// NewMemorySearchDAO creates a new Data Access Object for searching
+// in store given by parameter.
+func NewMemorySearchDAO(store *MemoryStore) *MemorySearchDAO {
+	return &MemorySearchDAO{
+		store: store,
+	}
+}
+
+// Search will return records of types given by parameter (all types when
+// empty) that match query and are visible to viewer, the best results
+// are first
+func (dao *MemorySearchDAO) Search(viewer *models.User, query string, types []string, limit int) ([]SearchResult, error) {
+	s := dao.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return rankSearch(query, types, limit, func(source searchSource, terms []string) ([]SearchResult, error) {
+		records := []interface{}{}
+		switch source.entity {
+		case models.EntityRisk:
+			for _, id := range sortedIDs(s.risks) {
+				if r := s.risks[id]; !isDeleted(r.Model) && s.riskVisible(viewer, r) {
+					records = append(records, r)
+				}
+			}
+		case models.EntityProject:
+			for _, id := range sortedIDs(s.projects) {
+				if p := s.projects[id]; !isDeleted(p.Model) && s.projectVisible(viewer, p) {
+					records = append(records, p)
+				}
+			}
+		case models.EntityUser:
+			for _, id := range sortedIDs(s.users) {
+				if u := s.users[id]; !isDeleted(u.Model) && s.userVisible(viewer, u) {
+					records = append(records, u)
+				}
+			}
+		}
+
+		results := []SearchResult{}
+		for _, record := range records {
+			v := reflect.ValueOf(record).Elem()
+			score := 0
+			for _, term := range terms {
+				term = strings.ToLower(term)
+				for i, column := range source.columns {
+					value, _ := columnValue(v, column)
+					if !strings.Contains(strings.ToLower(value.String()), term) {
+						continue
+					}
+					if i == 0 {
+						score += searchNameWeight
+					} else {
+						score++
+					}
+				}
+			}
+			if score == 0 {
+				continue
+			}
+			id, _ := columnValue(v, "id")
+			name, _ := columnValue(v, "name")
+			results = append(results, SearchResult{
+				ID:    uint(id.Uint()),
+				Name:  name.String(),
+				Score: float64(score),
+			})
+		}
+
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Score > results[j].Score
+		})
+		if len(results) > limit {
+			results = results[:limit]
+		}
+		return results, nil
+	})
+}
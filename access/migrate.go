@@ -0,0 +1,231 @@
+package access
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// Migration is a numbered change of DB schema and its data. Up and Down
+// run in a transaction (MySQL commits changes of schema on its own).
+// Migrations must not use current models, which change with later
+// migrations, they use their own frozen copies of models (see baseline.go)
+// or plain SQL. The ones converting data pass on a fresh DB
+type Migration struct {
+	Version     uint
+	Description string
+	Up          func(tx *gorm.DB) error
+	// nil if migration cannot be reverted
+	Down func(tx *gorm.DB) error
+}
+
+// MigrationStatus describes whether a migration is applied to DB
+type MigrationStatus struct {
+	Version     uint
+	Description string
+	Applied     bool
+	AppliedAt   *time.Time
+	// migration is applied but this version of fitlogic does not know it
+	Unknown bool
+}
+
+// Migrator applies migrations to DB and records them
+// in models.SchemaVersion
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a new Migrator of DB, migrations are applied
+// in order of their versions
+func NewMigrator(db *gorm.DB, migrations []Migration) *Migrator {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+	}
+}
+
+// applied will return applied migrations by their versions, table
+// of applied migrations is created when it does not exist
+func (m *Migrator) applied() (map[uint]models.SchemaVersion, error) {
+	if err := m.db.AutoMigrate(&models.SchemaVersion{}).Error; err != nil {
+		return nil, err
+	}
+
+	versions := []models.SchemaVersion{}
+	if err := m.db.Order("version").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	applied := map[uint]models.SchemaVersion{}
+	for _, v := range versions {
+		applied[v.Version] = v
+	}
+
+	return applied, nil
+}
+
+// Status will return all migrations known to this version and all
+// applied ones ordered by version
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	status := []MigrationStatus{}
+	known := map[uint]bool{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		s := MigrationStatus{
+			Version:     migration.Version,
+			Description: migration.Description,
+		}
+		if v, ok := applied[migration.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &v.AppliedAt
+		}
+		status = append(status, s)
+	}
+	for version, v := range applied {
+		if known[version] {
+			continue
+		}
+		appliedAt := v.AppliedAt
+		status = append(status, MigrationStatus{
+			Version:     version,
+			Description: v.Description,
+			Applied:     true,
+			AppliedAt:   &appliedAt,
+			Unknown:     true,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+
+	return status, nil
+}
+
+// Pending will return migrations that are not applied yet
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Check will return common.ErrPendingMigrations if some migrations are not
+// applied or common.ErrUnknownMigration if DB was migrated by newer version
+func (m *Migrator) Check() error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range status {
+		if s.Unknown {
+			return common.ErrUnknownMigration
+		}
+		if !s.Applied {
+			return common.ErrPendingMigrations
+		}
+	}
+
+	return nil
+}
+
+// Up will apply all pending migrations, each in its own transaction,
+// and return the applied ones. It stops at the first failed migration
+func (m *Migrator) Up() ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, migration := range pending {
+		tx := m.db.Begin()
+		if err := migration.Up(tx); err != nil {
+			tx.Rollback()
+			return done, err
+		}
+		err := tx.Create(&models.SchemaVersion{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return done, err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down will revert the last applied migration and return it
+func (m *Migrator) Down() (*Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var last *MigrationStatus
+	for i := range status {
+		if status[i].Applied {
+			last = &status[i]
+		}
+	}
+	if last == nil {
+		return nil, common.ErrNoAppliedMigration
+	}
+	if last.Unknown {
+		return nil, common.ErrUnknownMigration
+	}
+
+	var migration Migration
+	for _, known := range m.migrations {
+		if known.Version == last.Version {
+			migration = known
+		}
+	}
+	if migration.Down == nil {
+		return nil, common.ErrIrreversibleMigration
+	}
+
+	tx := m.db.Begin()
+	if err := migration.Down(tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Delete(&models.SchemaVersion{Version: migration.Version}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return &migration, nil
+}
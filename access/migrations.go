@@ -0,0 +1,842 @@
+package access
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+)
+
+// Migrations are all migrations of DB, versions of released ones never
+// change and new ones are appended with the next version
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "baseline schema, converts data of versions without migrations",
+		Up:          migrateBaseline,
+		// versions of fitlogic before migrations cannot be restored
+		Down: nil,
+	},
+	{
+		Version:     2,
+		Description: "dates of projects and risks are timestamps instead of strings in TimeFormat",
+		Up:          MigrateDatesToTimestamps,
+		Down:        MigrateTimestampsToDates,
+	},
+	{
+		Version:     3,
+		Description: "versions of users, projects and risks for conditional updates by If-Match",
+		Up:          MigrateVersions,
+		Down:        DropVersions,
+	},
+	{
+		Version:     4,
+		Description: "hashes of rotated refresh tokens, reusing one revokes its session",
+		Up:          MigrateRotatedRefreshTokens,
+		Down:        DropRotatedRefreshTokens,
+	},
+	{
+		Version:     5,
+		Description: "exposure of risks is computed from their value, cost is used when value is not set",
+		Up:          MigrateExposureFromValue,
+		Down:        MigrateExposureFromCost,
+	},
+	{
+		Version:     6,
+		Description: "roles of users start at 1, so zero is not a valid role, roles in audit log keep numbers they were written with",
+		Up:          MigrateRolesFromOne,
+		Down:        MigrateRolesFromZero,
+	},
+}
+
+// migrateBaseline will create tables of frozen baseline models and convert
+// data stored by versions before migrations, every step does nothing on
+// a fresh DB
+func migrateBaseline(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(baselineModels...).Error; err != nil {
+		return err
+	}
+
+	steps := []func(*gorm.DB) error{
+		MigrateMembershipRoles,
+		MigrateOrganizations,
+		// inline countermeasures have to be converted before risks are rebuilt
+		// without their columns
+		MigrateInlineCounterMeasures,
+		MigrateNamesUniqueInOrganization,
+		MigrateRiskScores,
+		MigrateRiskStatuses,
+		MigrateRiskVersions,
+	}
+	for _, step := range steps {
+		if err := step(tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateMembershipRoles will set roles of memberships created before users
+// had roles in projects, project managers become owners and others editors
+func MigrateMembershipRoles(db *gorm.DB) error {
+	err := db.Exec(`UPDATE user_projects SET role = ?
+		WHERE (role IS NULL OR role = '')
+		AND user_id IN (SELECT manager_id FROM projects WHERE projects.id = user_projects.project_id)`,
+		baselineProjectRoleOwner).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Exec(`UPDATE user_projects SET role = ? WHERE role IS NULL OR role = ''`,
+		baselineProjectRoleEditor).Error
+}
+
+// MigrateOrganizations will create the default organization and move into it
+// users, projects and risks created before there were organizations. Admins
+// of such installation managed everything, so they become super-admins
+func MigrateOrganizations(db *gorm.DB) error {
+	org := &baselineOrganization{}
+	err := db.Where(&baselineOrganization{Name: baselineOrganizationName}).First(org).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	if err == nil {
+		return nil
+	}
+
+	org.Name = baselineOrganizationName
+	if err := db.Create(org).Error; err != nil {
+		return err
+	}
+
+	for _, table := range []string{"users", "projects", "risks"} {
+		err := db.Exec("UPDATE "+table+" SET organization_id = ? WHERE organization_id IS NULL OR organization_id = 0",
+			org.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.Exec("UPDATE users SET role = ? WHERE role = ?", baselineRoleSuperAdmin, baselineRoleAdmin).Error
+}
+
+// MigrateNamesUniqueInOrganization will drop unique constraints of names
+// of projects and risks created by older versions and create unique indexes
+// of names in organization, names are unique only in organization now
+func MigrateNamesUniqueInOrganization(db *gorm.DB) error {
+	for _, table := range []string{"projects", "risks"} {
+		if err := dropUniqueName(db, table); err != nil {
+			return err
+		}
+		err := db.Table(table).AddUniqueIndex("idx_"+table+"_organization_name", "organization_id", "name").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropUniqueName will drop unique constraint of column name of table, it is
+// a constraint named by Postgres, an index named by column in MySQL and a part
+// of definition of column in SQLite, which cannot drop it, so the table is rebuilt
+func dropUniqueName(db *gorm.DB, table string) error {
+	quoted := db.Dialect().Quote(table)
+
+	switch db.Dialect().GetName() {
+	case DriverSqlite:
+		return rebuildSqliteTable(db, table)
+	case DriverPostgres:
+		return db.Exec("ALTER TABLE " + quoted + " DROP CONSTRAINT IF EXISTS " + db.Dialect().Quote(table+"_name_key")).Error
+	default:
+		if !db.Dialect().HasIndex(table, "name") {
+			return nil
+		}
+		return db.Exec("ALTER TABLE " + quoted + " DROP INDEX " + db.Dialect().Quote("name")).Error
+	}
+}
+
+// uniqueColumn matches unique constraint in definition of a column
+var uniqueColumn = regexp.MustCompile(`(?i)\s+UNIQUE\b`)
+
+// rebuildSqliteTable will recreate table without unique constraints
+// of its columns, the table keeps its other columns, indexes and data
+func rebuildSqliteTable(db *gorm.DB, table string) error {
+	definition, indexes, err := sqliteTable(db, table)
+	if err != nil {
+		return err
+	}
+	if !uniqueColumn.MatchString(definition) {
+		return nil
+	}
+
+	return recreateSqliteTable(db, table, uniqueColumn.ReplaceAllLiteralString(definition, ""), indexes, "*")
+}
+
+// inlineCounterMeasure is a countermeasure stored in columns of a risk
+// before countermeasures were separate records
+type inlineCounterMeasure struct {
+	RiskID         uint
+	OrganizationID uint
+	RiskName       string
+	Cost           int
+	Description    string
+}
+
+// MigrateInlineCounterMeasures will convert countermeasures stored in columns
+// of risks to countermeasures associated with the risk. The columns are
+// cleared afterwards, so every countermeasure is converted only once
+func MigrateInlineCounterMeasures(db *gorm.DB) error {
+	if !db.Dialect().HasColumn("risks", "counter_measure_desc") {
+		return nil
+	}
+
+	rows, err := db.Raw(`SELECT id, organization_id, name,
+		COALESCE(counter_measure_cost, 0), COALESCE(counter_measure_desc, '') FROM risks
+		WHERE counter_measure_used = ? OR counter_measure_cost <> 0 OR counter_measure_desc <> ''`, true).Rows()
+	if err != nil {
+		return err
+	}
+	inline := []inlineCounterMeasure{}
+	for rows.Next() {
+		cm := inlineCounterMeasure{}
+		if err := rows.Scan(&cm.RiskID, &cm.OrganizationID, &cm.RiskName, &cm.Cost, &cm.Description); err != nil {
+			rows.Close()
+			return err
+		}
+		inline = append(inline, cm)
+	}
+	rows.Close()
+	if len(inline) == 0 {
+		return nil
+	}
+
+	for _, old := range inline {
+		cm := baselineCounterMeasure{
+			OrganizationID: old.OrganizationID,
+			Name:           "Countermeasure of " + old.RiskName,
+			Description:    old.Description,
+			Cost:           old.Cost,
+		}
+		if err := db.Create(&cm).Error; err != nil {
+			return err
+		}
+		err := db.Exec("INSERT INTO risk_counter_measures (risk_id, counter_measure_id) VALUES (?, ?)",
+			old.RiskID, cm.ID).Error
+		if err != nil {
+			return err
+		}
+		err = db.Exec(`UPDATE risks SET counter_measure_used = ?, counter_measure_cost = 0,
+			counter_measure_desc = '' WHERE id = ?`, false, old.RiskID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateRiskScores will recompute risk scores and exposures of risks
+// whose stored values differ from the computed ones, older versions
+// stored the score sent by client
+func MigrateRiskScores(db *gorm.DB) error {
+	risks := []baselineRisk{}
+	if err := db.Unscoped().Find(&risks).Error; err != nil {
+		return err
+	}
+
+	for i := range risks {
+		risk, exposure := risks[i].Risk, risks[i].Exposure
+		risks[i].computeScores()
+		if risk == risks[i].Risk && exposure == risks[i].Exposure {
+			continue
+		}
+		err := db.Unscoped().Model(&risks[i]).UpdateColumns(map[string]interface{}{
+			"risk":     risks[i].Risk,
+			"exposure": risks[i].Exposure,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// legacyRiskStatuses maps free-form statuses used before risks had
+// a lifecycle to statuses of the lifecycle
+var legacyRiskStatuses = map[string]string{
+	"":          baselineRiskStatusIdentified,
+	"new":       baselineRiskStatusIdentified,
+	"open":      baselineRiskStatusIdentified,
+	"active":    baselineRiskStatusIdentified,
+	"analyzed":  baselineRiskStatusAnalysed,
+	"mitigated": baselineRiskStatusMonitored,
+}
+
+// isBaselineRiskStatus will return whether status is in lifecycle of risks
+func isBaselineRiskStatus(status string) bool {
+	for _, s := range baselineRiskStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateRiskStatuses will move risks with statuses unknown to the lifecycle
+// to a status of the lifecycle, unrecognized ones become identified
+func MigrateRiskStatuses(db *gorm.DB) error {
+	rows, err := db.Raw("SELECT DISTINCT COALESCE(status, '') FROM risks").Rows()
+	if err != nil {
+		return err
+	}
+	statuses := []string{}
+	for rows.Next() {
+		status := ""
+		if err := rows.Scan(&status); err != nil {
+			rows.Close()
+			return err
+		}
+		statuses = append(statuses, status)
+	}
+	rows.Close()
+
+	for _, status := range statuses {
+		if isBaselineRiskStatus(status) {
+			continue
+		}
+		normalized := strings.ToLower(strings.TrimSpace(status))
+		if legacy, ok := legacyRiskStatuses[normalized]; ok {
+			normalized = legacy
+		}
+		if !isBaselineRiskStatus(normalized) {
+			normalized = baselineRiskStatusIdentified
+		}
+
+		err := db.Exec("UPDATE risks SET status = ? WHERE COALESCE(status, '') = ?", normalized, status).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateRiskVersions will store current state of risks that have
+// no versions yet as their first version, history of risks starts there
+func MigrateRiskVersions(db *gorm.DB) error {
+	risks := []baselineRisk{}
+	err := db.Where("id NOT IN (SELECT risk_id FROM risk_versions)").Find(&risks).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range risks {
+		data, err := json.Marshal(risks[i])
+		if err != nil {
+			return err
+		}
+		// the state is current since the last update of risk
+		version := &baselineRiskVersion{
+			CreatedAt: risks[i].UpdatedAt,
+			RiskID:    risks[i].ID,
+			Version:   1,
+			Data:      string(data),
+		}
+		if err := db.Create(version).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionedTables are tables whose records have versions since migration 3
+var versionedTables = []string{"users", "projects", "risks"}
+
+// versionColumn is the column of versions added by migration 3
+type versionColumn struct {
+	Version uint `gorm:"not null;default:1"`
+}
+
+// frozenColumnType will return type of column of field of frozen model
+func frozenColumnType(db *gorm.DB, model interface{}, name string) (string, string) {
+	field, _ := db.NewScope(model).FieldByName(name)
+	return field.DBName, db.Dialect().DataTypeOf(field.StructField)
+}
+
+// MigrateVersions will add column of versions to users, projects and risks,
+// existing records get version 1 by default value of the column
+func MigrateVersions(db *gorm.DB) error {
+	column, typ := frozenColumnType(db, &versionColumn{}, "Version")
+	for _, table := range versionedTables {
+		if db.Dialect().HasColumn(table, column) {
+			continue
+		}
+		err := db.Exec("ALTER TABLE " + db.Dialect().Quote(table) + " ADD " + db.Dialect().Quote(column) + " " + typ).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DropVersions will drop column of versions of users, projects and risks
+func DropVersions(db *gorm.DB) error {
+	column, _ := frozenColumnType(db, &versionColumn{}, "Version")
+	for _, table := range versionedTables {
+		var err error
+		if db.Dialect().GetName() == DriverSqlite {
+			err = dropSqliteColumn(db, table, column)
+		} else {
+			err = db.Table(table).DropColumn(column).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotatedRefreshToken is a row of table of rotated refresh tokens
+// as migration 4 creates it
+type rotatedRefreshToken struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	SessionID uint   `gorm:"index"`
+	Hash      string `gorm:"unique_index"`
+}
+
+func (rotatedRefreshToken) TableName() string { return "rotated_refresh_tokens" }
+
+// MigrateRotatedRefreshTokens will create table of hashes of rotated refresh
+// tokens, tokens rotated before it are not known and reusing them is not
+// recognised
+func MigrateRotatedRefreshTokens(db *gorm.DB) error {
+	return db.AutoMigrate(&rotatedRefreshToken{}).Error
+}
+
+// DropRotatedRefreshTokens will drop table of hashes of rotated refresh tokens
+func DropRotatedRefreshTokens(db *gorm.DB) error {
+	return db.DropTableIfExists(&rotatedRefreshToken{}).Error
+}
+
+// MigrateExposureFromValue will recompute exposure of risks as probability
+// times value, or cost when value is not set. Stored versions of risks keep
+// exposure they had, it is recomputed when risk is reverted to them
+func MigrateExposureFromValue(db *gorm.DB) error {
+	return db.Exec(`UPDATE risks SET exposure = COALESCE(probability, 0) *
+		CASE WHEN COALESCE(value, 0) <> 0 THEN value ELSE COALESCE(cost, 0) END`).Error
+}
+
+// MigrateExposureFromCost will recompute exposure of risks as probability
+// times cost like the baseline did
+func MigrateExposureFromCost(db *gorm.DB) error {
+	return db.Exec("UPDATE risks SET exposure = COALESCE(probability, 0) * COALESCE(cost, 0)").Error
+}
+
+// MigrateRolesFromOne will move roles of users one up, super-admin has
+// role 1 instead of 0. Sessions are revoked, because their tokens carry
+// the old roles. Audit log is append-only, so roles in its entries are
+// not moved, versions of risks do not contain roles
+func MigrateRolesFromOne(db *gorm.DB) error {
+	return shiftRoles(db, 1)
+}
+
+// MigrateRolesFromZero will move roles of users one down like the baseline
+// numbered them, sessions are revoked and audit log is kept as it is
+func MigrateRolesFromZero(db *gorm.DB) error {
+	return shiftRoles(db, -1)
+}
+
+// shiftRoles will add offset to roles of users and revoke all sessions
+func shiftRoles(db *gorm.DB, offset int) error {
+	if err := db.Exec("UPDATE users SET role = role + ?", offset).Error; err != nil {
+		return err
+	}
+	return db.Exec("UPDATE sessions SET revoked = ? WHERE revoked = ?", true, false).Error
+}
+
+// DefaultTimeFormat is TimeFormat of older versions used when it is
+// not configured
+const DefaultTimeFormat = "02-01-2006"
+
+// dateTables are tables whose dates Start and End were stored as strings
+// in TimeFormat before migration 2
+var dateTables = []string{"projects", "risks"}
+
+// timestampDates are dates of projects and risks as migration 2 stores them
+type timestampDates struct {
+	Start time.Time
+	End   time.Time
+}
+
+var dateColumns = []string{"start", "end"}
+
+// storedDates are dates of a record as they are stored in DB
+type storedDates struct {
+	ID    uint
+	Start interface{}
+	End   interface{}
+}
+
+// configuredTimeFormat will return TimeFormat from configuration
+func configuredTimeFormat() string {
+	if format := viper.GetString("TimeFormat"); format != "" {
+		return format
+	}
+	return DefaultTimeFormat
+}
+
+// MigrateDatesToTimestamps will change type of dates of projects and risks
+// to timestamps, strings are parsed by TimeFormat from configuration.
+// Dates in stored versions of risks are converted as well
+func MigrateDatesToTimestamps(db *gorm.DB) error {
+	layout := configuredTimeFormat()
+	_, typ := frozenColumnType(db, &timestampDates{}, "Start")
+
+	for _, table := range dateTables {
+		table := table
+		isTimestamp, err := isTimestampColumn(db, table, "start")
+		if err != nil {
+			return err
+		}
+		if isTimestamp {
+			continue
+		}
+
+		err = convertDateColumns(db, table, typ, func(value interface{}) (interface{}, error) {
+			s := ""
+			switch v := value.(type) {
+			case string:
+				s = v
+			case []byte:
+				s = string(v)
+			}
+			if s == "" {
+				return time.Time{}, nil
+			}
+			t, err := time.Parse(layout, s)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot parse date %q of %s with TimeFormat %q", s, table, layout)
+			}
+			return t, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return convertRiskVersionDates(db, func(s string) (string, error) {
+		if s == "" {
+			return time.Time{}.Format(time.RFC3339Nano), nil
+		}
+		if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return s, nil
+		}
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return "", fmt.Errorf("Cannot parse date %q of risk version with TimeFormat %q", s, layout)
+		}
+		return t.Format(time.RFC3339Nano), nil
+	})
+}
+
+// MigrateTimestampsToDates will change dates of projects and risks back
+// to strings in TimeFormat from configuration
+func MigrateTimestampsToDates(db *gorm.DB) error {
+	layout := configuredTimeFormat()
+
+	for _, table := range dateTables {
+		err := convertDateColumns(db, table, "varchar(255)", func(value interface{}) (interface{}, error) {
+			t, ok := value.(time.Time)
+			if !ok || t.IsZero() {
+				return "", nil
+			}
+			return t.Format(layout), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return convertRiskVersionDates(db, func(s string) (string, error) {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return s, nil
+		}
+		if t.IsZero() {
+			return "", nil
+		}
+		return t.Format(layout), nil
+	})
+}
+
+// convertDateColumns will change type of date columns of table to typ
+// and write back their values converted by convert
+func convertDateColumns(db *gorm.DB, table string, typ string, convert func(interface{}) (interface{}, error)) error {
+	quoted := db.Dialect().Quote(table)
+	start, end := db.Dialect().Quote(dateColumns[0]), db.Dialect().Quote(dateColumns[1])
+
+	rows, err := db.Raw("SELECT id, " + start + ", " + end + " FROM " + quoted).Rows()
+	if err != nil {
+		return err
+	}
+	stored := []storedDates{}
+	for rows.Next() {
+		dates := storedDates{}
+		if err := rows.Scan(&dates.ID, &dates.Start, &dates.End); err != nil {
+			rows.Close()
+			return err
+		}
+		stored = append(stored, dates)
+	}
+	rows.Close()
+
+	if err := changeColumnTypes(db, table, dateColumns, typ); err != nil {
+		return err
+	}
+
+	for _, dates := range stored {
+		newStart, err := convert(dates.Start)
+		if err != nil {
+			return err
+		}
+		newEnd, err := convert(dates.End)
+		if err != nil {
+			return err
+		}
+		err = db.Exec("UPDATE "+quoted+" SET "+start+" = ?, "+end+" = ? WHERE id = ?", newStart, newEnd, dates.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// convertRiskVersionDates will convert dates Start and End in states
+// of risks stored in versions
+func convertRiskVersionDates(db *gorm.DB, convert func(string) (string, error)) error {
+	rows, err := db.Raw("SELECT id, data FROM risk_versions").Rows()
+	if err != nil {
+		return err
+	}
+	data := map[uint]string{}
+	for rows.Next() {
+		var id uint
+		var d sql.NullString
+		if err := rows.Scan(&id, &d); err != nil {
+			rows.Close()
+			return err
+		}
+		data[id] = d.String
+	}
+	rows.Close()
+
+	for id, d := range data {
+		state := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(d), &state); err != nil {
+			return err
+		}
+		for _, key := range []string{"Start", "End"} {
+			s, ok := state[key].(string)
+			if !ok {
+				continue
+			}
+			converted, err := convert(s)
+			if err != nil {
+				return err
+			}
+			state[key] = converted
+		}
+		b, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := db.Exec("UPDATE risk_versions SET data = ? WHERE id = ?", string(b), id).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isTimestampColumn will return whether column of table has type
+// of date and time
+func isTimestampColumn(db *gorm.DB, table, column string) (bool, error) {
+	typ := ""
+	switch db.Dialect().GetName() {
+	case DriverSqlite:
+		rows, err := db.Raw("PRAGMA table_info(" + db.Dialect().Quote(table) + ")").Rows()
+		if err != nil {
+			return false, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var cid, notNull, pk int
+			var name, columnType string
+			var defaultValue sql.NullString
+			if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+				return false, err
+			}
+			if name == column {
+				typ = columnType
+			}
+		}
+	default:
+		schema := "CURRENT_SCHEMA()"
+		if db.Dialect().GetName() == DriverMysql {
+			schema = "DATABASE()"
+		}
+		row := db.Raw("SELECT data_type FROM information_schema.columns WHERE table_schema = "+schema+
+			" AND table_name = ? AND column_name = ?", table, column).Row()
+		if err := row.Scan(&typ); err != nil {
+			return false, err
+		}
+	}
+
+	typ = strings.ToLower(typ)
+	return strings.Contains(typ, "time") || strings.Contains(typ, "date"), nil
+}
+
+// changeColumnTypes will change type of columns of table to typ, values
+// of the columns are lost and have to be written again
+func changeColumnTypes(db *gorm.DB, table string, columns []string, typ string) error {
+	quoted := db.Dialect().Quote(table)
+
+	switch db.Dialect().GetName() {
+	case DriverSqlite:
+		return changeSqliteColumnTypes(db, table, columns, typ)
+	case DriverPostgres:
+		for _, column := range columns {
+			err := db.Exec("ALTER TABLE " + quoted + " ALTER COLUMN " + db.Dialect().Quote(column) + " TYPE " + typ + " USING NULL").Error
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		for _, column := range columns {
+			if err := db.Exec("UPDATE " + quoted + " SET " + db.Dialect().Quote(column) + " = NULL").Error; err != nil {
+				return err
+			}
+			if err := db.Exec("ALTER TABLE " + quoted + " MODIFY COLUMN " + db.Dialect().Quote(column) + " " + typ).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// changeSqliteColumnTypes will change declared type of columns, SQLite cannot
+// alter a column, so the table is recreated from its changed definition
+// with its indexes and data
+func changeSqliteColumnTypes(db *gorm.DB, table string, columns []string, typ string) error {
+	definition, indexes, err := sqliteTable(db, table)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		re := regexp.MustCompile(`"` + column + `"\s+[A-Za-z]+(\(\d+\))?`)
+		if !re.MatchString(definition) {
+			return fmt.Errorf("Column %s not found in definition of %s", column, table)
+		}
+		definition = re.ReplaceAllLiteralString(definition, `"`+column+`" `+typ)
+	}
+
+	return recreateSqliteTable(db, table, definition, indexes, "*")
+}
+
+// dropSqliteColumn will drop column of table, SQLite before 3.35 cannot drop
+// a column, so the table is recreated without it with its indexes and data
+func dropSqliteColumn(db *gorm.DB, table string, column string) error {
+	definition, indexes, err := sqliteTable(db, table)
+	if err != nil {
+		return err
+	}
+	re := regexp.MustCompile(`,\s*"` + column + `"[^,)]*`)
+	if !re.MatchString(definition) {
+		return fmt.Errorf("Column %s not found in definition of %s", column, table)
+	}
+	definition = re.ReplaceAllLiteralString(definition, "")
+
+	columns := []string{}
+	rows, err := db.Raw("SELECT name FROM pragma_table_info(?)", table).Rows()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		name := ""
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if name != column {
+			columns = append(columns, db.Dialect().Quote(name))
+		}
+	}
+	rows.Close()
+
+	return recreateSqliteTable(db, table, definition, indexes, strings.Join(columns, ", "))
+}
+
+// sqliteTable will return definition of table and definitions of its indexes
+func sqliteTable(db *gorm.DB, table string) (string, []string, error) {
+	definition := ""
+	row := db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Row()
+	if err := row.Scan(&definition); err != nil {
+		return "", nil, err
+	}
+
+	indexes := []string{}
+	rows, err := db.Raw("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).Rows()
+	if err != nil {
+		return "", nil, err
+	}
+	for rows.Next() {
+		index := ""
+		if err := rows.Scan(&index); err != nil {
+			rows.Close()
+			return "", nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	rows.Close()
+
+	return definition, indexes, nil
+}
+
+// recreateSqliteTable will replace table by a new one created by definition,
+// columns (a list or *) are copied to it and indexes are created again
+func recreateSqliteTable(db *gorm.DB, table string, definition string, indexes []string, columns string) error {
+	quoted, recreated := db.Dialect().Quote(table), db.Dialect().Quote(table+"_new")
+	definition = "CREATE TABLE " + recreated + " " + definition[strings.Index(definition, "("):]
+	insert := "INSERT INTO " + recreated + " SELECT " + columns + " FROM " + quoted
+	if columns != "*" {
+		insert = "INSERT INTO " + recreated + " (" + columns + ") SELECT " + columns + " FROM " + quoted
+	}
+	statements := []string{
+		definition,
+		insert,
+		"DROP TABLE " + quoted,
+		"ALTER TABLE " + recreated + " RENAME TO " + quoted,
+	}
+	for _, statement := range append(statements, indexes...) {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// currentModels are all models stored in DB
+var currentModels = []interface{}{
+	&models.Organization{}, &models.User{}, &models.Project{}, &models.Membership{}, &models.Risk{},
+	&models.CounterMeasure{}, &models.Session{}, &models.RotatedRefreshToken{}, &models.AuditEntry{}, &models.RiskVersion{},
+}
+
+func TestBaselineIsFrozen(t *testing.T) {
+	forEachDB(t, testBaselineIsFrozen)
+}
+
+func testBaselineIsFrozen(t *testing.T, driver string) {
+	db := newTestDB(t, driver)
+	if _, err := NewMigrator(db, Migrations[:1]).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// dates are converted by migration 2 and versions added by migration 3
+	isTimestamp, err := isTimestampColumn(db, "risks", "start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isTimestamp {
+		t.Error("baseline created dates of risks as timestamps")
+	}
+	if db.Dialect().HasColumn("risks", "version") {
+		t.Error("baseline created versions of risks")
+	}
+}
+
+func TestMigrationsCreateColumnsOfModels(t *testing.T) {
+	forEachDB(t, testMigrationsCreateColumnsOfModels)
+}
+
+func testMigrationsCreateColumnsOfModels(t *testing.T, driver string) {
+	db := newMigratedDB(t, driver)
+
+	for _, model := range currentModels {
+		scope := db.NewScope(model)
+		for _, field := range scope.GetModelStruct().StructFields {
+			if !field.IsNormal || field.IsIgnored {
+				continue
+			}
+			if !db.Dialect().HasColumn(scope.TableName(), field.DBName) {
+				t.Errorf("column %s of %s is not created by migrations", field.DBName, scope.TableName())
+			}
+		}
+	}
+	for _, table := range []string{"risk_projects", "risk_counter_measures"} {
+		if !db.Dialect().HasTable(table) {
+			t.Errorf("table %s is not created by migrations", table)
+		}
+	}
+}
+
+func TestDownStopsAtBaseline(t *testing.T) {
+	forEachDB(t, testDownStopsAtBaseline)
+}
+
+func testDownStopsAtBaseline(t *testing.T, driver string) {
+	db := newMigratedDB(t, driver)
+	migrator := NewMigrator(db, Migrations)
+
+	for i := len(Migrations); i > 1; i-- {
+		migration, err := migrator.Down()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migration.Version != uint(i) {
+			t.Fatalf("expected down of migration %d, got %d", i, migration.Version)
+		}
+	}
+	if _, err := migrator.Down(); err != common.ErrIrreversibleMigration {
+		t.Fatalf("expected %v, got %v", common.ErrIrreversibleMigration, err)
+	}
+	if !db.Dialect().HasTable("risks") {
+		t.Error("down of baseline dropped tables")
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(Migrations)-1 {
+		t.Errorf("expected %d migrations applied again, got %d", len(Migrations)-1, len(applied))
+	}
+}
+
+// legacySchema are tables as versions of fitlogic before migrations
+// created them, names of projects and risks were unique, dates were
+// strings and countermeasures were columns of risks
+var legacySchema = []string{
+	`CREATE TABLE "users" ("id" {id}, "created_at" {datetime}, "updated_at" {datetime},
+		"deleted_at" {datetime}, "name" varchar(255), "email" varchar(255) UNIQUE, "password" varchar(255),
+		"role" integer, "skills" varchar(255), "status" varchar(255))`,
+	`CREATE TABLE "projects" ("id" {id}, "created_at" {datetime}, "updated_at" {datetime},
+		"deleted_at" {datetime}, "name" varchar(255) UNIQUE, "description" varchar(255), "start" varchar(255),
+		"end" varchar(255), "is_finished" boolean, "manager_id" integer)`,
+	`CREATE TABLE "risks" ("id" {id}, "created_at" {datetime}, "updated_at" {datetime},
+		"deleted_at" {datetime}, "name" varchar(255) UNIQUE, "description" varchar(255), "status" varchar(255),
+		"value" {real}, "cost" integer, "probability" {real}, "risk" {real}, "exposure" {real}, "impact" {real},
+		"start" varchar(255), "end" varchar(255), "user_id" integer, "counter_measure_used" boolean,
+		"counter_measure_cost" integer, "counter_measure_desc" varchar(255))`,
+	`CREATE TABLE "user_projects" ("user_id" integer, "project_id" integer, PRIMARY KEY ("user_id", "project_id"))`,
+	`CREATE TABLE "risk_projects" ("risk_id" integer, "project_id" integer, PRIMARY KEY ("risk_id", "project_id"))`,
+}
+
+// legacyTypes are types of columns of legacySchema older versions
+// created in every dialect
+var legacyTypes = map[string]*strings.Replacer{
+	DriverSqlite: strings.NewReplacer("{id}", "integer primary key autoincrement", "{datetime}", "datetime",
+		"{real}", "real"),
+	DriverPostgres: strings.NewReplacer("{id}", "serial primary key", "{datetime}", "timestamp with time zone",
+		"{real}", "numeric"),
+}
+
+var legacyData = []string{
+	`INSERT INTO users (id, name, email, role) VALUES (1, 'admin', 'admin@example.com', 1), (2, 'user', 'user@example.com', 3)`,
+	`INSERT INTO projects (id, name, start, "end", manager_id) VALUES (1, 'Project', '01-02-2019', '', 1)`,
+	`INSERT INTO user_projects (user_id, project_id) VALUES (1, 1), (2, 1)`,
+	`INSERT INTO risks (id, name, status, cost, probability, risk, exposure, impact, start, user_id,
+		counter_measure_used, counter_measure_cost, counter_measure_desc)
+		VALUES (1, 'Risk', 'open', 100, 0.5, 9, 9, 2, '31-12-2019', 2, TRUE, 40, 'Backups')`,
+	`INSERT INTO risk_projects (risk_id, project_id) VALUES (1, 1)`,
+}
+
+// newLegacyDB will return a temporary DB of driver with tables and data
+// of an older version
+func newLegacyDB(t *testing.T, driver string) *gorm.DB {
+	t.Helper()
+
+	db := newTestDB(t, driver)
+	statements := []string{}
+	for _, statement := range legacySchema {
+		statements = append(statements, legacyTypes[driver].Replace(statement))
+	}
+	statements = append(statements, legacyData...)
+	if driver == DriverPostgres {
+		// records were inserted with IDs, so sequences have to continue after them
+		for _, table := range []string{"users", "projects", "risks"} {
+			statements = append(statements, "SELECT setval(pg_get_serial_sequence('"+table+"', 'id'), MAX(id)) FROM "+table)
+		}
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestMigrationsConvertLegacyDB(t *testing.T) {
+	forEachDB(t, testMigrationsConvertLegacyDB)
+}
+
+func testMigrationsConvertLegacyDB(t *testing.T, driver string) {
+	db := newLegacyDB(t, driver)
+	if _, err := NewMigrator(db, Migrations).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	org := models.Organization{}
+	if err := db.Where("name = ?", models.DefaultOrganizationName).First(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	admin := models.User{}
+	if err := db.First(&admin, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if admin.Role != models.RoleSuperAdmin || admin.OrganizationID != org.ID || admin.Version != 1 {
+		t.Errorf("admin is not migrated: %+v", admin)
+	}
+
+	roles := map[uint]string{}
+	memberships := []models.Membership{}
+	if err := db.Find(&memberships).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range memberships {
+		roles[m.UserID] = m.Role
+	}
+	user := models.User{}
+	if err := db.First(&user, 2).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleUser {
+		t.Errorf("role of user is %d, expected %d", user.Role, models.RoleUser)
+	}
+	if roles[1] != models.ProjectRoleOwner || roles[2] != models.ProjectRoleEditor {
+		t.Errorf("unexpected roles of memberships %v", roles)
+	}
+
+	risk := models.Risk{}
+	if err := db.Preload("CounterMeasures").First(&risk, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !risk.Start.Equal(time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("start of risk is %v", risk.Start)
+	}
+	if risk.Status != models.RiskStatusIdentified {
+		t.Errorf("status of risk is %q", risk.Status)
+	}
+	// risk without value has exposure computed from cost
+	if risk.Risk != 1 || risk.Exposure != 50 || risk.OrganizationID != org.ID {
+		t.Errorf("risk is not migrated: %+v", risk)
+	}
+	if len(risk.CounterMeasures) != 1 || risk.CounterMeasures[0].Cost != 40 {
+		t.Errorf("inline countermeasure is not converted: %+v", risk.CounterMeasures)
+	}
+
+	version := models.RiskVersion{}
+	if err := db.Where("risk_id = ?", 1).First(&version).Error; err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != 1 || version.Data.Name != "Risk" || !version.Data.Start.Equal(risk.Start) {
+		t.Errorf("first version of risk is %+v", version)
+	}
+
+	// names are unique only in organization
+	if err := db.Create(&models.Project{Name: "Project", OrganizationID: org.ID + 1}).Error; err != nil {
+		t.Errorf("name of project is unique across organizations: %v", err)
+	}
+	if err := db.Create(&models.Risk{Name: "Risk", OrganizationID: org.ID + 1}).Error; err != nil {
+		t.Errorf("name of risk is unique across organizations: %v", err)
+	}
+	assertUniqueName(t, db, &models.Project{Name: "Project", OrganizationID: org.ID})
+	assertUniqueName(t, db, &models.Risk{Name: "Risk", OrganizationID: org.ID})
+}
+
+func TestNamesUniqueInOrganization(t *testing.T) {
+	forEachDB(t, testNamesUniqueInOrganization)
+}
+
+func testNamesUniqueInOrganization(t *testing.T, driver string) {
+	db := newLegacyDB(t, driver)
+	if _, err := NewMigrator(db, Migrations[:1]).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// indexes are created by the migration, not only by tags of models
+	for _, table := range []string{"projects", "risks"} {
+		if err := db.Table(table).RemoveIndex("idx_" + table + "_organization_name").Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := MigrateNamesUniqueInOrganization(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, table := range []string{"projects", "risks"} {
+		if !db.Dialect().HasIndex(table, "idx_"+table+"_organization_name") {
+			t.Errorf("unique index of names of %s in organization is not created", table)
+		}
+	}
+	assertUniqueName(t, db, &baselineProject{Name: "Project", OrganizationID: 1})
+	if err := db.Create(&baselineProject{Name: "Project", OrganizationID: 2}).Error; err != nil {
+		t.Errorf("name of project is unique across organizations: %v", err)
+	}
+}
+
+func TestExposureDownAndUp(t *testing.T) {
+	forEachDB(t, testExposureDownAndUp)
+}
+
+func testExposureDownAndUp(t *testing.T, driver string) {
+	db := newTestDB(t, driver)
+	migrator := NewMigrator(db, Migrations[:5])
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	for _, risk := range []*models.Risk{
+		{Name: "valued", Value: 1000, Cost: 100, Probability: 0.5},
+		{Name: "costed", Cost: 100, Probability: 0.5},
+	} {
+		if err := db.Create(risk).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	exposures := func() string {
+		t.Helper()
+		risks := []models.Risk{}
+		if err := db.Order("id").Find(&risks).Error; err != nil {
+			t.Fatal(err)
+		}
+		m := []float64{}
+		for _, r := range risks {
+			m = append(m, r.Exposure)
+		}
+		return fmt.Sprint(m)
+	}
+
+	if _, err := migrator.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if got := exposures(); got != "[50 50]" {
+		t.Errorf("exposures computed from cost are %s", got)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if got := exposures(); got != "[500 50]" {
+		t.Errorf("exposures computed from value are %s", got)
+	}
+}
+
+func TestRolesDownAndUp(t *testing.T) {
+	forEachDB(t, testRolesDownAndUp)
+}
+
+func testRolesDownAndUp(t *testing.T, driver string) {
+	db := newTestDB(t, driver)
+	migrator := NewMigrator(db, Migrations[:6])
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Name: "user", Email: "user@fitlogic.test", Role: models.RoleUser}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	session := &models.Session{UserID: user.ID, RefreshTokenHash: "hash"}
+	if err := db.Create(session).Error; err != nil {
+		t.Fatal(err)
+	}
+	state := func() string {
+		t.Helper()
+		if err := db.First(user, user.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.First(session, session.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(user.Role, " ", session.Revoked)
+	}
+
+	if _, err := migrator.Down(); err != nil {
+		t.Fatal(err)
+	}
+	// tokens of sessions carry roles, so they are revoked
+	if got, expected := state(), fmt.Sprint(models.RoleUser-1, " true"); got != expected {
+		t.Errorf("role and revocation of session are %s, expected %s", got, expected)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := state(), fmt.Sprint(models.RoleUser, " true"); got != expected {
+		t.Errorf("role and revocation of session are %s, expected %s", got, expected)
+	}
+}
+
+func TestDatesDownAndUp(t *testing.T) {
+	forEachDB(t, testDatesDownAndUp)
+}
+
+func testDatesDownAndUp(t *testing.T, driver string) {
+	db := newLegacyDB(t, driver)
+	migrator := NewMigrator(db, Migrations[:2])
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Down(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := ""
+	if err := db.Raw("SELECT start FROM risks WHERE id = 1").Row().Scan(&start); err != nil {
+		t.Fatal(err)
+	}
+	if start != "31-12-2019" {
+		t.Errorf("expected date in TimeFormat, got %q", start)
+	}
+	version := ""
+	if err := db.Raw("SELECT data FROM risk_versions WHERE risk_id = 1").Row().Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(version, `"Start":"31-12-2019"`) {
+		t.Errorf("date of version is not in TimeFormat: %s", version)
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	isTimestamp, err := isTimestampColumn(db, "risks", "start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isTimestamp {
+		t.Error("dates of risks are not timestamps after migration 2")
+	}
+}
+
+// assertUniqueName will check that record with name used in its
+// organization cannot be created
+func assertUniqueName(t *testing.T, db *gorm.DB, record interface{}) {
+	t.Helper()
+	if err := db.LogMode(false).Create(record).Error; err == nil {
+		t.Errorf("duplicate name in organization is created: %+v", record)
+	}
+}
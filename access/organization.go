@@ -0,0 +1,117 @@
+package access
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// OrganizationDAO is a data access object to a database containing models.Organizations
+type OrganizationDAO struct {
+	db *gorm.DB
+}
+
+// NewOrganizationDAO creates a new Data Access Object for the
+// models.Organization model.
+func NewOrganizationDAO(db *gorm.DB) *OrganizationDAO {
+	return &OrganizationDAO{
+		db: db,
+	}
+}
+
+// Create will create single models.Organization in database.
+func (dao *OrganizationDAO) Create(m *models.Organization) error {
+	if err := dao.db.Create(m).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update will update a record of models.Organization in DB
+func (dao *OrganizationDAO) Update(m *models.Organization, id uint) (*models.Organization, error) {
+	oldVal, err := dao.ReadByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dao.db.Model(&oldVal).Updates(m).Error; err != nil {
+		return nil, err
+	}
+	return oldVal, nil
+}
+
+// Delete will soft-delete a single models.Organization
+func (dao *OrganizationDAO) Delete(m *models.Organization) error {
+	if err := dao.db.Delete(m).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetAll will return all records of models.Organization in database
+// that are visible to viewer
+func (dao *OrganizationDAO) GetAll(viewer *models.User) ([]models.Organization, error) {
+	m := []models.Organization{}
+	cond, args := visibleOrganizationsCondition(viewer)
+	if err := scoped(dao.db, cond, args).Find(&m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// List will return one page of records of models.Organization visible
+// to viewer that match list query and number of all matching records
+func (dao *OrganizationDAO) List(viewer *models.User, q common.ListQuery) ([]models.Organization, int, error) {
+	m := []models.Organization{}
+	cond, args := visibleOrganizationsCondition(viewer)
+	total, err := listQuery(scoped(dao.db, cond, args), &models.Organization{}, &m, q, organizationListFields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return m, total, nil
+}
+
+// ReadByID will find models.Organization by ID given by parameter
+func (dao *OrganizationDAO) ReadByID(id uint) (*models.Organization, error) {
+	m := &models.Organization{}
+	if err := dao.db.First(&m, id).Error; err != nil {
+		return nil, notFound(err, ResourceOrganization)
+	}
+
+	return m, nil
+}
+
+// ReadVisibleByID will find models.Organization by ID given by parameter
+// if it is visible to viewer
+func (dao *OrganizationDAO) ReadVisibleByID(viewer *models.User, id uint) (*models.Organization, error) {
+	m := &models.Organization{}
+	cond, args := visibleOrganizationsCondition(viewer)
+	if err := scoped(dao.db, cond, args).First(&m, id).Error; err != nil {
+		return nil, notFound(err, ResourceOrganization)
+	}
+
+	return m, nil
+}
+
+// ReadByName will find models.Organization by its name
+func (dao *OrganizationDAO) ReadByName(name string) (*models.Organization, error) {
+	m := &models.Organization{}
+	if err := dao.db.Where(&models.Organization{Name: name}).First(&m).Error; err != nil {
+		return nil, notFound(err, ResourceOrganization)
+	}
+
+	return m, nil
+}
+
+// CountUsers will return number of users in organization given by parameter
+func (dao *OrganizationDAO) CountUsers(m *models.Organization) (int, error) {
+	count := 0
+	err := dao.db.Model(&models.User{}).Where("organization_id = ?", m.ID).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
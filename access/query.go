@@ -0,0 +1,276 @@
+package access
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+)
+
+// listFilter is a filter of list endpoint, its condition has one
+// placeholder for value parsed by parse
+type listFilter struct {
+	cond  string
+	parse func(string) (interface{}, error)
+}
+
+// listFields describes filters and sortable fields of a list endpoint,
+// sorts maps names of fields to DB columns
+type listFields struct {
+	filters map[string]listFilter
+	sorts   map[string]string
+	// order used when no sort is sent
+	defaultSort string
+}
+
+func parseString(value string) (interface{}, error) {
+	return value, nil
+}
+
+func parseInt(value string) (interface{}, error) {
+	return strconv.Atoi(value)
+}
+
+func parseUint(value string) (interface{}, error) {
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func parseBool(value string) (interface{}, error) {
+	return strconv.ParseBool(value)
+}
+
+func parseTime(value string) (interface{}, error) {
+	return time.Parse(time.RFC3339, value)
+}
+
+// ListParams are query parameters of a list endpoint used in its docs,
+// filters map names to types of their values (string, integer, boolean
+// or date-time)
+type ListParams struct {
+	Filters map[string]string
+	Sorts   []string
+}
+
+// params will return query parameters of list endpoint
+func (fields listFields) params() ListParams {
+	types := map[uintptr]string{
+		reflect.ValueOf(parseString).Pointer(): "string",
+		reflect.ValueOf(parseInt).Pointer():    "integer",
+		reflect.ValueOf(parseUint).Pointer():   "integer",
+		reflect.ValueOf(parseBool).Pointer():   "boolean",
+		reflect.ValueOf(parseTime).Pointer():   "date-time",
+	}
+
+	params := ListParams{Filters: map[string]string{}}
+	for name, filter := range fields.filters {
+		params.Filters[name] = types[reflect.ValueOf(filter.parse).Pointer()]
+	}
+	for name := range fields.sorts {
+		params.Sorts = append(params.Sorts, name)
+	}
+	sort.Strings(params.Sorts)
+
+	return params
+}
+
+// filterQuery will restrict query by filters of list query, unknown
+// filters and values in wrong format give common.ErrInvalidQueryParam
+func filterQuery(query *gorm.DB, q common.ListQuery, fields listFields) (*gorm.DB, error) {
+	for name, raw := range q.Filters {
+		filter, ok := fields.filters[name]
+		if !ok {
+			return nil, common.ErrInvalidQueryParam
+		}
+		value, err := filter.parse(raw)
+		if err != nil {
+			return nil, common.ErrInvalidQueryParam
+		}
+		query = query.Where(filter.cond, value)
+	}
+
+	return query, nil
+}
+
+// pageQuery will sort and page query by list query, records are sorted
+// by ID when no other order is given so pages are stable
+func pageQuery(query *gorm.DB, q common.ListQuery, fields listFields) (*gorm.DB, error) {
+	if len(q.Sort) == 0 && fields.defaultSort != "" {
+		query = query.Order(fields.defaultSort)
+	}
+	for _, sort := range q.Sort {
+		column, ok := fields.sorts[sort.Field]
+		if !ok {
+			return nil, common.ErrInvalidQueryParam
+		}
+		if sort.Desc {
+			column += " desc"
+		}
+		query = query.Order(column)
+	}
+	query = query.Order("id")
+
+	return query.Limit(q.Limit).Offset(q.Offset), nil
+}
+
+// listQuery will apply list query to query of model given by parameter,
+// find one page of records to out and return number of all records
+// matching filters
+func listQuery(query *gorm.DB, model interface{}, out interface{}, q common.ListQuery, fields listFields) (int, error) {
+	query, err := filterQuery(query, q, fields)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	if err := query.Model(model).Count(&total).Error; err != nil {
+		return 0, err
+	}
+
+	query, err = pageQuery(query, q, fields)
+	if err != nil {
+		return 0, err
+	}
+	if err := query.Find(out).Error; err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// filters of records by time of their creation and last update
+var timestampFilters = map[string]listFilter{
+	"createdFrom": {"created_at >= ?", parseTime},
+	"createdTo":   {"created_at <= ?", parseTime},
+	"updatedFrom": {"updated_at >= ?", parseTime},
+	"updatedTo":   {"updated_at <= ?", parseTime},
+}
+
+// dateRangeFilters will return filters of records by their start and end,
+// columns are qualified by table because end is a keyword
+func dateRangeFilters(table string, filters map[string]listFilter) map[string]listFilter {
+	filters["startFrom"] = listFilter{table + ".start >= ?", parseTime}
+	filters["startTo"] = listFilter{table + ".start <= ?", parseTime}
+	filters["endFrom"] = listFilter{table + ".end >= ?", parseTime}
+	filters["endTo"] = listFilter{table + ".end <= ?", parseTime}
+	return filters
+}
+
+// withTimestampFilters will add timestamp filters to filters
+func withTimestampFilters(filters map[string]listFilter) map[string]listFilter {
+	for name, filter := range timestampFilters {
+		filters[name] = filter
+	}
+	return filters
+}
+
+var organizationListFields = listFields{
+	filters: withTimestampFilters(map[string]listFilter{}),
+	sorts: map[string]string{
+		"id":        "id",
+		"name":      "name",
+		"createdAt": "created_at",
+	},
+}
+
+var userListFields = listFields{
+	filters: withTimestampFilters(map[string]listFilter{
+		"role":         {"role = ?", parseInt},
+		"status":       {"status = ?", parseString},
+		"organization": {"organization_id = ?", parseUint},
+		"project":      {"users.id IN (SELECT user_id FROM user_projects WHERE project_id = ?)", parseUint},
+	}),
+	sorts: map[string]string{
+		"id":        "id",
+		"name":      "name",
+		"email":     "email",
+		"role":      "role",
+		"createdAt": "created_at",
+	},
+}
+
+var projectListFields = listFields{
+	filters: dateRangeFilters("projects", withTimestampFilters(map[string]listFilter{
+		"manager":      {"manager_id = ?", parseUint},
+		"isFinished":   {"is_finished = ?", parseBool},
+		"organization": {"organization_id = ?", parseUint},
+		"user":         {"projects.id IN (SELECT project_id FROM user_projects WHERE user_id = ?)", parseUint},
+	})),
+	sorts: map[string]string{
+		"id":         "id",
+		"name":       "name",
+		"managerId":  "manager_id",
+		"isFinished": "is_finished",
+		"start":      "projects.start",
+		"end":        "projects.end",
+		"createdAt":  "created_at",
+		"updatedAt":  "updated_at",
+	},
+}
+
+var riskListFields = listFields{
+	filters: dateRangeFilters("risks", withTimestampFilters(map[string]listFilter{
+		"status":       {"status = ?", parseString},
+		"category":     {"category = ?", parseString},
+		"user":         {"user_id = ?", parseUint},
+		"organization": {"organization_id = ?", parseUint},
+		"project":      {"risks.id IN (SELECT risk_id FROM risk_projects WHERE project_id = ?)", parseUint},
+	})),
+	sorts: map[string]string{
+		"id":          "id",
+		"name":        "name",
+		"status":      "status",
+		"category":    "category",
+		"probability": "probability",
+		"impact":      "impact",
+		"risk":        "risk",
+		"exposure":    "exposure",
+		"cost":        "cost",
+		"value":       "value",
+		"start":       "risks.start",
+		"end":         "risks.end",
+		"createdAt":   "created_at",
+		"updatedAt":   "updated_at",
+	},
+}
+
+var counterMeasureListFields = listFields{
+	filters: withTimestampFilters(map[string]listFilter{
+		"risk": {"counter_measures.id IN (SELECT counter_measure_id FROM risk_counter_measures WHERE risk_id = ?)", parseUint},
+	}),
+	sorts: map[string]string{
+		"id":        "id",
+		"name":      "name",
+		"cost":      "cost",
+		"createdAt": "created_at",
+	},
+}
+
+var auditListFields = listFields{
+	filters: map[string]listFilter{
+		"entity":   {"entity_type = ?", parseString},
+		"entityId": {"entity_id = ?", parseUint},
+		"actor":    {"actor_id = ?", parseUint},
+		"from":     {"created_at >= ?", parseTime},
+		"to":       {"created_at <= ?", parseTime},
+	},
+	sorts: map[string]string{
+		"id":        "id",
+		"createdAt": "created_at",
+		"actor":     "actor_id",
+		"entity":    "entity_type",
+	},
+	defaultSort: "created_at desc, id desc",
+}
+
+// query parameters of list endpoints
+var (
+	OrganizationListParams   = organizationListFields.params()
+	UserListParams           = userListFields.params()
+	ProjectListParams        = projectListFields.params()
+	RiskListParams           = riskListFields.params()
+	CounterMeasureListParams = counterMeasureListFields.params()
+	AuditListParams          = auditListFields.params()
+)
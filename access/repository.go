@@ -0,0 +1,195 @@
+package access
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// Repositories are the methods of data access objects used by controllers.
+// They are implemented by DAOs over gorm and by DAOs over MemoryStore,
+// records that are not found give *ErrNotFound in both. Records and their
+// associations are read only for a viewer, except users, organizations and
+// sessions read by ID to authenticate requests and check existence. Changes
+// of users, projects, risks and countermeasures are written with their
+// entries of audit log given by callers
+
+// OrganizationRepository is a repository of models.Organization
+type OrganizationRepository interface {
+	Create(m *models.Organization) error
+	Update(m *models.Organization, id uint) (*models.Organization, error)
+	Delete(m *models.Organization) error
+	List(viewer *models.User, q common.ListQuery) ([]models.Organization, int, error)
+	ReadByID(id uint) (*models.Organization, error)
+	ReadVisibleByID(viewer *models.User, id uint) (*models.Organization, error)
+	ReadByName(name string) (*models.Organization, error)
+	CountUsers(m *models.Organization) (int, error)
+}
+
+// UserRepository is a repository of models.User
+type UserRepository interface {
+	Create(m *models.User, entry *models.AuditEntry) error
+	Update(m *models.User, id uint, entry *models.AuditEntry) (*models.User, error)
+	UpdateAll(m *models.User, id uint, entry *models.AuditEntry) (*models.User, error)
+	UpdatePassword(id uint, hash string) error
+	Delete(m *models.User, entry *models.AuditEntry) error
+	GetAll(viewer *models.User) ([]models.User, error)
+	List(viewer *models.User, q common.ListQuery) ([]models.User, int, error)
+	GetAllAssociatedVisibleProjects(viewer *models.User, m *models.User) ([]models.Project, error)
+	GetAllAssociatedVisibleRisks(viewer *models.User, m *models.User) ([]models.Risk, error)
+	ReadByEmail(email string) ([]models.User, error)
+	ReadByID(id uint) (*models.User, error)
+	ReadVisibleByID(viewer *models.User, id uint) (*models.User, error)
+}
+
+// ProjectRepository is a repository of models.Project
+type ProjectRepository interface {
+	Create(m *models.Project, entry *models.AuditEntry) error
+	Update(m *models.Project, id uint, entry *models.AuditEntry) (*models.Project, error)
+	UpdateAll(m *models.Project, id uint, entry *models.AuditEntry) (*models.Project, error)
+	Delete(m *models.Project, entry *models.AuditEntry) error
+	List(viewer *models.User, q common.ListQuery) ([]models.Project, int, error)
+	GetAllAssociatedUsers(m *models.Project) ([]models.User, error)
+	AddMemberships(m *models.Project, memberships []models.Membership, entry *models.AuditEntry) error
+	RemoveMemberships(m *models.Project, memberships []models.Membership, entry *models.AuditEntry) error
+	AddRisksAssociations(m *models.Project, risks []models.Risk, entry *models.AuditEntry) error
+	RemoveRisksAssociations(m *models.Project, risks []models.Risk, entry *models.AuditEntry) error
+	GetAllAssociatedVisibleRisks(viewer *models.User, m *models.Project) ([]models.Risk, error)
+	ReadVisibleByID(viewer *models.User, id uint) (*models.Project, error)
+}
+
+// RiskRepository is a repository of models.Risk
+type RiskRepository interface {
+	Create(m *models.Risk, entry *models.AuditEntry) error
+	Update(m *models.Risk, id uint, entry *models.AuditEntry) (*models.Risk, error)
+	UpdateAll(m *models.Risk, id uint, entry *models.AuditEntry) (*models.Risk, error)
+	UpdateStatus(m *models.Risk, status string, reason string, entry *models.AuditEntry) (*models.Risk, error)
+	Revert(m *models.Risk, old *models.Risk, entry *models.AuditEntry) (*models.Risk, error)
+	Delete(m *models.Risk, entry *models.AuditEntry) error
+	List(viewer *models.User, q common.ListQuery) ([]models.Risk, int, error)
+	GetAllAssociatedVisibleProjects(viewer *models.User, m *models.Risk) ([]models.Project, error)
+	AddCounterMeasuresAssociations(m *models.Risk, cms []models.CounterMeasure, entry *models.AuditEntry) error
+	RemoveCounterMeasuresAssociations(m *models.Risk, cms []models.CounterMeasure, entry *models.AuditEntry) error
+	GetAllAssociatedCounterMeasures(m *models.Risk) ([]models.CounterMeasure, error)
+	ReadVisibleByID(viewer *models.User, id uint) (*models.Risk, error)
+}
+
+// CounterMeasureRepository is a repository of models.CounterMeasure
+type CounterMeasureRepository interface {
+	Create(m *models.CounterMeasure, entry *models.AuditEntry) error
+	Update(m *models.CounterMeasure, id uint, entry *models.AuditEntry) (*models.CounterMeasure, error)
+	Delete(m *models.CounterMeasure, entry *models.AuditEntry) error
+	List(viewer *models.User, q common.ListQuery) ([]models.CounterMeasure, int, error)
+	GetAllAssociatedVisibleRisks(viewer *models.User, m *models.CounterMeasure) ([]models.Risk, error)
+	ReadVisibleByID(viewer *models.User, id uint) (*models.CounterMeasure, error)
+}
+
+// MembershipRepository is a repository of models.Membership
+type MembershipRepository interface {
+	Save(m *models.Membership) error
+	Delete(m *models.Membership) error
+	Read(userID uint, projectID uint) (*models.Membership, error)
+	GetAllOfProject(projectID uint) ([]models.Membership, error)
+	BestRole(userID uint, projectIDs []uint) (string, error)
+}
+
+// SessionRepository is a repository of models.Session
+type SessionRepository interface {
+	Create(m *models.Session) error
+	Rotate(m *models.Session, old string, hash string, expiresAt time.Time) error
+	ReadByID(id uint) (*models.Session, error)
+	ReadByRefreshTokenHash(hash string) (*models.Session, error)
+	ReadByRotatedRefreshTokenHash(hash string) (*models.Session, error)
+	Revoke(m *models.Session) error
+	RevokeAllOfUser(userID uint) error
+}
+
+// AuditRepository is a repository of models.AuditEntry
+type AuditRepository interface {
+	Create(m *models.AuditEntry) error
+	List(viewer *models.User, q common.ListQuery) ([]models.AuditEntry, int, error)
+}
+
+// RiskVersionRepository is a repository of models.RiskVersion
+type RiskVersionRepository interface {
+	GetAllOfRisk(riskID uint) ([]models.RiskVersion, error)
+	Read(riskID uint, version uint) (*models.RiskVersion, error)
+	ReadAsOf(riskID uint, at time.Time) (*models.RiskVersion, error)
+}
+
+// SearchRepository searches risks, projects and users
+type SearchRepository interface {
+	Search(viewer *models.User, query string, types []string, limit int) ([]SearchResult, error)
+}
+
+// Repositories are all repositories controllers depend on
+type Repositories struct {
+	Organizations   OrganizationRepository
+	Users           UserRepository
+	Projects        ProjectRepository
+	Risks           RiskRepository
+	CounterMeasures CounterMeasureRepository
+	Memberships     MembershipRepository
+	Sessions        SessionRepository
+	Audit           AuditRepository
+	RiskVersions    RiskVersionRepository
+	Search          SearchRepository
+}
+
+// NewRepositories creates repositories over DB given by parameter
+func NewRepositories(db *gorm.DB) Repositories {
+	return Repositories{
+		Organizations:   NewOrganizationDAO(db),
+		Users:           NewUserDAO(db),
+		Projects:        NewProjectDAO(db),
+		Risks:           NewRiskDAO(db),
+		CounterMeasures: NewCounterMeasureDAO(db),
+		Memberships:     NewMembershipDAO(db),
+		Sessions:        NewSessionDAO(db),
+		Audit:           NewAuditDAO(db),
+		RiskVersions:    NewRiskVersionDAO(db),
+		Search:          NewSearchDAO(db),
+	}
+}
+
+// NewMemoryRepositories creates repositories over store given by parameter
+func NewMemoryRepositories(store *MemoryStore) Repositories {
+	return Repositories{
+		Organizations:   NewMemoryOrganizationDAO(store),
+		Users:           NewMemoryUserDAO(store),
+		Projects:        NewMemoryProjectDAO(store),
+		Risks:           NewMemoryRiskDAO(store),
+		CounterMeasures: NewMemoryCounterMeasureDAO(store),
+		Memberships:     NewMemoryMembershipDAO(store),
+		Sessions:        NewMemorySessionDAO(store),
+		Audit:           NewMemoryAuditDAO(store),
+		RiskVersions:    NewMemoryRiskVersionDAO(store),
+		Search:          NewMemorySearchDAO(store),
+	}
+}
+
+var (
+	_ OrganizationRepository   = (*OrganizationDAO)(nil)
+	_ UserRepository           = (*UserDAO)(nil)
+	_ ProjectRepository        = (*ProjectDAO)(nil)
+	_ RiskRepository           = (*RiskDAO)(nil)
+	_ CounterMeasureRepository = (*CounterMeasureDAO)(nil)
+	_ MembershipRepository     = (*MembershipDAO)(nil)
+	_ SessionRepository        = (*SessionDAO)(nil)
+	_ AuditRepository          = (*AuditDAO)(nil)
+	_ RiskVersionRepository    = (*RiskVersionDAO)(nil)
+	_ SearchRepository         = (*SearchDAO)(nil)
+
+	_ OrganizationRepository   = (*MemoryOrganizationDAO)(nil)
+	_ UserRepository           = (*MemoryUserDAO)(nil)
+	_ ProjectRepository        = (*MemoryProjectDAO)(nil)
+	_ RiskRepository           = (*MemoryRiskDAO)(nil)
+	_ CounterMeasureRepository = (*MemoryCounterMeasureDAO)(nil)
+	_ MembershipRepository     = (*MemoryMembershipDAO)(nil)
+	_ SessionRepository        = (*MemorySessionDAO)(nil)
+	_ AuditRepository          = (*MemoryAuditDAO)(nil)
+	_ RiskVersionRepository    = (*MemoryRiskVersionDAO)(nil)
+	_ SearchRepository         = (*MemorySearchDAO)(nil)
+)
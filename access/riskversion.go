@@ -0,0 +1,83 @@
+package access
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// RiskVersionDAO is a data access object to a database containing
+// models.RiskVersions, versions are created by RiskDAO with changes of risks,
+// never updated or deleted
+type RiskVersionDAO struct {
+	db *gorm.DB
+}
+
+// NewRiskVersionDAO creates a new Data Access Object for the
+// models.RiskVersion model.
+func NewRiskVersionDAO(db *gorm.DB) *RiskVersionDAO {
+	return &RiskVersionDAO{
+		db: db,
+	}
+}
+
+// createRiskVersion will store current state of risk given by parameter
+// as its next version in transaction of its change. The change updates the
+// record of risk first, so it is locked until the transaction ends and
+// versions of the risk are numbered one by one
+func createRiskVersion(tx *gorm.DB, risk *models.Risk, entry *models.AuditEntry) error {
+	last := struct{ Version uint }{}
+	err := tx.Model(&models.RiskVersion{}).Select("COALESCE(MAX(version), 0) AS version").
+		Where("risk_id = ?", risk.ID).Scan(&last).Error
+	if err != nil {
+		return err
+	}
+
+	m := &models.RiskVersion{
+		RiskID:  risk.ID,
+		Version: last.Version + 1,
+		Data:    models.RiskData{Risk: *risk},
+	}
+	if entry != nil {
+		m.ActorID = entry.ActorID
+	}
+	// associations are not part of version
+	m.Data.Projects = nil
+	m.Data.CounterMeasures = nil
+	return tx.Create(m).Error
+}
+
+// GetAllOfRisk will return all versions of risk with ID given by parameter,
+// the oldest version is first
+func (dao *RiskVersionDAO) GetAllOfRisk(riskID uint) ([]models.RiskVersion, error) {
+	m := []models.RiskVersion{}
+	if err := dao.db.Where("risk_id = ?", riskID).Order("version").Find(&m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Read will find version of risk given by parameters
+func (dao *RiskVersionDAO) Read(riskID uint, version uint) (*models.RiskVersion, error) {
+	m := &models.RiskVersion{}
+	if err := dao.db.Where("risk_id = ? AND version = ?", riskID, version).First(m).Error; err != nil {
+		return nil, notFound(err, ResourceRiskVersion)
+	}
+
+	return m, nil
+}
+
+// ReadAsOf will find version of risk that was current at time given
+// by parameter
+func (dao *RiskVersionDAO) ReadAsOf(riskID uint, at time.Time) (*models.RiskVersion, error) {
+	m := &models.RiskVersion{}
+	err := dao.db.Where("risk_id = ? AND created_at <= ?", riskID, at).
+		Order("version desc").First(m).Error
+	if err != nil {
+		return nil, notFound(err, ResourceRiskVersion)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,186 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// riskVersions will return versions of risk as their numbers, actors
+// and names of risk
+func riskVersions(t *testing.T, repos Repositories, riskID uint) []string {
+	t.Helper()
+
+	versions, err := repos.RiskVersions.GetAllOfRisk(riskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := []string{}
+	for _, v := range versions {
+		m = append(m, fmt.Sprint(v.Version, " ", v.ActorID, " ", v.Data.Name))
+	}
+	return m
+}
+
+func TestRiskChangesAreVersioned(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testRiskChangesAreVersioned(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testRiskChangesAreVersioned(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testRiskChangesAreVersioned(t *testing.T, repos Repositories) {
+	d := newAuditData(t, repos)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := repos.Risks.Update(&models.Risk{Name: "renamed"}, d.risk.ID, d.entry("risk.update"))
+	must(err)
+	risk, err := repos.Risks.ReadVisibleByID(d.admin, d.risk.ID)
+	must(err)
+	_, err = repos.Risks.UpdateStatus(risk, models.RiskStatusAnalysed, "", d.entry("risk.transition"))
+	must(err)
+	first, err := repos.RiskVersions.Read(d.risk.ID, 1)
+	must(err)
+	_, err = repos.Risks.Revert(risk, &first.Data.Risk, d.entry("risk.revert"))
+	must(err)
+	_, err = repos.Risks.UpdateAll(risk, d.risk.ID, nil)
+	must(err)
+
+	expected := fmt.Sprint([]string{"1 0 risk", fmt.Sprint("2 ", d.admin.ID, " renamed"),
+		fmt.Sprint("3 ", d.admin.ID, " renamed"), fmt.Sprint("4 ", d.admin.ID, " risk"), "5 0 risk"})
+	if got := fmt.Sprint(riskVersions(t, repos, d.risk.ID)); got != expected {
+		t.Errorf("risk has versions %s, expected %s", got, expected)
+	}
+	v, err := repos.RiskVersions.Read(d.risk.ID, 3)
+	must(err)
+	if v.Data.Status != models.RiskStatusAnalysed || v.Data.Version != 3 {
+		t.Errorf("version 3 has status %s and version of record %d", v.Data.Status, v.Data.Version)
+	}
+}
+
+func TestUpdateOfRiskComputesScoresOfStoredRecord(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testUpdateOfRiskComputesScoresOfStoredRecord(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testUpdateOfRiskComputesScoresOfStoredRecord(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testUpdateOfRiskComputesScoresOfStoredRecord(t *testing.T, repos Repositories) {
+	d := newAuditData(t, repos)
+	risk := &models.Risk{Name: "valued", OrganizationID: d.admin.OrganizationID, Value: 1000, Cost: 200,
+		Probability: 0.5, Impact: models.ImpactMedium}
+	risk.ComputeScores()
+	if err := repos.Risks.Create(risk, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// zero probability and value are skipped, so are the scores of m
+	m := &models.Risk{Cost: 300, Impact: models.ImpactBig}
+	m.ComputeScores()
+	if _, err := repos.Risks.Update(m, risk.ID, d.entry("risk.update")); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repos.Risks.ReadVisibleByID(d.admin, risk.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Risk != 0.5*models.ImpactBig || stored.Exposure != 500 {
+		t.Errorf("risk of probability 0.5 and value 1000 has score %v and exposure %v", stored.Risk,
+			stored.Exposure)
+	}
+	v, err := repos.RiskVersions.Read(risk.ID, stored.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Data.Risk.Risk != stored.Risk || v.Data.Exposure != stored.Exposure {
+		t.Errorf("version has score %v and exposure %v", v.Data.Risk.Risk, v.Data.Exposure)
+	}
+}
+
+func TestConcurrentChangesOfRiskAreVersionedOneByOne(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testConcurrentChangesOfRiskAreVersionedOneByOne(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testConcurrentChangesOfRiskAreVersionedOneByOne(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testConcurrentChangesOfRiskAreVersionedOneByOne(t *testing.T, repos Repositories) {
+	d := newAuditData(t, repos)
+
+	const changes = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	applied := 0
+	for i := 0; i < changes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// changes not based on a version fail only when other change comes first
+			_, err := repos.Risks.Update(&models.Risk{Name: fmt.Sprint("change ", i)}, d.risk.ID, nil)
+			if err == nil {
+				mu.Lock()
+				applied++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	risk, err := repos.Risks.ReadVisibleByID(d.admin, d.risk.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := riskVersions(t, repos, d.risk.ID)
+	if applied == 0 || len(versions) != applied+1 || risk.Version != uint(applied+1) {
+		t.Fatalf("%d changes were applied to risk of version %d with versions %v", applied, risk.Version,
+			versions)
+	}
+	for i, v := range versions {
+		if !strings.HasPrefix(v, fmt.Sprint(i+1, " ")) {
+			t.Errorf("version %d is %s", i+1, v)
+		}
+	}
+}
+
+func TestFailedVersionRollsBackChange(t *testing.T) {
+	forEachDB(t, testFailedVersionRollsBackChange)
+}
+
+func testFailedVersionRollsBackChange(t *testing.T, driver string) {
+	db := newMigratedDB(t, driver)
+	repos := NewRepositories(db)
+	d := newAuditData(t, repos)
+	// versions cannot be written without the table
+	if err := db.DropTable(&models.RiskVersion{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.LogMode(false)
+
+	if _, err := repos.Risks.Update(&models.Risk{Name: "renamed"}, d.risk.ID, d.entry("risk.update")); err == nil {
+		t.Fatal("update without version succeeded")
+	}
+	risk, err := repos.Risks.ReadVisibleByID(d.admin, d.risk.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if risk.Name != "risk" || risk.Version != d.risk.Version {
+		t.Errorf("risk was changed to %s of version %d", risk.Name, risk.Version)
+	}
+	if entries := auditLog(t, repos, d.admin); len(entries) != 0 {
+		t.Errorf("audit log has entries %v of failed change", entries)
+	}
+}
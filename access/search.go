@@ -0,0 +1,327 @@
+package access
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// MaxSearchTerms is the highest number of words of search query that are used
+const MaxSearchTerms = 10
+
+// SearchResult is a record found by search, results of all types are
+// ranked together and higher Score is better
+type SearchResult struct {
+	Type  string
+	ID    uint
+	Name  string
+	Score float64
+}
+
+// searchSource is a table searched by SearchDAO, the first of columns
+// is name of record and it has the highest weight in ranking
+type searchSource struct {
+	entity  string
+	table   string
+	columns []string
+	visible func(viewer *models.User) (string, []interface{})
+}
+
+var searchSources = []searchSource{
+	{
+		entity:  models.EntityRisk,
+		table:   "risks",
+		columns: []string{"name", "description", "threat", "trigger", "category"},
+		visible: visibleRisksCondition,
+	},
+	{
+		entity:  models.EntityProject,
+		table:   "projects",
+		columns: []string{"name", "description"},
+		visible: visibleProjectsCondition,
+	},
+	{
+		entity:  models.EntityUser,
+		table:   "users",
+		columns: []string{"name", "skills"},
+		visible: visibleUsersCondition,
+	},
+}
+
+// weight of name in ranking, other columns have weight 1
+const searchNameWeight = 5
+
+// SearchDAO is a data access object that searches risks, projects and users.
+// SQLite built with FTS5 uses full-text index, other databases use LIKE
+type SearchDAO struct {
+	db  *gorm.DB
+	fts bool
+}
+
+// NewSearchDAO creates a new Data Access Object for searching, full-text
+// index is used when DB supports it
+func NewSearchDAO(db *gorm.DB) *SearchDAO {
+	return &SearchDAO{
+		db:  db,
+		fts: HasFullTextSearch(db),
+	}
+}
+
+// HasFullTextSearch will return whether DB is SQLite built with FTS5,
+// go-sqlite3 has to be built with tag sqlite_fts5
+func HasFullTextSearch(db *gorm.DB) bool {
+	if db.Dialect().GetName() != DriverSqlite {
+		return false
+	}
+
+	enabled := 0
+	row := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Row()
+	if err := row.Scan(&enabled); err != nil {
+		return false
+	}
+
+	return enabled == 1
+}
+
+// Search will return records of types given by parameter (all types when
+// empty) that match query and are visible to viewer, the best results
+// are first
+func (dao *SearchDAO) Search(viewer *models.User, query string, types []string, limit int) ([]SearchResult, error) {
+	return rankSearch(query, types, limit, func(source searchSource, terms []string) ([]SearchResult, error) {
+		if dao.fts {
+			return dao.searchFullText(viewer, source, terms, limit)
+		}
+		return dao.searchLike(viewer, source, terms, limit)
+	})
+}
+
+// rankSearch will split query to terms, search sources of types given
+// by parameter with search and rank their results together
+func rankSearch(query string, types []string, limit int,
+	search func(source searchSource, terms []string) ([]SearchResult, error)) ([]SearchResult, error) {
+	terms := strings.Fields(query)
+	if len(terms) > MaxSearchTerms {
+		terms = terms[:MaxSearchTerms]
+	}
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	for _, source := range searchSources {
+		if len(types) > 0 && !containsString(types, source.entity) {
+			continue
+		}
+
+		found, err := search(source, terms)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			found[i].Type = source.entity
+		}
+		results = append(results, found...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// searchFullText will search source in its full-text index, every term
+// has to match as a prefix of a word
+func (dao *SearchDAO) searchFullText(viewer *models.User, source searchSource, terms []string, limit int) ([]SearchResult, error) {
+	match := []string{}
+	for _, term := range terms {
+		match = append(match, `"`+strings.Replace(term, `"`, `""`, -1)+`"*`)
+	}
+	weights := []string{}
+	for i := range source.columns {
+		if i == 0 {
+			weights = append(weights, fmt.Sprint(searchNameWeight))
+		} else {
+			weights = append(weights, "1")
+		}
+	}
+	fts := ftsTable(source)
+
+	sql := "SELECT " + source.table + ".id AS id, " + source.table + ".name AS name, " +
+		"-bm25(" + fts + ", " + strings.Join(weights, ", ") + ") AS score " +
+		"FROM " + fts + " JOIN " + source.table + " ON " + source.table + ".id = " + fts + ".rowid " +
+		"WHERE " + fts + " MATCH ? AND " + source.table + ".deleted_at IS NULL"
+	args := []interface{}{strings.Join(match, " ")}
+	if cond, condArgs := source.visible(viewer); cond != "" {
+		sql += " AND (" + cond + ")"
+		args = append(args, condArgs...)
+	}
+	sql += " ORDER BY score DESC LIMIT ?"
+	args = append(args, limit)
+
+	results := []SearchResult{}
+	if err := dao.db.Raw(sql, args...).Scan(&results).Error; err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// searchLike will search source with LIKE, score is a weighted number
+// of columns matching terms
+func (dao *SearchDAO) searchLike(viewer *models.User, source searchSource, terms []string, limit int) ([]SearchResult, error) {
+	score := []string{}
+	scoreArgs := []interface{}{}
+	for _, term := range terms {
+		pattern := "%" + escapeLike(strings.ToLower(term)) + "%"
+		for i, column := range source.columns {
+			weight := 1
+			if i == 0 {
+				weight = searchNameWeight
+			}
+			score = append(score, fmt.Sprintf("CASE WHEN LOWER(%s.%s) LIKE ? ESCAPE '!' THEN %d ELSE 0 END",
+				source.table, dao.db.Dialect().Quote(column), weight))
+			scoreArgs = append(scoreArgs, pattern)
+		}
+	}
+	scoreSQL := "(" + strings.Join(score, " + ") + ")"
+
+	sql := "SELECT " + source.table + ".id AS id, " + source.table + ".name AS name, " + scoreSQL + " AS score " +
+		"FROM " + source.table + " WHERE " + source.table + ".deleted_at IS NULL AND " + scoreSQL + " > 0"
+	args := append(append([]interface{}{}, scoreArgs...), scoreArgs...)
+	if cond, condArgs := source.visible(viewer); cond != "" {
+		sql += " AND (" + cond + ")"
+		args = append(args, condArgs...)
+	}
+	sql += " ORDER BY score DESC LIMIT ?"
+	args = append(args, limit)
+
+	results := []SearchResult{}
+	if err := dao.db.Raw(sql, args...).Scan(&results).Error; err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// MigrateSearchIndex will create full-text index of searched tables with
+// triggers that keep it up to date. Index is rebuilt when it is new or its
+// triggers were lost. Without FTS5 old triggers are dropped, so writes do not
+// fail on missing module, and search falls back to LIKE
+func MigrateSearchIndex(db *gorm.DB) error {
+	if db.Dialect().GetName() != DriverSqlite {
+		return nil
+	}
+
+	fts := HasFullTextSearch(db)
+	for _, source := range searchSources {
+		if !fts {
+			for _, trigger := range ftsTriggers(source) {
+				if err := db.Exec("DROP TRIGGER IF EXISTS " + trigger.name).Error; err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		rebuild := !db.HasTable(ftsTable(source))
+		columns := quoteColumns(source.columns)
+		err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS " + ftsTable(source) + " USING fts5(" +
+			strings.Join(columns, ", ") + ", content='" + source.table + "', content_rowid='id')").Error
+		if err != nil {
+			return err
+		}
+
+		for _, trigger := range ftsTriggers(source) {
+			count := 0
+			err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?", trigger.name).
+				Row().Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			if err := db.Exec(trigger.sql).Error; err != nil {
+				return err
+			}
+			rebuild = true
+		}
+
+		if rebuild {
+			err := db.Exec("INSERT INTO " + ftsTable(source) + "(" + ftsTable(source) + ") VALUES('rebuild')").Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ftsTrigger is a trigger that copies changes of searched table to its index
+type ftsTrigger struct {
+	name string
+	sql  string
+}
+
+// ftsTriggers will return triggers keeping index of source up to date
+func ftsTriggers(source searchSource) []ftsTrigger {
+	fts := ftsTable(source)
+	columns := strings.Join(quoteColumns(source.columns), ", ")
+	values := func(prefix string) string {
+		v := []string{}
+		for _, column := range quoteColumns(source.columns) {
+			v = append(v, prefix+"."+column)
+		}
+		return strings.Join(v, ", ")
+	}
+	insert := "INSERT INTO " + fts + "(rowid, " + columns + ") VALUES (new.id, " + values("new") + ");"
+	remove := "INSERT INTO " + fts + "(" + fts + ", rowid, " + columns + ") VALUES ('delete', old.id, " + values("old") + ");"
+
+	return []ftsTrigger{
+		{fts + "_ai", "CREATE TRIGGER " + fts + "_ai AFTER INSERT ON " + source.table + " BEGIN " + insert + " END"},
+		{fts + "_ad", "CREATE TRIGGER " + fts + "_ad AFTER DELETE ON " + source.table + " BEGIN " + remove + " END"},
+		{fts + "_au", "CREATE TRIGGER " + fts + "_au AFTER UPDATE ON " + source.table + " BEGIN " + remove + " " + insert + " END"},
+	}
+}
+
+// ftsTable will return name of full-text index of source
+func ftsTable(source searchSource) string {
+	return source.table + "_fts"
+}
+
+// quoteColumn will quote name of column for SQLite, some of them
+// (trigger) are keywords
+func quoteColumn(column string) string {
+	return `"` + column + `"`
+}
+
+func quoteColumns(columns []string) []string {
+	quoted := []string{}
+	for _, column := range columns {
+		quoted = append(quoted, quoteColumn(column))
+	}
+	return quoted
+}
+
+// escapeLike will escape wildcards of LIKE in value with '!', backslash
+// is not used because MySQL takes it as escape in string literals
+func escapeLike(value string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(value)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package access
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// SessionDAO is a data access object to a database containing models.Sessions
+type SessionDAO struct {
+	db *gorm.DB
+}
+
+// NewSessionDAO creates a new Data Access Object for the
+// models.Session model.
+func NewSessionDAO(db *gorm.DB) *SessionDAO {
+	return &SessionDAO{
+		db: db,
+	}
+}
+
+// Create will create single models.Session in database.
+func (dao *SessionDAO) Create(m *models.Session) error {
+	if err := dao.db.Create(m).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// Rotate will replace refresh token of session by the one with hash given
+// by parameter and extend its expiration. The session is changed only when
+// it is not revoked and still has refresh token with hash old, otherwise
+// the token was rotated in the meantime and common.ErrRefreshTokenReused is
+// returned. Hash old is kept, ReadByRotatedRefreshTokenHash finds its session
+func (dao *SessionDAO) Rotate(m *models.Session, old string, hash string, expiresAt time.Time) error {
+	tx := dao.db.Begin()
+	query := tx.Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked = ?", m.ID, old, false).
+		Updates(map[string]interface{}{"refresh_token_hash": hash, "expires_at": expiresAt})
+	if query.Error != nil {
+		tx.Rollback()
+		return query.Error
+	}
+	if query.RowsAffected != 1 {
+		tx.Rollback()
+		return common.ErrRefreshTokenReused
+	}
+	if err := tx.Create(&models.RotatedRefreshToken{SessionID: m.ID, Hash: old}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	m.RefreshTokenHash = hash
+	m.ExpiresAt = expiresAt
+	return nil
+}
+
+// ReadByID will find models.Session by ID given by parameter
+func (dao *SessionDAO) ReadByID(id uint) (*models.Session, error) {
+	m := &models.Session{}
+	if err := dao.db.First(&m, id).Error; err != nil {
+		return nil, notFound(err, ResourceSession)
+	}
+
+	return m, nil
+}
+
+// ReadByRefreshTokenHash will find models.Session by hash of its refresh token
+func (dao *SessionDAO) ReadByRefreshTokenHash(hash string) (*models.Session, error) {
+	m := &models.Session{}
+	if err := dao.db.Where(&models.Session{RefreshTokenHash: hash}).First(&m).Error; err != nil {
+		return nil, notFound(err, ResourceSession)
+	}
+
+	return m, nil
+}
+
+// ReadByRotatedRefreshTokenHash will find models.Session whose refresh token
+// with hash given by parameter was already rotated
+func (dao *SessionDAO) ReadByRotatedRefreshTokenHash(hash string) (*models.Session, error) {
+	rotated := &models.RotatedRefreshToken{}
+	if err := dao.db.Where(&models.RotatedRefreshToken{Hash: hash}).First(rotated).Error; err != nil {
+		return nil, notFound(err, ResourceSession)
+	}
+
+	return dao.ReadByID(rotated.SessionID)
+}
+
+// Revoke will revoke a single models.Session
+func (dao *SessionDAO) Revoke(m *models.Session) error {
+	if err := dao.db.Model(m).Update("revoked", true).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// RevokeAllOfUser will revoke all sessions of user with ID given by parameter
+func (dao *SessionDAO) RevokeAllOfUser(userID uint) error {
+	err := dao.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked = ?", userID, false).
+		Update("revoked", true).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package access
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+func TestRotatedRefreshTokenCannotBeRotatedAgain(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testRotatedRefreshTokenCannotBeRotatedAgain(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testRotatedRefreshTokenCannotBeRotatedAgain(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testRotatedRefreshTokenCannotBeRotatedAgain(t *testing.T, repos Repositories) {
+	session := &models.Session{UserID: 1, RefreshTokenHash: "first", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repos.Sessions.Create(session); err != nil {
+		t.Fatal(err)
+	}
+	stale := *session
+
+	expiresAt := time.Now().Add(2 * time.Hour)
+	if err := repos.Sessions.Rotate(session, "first", "second", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Sessions.Rotate(&stale, "first", "third", expiresAt); err != common.ErrRefreshTokenReused {
+		t.Errorf("rotating rotated token gives %v, expected %v", err, common.ErrRefreshTokenReused)
+	}
+
+	current, err := repos.Sessions.ReadByRefreshTokenHash("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != session.ID || !current.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("session %d expires at %v, expected session %d at %v", current.ID, current.ExpiresAt,
+			session.ID, expiresAt)
+	}
+	if _, err := repos.Sessions.ReadByRefreshTokenHash("first"); !IsNotFound(err) {
+		t.Errorf("session is found by rotated token: %v", err)
+	}
+	if _, err := repos.Sessions.ReadByRefreshTokenHash("third"); !IsNotFound(err) {
+		t.Errorf("session is found by token of failed rotation: %v", err)
+	}
+	rotated, err := repos.Sessions.ReadByRotatedRefreshTokenHash("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ID != session.ID {
+		t.Errorf("rotated token belongs to session %d, expected %d", rotated.ID, session.ID)
+	}
+	if _, err := repos.Sessions.ReadByRotatedRefreshTokenHash("second"); !IsNotFound(err) {
+		t.Errorf("current token is found as rotated: %v", err)
+	}
+
+	// token of revoked session cannot be rotated
+	if err := repos.Sessions.Revoke(current); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Sessions.Rotate(current, "second", "fourth", expiresAt); err != common.ErrRefreshTokenReused {
+		t.Errorf("rotating token of revoked session gives %v, expected %v", err, common.ErrRefreshTokenReused)
+	}
+}
+
+func TestConcurrentRotationsOfRefreshTokenRotateItOnce(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testConcurrentRotationsOfRefreshTokenRotateItOnce(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testConcurrentRotationsOfRefreshTokenRotateItOnce(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testConcurrentRotationsOfRefreshTokenRotateItOnce(t *testing.T, repos Repositories) {
+	session := &models.Session{UserID: 1, RefreshTokenHash: "first", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repos.Sessions.Create(session); err != nil {
+		t.Fatal(err)
+	}
+
+	const rotations = 8
+	var wg sync.WaitGroup
+	errs := make([]error, rotations)
+	for i := 0; i < rotations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := *session
+			errs[i] = repos.Sessions.Rotate(&s, "first", string(rune('a'+i)), time.Now().Add(time.Hour))
+		}(i)
+	}
+	wg.Wait()
+
+	rotated := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			rotated++
+		case common.ErrRefreshTokenReused:
+		default:
+			// SQLite rejects concurrent writes, the token is not rotated by them
+			t.Logf("rotation failed: %v", err)
+		}
+	}
+	if rotated != 1 {
+		t.Errorf("token was rotated %d times, expected once: %v", rotated, errs)
+	}
+}
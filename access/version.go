@@ -0,0 +1,42 @@
+package access
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/common"
+)
+
+// Versions of users, projects and risks are checked by DAOs, a change is
+// based on the version in the model given to Update, UpdateAll, UpdateStatus,
+// Revert or Delete and it fails with common.ErrVersionMismatch when the record
+// has other version. Zero version means the change is based on the version
+// read by DAO, so callers that do not check versions are not affected
+
+// baseVersion will return version a change is based on, current version
+// of record when the caller did not give it
+func baseVersion(version uint, current uint) uint {
+	if version == 0 {
+		return current
+	}
+	return version
+}
+
+// checkVersion will return common.ErrVersionMismatch when a change based
+// on version cannot be applied to record with current version
+func checkVersion(version uint, current uint) error {
+	if baseVersion(version, current) != current {
+		return common.ErrVersionMismatch
+	}
+	return nil
+}
+
+// versionResult will return error of update conditioned by version,
+// no affected record means the version was changed in the meantime
+func versionResult(query *gorm.DB) error {
+	if query.Error != nil {
+		return query.Error
+	}
+	if query.RowsAffected == 0 {
+		return common.ErrVersionMismatch
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+func TestUpdateOfStaleVersionIsRejected(t *testing.T) {
+	forEachDB(t, func(t *testing.T, driver string) {
+		testUpdateOfStaleVersionIsRejected(t, NewRepositories(newMigratedDB(t, driver)))
+	})
+	t.Run("memory", func(t *testing.T) {
+		testUpdateOfStaleVersionIsRejected(t, NewMemoryRepositories(NewMemoryStore()))
+	})
+}
+
+func testUpdateOfStaleVersionIsRejected(t *testing.T, repos Repositories) {
+	d := newAuditData(t, repos)
+	expect := func(what string, err error, want error) {
+		t.Helper()
+		if err != want {
+			t.Fatalf("%s: got %v, expected %v", what, err, want)
+		}
+	}
+
+	user, err := repos.Users.Update(&models.User{Name: "renamed", Version: d.admin.Version}, d.admin.ID, d.entry("update"))
+	expect("update of current user", err, nil)
+	_, err = repos.Users.Update(&models.User{Name: "stale", Version: d.admin.Version}, d.admin.ID, d.entry("update"))
+	expect("update of stale user", err, common.ErrVersionMismatch)
+	read, err := repos.Users.ReadByID(d.admin.ID)
+	expect("read of user", err, nil)
+	if read.Name != "renamed" || read.Version != user.Version {
+		t.Errorf("stale update changed user to %q version %d, expected %q version %d", read.Name, read.Version,
+			"renamed", user.Version)
+	}
+
+	project, err := repos.Projects.Update(&models.Project{Name: "renamed", Version: d.project.Version}, d.project.ID,
+		d.entry("update"))
+	expect("update of current project", err, nil)
+	_, err = repos.Projects.Update(&models.Project{Name: "stale", Version: d.project.Version}, d.project.ID,
+		d.entry("update"))
+	expect("update of stale project", err, common.ErrVersionMismatch)
+	readProject, err := repos.Projects.ReadVisibleByID(d.admin, d.project.ID)
+	expect("read of project", err, nil)
+	if readProject.Name != "renamed" || readProject.Version != project.Version {
+		t.Errorf("stale update changed project to %q version %d, expected %q version %d", readProject.Name,
+			readProject.Version, "renamed", project.Version)
+	}
+
+	risk, err := repos.Risks.Update(&models.Risk{Name: "renamed", Version: d.risk.Version}, d.risk.ID, d.entry("update"))
+	expect("update of current risk", err, nil)
+	_, err = repos.Risks.Update(&models.Risk{Name: "stale", Version: d.risk.Version}, d.risk.ID, d.entry("update"))
+	expect("update of stale risk", err, common.ErrVersionMismatch)
+	readRisk, err := repos.Risks.ReadVisibleByID(d.admin, d.risk.ID)
+	expect("read of risk", err, nil)
+	if readRisk.Name != "renamed" || readRisk.Version != risk.Version {
+		t.Errorf("stale update changed risk to %q version %d, expected %q version %d", readRisk.Name,
+			readRisk.Version, "renamed", risk.Version)
+	}
+}
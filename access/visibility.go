@@ -0,0 +1,121 @@
+package access
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// scoped will restrict query by condition, empty condition means
+// viewer can see all records
+func scoped(query *gorm.DB, cond string, args []interface{}) *gorm.DB {
+	if cond == "" {
+		return query
+	}
+
+	return query.Where(cond, args...)
+}
+
+// isSuperAdmin will return whether viewer can see records of all organizations
+func isSuperAdmin(viewer *models.User) bool {
+	return viewer.Role <= models.RoleSuperAdmin
+}
+
+// visibleOrganizationsCondition will return SQL condition with its arguments
+// that matches organizations visible to viewer
+func visibleOrganizationsCondition(viewer *models.User) (string, []interface{}) {
+	if isSuperAdmin(viewer) {
+		return "", nil
+	}
+
+	return "organizations.id = ?", []interface{}{viewer.OrganizationID}
+}
+
+// visibleUsersCondition will return SQL condition with its arguments
+// that matches users visible to viewer, those are users of his organization
+func visibleUsersCondition(viewer *models.User) (string, []interface{}) {
+	if isSuperAdmin(viewer) {
+		return "", nil
+	}
+
+	return "users.organization_id = ?", []interface{}{viewer.OrganizationID}
+}
+
+// visibleCounterMeasuresCondition will return SQL condition with its arguments
+// that matches countermeasures visible to viewer, those are countermeasures
+// of his organization
+func visibleCounterMeasuresCondition(viewer *models.User) (string, []interface{}) {
+	if isSuperAdmin(viewer) {
+		return "", nil
+	}
+
+	return "counter_measures.organization_id = ?", []interface{}{viewer.OrganizationID}
+}
+
+// visibleProjectsCondition will return SQL condition with its arguments
+// that matches projects visible to viewer. Admins see all projects of their
+// organization, managers see projects they manage or are members of, users
+// only projects they are members of
+func visibleProjectsCondition(viewer *models.User) (string, []interface{}) {
+	if isSuperAdmin(viewer) {
+		return "", nil
+	}
+
+	org := "projects.organization_id = ?"
+	if viewer.Role <= models.RoleAdmin {
+		return org, []interface{}{viewer.OrganizationID}
+	}
+
+	member := "projects.id IN (SELECT project_id FROM user_projects WHERE user_id = ?)"
+	if viewer.Role <= models.RoleManager {
+		return org + " AND (projects.manager_id = ? OR " + member + ")",
+			[]interface{}{viewer.OrganizationID, viewer.ID, viewer.ID}
+	}
+
+	return org + " AND " + member, []interface{}{viewer.OrganizationID, viewer.ID}
+}
+
+// visibleRisksCondition will return SQL condition with its arguments
+// that matches risks visible to viewer. Admins see all risks of their
+// organization, others risks they own and risks assigned to projects
+// visible to them
+func visibleRisksCondition(viewer *models.User) (string, []interface{}) {
+	if isSuperAdmin(viewer) {
+		return "", nil
+	}
+
+	org := "risks.organization_id = ?"
+	if viewer.Role <= models.RoleAdmin {
+		return org, []interface{}{viewer.OrganizationID}
+	}
+
+	projects, args := visibleProjectsCondition(viewer)
+
+	return org + " AND (risks.user_id = ? OR risks.id IN (SELECT risk_projects.risk_id FROM risk_projects " +
+			"JOIN projects ON projects.id = risk_projects.project_id " +
+			"WHERE projects.deleted_at IS NULL AND " + projects + "))",
+		append([]interface{}{viewer.OrganizationID, viewer.ID}, args...)
+}
+
+// visibleAuditEntriesCondition will return SQL condition with its arguments
+// that matches entries of audit log visible to viewer. Admins see entries
+// of their organization, managers entries of projects they manage and of
+// risks assigned to them, others nothing
+func visibleAuditEntriesCondition(viewer *models.User) (string, []interface{}) {
+	if isSuperAdmin(viewer) {
+		return "", nil
+	}
+
+	org := "audit_entries.organization_id = ?"
+	if viewer.Role <= models.RoleAdmin {
+		return org, []interface{}{viewer.OrganizationID}
+	}
+	if viewer.Role > models.RoleManager {
+		return "1 = 0", nil
+	}
+
+	managed := "SELECT id FROM projects WHERE manager_id = ?"
+	return org + " AND ((audit_entries.entity_type = ? AND audit_entries.entity_id IN (" + managed + "))" +
+			" OR (audit_entries.entity_type = ? AND audit_entries.entity_id IN" +
+			" (SELECT risk_id FROM risk_projects WHERE project_id IN (" + managed + "))))",
+		[]interface{}{viewer.OrganizationID, models.EntityProject, viewer.ID, models.EntityRisk, viewer.ID}
+}
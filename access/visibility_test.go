@@ -0,0 +1,322 @@
+package access
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// visibilityData are records created in the same order in repositories
+// of both backends, so they get the same IDs
+type visibilityData struct {
+	viewers  map[string]*models.User
+	projects []*models.Project
+	risks    []*models.Risk
+	cms      []*models.CounterMeasure
+}
+
+// newVisibilityData will create organizations, users of every role,
+// projects with members (one of them deleted) and risks assigned to them
+func newVisibilityData(t *testing.T, repos Repositories) *visibilityData {
+	t.Helper()
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	other := &models.Organization{Name: "Other"}
+	must(repos.Organizations.Create(other))
+	def, err := repos.Organizations.ReadByName(models.DefaultOrganizationName)
+	must(err)
+
+	d := &visibilityData{viewers: map[string]*models.User{}}
+	users := []struct {
+		name string
+		role int
+		org  uint
+	}{
+		{"superadmin", models.RoleSuperAdmin, def.ID},
+		{"admin", models.RoleAdmin, def.ID},
+		{"manager", models.RoleManager, def.ID},
+		{"member-manager", models.RoleManager, def.ID},
+		{"user", models.RoleUser, def.ID},
+		{"stranger", models.RoleUser, def.ID},
+		{"foreign-admin", models.RoleAdmin, other.ID},
+		{"foreign-user", models.RoleUser, other.ID},
+	}
+	for _, u := range users {
+		user := &models.User{Name: u.name, Email: u.name + "@fitlogic.test", Role: u.role, OrganizationID: u.org}
+		must(repos.Users.Create(user, nil))
+		d.viewers[u.name] = user
+	}
+
+	project := func(name string, manager string) *models.Project {
+		p := &models.Project{Name: name, ManagerID: d.viewers[manager].ID,
+			OrganizationID: d.viewers[manager].OrganizationID, Start: time.Unix(0, 0).UTC(), End: time.Unix(0, 0).UTC()}
+		must(repos.Projects.Create(p, nil))
+		d.projects = append(d.projects, p)
+		return p
+	}
+	managed := project("managed", "manager")
+	joined := project("joined", "admin")
+	deleted := project("deleted", "manager")
+	foreign := project("foreign", "foreign-admin")
+	must(repos.Memberships.Save(&models.Membership{UserID: d.viewers["user"].ID, ProjectID: joined.ID,
+		Role: models.ProjectRoleViewer}))
+	must(repos.Memberships.Save(&models.Membership{UserID: d.viewers["member-manager"].ID, ProjectID: joined.ID,
+		Role: models.ProjectRoleEditor}))
+	must(repos.Memberships.Save(&models.Membership{UserID: d.viewers["stranger"].ID, ProjectID: deleted.ID,
+		Role: models.ProjectRoleOwner}))
+
+	risk := func(name string, owner string, projects ...*models.Project) {
+		r := &models.Risk{Name: name, UserID: d.viewers[owner].ID, OrganizationID: d.viewers[owner].OrganizationID,
+			Status: models.RiskStatusIdentified}
+		must(repos.Risks.Create(r, nil))
+		for _, p := range projects {
+			must(repos.Projects.AddRisksAssociations(p, []models.Risk{*r}, nil))
+		}
+		d.risks = append(d.risks, r)
+	}
+	risk("managed", "admin", managed)
+	risk("joined", "admin", joined)
+	risk("both", "admin", managed, joined)
+	risk("own", "stranger")
+	risk("deleted", "admin", deleted)
+	risk("foreign", "foreign-user", foreign)
+	must(repos.Projects.Delete(deleted, nil))
+
+	for _, org := range []uint{def.ID, other.ID} {
+		cm := &models.CounterMeasure{Name: fmt.Sprint("cm-", org), OrganizationID: org}
+		must(repos.CounterMeasures.Create(cm, nil))
+		d.cms = append(d.cms, cm)
+	}
+	for _, r := range d.risks {
+		for _, cm := range d.cms {
+			if cm.OrganizationID == r.OrganizationID {
+				must(repos.Risks.AddCounterMeasuresAssociations(r, []models.CounterMeasure{*cm}, nil))
+			}
+		}
+	}
+
+	entries := []*models.AuditEntry{
+		{OrganizationID: def.ID, EntityType: models.EntityProject, EntityID: managed.ID},
+		{OrganizationID: def.ID, EntityType: models.EntityProject, EntityID: joined.ID},
+		{OrganizationID: def.ID, EntityType: models.EntityRisk, EntityID: d.risks[0].ID},
+		{OrganizationID: def.ID, EntityType: models.EntityRisk, EntityID: d.risks[3].ID},
+		{OrganizationID: def.ID, EntityType: models.EntityUser, EntityID: d.viewers["user"].ID},
+		{OrganizationID: other.ID, EntityType: models.EntityProject, EntityID: foreign.ID},
+	}
+	for _, e := range entries {
+		must(repos.Audit.Create(e))
+	}
+
+	return d
+}
+
+// visible is what viewer sees in repositories, IDs of records by their kind
+type visible map[string][]uint
+
+// visibleTo will list and read by ID all records visible to viewer
+func visibleTo(t *testing.T, repos Repositories, d *visibilityData, viewer *models.User) visible {
+	t.Helper()
+
+	all := common.ListQuery{Limit: 1000}
+	v := visible{}
+	add := func(kind string, id uint) { v[kind] = append(v[kind], id) }
+	check := func(err error) {
+		t.Helper()
+		if err != nil && !IsNotFound(err) {
+			t.Fatal(err)
+		}
+	}
+
+	orgs, _, err := repos.Organizations.List(viewer, all)
+	check(err)
+	for _, m := range orgs {
+		add("organizations", m.ID)
+	}
+	users, _, err := repos.Users.List(viewer, all)
+	check(err)
+	for _, m := range users {
+		add("users", m.ID)
+	}
+	projects, _, err := repos.Projects.List(viewer, all)
+	check(err)
+	for _, m := range projects {
+		add("projects", m.ID)
+	}
+	risks, _, err := repos.Risks.List(viewer, all)
+	check(err)
+	for _, m := range risks {
+		add("risks", m.ID)
+	}
+	cms, _, err := repos.CounterMeasures.List(viewer, all)
+	check(err)
+	for _, m := range cms {
+		add("cms", m.ID)
+	}
+	entries, _, err := repos.Audit.List(viewer, all)
+	check(err)
+	for _, m := range entries {
+		add("audit", m.ID)
+	}
+
+	for id := uint(1); id <= 10; id++ {
+		if _, err := repos.Organizations.ReadVisibleByID(viewer, id); err == nil {
+			add("organization by ID", id)
+		} else {
+			check(err)
+		}
+		if _, err := repos.Users.ReadVisibleByID(viewer, id); err == nil {
+			add("user by ID", id)
+		} else {
+			check(err)
+		}
+		if _, err := repos.Projects.ReadVisibleByID(viewer, id); err == nil {
+			add("project by ID", id)
+		} else {
+			check(err)
+		}
+		if _, err := repos.Risks.ReadVisibleByID(viewer, id); err == nil {
+			add("risk by ID", id)
+		} else {
+			check(err)
+		}
+		if _, err := repos.CounterMeasures.ReadVisibleByID(viewer, id); err == nil {
+			add("cm by ID", id)
+		} else {
+			check(err)
+		}
+	}
+
+	for _, r := range d.risks {
+		projects, err := repos.Risks.GetAllAssociatedVisibleProjects(viewer, r)
+		check(err)
+		for _, p := range projects {
+			add(fmt.Sprint("projects of risk ", r.Name), p.ID)
+		}
+	}
+	for name, user := range d.viewers {
+		projects, err := repos.Users.GetAllAssociatedVisibleProjects(viewer, user)
+		check(err)
+		for _, p := range projects {
+			add("projects of user "+name, p.ID)
+		}
+		risks, err := repos.Users.GetAllAssociatedVisibleRisks(viewer, user)
+		check(err)
+		for _, r := range risks {
+			add("risks of user "+name, r.ID)
+		}
+	}
+	for _, p := range d.projects {
+		risks, err := repos.Projects.GetAllAssociatedVisibleRisks(viewer, p)
+		check(err)
+		for _, r := range risks {
+			add(fmt.Sprint("risks of project ", p.Name), r.ID)
+		}
+	}
+	for _, cm := range d.cms {
+		risks, err := repos.CounterMeasures.GetAllAssociatedVisibleRisks(viewer, cm)
+		check(err)
+		for _, r := range risks {
+			add(fmt.Sprint("risks of ", cm.Name), r.ID)
+		}
+	}
+
+	for kind := range v {
+		sort.Slice(v[kind], func(i, j int) bool { return v[kind][i] < v[kind][j] })
+	}
+	return v
+}
+
+func TestMemoryVisibilityMatchesSQL(t *testing.T) {
+	forEachDB(t, testMemoryVisibilityMatchesSQL)
+}
+
+func testMemoryVisibilityMatchesSQL(t *testing.T, driver string) {
+	sqlRepos := NewRepositories(newMigratedDB(t, driver))
+	memoryRepos := NewMemoryRepositories(NewMemoryStore())
+	sqlData := newVisibilityData(t, sqlRepos)
+	memoryData := newVisibilityData(t, memoryRepos)
+
+	for name, viewer := range sqlData.viewers {
+		sqlVisible := visibleTo(t, sqlRepos, sqlData, viewer)
+		memoryVisible := visibleTo(t, memoryRepos, memoryData, memoryData.viewers[name])
+		if fmt.Sprint(sqlVisible) != fmt.Sprint(memoryVisible) {
+			t.Errorf("%s sees in SQL:\n%v\nand in memory:\n%v", name, sqlVisible, memoryVisible)
+		}
+	}
+
+	// the rules themselves, so the backends do not agree on a wrong result
+	expected := map[string]map[string]string{
+		"superadmin": {"projects": "[1 2 4]", "risks": "[1 2 3 4 5 6]", "users": "[1 2 3 4 5 6 7 8]"},
+		"admin":      {"projects": "[1 2]", "risks": "[1 2 3 4 5]", "users": "[1 2 3 4 5 6]", "audit": "[1 2 3 4 5]"},
+		"manager": {"projects": "[1]", "risks": "[1 3]", "audit": "[1 3]",
+			"risks of user admin": "[1 3]", "risks of project joined": "[3]", "projects of user user": "[]"},
+		"member-manager": {"projects": "[2]", "risks": "[2 3]", "audit": "[]"},
+		"user": {"projects": "[2]", "risks": "[2 3]", "audit": "[]",
+			"risks of user admin": "[2 3]", "risks of project managed": "[3]", "projects of user user": "[2]"},
+		"stranger":      {"projects": "[]", "risks": "[4]", "cms": "[1]"},
+		"foreign-admin": {"projects": "[4]", "risks": "[6]", "users": "[7 8]", "cms": "[2]", "organizations": "[2]"},
+	}
+	for name, kinds := range expected {
+		v := visibleTo(t, sqlRepos, sqlData, sqlData.viewers[name])
+		for kind, ids := range kinds {
+			if got := fmt.Sprint(v[kind]); got != ids && !(ids == "[]" && v[kind] == nil) {
+				t.Errorf("%s sees %s %s, expected %s", name, kind, got, ids)
+			}
+		}
+	}
+}
+
+func TestFailedReadOfVisibleAssociationIsReturned(t *testing.T) {
+	forEachDB(t, testFailedReadOfVisibleAssociationIsReturned)
+}
+
+func testFailedReadOfVisibleAssociationIsReturned(t *testing.T, driver string) {
+	// failed reads are expected, they are not logged
+	db := newMigratedDB(t, driver).LogMode(false)
+	repos := NewRepositories(db)
+	d := newVisibilityData(t, repos)
+	for _, table := range []string{"user_projects", "risk_projects", "risk_counter_measures"} {
+		if err := db.DropTable(table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viewer := d.viewers["user"]
+	reads := map[string]func() error{
+		"projects of user": func() error {
+			_, err := repos.Users.GetAllAssociatedVisibleProjects(viewer, viewer)
+			return err
+		},
+		"risks of user": func() error {
+			_, err := repos.Users.GetAllAssociatedVisibleRisks(viewer, viewer)
+			return err
+		},
+		"risks of project": func() error {
+			_, err := repos.Projects.GetAllAssociatedVisibleRisks(viewer, d.projects[0])
+			return err
+		},
+		"projects of risk": func() error {
+			_, err := repos.Risks.GetAllAssociatedVisibleProjects(viewer, d.risks[0])
+			return err
+		},
+		"risks of countermeasure": func() error {
+			_, err := repos.CounterMeasures.GetAllAssociatedVisibleRisks(viewer, d.cms[0])
+			return err
+		},
+	}
+	for name, read := range reads {
+		if err := read(); err == nil {
+			t.Errorf("read of %s without its table succeeded", name)
+		}
+	}
+}
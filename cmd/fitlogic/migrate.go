@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wscherfel/fitlogic-backend/access"
+)
+
+const usage = "usage: fitlogic [migrate up|down|status]"
+
+// runCommand will run command given in arguments of fitlogic,
+// server is started when there are none
+func runCommand(migrator *access.Migrator, args []string) error {
+	if len(args) != 2 || args[0] != "migrate" {
+		return errors.New(usage)
+	}
+
+	switch args[1] {
+	case "up":
+		done, err := migrator.Up()
+		for _, migration := range done {
+			fmt.Printf("applied %d: %s\n", migration.Version, migration.Description)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		migration, err := migrator.Down()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d: %s\n", migration.Version, migration.Description)
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tSTATUS\tAPPLIED AT\tDESCRIPTION")
+		for _, s := range status {
+			state, appliedAt := "pending", ""
+			if s.Applied {
+				state = "applied"
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Unknown {
+				state = "unknown"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, state, appliedAt, s.Description)
+		}
+		return w.Flush()
+	default:
+		return errors.New(usage)
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/controllers"
+	"github.com/wscherfel/fitlogic-backend/docs"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// paths of API documentation
+const (
+	specPath = "/openapi.json"
+	docsPath = "/docs"
+)
+
+var partialParam = docs.Param{
+	Name:        "partial",
+	Type:        "boolean",
+	Description: "change IDs that can be changed and report the rest instead of rejecting all",
+}
+
+// operations describe every route of newRouter, router cannot be created
+// when a route is missing here
+var operations = docs.Operations{
+	docs.Key(http.MethodGet, specPath): {
+		ID:       "Docs.Spec",
+		Summary:  "OpenAPI document of the API",
+		Public:   true,
+		Response: map[string]interface{}{},
+	},
+	docs.Key(http.MethodGet, docsPath): {
+		ID:          "Docs.Page",
+		Summary:     "Interactive documentation of the API",
+		Public:      true,
+		ContentType: "text/html",
+	},
+
+	docs.Key(http.MethodPost, "/login"): {
+		Summary:  "Log in by email and password",
+		Public:   true,
+		Request:  controllers.LoginCredentials{},
+		Response: controllers.LoginResponse{},
+	},
+	docs.Key(http.MethodPost, "/refresh"): {
+		Summary:  "Exchange refresh token for new tokens",
+		Public:   true,
+		Request:  controllers.RefreshRequest{},
+		Response: controllers.TokensResponse{},
+	},
+	docs.Key(http.MethodPost, "/logout"): {
+		Summary: "Revoke session of access token",
+	},
+
+	docs.Key(http.MethodPost, "/organizations/"): {
+		Summary:  "Create organization",
+		Request:  controllers.OrganizationAPI{},
+		Response: models.Organization{},
+	},
+	docs.Key(http.MethodGet, "/organizations/"): {
+		Summary:  "List organizations",
+		Response: []models.Organization{},
+		List:     &access.OrganizationListParams,
+	},
+	docs.Key(http.MethodGet, "/organizations/:id"): {
+		Summary:  "Read organization",
+		Response: models.Organization{},
+	},
+	docs.Key(http.MethodPut, "/organizations/:id"): {
+		Summary:  "Update organization",
+		Request:  controllers.OrganizationAPI{},
+		Response: models.Organization{},
+	},
+	docs.Key(http.MethodDelete, "/organizations/:id"): {
+		Summary: "Delete organization without users",
+	},
+
+	docs.Key(http.MethodPost, "/users/"): {
+		Summary:  "Create user",
+		Request:  models.User{},
+		Response: models.User{},
+	},
+	docs.Key(http.MethodGet, "/users/"): {
+		Summary:  "List users",
+		Response: []models.User{},
+		List:     &access.UserListParams,
+	},
+	docs.Key(http.MethodGet, "/users/:id"): {
+		Summary:   "Read user",
+		Response:  models.User{},
+		Versioned: true,
+	},
+	docs.Key(http.MethodPut, "/users/:id"): {
+		Summary:   "Update user",
+		Request:   controllers.UpdateRequest{},
+		Response:  models.User{},
+		Versioned: true,
+	},
+	docs.Key(http.MethodPatch, "/users/:id"): {
+		Summary:   "Change fields of user by JSON merge patch",
+		Request:   controllers.PatchRequest{},
+		Response:  models.User{},
+		Patch:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodDelete, "/users/:id"): {
+		Summary:   "Delete user",
+		Versioned: true,
+	},
+	docs.Key(http.MethodPost, "/users/:id/changepassword"): {
+		Summary: "Change password of user",
+		Request: controllers.ChangePasswordRequest{},
+	},
+
+	docs.Key(http.MethodPost, "/projects/"): {
+		Summary:  "Create project",
+		Request:  controllers.ProjectAPI{},
+		Response: models.Project{},
+		Dates:    true,
+	},
+	docs.Key(http.MethodGet, "/projects/"): {
+		Summary:  "List projects",
+		Response: []models.Project{},
+		List:     &access.ProjectListParams,
+		Dates:    true,
+	},
+	docs.Key(http.MethodPost, "/projects/:id/assignusers"): {
+		Summary:  "Assign users to project",
+		Request:  controllers.AssignUsersRequest{},
+		Response: controllers.AssignResult{},
+		Query:    []docs.Param{partialParam},
+	},
+	docs.Key(http.MethodPost, "/projects/:id/unassignusers"): {
+		Summary:  "Unassign users from project",
+		Request:  common.IDsRequest{},
+		Response: controllers.AssignResult{},
+		Query:    []docs.Param{partialParam},
+	},
+	docs.Key(http.MethodPost, "/projects/:id/assignrisks"): {
+		Summary:  "Assign risks to project",
+		Request:  common.IDsRequest{},
+		Response: controllers.AssignResult{},
+		Query:    []docs.Param{partialParam},
+	},
+	docs.Key(http.MethodPost, "/projects/:id/unassignrisks"): {
+		Summary:  "Unassign risks from project",
+		Request:  common.IDsRequest{},
+		Response: controllers.AssignResult{},
+		Query:    []docs.Param{partialParam},
+	},
+	docs.Key(http.MethodGet, "/projects/:id"): {
+		Summary:   "Read project",
+		Response:  models.Project{},
+		Dates:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodPut, "/projects/:id"): {
+		Summary:   "Update project",
+		Request:   controllers.ProjectAPI{},
+		Response:  models.Project{},
+		Dates:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodPatch, "/projects/:id"): {
+		Summary:   "Change fields of project by JSON merge patch",
+		Request:   controllers.ProjectAPI{},
+		Response:  models.Project{},
+		Dates:     true,
+		Patch:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodDelete, "/projects/:id"): {
+		Summary:   "Delete project",
+		Versioned: true,
+	},
+	docs.Key(http.MethodPost, "/projects/risks"): {
+		Summary:  "Read risks of projects",
+		Request:  common.IDsRequest{},
+		Response: []models.Risk{},
+		Dates:    true,
+	},
+
+	docs.Key(http.MethodPost, "/risks/"): {
+		Summary:  "Create risk",
+		Request:  controllers.RiskAPI{},
+		Response: models.Risk{},
+		Dates:    true,
+	},
+	docs.Key(http.MethodGet, "/risks/"): {
+		Summary:  "List risks",
+		Response: []models.Risk{},
+		List:     &access.RiskListParams,
+		Dates:    true,
+	},
+	docs.Key(http.MethodGet, "/risks/:id"): {
+		Summary:   "Read risk",
+		Response:  models.Risk{},
+		Dates:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodPut, "/risks/:id"): {
+		Summary:   "Update risk",
+		Request:   controllers.RiskAPI{},
+		Response:  models.Risk{},
+		Dates:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodPatch, "/risks/:id"): {
+		Summary:   "Change fields of risk by JSON merge patch",
+		Request:   controllers.RiskAPI{},
+		Response:  models.Risk{},
+		Dates:     true,
+		Patch:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodDelete, "/risks/:id"): {
+		Summary:   "Delete risk",
+		Versioned: true,
+	},
+	docs.Key(http.MethodPost, "/risks/:id/transition"): {
+		Summary:   "Change status of risk",
+		Request:   controllers.RiskTransitionRequest{},
+		Response:  models.Risk{},
+		Dates:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodGet, "/risks/:id/versions"): {
+		Summary:  "List versions of risk",
+		Response: []models.RiskVersion{},
+		Dates:    true,
+	},
+	docs.Key(http.MethodGet, "/risks/:id/versions/diff"): {
+		Summary:  "Compare two versions of risk",
+		Response: controllers.RiskVersionsDiff{},
+		Query: []docs.Param{
+			{Name: "from", Type: "integer", Description: "older version", Required: true},
+			{Name: "to", Type: "integer", Description: "newer version", Required: true},
+		},
+	},
+	docs.Key(http.MethodGet, "/risks/:id/versions/:version"): {
+		Summary:  "Read version of risk",
+		Response: models.RiskVersion{},
+		Dates:    true,
+	},
+	docs.Key(http.MethodPost, "/risks/:id/versions/:version/revert"): {
+		Summary:   "Revert risk to version",
+		Response:  models.Risk{},
+		Dates:     true,
+		Versioned: true,
+	},
+	docs.Key(http.MethodGet, "/risks/:id/asof"): {
+		Summary:  "Read version of risk current at time",
+		Response: models.RiskVersion{},
+		Query: []docs.Param{
+			{Name: "time", Type: "date-time", Required: true},
+		},
+		Dates: true,
+	},
+	docs.Key(http.MethodPost, "/risks/:id/assigncms"): {
+		Summary:  "Assign countermeasures to risk",
+		Request:  common.IDsRequest{},
+		Response: controllers.AssignResult{},
+		Query:    []docs.Param{partialParam},
+	},
+	docs.Key(http.MethodPost, "/risks/:id/unassigncms"): {
+		Summary:  "Unassign countermeasures from risk",
+		Request:  common.IDsRequest{},
+		Response: controllers.AssignResult{},
+		Query:    []docs.Param{partialParam},
+	},
+
+	docs.Key(http.MethodPost, "/cms/"): {
+		Summary:  "Create countermeasure",
+		Request:  controllers.CmAPI{},
+		Response: models.CounterMeasure{},
+	},
+	docs.Key(http.MethodGet, "/cms/"): {
+		Summary:  "List countermeasures",
+		Response: []models.CounterMeasure{},
+		List:     &access.CounterMeasureListParams,
+	},
+	docs.Key(http.MethodGet, "/cms/:id"): {
+		Summary:  "Read countermeasure",
+		Response: models.CounterMeasure{},
+	},
+	docs.Key(http.MethodPut, "/cms/:id"): {
+		Summary:  "Update countermeasure",
+		Request:  controllers.CmAPI{},
+		Response: models.CounterMeasure{},
+	},
+	docs.Key(http.MethodDelete, "/cms/:id"): {
+		Summary: "Delete countermeasure",
+	},
+
+	docs.Key(http.MethodGet, "/audit/"): {
+		Summary:  "List audit log",
+		Response: []models.AuditEntry{},
+		List:     &access.AuditListParams,
+	},
+
+	docs.Key(http.MethodGet, "/search"): {
+		Summary:  "Search risks, projects and users",
+		Response: []access.SearchResult{},
+		Query: []docs.Param{
+			{Name: "q", Type: "string", Description: "searched words", Required: true},
+			{Name: "types", Type: "string", Description: "comma separated types of results: risk, project, user"},
+			{Name: "limit", Type: "integer", Description: "maximum number of results"},
+		},
+	},
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/controllers"
+	"github.com/wscherfel/fitlogic-backend/docs"
+)
+
+// newRouter will create echo server with all endpoints routed to controllers
+// over repositories given by parameter, secret signs issued JWTs and checks
+// sent ones. Configuration is read only for TimeFormat of dateFormat=config,
+// so tests can route requests to it over any repositories. It returns
+// *docs.RouteError when a route is missing in operations or an operation is
+// not routed, so API documentation cannot get out of date
+func newRouter(repos access.Repositories, secret []byte) (*echo.Echo, error) {
+	e := echo.New()
+	// errors returned by handlers and middlewares are sent as common.Error
+	// with ID of request
+	e.HTTPErrorHandler = common.HTTPErrorHandler
+	e.Use(middleware.RequestID())
+	// dates are sent in RFC 3339 unless request selects other format
+	e.Use(common.FormatDates)
+
+	// create controllers
+	userController := controllers.NewUserController(
+		controllers.UserControllerConfig{
+			UserDao:         repos.Users,
+			SessionDao:      repos.Sessions,
+			OrganizationDao: repos.Organizations,
+			Secret:          secret,
+		})
+
+	organizationController := controllers.NewOrganizationController(
+		controllers.OrganizationControllerConfig{
+			OrganizationDao: repos.Organizations,
+		})
+
+	sessionController := controllers.NewSessionController(
+		controllers.SessionControllerConfig{
+			SessionDao: repos.Sessions,
+			UserDao:    repos.Users,
+			Secret:     secret,
+		})
+
+	projectController := controllers.NewProjectController(
+		controllers.ProjectControllerConfig{
+			ProjectDao:    repos.Projects,
+			UserDao:       repos.Users,
+			RiskDao:       repos.Risks,
+			MembershipDao: repos.Memberships,
+		})
+
+	riskController := controllers.NewRiskController(
+		controllers.RiskControllerConfig{
+			RiskDao:        repos.Risks,
+			CmDao:          repos.CounterMeasures,
+			ProjectDao:     repos.Projects,
+			UserDao:        repos.Users,
+			MembershipDao:  repos.Memberships,
+			RiskVersionDao: repos.RiskVersions,
+		},
+	)
+
+	cmController := controllers.NewCounterMeasureController(
+		controllers.CmControllerConfig{
+			CmDao: repos.CounterMeasures,
+		},
+	)
+
+	auditController := controllers.NewAuditController(
+		controllers.AuditControllerConfig{
+			AuditDao: repos.Audit,
+		})
+
+	searchController := controllers.NewSearchController(
+		controllers.SearchControllerConfig{
+			SearchDao: repos.Search,
+		})
+
+	// only endpoints that do not use JWT authentication
+	e.POST("/login", userController.Login)
+	e.POST("/refresh", sessionController.Refresh)
+
+	// every token is checked against revoked sessions and its user
+	// is loaded from DB
+	auth := []echo.MiddlewareFunc{
+		middleware.JWT(secret),
+		sessionController.CheckRevoked,
+		sessionController.LoadUser,
+	}
+
+	e.POST("/logout", sessionController.Logout, auth...)
+
+	// route organization endpoints
+	organizations := e.Group("/organizations", auth...)
+
+	organizations.POST("/", organizationController.Create)
+	organizations.GET("/", organizationController.GetAll)
+	organizations.GET("/:id", organizationController.ReadByID)
+	organizations.PUT("/:id", organizationController.UpdateByID)
+	organizations.DELETE("/:id", organizationController.DeleteByID)
+
+	// route user endpoints
+	users := e.Group("/users", auth...)
+
+	users.POST("/", userController.Create)
+	users.GET("/", userController.Read)
+	users.GET("/:id", userController.ReadByID)
+	users.DELETE("/:id", userController.DeleteByID)
+	users.PUT("/:id", userController.UpdateByID)
+	users.PATCH("/:id", userController.PatchByID)
+	users.POST("/:id/changepassword", userController.ChangePasswordByID)
+
+	// route project endpoints
+	projects := e.Group("/projects", auth...)
+
+	projects.POST("/", projectController.Create)
+	projects.GET("/", projectController.GetAll)
+	projects.POST("/:id/assignusers", projectController.AssignUsers)
+	projects.POST("/:id/unassignusers", projectController.UnAssignUsers)
+	projects.POST("/:id/assignrisks", projectController.AssignRisks)
+	projects.POST("/:id/unassignrisks", projectController.UnAssignRisks)
+	projects.GET("/:id", projectController.ReadByID)
+	projects.PUT("/:id", projectController.UpdateByID)
+	projects.PATCH("/:id", projectController.PatchByID)
+	projects.DELETE("/:id", projectController.DeleteByID)
+	projects.POST("/risks", projectController.GetRisksOfProjects)
+
+	// route risk endpoints
+	risks := e.Group("/risks", auth...)
+
+	risks.POST("/", riskController.Create)
+	risks.GET("/", riskController.GetAll)
+	risks.GET("/:id", riskController.ReadByID)
+	risks.PUT("/:id", riskController.UpdateByID)
+	risks.PATCH("/:id", riskController.PatchByID)
+	risks.DELETE("/:id", riskController.DeleteByID)
+	risks.POST("/:id/transition", riskController.Transition)
+	risks.GET("/:id/versions", riskController.GetVersions)
+	risks.GET("/:id/versions/diff", riskController.DiffVersions)
+	risks.GET("/:id/versions/:version", riskController.ReadVersion)
+	risks.POST("/:id/versions/:version/revert", riskController.RevertToVersion)
+	risks.GET("/:id/asof", riskController.ReadAsOf)
+	risks.POST("/:id/assigncms", riskController.AssignCms)
+	risks.POST("/:id/unassigncms", riskController.UnAssignCms)
+
+	// route countermeasure endpoints
+	cms := e.Group("/cms", auth...)
+
+	cms.POST("/", cmController.Create)
+	cms.GET("/", cmController.GetAll)
+	cms.GET("/:id", cmController.ReadByID)
+	cms.PUT("/:id", cmController.UpdateByID)
+	cms.DELETE("/:id", cmController.DeleteByID)
+
+	// route audit log endpoints
+	audit := e.Group("/audit", auth...)
+
+	audit.GET("/", auditController.GetAll)
+
+	// route search endpoint
+	e.GET("/search", searchController.Search, auth...)
+
+	// route API documentation, it is built from routes registered above
+	spec := &docs.Document{}
+	e.GET(specPath, docs.SpecHandler(spec))
+	e.GET(docsPath, docs.PageHandler(specPath))
+
+	doc, err := docs.NewDocument(e.Routes(), operations)
+	if err != nil {
+		return nil, err
+	}
+	*spec = *doc
+
+	return e, nil
+}
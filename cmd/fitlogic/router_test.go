@@ -0,0 +1,1289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/controllers"
+	"github.com/wscherfel/fitlogic-backend/docs"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// testSecret signs tokens of routers in tests
+var testSecret = []byte("test secret")
+
+// testPassword is a password of users created by fixtures
+const testPassword = "secret"
+
+// backend will return empty repositories of one backend tests are run with
+type backend func(t *testing.T) access.Repositories
+
+// backends of repositories the tests are run with, every test of routes
+// runs with DAOs over SQLite and with memory DAOs as subtests, so both
+// of them are checked to behave the same
+var backends = []struct {
+	name         string
+	repositories backend
+}{
+	{"sqlite", sqliteRepositories},
+	{"memory", memoryRepositories},
+}
+
+// forEachBackend will run test as a subtest for every backend of backends
+func forEachBackend(t *testing.T, test func(t *testing.T, repos backend)) {
+	for _, b := range backends {
+		repos := b.repositories
+		t.Run(b.name, func(t *testing.T) { test(t, repos) })
+	}
+}
+
+// testServer is a router over repositories of backend, SQLite DB is
+// temporary and has all migrations applied. Requests are served
+// without network
+type testServer struct {
+	t *testing.T
+	e *echo.Echo
+}
+
+func newTestServer(t *testing.T, repos backend) *testServer {
+	t.Helper()
+
+	e, err := newRouter(repos(t), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{t: t, e: e}
+}
+
+// memoryRepositories will return empty memory repositories
+func memoryRepositories(t *testing.T) access.Repositories {
+	return access.NewMemoryRepositories(access.NewMemoryStore())
+}
+
+// sqliteRepositories will return repositories over temporary SQLite DB
+// with all migrations applied
+func sqliteRepositories(t *testing.T) access.Repositories {
+	t.Helper()
+
+	db, err := access.ConnectToDb(access.DBConfig{
+		Driver: access.DriverSqlite,
+		DSN:    filepath.Join(t.TempDir(), "fitlogic.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := access.NewMigrator(db, access.Migrations).Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := access.MigrateSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	return access.NewRepositories(db)
+}
+
+// request will send request with body to router, body that is not
+// a string is sent as JSON. Headers are pairs of name and value
+func (s *testServer) request(method, path, token string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	raw, ok := body.(string)
+	if !ok && body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		raw = string(b)
+	}
+
+	req := httptest.NewRequest(method, path, strings.NewReader(raw))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect will send request and fail test when response has other status,
+// body of response is decoded to out if it is not nil
+func (s *testServer) expect(status int, out interface{}, method, path, token string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	rec := s.request(method, path, token, body, headers...)
+	if rec.Code != status {
+		s.t.Fatalf("%s %s: expected %d, got %d: %s", method, path, status, rec.Code, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: %v: %s", method, path, err, rec.Body.String())
+		}
+	}
+	return rec
+}
+
+// login will log in user and return his access token
+func (s *testServer) login(email, password string) string {
+	s.t.Helper()
+
+	res := controllers.LoginResponse{}
+	s.expect(http.StatusOK, &res, http.MethodPost, "/login", "", controllers.LoginCredentials{
+		Email:    email,
+		Password: password,
+	})
+	return res.Token
+}
+
+// expectError will send request and check that error response has status
+// and code, the decoded error is returned
+func (s *testServer) expectError(status int, code string, method, path, token string, body interface{}, headers ...string) common.Error {
+	s.t.Helper()
+
+	res := common.Error{}
+	s.expect(status, &res, method, path, token, body, headers...)
+	if res.Code != code || res.Status != status {
+		s.t.Fatalf("%s %s: expected %s (%d), got %s (%d): %s", method, path, code, status, res.Code, res.Status, res.Message)
+	}
+	return res
+}
+
+// actors of fixture, tokens and IDs of users are stored under these names
+const (
+	actorSuperAdmin = "superadmin"
+	actorAdmin      = "admin"
+	actorManager    = "manager"
+	actorUser       = "user"
+	// user of the same organization who is not member of the project
+	actorStranger = "stranger"
+	// admin of other organization
+	actorForeign = "foreign"
+)
+
+var actors = []string{actorSuperAdmin, actorAdmin, actorManager, actorUser, actorStranger, actorForeign}
+
+// fixture is a test server with users of every role. Manager leads
+// the project, user is its editor and owns risk assigned to it
+type fixture struct {
+	*testServer
+
+	tokens  map[string]string
+	ids     map[string]uint
+	org     uint
+	project uint
+	risk    uint
+	cm      uint
+
+	// counter of unique names of created records
+	names int
+}
+
+func newFixture(t *testing.T, repos backend) *fixture {
+	t.Helper()
+
+	f := &fixture{
+		testServer: newTestServer(t, repos),
+		tokens:     map[string]string{},
+		ids:        map[string]uint{},
+	}
+
+	f.tokens[actorSuperAdmin] = f.login(controllers.DefaultAdmin.Email, controllers.DefaultAdmin.Password)
+	org := models.Organization{}
+	f.expect(http.StatusOK, &org, http.MethodPost, "/organizations/", f.tokens[actorSuperAdmin],
+		controllers.OrganizationAPI{Name: "Other"})
+	f.org = org.ID
+
+	roles := map[string]int{
+		actorAdmin:    models.RoleAdmin,
+		actorManager:  models.RoleManager,
+		actorUser:     models.RoleUser,
+		actorStranger: models.RoleUser,
+		actorForeign:  models.RoleAdmin,
+	}
+	for _, actor := range actors[1:] {
+		orgID := uint(0)
+		if actor == actorForeign {
+			orgID = f.org
+		}
+		f.ids[actor] = f.createUser(actor, roles[actor], orgID)
+		f.tokens[actor] = f.login(actor+"@fitlogic.test", testPassword)
+	}
+
+	f.project = f.createProject(actorManager)
+	f.expect(http.StatusOK, nil, http.MethodPost, f.path("/projects/%d/assignusers", f.project), f.tokens[actorManager],
+		controllers.AssignUsersRequest{IDs: []uint{f.ids[actorUser]}, Role: models.ProjectRoleEditor})
+	f.risk = f.createProjectRisk()
+
+	cm := models.CounterMeasure{}
+	f.expect(http.StatusOK, &cm, http.MethodPost, "/cms/", f.tokens[actorManager], controllers.CmAPI{Name: "Backup"})
+	f.cm = cm.ID
+
+	return f
+}
+
+// path will format path of request
+func (f *fixture) path(format string, args ...interface{}) string {
+	return fmt.Sprintf(format, args...)
+}
+
+// name will return a unique name of record
+func (f *fixture) name(prefix string) string {
+	f.names++
+	return fmt.Sprintf("%s-%d", prefix, f.names)
+}
+
+// createUser will create user by super-admin, zero organization is
+// the organization of super-admin
+func (f *fixture) createUser(name string, role int, orgID uint) uint {
+	f.t.Helper()
+
+	user := models.User{}
+	f.expect(http.StatusOK, &user, http.MethodPost, "/users/", f.tokens[actorSuperAdmin], models.User{
+		Name:           name,
+		Email:          name + "@fitlogic.test",
+		Password:       testPassword,
+		Role:           role,
+		OrganizationID: orgID,
+	})
+	return user.ID
+}
+
+// projectRequest will return request that creates project managed by user
+func (f *fixture) projectRequest(managerID uint) controllers.ProjectAPI {
+	return controllers.ProjectAPI{
+		Name:      f.name("Project"),
+		Start:     "2020-01-01T00:00:00Z",
+		End:       "2020-12-31T00:00:00Z",
+		ManagerID: managerID,
+	}
+}
+
+// createProject will create project managed by actor
+func (f *fixture) createProject(actor string) uint {
+	f.t.Helper()
+
+	project := models.Project{}
+	f.expect(http.StatusOK, &project, http.MethodPost, "/projects/", f.tokens[actor], f.projectRequest(f.ids[actor]))
+	return project.ID
+}
+
+// riskRequest will return request that creates risk owned by user
+func (f *fixture) riskRequest(ownerID uint) controllers.RiskAPI {
+	return controllers.RiskAPI{
+		Name:        f.name("Risk"),
+		Value:       1000,
+		Cost:        200,
+		Probability: 0.5,
+		Impact:      models.ImpactMedium,
+		Start:       "2020-01-01T00:00:00Z",
+		End:         "2020-12-31T00:00:00Z",
+		UserID:      ownerID,
+	}
+}
+
+// createRisk will create risk owned by actor
+func (f *fixture) createRisk(actor string) uint {
+	f.t.Helper()
+
+	risk := models.Risk{}
+	f.expect(http.StatusOK, &risk, http.MethodPost, "/risks/", f.tokens[actor], f.riskRequest(f.ids[actor]))
+	return risk.ID
+}
+
+// createProjectRisk will create risk owned by user and assigned to project
+func (f *fixture) createProjectRisk() uint {
+	f.t.Helper()
+
+	// risk is not visible to manager until it is assigned to his project
+	id := f.createRisk(actorUser)
+	f.expect(http.StatusOK, nil, http.MethodPost, f.path("/projects/%d/assignrisks", f.project), f.tokens[actorUser],
+		common.IDsRequest{IDs: []uint{id}})
+	return id
+}
+
+// routeCase is a request every actor sends, want maps actors to expected
+// statuses, actors missing in want do not send it
+type routeCase struct {
+	method string
+	// path will return path of request, it can create the records
+	// the request changes
+	path func(f *fixture) string
+	body func(f *fixture) interface{}
+	want map[string]int
+}
+
+// runMatrix will send requests of cases as actors on one fixture
+// of every backend
+func runMatrix(t *testing.T, cases []routeCase) {
+	forEachBackend(t, func(t *testing.T, repos backend) {
+		f := newFixture(t, repos)
+
+		for _, c := range cases {
+			for _, actor := range actors {
+				want, ok := c.want[actor]
+				if !ok {
+					continue
+				}
+				path := c.path(f)
+				var body interface{}
+				if c.body != nil {
+					body = c.body(f)
+				}
+				rec := f.request(c.method, path, f.tokens[actor], body)
+				if rec.Code != want {
+					t.Errorf("%s %s as %s: expected %d, got %d: %s", c.method, path, actor, want, rec.Code,
+						rec.Body.String())
+				}
+			}
+		}
+	})
+}
+
+// all will return the same status for every actor
+func all(status int) map[string]int {
+	want := map[string]int{}
+	for _, actor := range actors {
+		want[actor] = status
+	}
+	return want
+}
+
+func TestLogin(t *testing.T) {
+	forEachBackend(t, testLogin)
+}
+
+func testLogin(t *testing.T, repos backend) {
+	s := newTestServer(t, repos)
+
+	res := controllers.LoginResponse{}
+	s.expect(http.StatusOK, &res, http.MethodPost, "/login", "", controllers.LoginCredentials{
+		Email:    controllers.DefaultAdmin.Email,
+		Password: controllers.DefaultAdmin.Password,
+	})
+	if res.Token == "" || res.RefreshToken == "" || res.Role != models.RoleSuperAdmin {
+		t.Errorf("unexpected login response %+v", res)
+	}
+
+	s.expectError(http.StatusUnauthorized, "WRONG_EMAIL_OR_PASSWORD", http.MethodPost, "/login", "",
+		controllers.LoginCredentials{Email: controllers.DefaultAdmin.Email, Password: "wrong"})
+	s.expectError(http.StatusUnauthorized, "WRONG_EMAIL_OR_PASSWORD", http.MethodPost, "/login", "",
+		controllers.LoginCredentials{Email: "nobody@fitlogic.test", Password: "wrong"})
+	s.expectError(http.StatusBadRequest, common.CodeValidationFailed, http.MethodPost, "/login", "",
+		controllers.LoginCredentials{Email: "not an email", Password: "wrong"})
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	forEachBackend(t, testRefreshAndLogout)
+}
+
+func testRefreshAndLogout(t *testing.T, repos backend) {
+	s := newTestServer(t, repos)
+
+	login := controllers.LoginResponse{}
+	s.expect(http.StatusOK, &login, http.MethodPost, "/login", "", controllers.LoginCredentials{
+		Email:    controllers.DefaultAdmin.Email,
+		Password: controllers.DefaultAdmin.Password,
+	})
+
+	tokens := controllers.TokensResponse{}
+	s.expect(http.StatusOK, &tokens, http.MethodPost, "/refresh", "",
+		controllers.RefreshRequest{RefreshToken: login.RefreshToken})
+	if tokens.RefreshToken == login.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+	s.expect(http.StatusOK, nil, http.MethodGet, "/users/", tokens.Token, nil)
+
+	s.expect(http.StatusOK, nil, http.MethodPost, "/logout", tokens.Token, nil)
+	s.expectError(http.StatusUnauthorized, "SESSION_REVOKED", http.MethodGet, "/users/", tokens.Token, nil)
+	s.expectError(http.StatusUnauthorized, "SESSION_REVOKED", http.MethodGet, "/users/", login.Token, nil)
+	s.expectError(http.StatusUnauthorized, "INVALID_REFRESH_TOKEN", http.MethodPost, "/refresh", "",
+		controllers.RefreshRequest{RefreshToken: tokens.RefreshToken})
+}
+
+func TestReusedRefreshTokenRevokesSession(t *testing.T) {
+	forEachBackend(t, testReusedRefreshTokenRevokesSession)
+}
+
+func testReusedRefreshTokenRevokesSession(t *testing.T, repos backend) {
+	s := newTestServer(t, repos)
+
+	login := controllers.LoginResponse{}
+	s.expect(http.StatusOK, &login, http.MethodPost, "/login", "", controllers.LoginCredentials{
+		Email:    controllers.DefaultAdmin.Email,
+		Password: controllers.DefaultAdmin.Password,
+	})
+	tokens := controllers.TokensResponse{}
+	s.expect(http.StatusOK, &tokens, http.MethodPost, "/refresh", "",
+		controllers.RefreshRequest{RefreshToken: login.RefreshToken})
+
+	// the rotated token was stolen, the session is revoked for both holders
+	s.expectError(http.StatusUnauthorized, "REFRESH_TOKEN_REUSED", http.MethodPost, "/refresh", "",
+		controllers.RefreshRequest{RefreshToken: login.RefreshToken})
+	s.expectError(http.StatusUnauthorized, "SESSION_REVOKED", http.MethodGet, "/users/", tokens.Token, nil)
+	s.expectError(http.StatusUnauthorized, "INVALID_REFRESH_TOKEN", http.MethodPost, "/refresh", "",
+		controllers.RefreshRequest{RefreshToken: tokens.RefreshToken})
+
+	// other sessions of user are not affected
+	other := controllers.LoginResponse{}
+	s.expect(http.StatusOK, &other, http.MethodPost, "/login", "", controllers.LoginCredentials{
+		Email:    controllers.DefaultAdmin.Email,
+		Password: controllers.DefaultAdmin.Password,
+	})
+	s.expect(http.StatusOK, nil, http.MethodPost, "/refresh", "",
+		controllers.RefreshRequest{RefreshToken: other.RefreshToken})
+}
+
+func TestTokenSignedWithOtherSecretIsRejected(t *testing.T) {
+	forEachBackend(t, testTokenSignedWithOtherSecretIsRejected)
+}
+
+func testTokenSignedWithOtherSecretIsRejected(t *testing.T, repos backend) {
+	s := newTestServer(t, repos)
+	s.login(controllers.DefaultAdmin.Email, controllers.DefaultAdmin.Password)
+
+	token, err := common.CreateToken([]byte("other secret"), 1, models.RoleSuperAdmin, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expectError(http.StatusUnauthorized, common.CodeUnauthorized, http.MethodGet, "/users/", token, nil)
+
+	// the same claims signed with secret of router are accepted
+	token, err = common.CreateToken(testSecret, 1, models.RoleSuperAdmin, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expect(http.StatusOK, nil, http.MethodGet, "/users/", token, nil)
+}
+
+func TestErrorResponses(t *testing.T) {
+	forEachBackend(t, testErrorResponses)
+}
+
+func testErrorResponses(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+
+	// token is required
+	f.expectError(http.StatusBadRequest, common.CodeBadRequest, http.MethodGet, "/projects/", "", nil)
+	f.expectError(http.StatusUnauthorized, common.CodeUnauthorized, http.MethodGet, "/projects/", "garbage", nil)
+
+	// request ID is sent in body and header
+	rec := f.request(http.MethodGet, "/projects/abc", f.tokens[actorUser], nil)
+	res := common.Error{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "INVALID_ID_IN_PATH" || res.Status != http.StatusBadRequest || rec.Code != http.StatusBadRequest {
+		t.Errorf("unexpected error %+v", res)
+	}
+	if res.RequestID == "" || res.RequestID != rec.Header().Get(echo.HeaderXRequestID) {
+		t.Errorf("request ID %q does not match header %q", res.RequestID, rec.Header().Get(echo.HeaderXRequestID))
+	}
+	if res.Message == "" || res.Error != res.Message {
+		t.Errorf("unexpected message %+v", res)
+	}
+
+	// validation errors have details of fields
+	res = f.expectError(http.StatusBadRequest, common.CodeValidationFailed, http.MethodPost, "/projects/",
+		f.tokens[actorManager], controllers.ProjectAPI{Name: "Incomplete"})
+	fields := map[string]bool{}
+	for _, detail := range res.Details {
+		fields[detail.Field] = true
+	}
+	for _, field := range []string{"Start", "End", "ManagerID"} {
+		if !fields[field] {
+			t.Errorf("missing detail of %s in %+v", field, res.Details)
+		}
+	}
+
+	f.expectError(http.StatusForbidden, "INSUFFICIENT_PRIVILEGES", http.MethodPost, "/users/", f.tokens[actorUser],
+		models.User{Name: "x", Email: "x@fitlogic.test", Password: "x", Role: models.RoleUser})
+	f.expectError(http.StatusNotFound, "PROJECT_NOT_FOUND", http.MethodGet, "/projects/999", f.tokens[actorAdmin], nil)
+	f.expectError(http.StatusNotFound, "RISK_NOT_FOUND", http.MethodGet, "/risks/999", f.tokens[actorAdmin], nil)
+	f.expectError(http.StatusNotFound, "USER_NOT_FOUND", http.MethodGet, "/users/999", f.tokens[actorAdmin], nil)
+	f.expectError(http.StatusNotFound, "COUNTERMEASURE_NOT_FOUND", http.MethodGet, "/cms/999", f.tokens[actorAdmin], nil)
+
+	// records of other organization do not exist for foreign admin
+	f.expectError(http.StatusNotFound, "PROJECT_NOT_FOUND", http.MethodGet, f.path("/projects/%d", f.project),
+		f.tokens[actorForeign], nil)
+
+	f.expectError(http.StatusBadRequest, "START_DATE_AFTER_END", http.MethodPost, "/projects/", f.tokens[actorManager],
+		controllers.ProjectAPI{Name: "Reversed", Start: "2020-12-31T00:00:00Z", End: "2020-01-01T00:00:00Z",
+			ManagerID: f.ids[actorManager]})
+	req := f.riskRequest(f.ids[actorUser])
+	req.Probability = 2
+	f.expectError(http.StatusBadRequest, "PROBABILITY_OUT_OF_RANGE", http.MethodPost, "/risks/", f.tokens[actorUser], req)
+	f.expectError(http.StatusBadRequest, "INVALID_RISK_TRANSITION", http.MethodPost, f.path("/risks/%d/transition", f.risk),
+		f.tokens[actorUser], controllers.RiskTransitionRequest{Status: models.RiskStatusMonitored})
+	result := controllers.AssignResult{}
+	f.expect(http.StatusBadRequest, &result, http.MethodPost, f.path("/projects/%d/assignusers", f.project),
+		f.tokens[actorManager], controllers.AssignUsersRequest{IDs: []uint{999}})
+	if result.Code != "ASSIGNMENT_REJECTED" || len(result.NotFound) != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	f.expectError(http.StatusBadRequest, "SEARCH_QUERY_REQUIRED", http.MethodGet, "/search", f.tokens[actorUser], nil)
+	f.expectError(http.StatusBadRequest, "CANNOT_DELETE_ONLY_ADMIN", http.MethodDelete, f.path("/users/%d", f.ids[actorForeign]),
+		f.tokens[actorSuperAdmin], nil)
+	f.expectError(http.StatusPreconditionFailed, "VERSION_MISMATCH", http.MethodPut, f.path("/projects/%d", f.project),
+		f.tokens[actorManager], f.projectRequest(f.ids[actorManager]), common.HeaderIfMatch, `"999"`)
+	f.expectError(http.StatusMethodNotAllowed, common.CodeMethodNotAllowed, http.MethodPut, "/login", "", nil)
+}
+
+func TestNotFoundResponses(t *testing.T) {
+	forEachBackend(t, testNotFoundResponses)
+}
+
+func testNotFoundResponses(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+
+	type notFoundCase struct {
+		method string
+		path   string
+		code   string
+		body   interface{}
+	}
+	cases := []notFoundCase{
+		{http.MethodGet, "/organizations/999", "ORGANIZATION_NOT_FOUND", nil},
+		{http.MethodPut, "/organizations/999", "ORGANIZATION_NOT_FOUND", controllers.OrganizationAPI{Name: "x"}},
+		{http.MethodDelete, "/organizations/999", "ORGANIZATION_NOT_FOUND", nil},
+		{http.MethodGet, "/cms/999", "COUNTERMEASURE_NOT_FOUND", nil},
+		{http.MethodPut, "/cms/999", "COUNTERMEASURE_NOT_FOUND", controllers.CmAPI{Name: "x"}},
+		{http.MethodDelete, "/cms/999", "COUNTERMEASURE_NOT_FOUND", nil},
+	}
+	for _, entity := range []string{"users", "projects", "risks"} {
+		code := strings.ToUpper(strings.TrimSuffix(entity, "s")) + "_NOT_FOUND"
+		cases = append(cases,
+			notFoundCase{http.MethodGet, "/" + entity + "/999", code, nil},
+			notFoundCase{http.MethodPut, "/" + entity + "/999", code, map[string]interface{}{}},
+			notFoundCase{http.MethodPatch, "/" + entity + "/999", code, map[string]interface{}{}},
+			notFoundCase{http.MethodDelete, "/" + entity + "/999", code, nil},
+		)
+	}
+	for _, action := range []string{"assignusers", "unassignusers", "assignrisks", "unassignrisks"} {
+		cases = append(cases, notFoundCase{http.MethodPost, "/projects/999/" + action, "PROJECT_NOT_FOUND",
+			controllers.AssignUsersRequest{IDs: []uint{f.ids[actorUser]}}})
+	}
+	for _, path := range []string{"/risks/999/versions", "/risks/999/versions/1", "/risks/999/versions/diff?from=1&to=1",
+		"/risks/999/asof?time=2020-01-01T00:00:00Z"} {
+		cases = append(cases, notFoundCase{http.MethodGet, path, "RISK_NOT_FOUND", nil})
+	}
+	cases = append(cases,
+		notFoundCase{http.MethodPost, "/risks/999/transition", "RISK_NOT_FOUND",
+			controllers.RiskTransitionRequest{Status: models.RiskStatusAnalysed}},
+		notFoundCase{http.MethodPost, "/risks/999/versions/1/revert", "RISK_NOT_FOUND", nil},
+		notFoundCase{http.MethodGet, f.path("/risks/%d/versions/99", f.risk), "RISK_VERSION_NOT_FOUND", nil},
+		notFoundCase{http.MethodGet, f.path("/risks/%d/versions/diff?from=1&to=99", f.risk), "RISK_VERSION_NOT_FOUND", nil},
+		notFoundCase{http.MethodGet, f.path("/risks/%d/asof?time=2000-01-01T00:00:00Z", f.risk), "RISK_VERSION_NOT_FOUND", nil},
+		notFoundCase{http.MethodPost, f.path("/risks/%d/versions/99/revert", f.risk), "RISK_VERSION_NOT_FOUND", nil},
+	)
+
+	for _, c := range cases {
+		f.expectError(http.StatusNotFound, c.code, c.method, c.path, f.tokens[actorSuperAdmin], c.body)
+	}
+
+	// records of other organization are not found rather than forbidden
+	for _, path := range []string{f.path("/users/%d", f.ids[actorUser]), f.path("/projects/%d", f.project),
+		f.path("/risks/%d", f.risk), f.path("/cms/%d", f.cm)} {
+		rec := f.request(http.MethodGet, path, f.tokens[actorForeign], nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s as foreign admin: expected 404, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestUserRoutes(t *testing.T) {
+	update := func(f *fixture) interface{} {
+		return controllers.UpdateRequest{Name: f.name("User"), Email: f.name("user") + "@fitlogic.test"}
+	}
+	user := func(f *fixture) string { return f.path("/users/%d", f.ids[actorUser]) }
+	created := func(f *fixture) string {
+		return f.path("/users/%d", f.createUser(f.name("deleted"), models.RoleUser, 0))
+	}
+
+	runMatrix(t, []routeCase{
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return "/users/" },
+			body: func(f *fixture) interface{} {
+				name := f.name("new")
+				return models.User{Name: name, Email: name + "@fitlogic.test", Password: testPassword, Role: models.RoleUser}
+			},
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusForbidden,
+				actorUser: http.StatusForbidden, actorForeign: http.StatusOK,
+			},
+		},
+		{
+			method: http.MethodGet,
+			path:   func(f *fixture) string { return "/users/" },
+			want:   all(http.StatusOK),
+		},
+		{
+			method: http.MethodGet,
+			path:   user,
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusOK, actorStranger: http.StatusForbidden, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPut,
+			path:   user,
+			body:   update,
+			// managers change only themselves
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusForbidden,
+				actorUser: http.StatusOK, actorStranger: http.StatusForbidden, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPatch,
+			path:   user,
+			body:   func(f *fixture) interface{} { return map[string]string{"Skills": f.name("skill")} },
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusForbidden,
+				actorUser: http.StatusOK, actorStranger: http.StatusForbidden, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return f.path("/users/%d/changepassword", f.ids[actorStranger]) },
+			body: func(f *fixture) interface{} {
+				return controllers.ChangePasswordRequest{OldPassword: testPassword, NewPassword: testPassword}
+			},
+			want: map[string]int{
+				actorSuperAdmin: http.StatusForbidden, actorAdmin: http.StatusForbidden, actorManager: http.StatusForbidden,
+				actorUser: http.StatusForbidden, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodDelete,
+			path:   created,
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusForbidden,
+				actorUser: http.StatusForbidden, actorStranger: http.StatusForbidden, actorForeign: http.StatusNotFound,
+			},
+		},
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	forEachBackend(t, testChangePassword)
+}
+
+func testChangePassword(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+	path := f.path("/users/%d/changepassword", f.ids[actorUser])
+
+	f.expectError(http.StatusUnauthorized, "WRONG_PASSWORD", http.MethodPost, path, f.tokens[actorUser],
+		controllers.ChangePasswordRequest{OldPassword: "wrong", NewPassword: "new"})
+	f.expect(http.StatusOK, nil, http.MethodPost, path, f.tokens[actorUser],
+		controllers.ChangePasswordRequest{OldPassword: testPassword, NewPassword: "new"})
+
+	// sessions of user are revoked
+	f.expectError(http.StatusUnauthorized, "SESSION_REVOKED", http.MethodGet, "/users/", f.tokens[actorUser], nil)
+	f.login(actorUser+"@fitlogic.test", "new")
+}
+
+func TestChangeRoleRevokesSessions(t *testing.T) {
+	forEachBackend(t, testChangeRoleRevokesSessions)
+}
+
+func testChangeRoleRevokesSessions(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+	path := f.path("/users/%d", f.ids[actorStranger])
+
+	// users cannot change roles, the role is kept
+	user := models.User{}
+	f.expect(http.StatusOK, &user, http.MethodPut, path, f.tokens[actorStranger],
+		controllers.UpdateRequest{Name: "stranger", Email: "stranger@fitlogic.test", Role: models.RoleManager})
+	if user.Role != models.RoleUser {
+		t.Errorf("user changed role to %d", user.Role)
+	}
+	f.expect(http.StatusOK, nil, http.MethodGet, "/users/", f.tokens[actorStranger], nil)
+
+	// nobody can promote user above himself
+	f.expectError(http.StatusForbidden, "INSUFFICIENT_PRIVILEGES", http.MethodPatch, path, f.tokens[actorAdmin],
+		map[string]int{"Role": models.RoleSuperAdmin})
+
+	f.expect(http.StatusOK, &user, http.MethodPut, path, f.tokens[actorAdmin],
+		controllers.UpdateRequest{Name: "stranger", Email: "stranger@fitlogic.test", Role: models.RoleManager})
+	if user.Role != models.RoleManager {
+		t.Errorf("admin did not change role, it is %d", user.Role)
+	}
+	f.expectError(http.StatusUnauthorized, "SESSION_REVOKED", http.MethodGet, "/users/", f.tokens[actorStranger], nil)
+
+	// manager who leads a project cannot be downgraded
+	f.expectError(http.StatusBadRequest, "MANAGER_STILL_LEADS_PROJECTS", http.MethodPut,
+		f.path("/users/%d", f.ids[actorManager]), f.tokens[actorAdmin],
+		controllers.UpdateRequest{Name: "manager", Email: "manager@fitlogic.test", Role: models.RoleUser})
+}
+
+func TestRoleOfSuperAdminIsSet(t *testing.T) {
+	forEachBackend(t, testRoleOfSuperAdminIsSet)
+}
+
+func testRoleOfSuperAdminIsSet(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+
+	// role of super-admin is not blank, so it passes validation
+	name := f.name("superadmin")
+	path := f.path("/users/%d", f.createUser(name, models.RoleSuperAdmin, 0))
+	f.expectError(http.StatusBadRequest, common.CodeValidationFailed, http.MethodPost, "/users/",
+		f.tokens[actorSuperAdmin], models.User{Name: "blank", Email: "blank@fitlogic.test", Password: testPassword})
+
+	// blank role of update keeps the role, null one of patch is rejected
+	user := models.User{}
+	f.expect(http.StatusOK, &user, http.MethodPut, path, f.tokens[actorSuperAdmin],
+		controllers.UpdateRequest{Name: name, Email: name + "@fitlogic.test"})
+	if user.Role != models.RoleSuperAdmin {
+		t.Errorf("update without role changed role of super-admin to %d", user.Role)
+	}
+	f.expectError(http.StatusBadRequest, common.CodeValidationFailed, http.MethodPatch, path,
+		f.tokens[actorSuperAdmin], map[string]interface{}{"Role": nil})
+}
+
+func TestProjectRoutes(t *testing.T) {
+	project := func(f *fixture) string { return f.path("/projects/%d", f.project) }
+	created := func(f *fixture) string { return f.path("/projects/%d", f.createProject(actorManager)) }
+	update := func(f *fixture) interface{} { return f.projectRequest(f.ids[actorManager]) }
+	ids := func(id func(f *fixture) uint) func(f *fixture) interface{} {
+		return func(f *fixture) interface{} { return common.IDsRequest{IDs: []uint{id(f)}} }
+	}
+	stranger := func(f *fixture) uint { return f.ids[actorStranger] }
+	// the request is rejected for everyone who can change the project,
+	// stranger is not assigned yet
+	notAssigned := map[string]int{
+		actorSuperAdmin: http.StatusBadRequest, actorAdmin: http.StatusBadRequest, actorManager: http.StatusBadRequest,
+		actorUser: http.StatusForbidden, actorStranger: http.StatusNotFound, actorForeign: http.StatusNotFound,
+	}
+
+	runMatrix(t, []routeCase{
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return "/projects/" },
+			body:   update,
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusForbidden, actorStranger: http.StatusForbidden,
+				// manager of other organization does not exist for him
+				actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodGet,
+			path:   func(f *fixture) string { return "/projects/" },
+			want:   all(http.StatusOK),
+		},
+		{
+			method: http.MethodGet,
+			path:   project,
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusOK, actorStranger: http.StatusNotFound, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPut,
+			path:   project,
+			body:   update,
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusForbidden, actorStranger: http.StatusNotFound, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPatch,
+			path:   project,
+			body:   func(f *fixture) interface{} { return map[string]interface{}{"Description": f.name("patched")} },
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusForbidden, actorStranger: http.StatusNotFound, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return f.path("/projects/%d/unassignusers", f.project) },
+			body:   ids(stranger),
+			want:   notAssigned,
+		},
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return f.path("/projects/%d/assignusers?partial=true", f.project) },
+			body:   ids(stranger),
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusForbidden, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return f.path("/projects/%d/unassignrisks", f.project) },
+			body:   ids(func(f *fixture) uint { return f.createRisk(actorUser) }),
+			want: map[string]int{
+				actorSuperAdmin: http.StatusBadRequest, actorAdmin: http.StatusBadRequest, actorManager: http.StatusBadRequest,
+				actorUser: http.StatusBadRequest, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return f.path("/projects/%d/assignrisks", f.project) },
+			body:   ids(func(f *fixture) uint { return f.createRisk(actorUser) }),
+			// manager does not see risks of users outside of his projects
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusBadRequest,
+				actorUser: http.StatusOK, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return "/projects/risks" },
+			body:   ids(func(f *fixture) uint { return f.project }),
+			want:   all(http.StatusOK),
+		},
+		{
+			method: http.MethodDelete,
+			path:   created,
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusNotFound, actorStranger: http.StatusNotFound, actorForeign: http.StatusNotFound,
+			},
+		},
+	})
+}
+
+func TestRisksOfProjectsSkipInvisibleProjects(t *testing.T) {
+	forEachBackend(t, testRisksOfProjectsSkipInvisibleProjects)
+}
+
+func testRisksOfProjectsSkipInvisibleProjects(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+
+	risks := []models.Risk{}
+	f.expect(http.StatusOK, &risks, http.MethodPost, "/projects/risks", f.tokens[actorUser],
+		common.IDsRequest{IDs: []uint{f.project, 999}})
+	if len(risks) != 1 || risks[0].ID != f.risk {
+		t.Errorf("expected risk %d of project, got %+v", f.risk, risks)
+	}
+
+	f.expect(http.StatusOK, &risks, http.MethodPost, "/projects/risks", f.tokens[actorStranger],
+		common.IDsRequest{IDs: []uint{f.project}})
+	if len(risks) != 0 {
+		t.Errorf("stranger got risks of project he is not member of: %+v", risks)
+	}
+}
+
+func TestRiskRoutes(t *testing.T) {
+	risk := func(f *fixture) string { return f.path("/risks/%d", f.risk) }
+	fresh := func(format string) func(f *fixture) string {
+		return func(f *fixture) string { return f.path(format, f.createProjectRisk()) }
+	}
+	update := func(f *fixture) interface{} {
+		req := f.riskRequest(f.ids[actorUser])
+		req.Name = "Risk of user"
+		return req
+	}
+	// only members of project and admins of its organization see the risk
+	readers := map[string]int{
+		actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+		actorUser: http.StatusOK, actorStranger: http.StatusNotFound, actorForeign: http.StatusNotFound,
+	}
+
+	runMatrix(t, []routeCase{
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return "/risks/" },
+			body:   func(f *fixture) interface{} { return f.riskRequest(f.ids[actorUser]) },
+			// only admins create risks owned by others
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusForbidden,
+				actorUser: http.StatusOK, actorStranger: http.StatusForbidden, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodGet,
+			path:   func(f *fixture) string { return "/risks/" },
+			want:   all(http.StatusOK),
+		},
+		{method: http.MethodGet, path: risk, want: readers},
+		{method: http.MethodPut, path: risk, body: update, want: readers},
+		{
+			method: http.MethodPatch,
+			path:   risk,
+			body:   func(f *fixture) interface{} { return map[string]interface{}{"Description": f.name("patched")} },
+			want:   readers,
+		},
+		{
+			method: http.MethodPost,
+			path:   fresh("/risks/%d/transition"),
+			body: func(f *fixture) interface{} {
+				return controllers.RiskTransitionRequest{Status: models.RiskStatusAnalysed}
+			},
+			want: readers,
+		},
+		{
+			method: http.MethodPost,
+			path:   fresh("/risks/%d/transition"),
+			body: func(f *fixture) interface{} {
+				return controllers.RiskTransitionRequest{Status: models.RiskStatusClosed, Reason: "Gone"}
+			},
+			// closing needs owner of project
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusForbidden, actorStranger: http.StatusNotFound, actorForeign: http.StatusNotFound,
+			},
+		},
+		{method: http.MethodGet, path: fresh("/risks/%d/versions"), want: readers},
+		{method: http.MethodGet, path: fresh("/risks/%d/versions/1"), want: readers},
+		{method: http.MethodGet, path: fresh("/risks/%d/versions/diff?from=1&to=1"), want: readers},
+		{method: http.MethodGet, path: fresh("/risks/%d/asof?time=2099-01-01T00:00:00Z"), want: readers},
+		{method: http.MethodPost, path: fresh("/risks/%d/versions/1/revert"), want: readers},
+		{
+			method: http.MethodPost,
+			path:   fresh("/risks/%d/assigncms"),
+			body:   func(f *fixture) interface{} { return common.IDsRequest{IDs: []uint{f.cm}} },
+			want:   readers,
+		},
+		{
+			method: http.MethodPost,
+			// countermeasure is not assigned to a fresh risk
+			path: fresh("/risks/%d/unassigncms?partial=true"),
+			body: func(f *fixture) interface{} { return common.IDsRequest{IDs: []uint{f.cm}} },
+			want: readers,
+		},
+		{
+			method: http.MethodDelete,
+			path:   fresh("/risks/%d"),
+			// deleting needs owner of risk or of project
+			want: readers,
+		},
+	})
+}
+
+func TestRiskHistory(t *testing.T) {
+	forEachBackend(t, testRiskHistory)
+}
+
+func testRiskHistory(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+	token := f.tokens[actorUser]
+
+	req := f.riskRequest(f.ids[actorUser])
+	req.Name = "Renamed"
+	f.expect(http.StatusOK, nil, http.MethodPut, f.path("/risks/%d", f.risk), token, req)
+
+	versions := []models.RiskVersion{}
+	f.expect(http.StatusOK, &versions, http.MethodGet, f.path("/risks/%d/versions", f.risk), token, nil)
+	if len(versions) != 2 || versions[1].Data.Name != "Renamed" {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	diff := controllers.RiskVersionsDiff{}
+	f.expect(http.StatusOK, &diff, http.MethodGet, f.path("/risks/%d/versions/diff?from=1&to=2", f.risk), token, nil)
+	if _, ok := diff.Changes["Name"]; !ok || len(diff.Changes) != 1 {
+		t.Errorf("unexpected diff %+v", diff)
+	}
+
+	risk := models.Risk{}
+	f.expect(http.StatusOK, &risk, http.MethodPost, f.path("/risks/%d/versions/1/revert", f.risk), token, nil)
+	if risk.Name != versions[0].Data.Name {
+		t.Errorf("risk was not reverted, name is %q", risk.Name)
+	}
+	f.expect(http.StatusOK, &versions, http.MethodGet, f.path("/risks/%d/versions", f.risk), token, nil)
+	if len(versions) != 3 {
+		t.Errorf("revert was not stored as version, got %d versions", len(versions))
+	}
+
+	f.expectError(http.StatusNotFound, "RISK_VERSION_NOT_FOUND", http.MethodGet, f.path("/risks/%d/versions/9", f.risk), token, nil)
+	f.expectError(http.StatusBadRequest, "INVALID_QUERY_PARAM", http.MethodGet, f.path("/risks/%d/asof", f.risk), token, nil)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	forEachBackend(t, testConditionalRequests)
+}
+
+func testConditionalRequests(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+	token := f.tokens[actorUser]
+	path := f.path("/risks/%d", f.risk)
+
+	rec := f.expect(http.StatusOK, nil, http.MethodGet, path, token, nil)
+	read := rec.Header().Get(common.HeaderETag)
+	rec = f.expect(http.StatusNotModified, nil, http.MethodGet, path, token, nil, common.HeaderIfNoneMatch, read)
+	if rec.Body.Len() != 0 {
+		t.Errorf("response 304 has body %q", rec.Body.String())
+	}
+
+	// a version alone or with other digest is not the tag of the record
+	version := strings.SplitN(strings.Trim(read, `"`), "-", 2)[0]
+	for _, tag := range []string{`"` + version + `"`, `"` + version + `-garbage"`, "W/" + read} {
+		f.expectError(http.StatusPreconditionFailed, "VERSION_MISMATCH", http.MethodPut, path, token,
+			f.riskRequest(f.ids[actorUser]), common.HeaderIfMatch, tag)
+	}
+
+	rec = f.expect(http.StatusOK, nil, http.MethodPut, path, token, f.riskRequest(f.ids[actorUser]), common.HeaderIfMatch, read)
+	updated := rec.Header().Get(common.HeaderETag)
+	if updated == "" || updated == read {
+		t.Fatalf("update did not change tag %q", read)
+	}
+	f.expect(http.StatusNotModified, nil, http.MethodGet, path, token, nil, common.HeaderIfNoneMatch, updated)
+	f.expectError(http.StatusPreconditionFailed, "VERSION_MISMATCH", http.MethodPut, path, token,
+		f.riskRequest(f.ids[actorUser]), common.HeaderIfMatch, read)
+
+	// tag of response to change is accepted by next change
+	rec = f.expect(http.StatusOK, nil, http.MethodPatch, path, token, map[string]string{"Description": "Patched"},
+		common.HeaderIfMatch, updated)
+	patched := rec.Header().Get(common.HeaderETag)
+	f.expect(http.StatusOK, nil, http.MethodPut, path, token, f.riskRequest(f.ids[actorUser]), common.HeaderIfMatch, patched)
+	f.expect(http.StatusOK, nil, http.MethodPut, path, token, f.riskRequest(f.ids[actorUser]), common.HeaderIfMatch, "*")
+
+	// changed associations change the tag of record
+	rec = f.expect(http.StatusOK, nil, http.MethodGet, path, token, nil)
+	read = rec.Header().Get(common.HeaderETag)
+	f.expect(http.StatusOK, nil, http.MethodPost, f.path("/risks/%d/assigncms", f.risk), token, common.IDsRequest{IDs: []uint{f.cm}})
+	f.expect(http.StatusOK, nil, http.MethodGet, path, token, nil, common.HeaderIfNoneMatch, read)
+	f.expectError(http.StatusPreconditionFailed, "VERSION_MISMATCH", http.MethodDelete, path, token, nil,
+		common.HeaderIfMatch, read)
+
+	projectPath := f.path("/projects/%d", f.project)
+	rec = f.expect(http.StatusOK, nil, http.MethodGet, projectPath, f.tokens[actorManager], nil)
+	f.expect(http.StatusOK, nil, http.MethodPut, projectPath, f.tokens[actorManager], f.projectRequest(f.ids[actorManager]),
+		common.HeaderIfMatch, rec.Header().Get(common.HeaderETag))
+	f.expectError(http.StatusPreconditionFailed, "VERSION_MISMATCH", http.MethodPut, projectPath, f.tokens[actorManager],
+		f.projectRequest(f.ids[actorManager]), common.HeaderIfMatch, rec.Header().Get(common.HeaderETag))
+
+	userPath := f.path("/users/%d", f.ids[actorUser])
+	rec = f.expect(http.StatusOK, nil, http.MethodGet, userPath, f.tokens[actorAdmin], nil)
+	f.expect(http.StatusOK, nil, http.MethodPut, userPath, f.tokens[actorAdmin],
+		controllers.UpdateRequest{Name: f.name("User"), Email: f.name("user") + "@fitlogic.test"},
+		common.HeaderIfMatch, rec.Header().Get(common.HeaderETag))
+	f.expectError(http.StatusPreconditionFailed, "VERSION_MISMATCH", http.MethodPut, userPath, f.tokens[actorAdmin],
+		controllers.UpdateRequest{Name: f.name("User"), Email: f.name("user") + "@fitlogic.test"},
+		common.HeaderIfMatch, rec.Header().Get(common.HeaderETag))
+}
+
+func TestOrganizationRoutes(t *testing.T) {
+	own := func(f *fixture) string { return "/organizations/1" }
+
+	runMatrix(t, []routeCase{
+		{
+			method: http.MethodPost,
+			path:   func(f *fixture) string { return "/organizations/" },
+			body:   func(f *fixture) interface{} { return controllers.OrganizationAPI{Name: f.name("Organization")} },
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusForbidden, actorUser: http.StatusForbidden,
+			},
+		},
+		{
+			method: http.MethodGet,
+			path:   func(f *fixture) string { return "/organizations/" },
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusForbidden, actorUser: http.StatusForbidden,
+			},
+		},
+		{
+			method: http.MethodGet,
+			path:   own,
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorUser: http.StatusOK,
+				actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodPut,
+			path:   own,
+			body:   func(f *fixture) interface{} { return controllers.OrganizationAPI{Name: models.DefaultOrganizationName} },
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusForbidden,
+				actorUser: http.StatusForbidden, actorForeign: http.StatusNotFound,
+			},
+		},
+		{
+			method: http.MethodDelete,
+			path:   func(f *fixture) string { return f.path("/organizations/%d", f.org) },
+			want: map[string]int{
+				actorAdmin: http.StatusForbidden, actorForeign: http.StatusForbidden,
+				// organization still has foreign admin
+				actorSuperAdmin: http.StatusBadRequest,
+			},
+		},
+	})
+}
+
+func TestCounterMeasureRoutes(t *testing.T) {
+	cm := func(f *fixture) string { return f.path("/cms/%d", f.cm) }
+	body := func(f *fixture) interface{} { return controllers.CmAPI{Name: f.name("Countermeasure"), Cost: 10} }
+	managers := map[string]int{
+		actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+		actorUser: http.StatusForbidden, actorForeign: http.StatusNotFound,
+	}
+
+	runMatrix(t, []routeCase{
+		{method: http.MethodPost, path: func(f *fixture) string { return "/cms/" }, body: body, want: all(http.StatusOK)},
+		{method: http.MethodGet, path: func(f *fixture) string { return "/cms/" }, want: all(http.StatusOK)},
+		{
+			method: http.MethodGet,
+			path:   cm,
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusOK, actorForeign: http.StatusNotFound,
+			},
+		},
+		{method: http.MethodPut, path: cm, body: body, want: managers},
+		{
+			method: http.MethodDelete,
+			path: func(f *fixture) string {
+				created := models.CounterMeasure{}
+				f.expect(http.StatusOK, &created, http.MethodPost, "/cms/", f.tokens[actorManager], body(f))
+				return f.path("/cms/%d", created.ID)
+			},
+			want: managers,
+		},
+	})
+}
+
+func TestAuditAndSearchRoutes(t *testing.T) {
+	forEachBackend(t, testAuditAndSearchRoutes)
+}
+
+func testAuditAndSearchRoutes(t *testing.T, repos backend) {
+	runMatrix(t, []routeCase{
+		{
+			method: http.MethodGet,
+			path:   func(f *fixture) string { return "/audit/" },
+			want: map[string]int{
+				actorSuperAdmin: http.StatusOK, actorAdmin: http.StatusOK, actorManager: http.StatusOK,
+				actorUser: http.StatusForbidden, actorForeign: http.StatusOK,
+			},
+		},
+		{
+			method: http.MethodGet,
+			path:   func(f *fixture) string { return "/search?q=Risk" },
+			want:   all(http.StatusOK),
+		},
+	})
+
+	f := newFixture(t, repos)
+	entries := []models.AuditEntry{}
+	f.expect(http.StatusOK, &entries, http.MethodGet, f.path("/audit/?entity=project&entityId=%d", f.project),
+		f.tokens[actorManager], nil)
+	if len(entries) == 0 {
+		t.Error("manager does not see audit of his project")
+	}
+	f.expect(http.StatusOK, &entries, http.MethodGet, "/audit/", f.tokens[actorForeign], nil)
+	for _, entry := range entries {
+		if entry.OrganizationID != f.org {
+			t.Errorf("foreign admin sees entry of other organization %+v", entry)
+		}
+	}
+
+	results := []access.SearchResult{}
+	f.expect(http.StatusOK, &results, http.MethodGet, "/search?q=Risk", f.tokens[actorStranger], nil)
+	if len(results) != 0 {
+		t.Errorf("stranger found records he cannot see: %+v", results)
+	}
+}
+
+func TestDocumentationRoutes(t *testing.T) {
+	forEachBackend(t, testDocumentationRoutes)
+}
+
+func testDocumentationRoutes(t *testing.T, repos backend) {
+	s := newTestServer(t, repos)
+
+	spec := map[string]interface{}{}
+	s.expect(http.StatusOK, &spec, http.MethodGet, specPath, "", nil)
+	if spec["openapi"] == nil {
+		t.Errorf("unexpected document %v", spec)
+	}
+	doc := docs.Document{}
+	s.expect(http.StatusOK, &doc, http.MethodGet, specPath, "", nil)
+	exposure := doc.Components.Schemas["RiskAPI"].Properties["Exposure"]
+	if exposure == nil || !exposure.ReadOnly || !strings.Contains(exposure.Description, "Value") {
+		t.Errorf("exposure of risk is documented as %+v", exposure)
+	}
+	rec := s.expect(http.StatusOK, nil, http.MethodGet, docsPath, "", nil)
+	if !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), echo.MIMETextHTML) {
+		t.Errorf("docs page has content type %q", rec.Header().Get(echo.HeaderContentType))
+	}
+}
+
+func TestEveryRouteHasOperation(t *testing.T) {
+	e, err := newRouter(access.NewMemoryRepositories(access.NewMemoryStore()), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range e.Routes() {
+		// catch-all routes of groups are added by echo
+		if strings.HasPrefix(route.Name, "github.com/labstack/echo.") {
+			continue
+		}
+		if _, ok := operations[docs.Key(route.Method, route.Path)]; !ok {
+			t.Errorf("route %s %s has no operation", route.Method, route.Path)
+		}
+	}
+}
+
+func TestRouteWithoutOperationIsError(t *testing.T) {
+	key := docs.Key(http.MethodGet, "/risks/:id")
+	op := operations[key]
+	delete(operations, key)
+	defer func() { operations[key] = op }()
+
+	e, err := newRouter(access.NewMemoryRepositories(access.NewMemoryStore()), testSecret)
+	routeErr, ok := err.(*docs.RouteError)
+	if e != nil || !ok {
+		t.Fatalf("router created without operation: %v", err)
+	}
+	if len(routeErr.Undocumented) != 1 || routeErr.Undocumented[0] != key {
+		t.Errorf("unexpected routes without operation %v", routeErr.Undocumented)
+	}
+}
+
+// tokenClaims will decode claims of token signed by testSecret
+func tokenClaims(t *testing.T, token string) jwt.MapClaims {
+	t.Helper()
+
+	parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return testSecret, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Claims.(jwt.MapClaims)
+}
+
+func TestLoginTokenCarriesCurrentRole(t *testing.T) {
+	forEachBackend(t, testLoginTokenCarriesCurrentRole)
+}
+
+func testLoginTokenCarriesCurrentRole(t *testing.T, repos backend) {
+	f := newFixture(t, repos)
+
+	for actor, role := range map[string]int{actorSuperAdmin: models.RoleSuperAdmin, actorManager: models.RoleManager} {
+		claims := tokenClaims(t, f.tokens[actor])
+		if int(claims["role"].(float64)) != role || uint(claims["userId"].(float64)) != f.idOf(actor) {
+			t.Errorf("unexpected claims of %s: %v", actor, claims)
+		}
+	}
+}
+
+// idOf will return ID of actor, super-admin is the default admin
+func (f *fixture) idOf(actor string) uint {
+	if actor == actorSuperAdmin {
+		return 1
+	}
+	return f.ids[actor]
+}
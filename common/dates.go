@@ -0,0 +1,139 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// DateFormatParam is a query parameter that selects format of dates
+// of projects and risks in request body and response
+const DateFormatParam = "dateFormat"
+
+// formats of dates selected by DateFormatParam, config is TimeFormat
+// from configuration
+const (
+	DateFormatRFC3339 = "rfc3339"
+	DateFormatDate    = "date"
+	DateFormatConfig  = "config"
+)
+
+// dateFields are fields of projects and risks formatted by FormatDates
+var dateFields = map[string]bool{
+	"Start": true,
+	"End":   true,
+}
+
+// DateLayout will return layout of dates selected by query parameter
+// dateFormat of request, RFC 3339 is used when it is not sent
+func DateLayout(ctx echo.Context) (string, error) {
+	switch ctx.QueryParam(DateFormatParam) {
+	case "", DateFormatRFC3339:
+		return time.RFC3339, nil
+	case DateFormatDate:
+		return "2006-01-02", nil
+	case DateFormatConfig:
+		return viper.GetString("TimeFormat"), nil
+	}
+
+	return "", ErrInvalidQueryParam
+}
+
+// FormatDates is a middleware that formats fields Start and End of JSON
+// responses by layout selected by dateFormat, without the parameter
+// responses are sent as they are with dates in RFC 3339
+func FormatDates(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		layout, err := DateLayout(ctx)
+		if err != nil {
+			return NewError(http.StatusBadRequest, err)
+		}
+		if ctx.QueryParam(DateFormatParam) == "" {
+			return next(ctx)
+		}
+
+		res := ctx.Response()
+		original := res.Writer
+		buffered := &bufferedWriter{ResponseWriter: original}
+		res.Writer = buffered
+		err = next(ctx)
+		res.Writer = original
+		if buffered.status == 0 {
+			return err
+		}
+
+		body := buffered.body.Bytes()
+		if strings.HasPrefix(res.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+			body = formatDatesInJSON(body, layout)
+		}
+		original.WriteHeader(buffered.status)
+		if _, werr := original.Write(body); werr != nil && err == nil {
+			err = werr
+		}
+
+		return err
+	}
+}
+
+// formatDatesInJSON will format date fields anywhere in body, body
+// is returned unchanged if it cannot be decoded
+func formatDatesInJSON(body []byte, layout string) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return body
+	}
+
+	formatted, err := json.Marshal(formatDates(value, layout))
+	if err != nil {
+		return body
+	}
+	// the same as body encoded by echo
+	return append(formatted, '\n')
+}
+
+// formatDates will format date fields of decoded JSON value
+func formatDates(value interface{}, layout string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if s, ok := field.(string); ok && dateFields[key] {
+				if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					v[key] = t.Format(layout)
+				}
+				continue
+			}
+			v[key] = formatDates(field, layout)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = formatDates(v[i], layout)
+		}
+	}
+
+	return value
+}
+
+// bufferedWriter keeps response so it can be changed before it is sent
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
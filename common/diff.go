@@ -0,0 +1,107 @@
+package common
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// RedactedValue replaces values of secret fields in audit log
+const RedactedValue = "[redacted]"
+
+// secretFields are fields whose values are never written to audit log
+var secretFields = map[string]bool{
+	"Password": true,
+}
+
+// versionFields are incremented by every update like UpdatedAt of
+// gorm.Model, their changes are not changes of the entity
+var versionFields = map[string]bool{
+	"Version": true,
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Diff will return changed fields of two structs of the same type, nil is
+// taken as struct with zero values so created and deleted entities can be
+// described too. Embedded structs (gorm.Model) and associations are left out
+func Diff(before, after interface{}) models.Changes {
+	changes := models.Changes{}
+
+	b, a := structValue(before), structValue(after)
+	if !b.IsValid() && !a.IsValid() {
+		return changes
+	}
+	var t reflect.Type
+	if a.IsValid() {
+		t = a.Type()
+	} else {
+		t = b.Type()
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous || field.PkgPath != "" || !isAuditedType(field.Type) || versionFields[field.Name] {
+			continue
+		}
+
+		var oldValue, newValue interface{}
+		if b.IsValid() {
+			oldValue = b.Field(i).Interface()
+		}
+		if a.IsValid() {
+			newValue = a.Field(i).Interface()
+		}
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		// field did not exist before or after, zero value is not a change
+		if oldValue == nil && isZero(a.Field(i)) || newValue == nil && isZero(b.Field(i)) {
+			continue
+		}
+
+		if secretFields[field.Name] {
+			if oldValue != nil {
+				oldValue = RedactedValue
+			}
+			if newValue != nil {
+				newValue = RedactedValue
+			}
+		}
+		changes[field.Name] = models.Change{Old: oldValue, New: newValue}
+	}
+
+	return changes
+}
+
+// structValue will dereference pointer to struct, nil gives invalid value
+func structValue(v interface{}) reflect.Value {
+	value := reflect.ValueOf(v)
+	for value.IsValid() && value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+
+	return value
+}
+
+// isAuditedType will return whether field of type t is a plain value,
+// associations are audited by their own entries
+func isAuditedType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+		return false
+	case reflect.Struct:
+		return t == timeType
+	}
+
+	return true
+}
+
+// isZero will return whether value is zero value of its type
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
@@ -1,6 +1,14 @@
 package common
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/labstack/echo"
+)
 
 // Error messages used in return JSONs
 var (
@@ -14,6 +22,8 @@ var (
 
 	ErrCannotCreateProjectForOthers = errors.New("Cannot create project with other user as project manager")
 
+	ErrCannotHandProjectToOthers = errors.New("Cannot make other user project manager")
+
 	ErrWrongPassword = errors.New("Wrong password")
 
 	ErrManagerStillLeadsProjects = errors.New("Manager you want to downgrade still leads a project")
@@ -23,17 +33,283 @@ var (
 	ErrStartDateAfterEnd = errors.New("Start date is the same or after end date")
 
 	ErrCannotDeleteOnlyAdmin = errors.New("Cannot delete only administrator")
+
+	ErrSessionRevoked = errors.New("Session of sent token was revoked or has expired. Log in again to obtain a new token")
+
+	ErrInvalidRefreshToken = errors.New("Refresh token is invalid or has expired")
+
+	ErrRefreshTokenReused = errors.New("Refresh token was already used, its session was revoked. Log in again to obtain a new token")
+
+	ErrUserNoLongerExists = errors.New("User of sent token no longer exists")
+
+	ErrOrganizationDoesNotExist = errors.New("Organization does not exist")
+
+	ErrOrganizationNotEmpty = errors.New("Organization still has users")
+
+	ErrAssociationOutsideOrganization = errors.New("Cannot associate records of different organizations")
+
+	ErrProbabilityOutOfRange = errors.New("Probability has to be between 0 and 1")
+
+	ErrInvalidImpact = errors.New("Impact has to be one of 0.05, 0.1, 0.2, 0.4 or 0.8")
+
+	ErrUnknownRole = errors.New("Unknown role of user, use one of 1 (super-admin), 2 (admin), 3 (manager) or 4 (user)")
+
+	ErrUnknownRiskStatus = errors.New("Unknown status of risk, use one of identified, analysed, mitigating, monitored, closed or occurred")
+
+	ErrInvalidRiskTransition = errors.New("Risk cannot move from its current status to the requested one")
+
+	ErrReasonRequired = errors.New("Reason is required to close a risk")
+
+	ErrInvalidQueryParam = errors.New("Query parameter has invalid format")
+
+	ErrAuditFailed = errors.New("Change was not made because it could not be written to audit log")
+
+	ErrSearchQueryRequired = errors.New("Search query q is required")
+
+	ErrAssignmentRejected = errors.New("Some of sent IDs cannot be changed, nothing was changed. Send partial=true to change the rest")
+
+	ErrPatchNotObject = errors.New("Merge patch has to be a JSON object")
+
+	ErrVersionMismatch = errors.New("Record was changed since sent ETag was read, read it again and repeat the request")
+
+	ErrPendingMigrations = errors.New("Database has pending migrations, run fitlogic migrate up")
+	ErrNoAppliedMigration = errors.New("No migration is applied")
+	ErrIrreversibleMigration = errors.New("Migration cannot be reverted")
+	ErrUnknownMigration = errors.New("Database has a migration unknown to this version of fitlogic")
+)
+
+// ErrorCode is a stable code of error and HTTP status it is sent with
+type ErrorCode struct {
+	Code string
+	Status int
+}
+
+// codes of errors that are not in ErrorCodes, they are given by HTTP
+// status of request
+const (
+	CodeBadRequest = "BAD_REQUEST"
+	CodeUnauthorized = "UNAUTHORIZED"
+	CodeForbidden = "FORBIDDEN"
+	CodeNotFound = "NOT_FOUND"
+	CodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	CodeConflict = "CONFLICT"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeValidationFailed = "VALIDATION_FAILED"
+	CodeInternal = "INTERNAL_ERROR"
 )
 
+// ErrorCodes maps every error of this package to its code and HTTP status,
+// codes are part of API and must not change
+var ErrorCodes = map[error]ErrorCode{
+	ErrWrongEmailOrPassword: {"WRONG_EMAIL_OR_PASSWORD", http.StatusUnauthorized},
+	ErrMissingTokenClaims: {"MISSING_TOKEN_CLAIMS", http.StatusUnauthorized},
+	ErrUnsufficientPrivileges: {"INSUFFICIENT_PRIVILEGES", http.StatusForbidden},
+	ErrIdInPathWrongFormat: {"INVALID_ID_IN_PATH", http.StatusBadRequest},
+	ErrCannotCreateProjectForOthers: {"CANNOT_CREATE_PROJECT_FOR_OTHERS", http.StatusForbidden},
+	ErrCannotHandProjectToOthers: {"CANNOT_HAND_PROJECT_TO_OTHERS", http.StatusForbidden},
+	ErrWrongPassword: {"WRONG_PASSWORD", http.StatusUnauthorized},
+	ErrManagerStillLeadsProjects: {"MANAGER_STILL_LEADS_PROJECTS", http.StatusBadRequest},
+	ErrDateOutOfRange: {"DATE_OUT_OF_RANGE", http.StatusBadRequest},
+	ErrStartDateAfterEnd: {"START_DATE_AFTER_END", http.StatusBadRequest},
+	ErrCannotDeleteOnlyAdmin: {"CANNOT_DELETE_ONLY_ADMIN", http.StatusBadRequest},
+	ErrSessionRevoked: {"SESSION_REVOKED", http.StatusUnauthorized},
+	ErrInvalidRefreshToken: {"INVALID_REFRESH_TOKEN", http.StatusUnauthorized},
+	ErrRefreshTokenReused: {"REFRESH_TOKEN_REUSED", http.StatusUnauthorized},
+	ErrUserNoLongerExists: {"USER_NO_LONGER_EXISTS", http.StatusUnauthorized},
+	ErrOrganizationDoesNotExist: {"ORGANIZATION_NOT_FOUND", http.StatusBadRequest},
+	ErrOrganizationNotEmpty: {"ORGANIZATION_NOT_EMPTY", http.StatusBadRequest},
+	ErrAssociationOutsideOrganization: {"ASSOCIATION_OUTSIDE_ORGANIZATION", http.StatusBadRequest},
+	ErrProbabilityOutOfRange: {"PROBABILITY_OUT_OF_RANGE", http.StatusBadRequest},
+	ErrInvalidImpact: {"INVALID_IMPACT", http.StatusBadRequest},
+	ErrUnknownRole: {"UNKNOWN_ROLE", http.StatusBadRequest},
+	ErrUnknownRiskStatus: {"UNKNOWN_RISK_STATUS", http.StatusBadRequest},
+	ErrInvalidRiskTransition: {"INVALID_RISK_TRANSITION", http.StatusBadRequest},
+	ErrReasonRequired: {"REASON_REQUIRED", http.StatusBadRequest},
+	ErrInvalidQueryParam: {"INVALID_QUERY_PARAM", http.StatusBadRequest},
+	ErrAuditFailed: {"AUDIT_FAILED", http.StatusInternalServerError},
+	ErrSearchQueryRequired: {"SEARCH_QUERY_REQUIRED", http.StatusBadRequest},
+	ErrAssignmentRejected: {"ASSIGNMENT_REJECTED", http.StatusBadRequest},
+	ErrPatchNotObject: {"PATCH_NOT_OBJECT", http.StatusBadRequest},
+	ErrVersionMismatch: {"VERSION_MISMATCH", http.StatusPreconditionFailed},
+
+	ErrPendingMigrations: {"PENDING_MIGRATIONS", http.StatusInternalServerError},
+	ErrNoAppliedMigration: {"NO_APPLIED_MIGRATION", http.StatusInternalServerError},
+	ErrIrreversibleMigration: {"IRREVERSIBLE_MIGRATION", http.StatusInternalServerError},
+	ErrUnknownMigration: {"UNKNOWN_MIGRATION", http.StatusInternalServerError},
+}
+
 // Error is a structure of error message returned in json
 type Error struct {
+	// stable code of error, see ErrorCodes
+	Code string
+	Status int
+	// message of error, Error has the same value for older clients
+	Message string
 	Error string
+	// failed validations of fields of request body
+	Details []FieldError `json:",omitempty"`
+	// ID of request, it is sent in X-Request-ID header as well
+	RequestID string `json:",omitempty"`
+}
+
+// FieldError is a failed validation of field of request body
+type FieldError struct {
+	Field string
+	Validator string
+	Message string
+}
+
+// StatusError is an error returned by handlers with HTTP status that is
+// sent when error is not in ErrorCodes, e.g. errors of DB
+type StatusError struct {
+	Status int
+	Err error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Status)
+	}
+	return e.Err.Error()
+}
+
+// NewError will create error returned by handler from error given
+// by parameter, status is used when err is not in ErrorCodes
+func NewError(status int, err error) error {
+	return &StatusError{Status: status, Err: err}
 }
 
 // CreateError will create error structure for return JSON
-// from golang error
-func CreateError(err error) *Error {
-	return &Error{Error: err.Error()}
+// from golang error, status is used when err is not in ErrorCodes
+func CreateError(status int, err error) *Error {
+	if statusErr, ok := err.(*StatusError); ok {
+		status = statusErr.Status
+		err = statusErr.Err
+	}
+
+	if httpErr, ok := err.(*echo.HTTPError); ok {
+		status = httpErr.Code
+		message, ok := httpErr.Message.(string)
+		if !ok {
+			message = http.StatusText(status)
+		}
+		err = errors.New(message)
+	}
+
+	// handler returned status without error
+	if err == nil {
+		err = errors.New(http.StatusText(status))
+	}
+
+	if validationErrs, ok := err.(govalidator.Errors); ok {
+		return &Error{
+			Code: CodeValidationFailed,
+			Status: http.StatusBadRequest,
+			Message: err.Error(),
+			Error: err.Error(),
+			Details: fieldErrors(validationErrs),
+		}
+	}
+
+	code, ok := ErrorCodeOf(err)
+	if !ok {
+		code = ErrorCode{Code: statusCode(status), Status: status}
+	}
+
+	return &Error{
+		Code: code.Code,
+		Status: code.Status,
+		Message: err.Error(),
+		Error: err.Error(),
+	}
+}
+
+// CodedError is an error that gives its own code and status, it is used
+// by errors of packages that import common, e.g. access.ErrNotFound
+type CodedError interface {
+	error
+	ErrorCode() ErrorCode
 }
 
+// ErrorCodeOf will return code and status of error in ErrorCodes or
+// of CodedError
+func ErrorCodeOf(err error) (ErrorCode, bool) {
+	if err == nil {
+		return ErrorCode{}, false
+	}
+	if coded, ok := err.(CodedError); ok {
+		return coded.ErrorCode(), true
+	}
+	// errors of uncomparable types, e.g. slices, cannot be keys
+	if !reflect.TypeOf(err).Comparable() {
+		return ErrorCode{}, false
+	}
+	code, ok := ErrorCodes[err]
+	return code, ok
+}
+
+// statusCode will return code of error that is not in ErrorCodes
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	}
+	return CodeInternal
+}
 
+// fieldErrors will flatten errors of govalidator to failed validations
+// of fields, nested structures give path of field joined by dots
+func fieldErrors(errs govalidator.Errors) []FieldError {
+	fields := []FieldError{}
+	for _, err := range errs {
+		switch e := err.(type) {
+		case govalidator.Errors:
+			fields = append(fields, fieldErrors(e)...)
+		case govalidator.Error:
+			fields = append(fields, FieldError{
+				Field: strings.Join(append(e.Path, e.Name), "."),
+				Validator: e.Validator,
+				Message: e.Err.Error(),
+			})
+		default:
+			fields = append(fields, FieldError{Message: err.Error()})
+		}
+	}
+
+	return fields
+}
+
+// HTTPErrorHandler is an error handler of echo, it sends errors returned
+// by handlers and middlewares as Error with code and status from ErrorCodes
+func HTTPErrorHandler(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+
+	ret := CreateError(http.StatusInternalServerError, err)
+	ret.RequestID = ctx.Response().Header().Get(echo.HeaderXRequestID)
+	if ret.RequestID == "" {
+		ret.RequestID = ctx.Request().Header.Get(echo.HeaderXRequestID)
+	}
+
+	if ctx.Request().Method == http.MethodHead {
+		err = ctx.NoContent(ret.Status)
+	} else {
+		err = ctx.JSON(ret.Status, ret)
+	}
+	if err != nil {
+		ctx.Logger().Error(err)
+	}
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// codedErr is an error that gives its own code like access.ErrNotFound
+type codedErr struct{}
+
+func (codedErr) Error() string { return "Thing not found" }
+
+func (codedErr) ErrorCode() ErrorCode {
+	return ErrorCode{Code: "THING_NOT_FOUND", Status: http.StatusNotFound}
+}
+
+// sliceErr is an error of uncomparable type
+type sliceErr []string
+
+func (sliceErr) Error() string { return "many errors" }
+
+func TestErrorCodeOf(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		code ErrorCode
+		ok   bool
+	}{
+		{"nil", nil, ErrorCode{}, false},
+		{"known", ErrVersionMismatch, ErrorCode{"VERSION_MISMATCH", http.StatusPreconditionFailed}, true},
+		{"unknown", errors.New("x"), ErrorCode{}, false},
+		{"coded", codedErr{}, ErrorCode{"THING_NOT_FOUND", http.StatusNotFound}, true},
+		{"uncomparable", sliceErr{"a"}, ErrorCode{}, false},
+	}
+
+	for _, c := range cases {
+		code, ok := ErrorCodeOf(c.err)
+		if code != c.code || ok != c.ok {
+			t.Errorf("%s: expected %v %v, got %v %v", c.name, c.code, c.ok, code, ok)
+		}
+	}
+}
+
+func TestCreateError(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		code   string
+		status int
+	}{
+		{"nil", nil, CodeInternal, http.StatusInternalServerError},
+		{"status without error", NewError(http.StatusNotFound, nil), CodeNotFound, http.StatusNotFound},
+		{"status of unknown error", NewError(http.StatusBadRequest, errors.New("x")), CodeBadRequest, http.StatusBadRequest},
+		// code of error wins over status given by handler
+		{"coded error", NewError(http.StatusInternalServerError, codedErr{}), "THING_NOT_FOUND", http.StatusNotFound},
+		{"known error", NewError(http.StatusBadRequest, ErrVersionMismatch), "VERSION_MISMATCH", http.StatusPreconditionFailed},
+	}
+
+	for _, c := range cases {
+		res := CreateError(http.StatusInternalServerError, c.err)
+		if res.Code != c.code || res.Status != c.status || res.Message == "" {
+			t.Errorf("%s: expected %s (%d), got %+v", c.name, c.code, c.status, res)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// headers of conditional requests
+const (
+	HeaderETag        = "ETag"
+	HeaderIfMatch     = "If-Match"
+	HeaderIfNoneMatch = "If-None-Match"
+)
+
+// ETag will return entity tag of JSON body of record with version given by
+// parameter. The digest of body changes with associations sent with the
+// record, so their changes are noticed by If-None-Match and If-Match too
+func ETag(version uint, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%d-%x"`, version, sum[:8])
+}
+
+// SendVersioned will send model as JSON with ETag of its version, 304
+// without body is sent instead when GET has the tag in If-None-Match
+func SendVersioned(ctx echo.Context, status int, version uint, model interface{}) error {
+	body, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	tag := ETag(version, body)
+	ctx.Response().Header().Set(HeaderETag, tag)
+
+	if ctx.Request().Method == http.MethodGet && matchesNone(ctx.Request().Header.Get(HeaderIfNoneMatch), tag) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+
+	return ctx.JSONBlob(status, body)
+}
+
+// CheckIfMatch will return ErrVersionMismatch when request has If-Match
+// and none of its tags is the tag SendVersioned sends with model of version
+// given by parameter. Tags are compared strongly, so only a tag of the
+// current body matches (or *)
+func CheckIfMatch(ctx echo.Context, version uint, model interface{}) error {
+	header := ctx.Request().Header.Get(HeaderIfMatch)
+	if header == "" {
+		return nil
+	}
+	body, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+
+	current := ETag(version, body)
+	for _, tag := range splitTags(header) {
+		if tag == "*" || tag == current {
+			return nil
+		}
+	}
+
+	return ErrVersionMismatch
+}
+
+// matchesNone will return whether If-None-Match header has tag given by
+// parameter, tags are compared weakly
+func matchesNone(header string, tag string) bool {
+	for _, t := range splitTags(header) {
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTags will split list of entity tags in header
+func splitTags(header string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+// tagged is a record ETags are computed of in tests
+type tagged struct {
+	ID      uint
+	Version uint
+}
+
+// conditional will return context of request with method and header
+func conditional(method string, header string, value string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, "/", nil)
+	if value != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+// tagOf will return ETag SendVersioned sends with record
+func tagOf(t *testing.T, record tagged) string {
+	t.Helper()
+
+	ctx, rec := conditional(http.MethodGet, "", "")
+	if err := SendVersioned(ctx, http.StatusOK, record.Version, record); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Header().Get(HeaderETag)
+}
+
+func TestCheckIfMatchComparesWholeTag(t *testing.T) {
+	record := tagged{ID: 1, Version: 3}
+	tag := tagOf(t, record)
+
+	cases := []struct {
+		header string
+		err    error
+	}{
+		{"", nil},
+		{tag, nil},
+		{"*", nil},
+		{`"1-0000000000000000", ` + tag, nil},
+		// version alone or with other digest is not the tag of the record
+		{`"3"`, ErrVersionMismatch},
+		{`"3-garbage"`, ErrVersionMismatch},
+		{"W/" + tag, ErrVersionMismatch},
+		{ETag(2, []byte(`{"ID":1,"Version":3}`)), ErrVersionMismatch},
+	}
+	for _, c := range cases {
+		ctx, _ := conditional(http.MethodPut, HeaderIfMatch, c.header)
+		if err := CheckIfMatch(ctx, record.Version, record); err != c.err {
+			t.Errorf("If-Match %s gives %v, expected %v", c.header, err, c.err)
+		}
+	}
+}
+
+func TestSendVersionedHonorsIfNoneMatch(t *testing.T) {
+	record := tagged{ID: 1, Version: 3}
+	tag := tagOf(t, record)
+
+	cases := []struct {
+		method string
+		header string
+		status int
+	}{
+		{http.MethodGet, tag, http.StatusNotModified},
+		{http.MethodGet, "W/" + tag, http.StatusNotModified},
+		{http.MethodGet, "*", http.StatusNotModified},
+		{http.MethodGet, `"3-garbage"`, http.StatusOK},
+		{http.MethodGet, "", http.StatusOK},
+		// only GET is answered by 304
+		{http.MethodPut, tag, http.StatusOK},
+	}
+	for _, c := range cases {
+		ctx, rec := conditional(c.method, HeaderIfNoneMatch, c.header)
+		if err := SendVersioned(ctx, http.StatusOK, record.Version, record); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.status || rec.Header().Get(HeaderETag) != tag {
+			t.Errorf("%s with If-None-Match %s gives %d with tag %s, expected %d with %s", c.method, c.header,
+				rec.Code, rec.Header().Get(HeaderETag), c.status, tag)
+		}
+		if c.status == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("304 has body %s", rec.Body)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/labstack/echo"
+)
+
+// MIMEMergePatch is a media type of JSON merge patch (RFC 7396)
+const MIMEMergePatch = "application/merge-patch+json"
+
+// MergePatch will apply JSON merge patch (RFC 7396) to JSON document,
+// members of patch replace members of document, objects are merged
+// recursively and null removes the member
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeValue(target, changes))
+}
+
+// mergeValue will apply decoded patch to decoded target
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	merged, ok := target.(map[string]interface{})
+	if !ok {
+		merged = map[string]interface{}{}
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(merged, name)
+			continue
+		}
+		merged[name] = mergeValue(merged[name], value)
+	}
+
+	return merged
+}
+
+// canonicalKeys will rename members of patch to names of members of target
+// they match case-insensitively, like encoding/json matches names of fields,
+// so null removes the member whatever case it is sent in. Member named
+// exactly like the one of target wins over the others
+func canonicalKeys(target interface{}, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	members, _ := target.(map[string]interface{})
+	canonical := map[string]interface{}{}
+	for name, value := range changes {
+		key := name
+		if _, exact := members[name]; !exact {
+			for member := range members {
+				if strings.EqualFold(member, name) {
+					key = member
+					break
+				}
+			}
+		}
+		if _, sent := changes[key]; sent && key != name {
+			continue
+		}
+		canonical[key] = canonicalKeys(members[key], value)
+	}
+
+	return canonical
+}
+
+// BindMergePatch will apply JSON merge patch in request body to model,
+// which holds current values of resource. Members of patch are matched
+// to fields of model case-insensitively, members that are null become
+// zero values and merged model is validated like BindAndValid does
+func BindMergePatch(c echo.Context, model interface{}) error {
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	if !strings.HasPrefix(contentType, MIMEMergePatch) && !strings.HasPrefix(contentType, echo.MIMEApplicationJSON) {
+		return echo.ErrUnsupportedMediaType
+	}
+	patch, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	if !isObject(patch) {
+		return ErrPatchNotObject
+	}
+
+	doc, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	var target, changes interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return err
+	}
+	merged, err := json.Marshal(mergeValue(target, canonicalKeys(target, changes)))
+	if err != nil {
+		return err
+	}
+
+	// removed members are not in merged document, so they are left
+	// zero only when decoding starts from zero value
+	value := reflect.ValueOf(model).Elem()
+	value.Set(reflect.Zero(value.Type()))
+	if err := json.Unmarshal(merged, model); err != nil {
+		return err
+	}
+
+	valid, err := govalidator.ValidateStruct(model)
+	if err != nil && !valid {
+		return err
+	}
+
+	return nil
+}
+
+// isObject will return whether data is a JSON object
+func isObject(data []byte) bool {
+	var object map[string]interface{}
+	return json.Unmarshal(data, &object) == nil && object != nil
+}
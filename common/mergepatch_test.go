@@ -0,0 +1,50 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+// patched is a model merge patches are applied to in tests
+type patched struct {
+	Name   string
+	Skills string
+	Owner  struct {
+		Email string
+	}
+}
+
+func TestBindMergePatchMatchesMembersCaseInsensitively(t *testing.T) {
+	cases := []struct {
+		patch    string
+		expected string
+	}{
+		{`{"name": null}`, "{ go {owner@fitlogic.test}}"},
+		{`{"name": "renamed"}`, "{renamed go {owner@fitlogic.test}}"},
+		{`{"NAME": "renamed", "skills": null}`, "{renamed  {owner@fitlogic.test}}"},
+		{`{"owner": {"email": null}}`, "{name go {}}"},
+		// member named exactly like the field wins
+		{`{"name": "folded", "Name": "exact"}`, "{exact go {owner@fitlogic.test}}"},
+		{`{"Name": "exact", "name": null}`, "{exact go {owner@fitlogic.test}}"},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(c.patch))
+		req.Header.Set(echo.HeaderContentType, MIMEMergePatch)
+		ctx := echo.New().NewContext(req, httptest.NewRecorder())
+
+		model := patched{Name: "name", Skills: "go"}
+		model.Owner.Email = "owner@fitlogic.test"
+		if err := BindMergePatch(ctx, &model); err != nil {
+			t.Fatalf("%s: %v", c.patch, err)
+		}
+		if got := fmt.Sprint(model); got != c.expected {
+			t.Errorf("%s gives %s, expected %s", c.patch, got, c.expected)
+		}
+	}
+}
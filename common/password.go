@@ -0,0 +1,48 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordCost is a bcrypt cost used when hashing passwords
+const PasswordCost = bcrypt.DefaultCost
+
+// HashPassword will hash password sent by client with bcrypt, returned
+// string is what is stored in DB
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// CheckPassword will compare password sent by client with value stored in DB.
+// Users created before passwords were hashed with bcrypt have MD5 of their
+// password stored, MD5 of sent password is compared with it, so the stored
+// digest itself is not a password, and needsRehash is set so caller can
+// replace it with bcrypt hash
+func CheckPassword(stored, password string) (match bool, needsRehash bool) {
+	if cost, err := bcrypt.Cost([]byte(stored)); err == nil {
+		if bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) != nil {
+			return false, false
+		}
+		// hash created with weaker cost than is currently used
+		return true, cost < PasswordCost
+	}
+
+	// legacy MD5 of password
+	digest := md5.Sum([]byte(password))
+	if stored == "" || subtle.ConstantTimeCompare([]byte(strings.ToLower(stored)),
+		[]byte(hex.EncodeToString(digest[:]))) != 1 {
+		return false, false
+	}
+
+	return true, true
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	// DefaultPageSize is a number of records returned by list endpoints
+	// when limit is not sent
+	DefaultPageSize = 100
+
+	// MaxPageSize is the highest limit list endpoints accept
+	MaxPageSize = 1000
+
+	// TotalCountHeader is a response header of list endpoints with number
+	// of all records matching filters
+	TotalCountHeader = "X-Total-Count"
+)
+
+// parameters of list endpoints that are not filters
+const (
+	limitParam  = "limit"
+	offsetParam = "offset"
+	sortParam   = "sort"
+)
+
+// SortField is a field records are sorted by
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListQuery is a parsed query of list endpoint, it is translated
+// to DB query by access package
+type ListQuery struct {
+	Limit  int
+	Offset int
+	Sort   []SortField
+	// Filters maps names of filters to their values as sent
+	Filters map[string]string
+}
+
+// ParseListQuery will parse query parameters of list endpoint. Records
+// are paged by limit and offset, sorted by comma separated fields in sort
+// (prefix "-" for descending order) and all other parameters are filters
+func ParseListQuery(ctx echo.Context) (ListQuery, error) {
+	q := ListQuery{
+		Limit:   DefaultPageSize,
+		Filters: map[string]string{},
+	}
+
+	for name, values := range ctx.QueryParams() {
+		if len(values) == 0 {
+			continue
+		}
+		value := values[0]
+
+		switch name {
+		case limitParam:
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > MaxPageSize {
+				return ListQuery{}, ErrInvalidQueryParam
+			}
+			q.Limit = limit
+		case offsetParam:
+			offset, err := strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				return ListQuery{}, ErrInvalidQueryParam
+			}
+			q.Offset = offset
+		case sortParam:
+			for _, field := range strings.Split(value, ",") {
+				field = strings.TrimSpace(field)
+				desc := strings.HasPrefix(field, "-")
+				field = strings.TrimPrefix(field, "-")
+				if field == "" {
+					return ListQuery{}, ErrInvalidQueryParam
+				}
+				q.Sort = append(q.Sort, SortField{Field: field, Desc: desc})
+			}
+		case DateFormatParam:
+			// applies to whole request, see FormatDates
+		default:
+			q.Filters[name] = value
+		}
+	}
+
+	return q, nil
+}
+
+// SetTotalCount will set header with number of all records matching
+// filters of list endpoint
+func SetTotalCount(ctx echo.Context, total int) {
+	ctx.Response().Header().Set(TotalCountHeader, strconv.Itoa(total))
+}
@@ -1,17 +1,30 @@
 package common
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"github.com/labstack/echo"
 	"github.com/asaskevich/govalidator"
 	"github.com/dgrijalva/jwt-go"
 	"time"
-	"github.com/spf13/viper"
+	"github.com/wscherfel/fitlogic-backend/models"
 )
 
 const (
-	JWTExpiration = time.Hour * 24 * 5
+	// JWTExpiration is a lifetime of access token, new one is obtained
+	// with refresh token
+	JWTExpiration = time.Minute * 15
+
+	// RefreshTokenExpiration is a lifetime of refresh token and its session
+	RefreshTokenExpiration = time.Hour * 24 * 5
 )
 
+// CurrentUserKey is a key of echo context under which the logged user
+// loaded from DB is stored
+const CurrentUserKey = "currentUser"
+
 var (
 	DateMin = time.Date(1970, time.January, 1, 0,0,0,0, time.UTC)
 
@@ -37,16 +50,17 @@ func BindAndValid(c echo.Context, model interface{}) error {
 	return nil
 }
 
-// CreateToken will create token with user ID and role coded inside and
-// return its string form
-func CreateToken(userId uint, role int) (string, error) {
+// CreateToken will create token with user ID, role and ID of session coded
+// inside, sign it with secret and return its string form
+func CreateToken(secret []byte, userId uint, role int, sessionId uint) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"exp":time.Now().Add(JWTExpiration).Unix(),
 		"userId": userId,
 		"role": role,
+		"sessionId": sessionId,
 	})
 
-	tokenString, err := token.SignedString([]byte(viper.GetString("Secret")))
+	tokenString, err := token.SignedString(secret)
 	if err != nil {
 		return "", err
 	}
@@ -57,19 +71,72 @@ func CreateToken(userId uint, role int) (string, error) {
 // GetUserIdAndRoleFromToken will decode token and return user id and his role
 // from token
 func GetUserIdAndRoleFromToken(token *jwt.Token) (id uint, role int, err error) {
-	claims := token.Claims.(jwt.MapClaims)
-
-	nonTypedId, foundId := claims["userId"]
-	floatId := nonTypedId.(float64)
-	id = uint(floatId)
-
-	nonTypedRole, foundRole := claims["role"]
-	floatRole := nonTypedRole.(float64)
-	role = int(floatRole)
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, 0, ErrMissingTokenClaims
+	}
 
+	floatId, foundId := claims["userId"].(float64)
+	floatRole, foundRole := claims["role"].(float64)
 	if !foundId || !foundRole {
 		return 0, 0, ErrMissingTokenClaims
 	}
 
-	return id, role, nil
+	return uint(floatId), int(floatRole), nil
+}
+
+// GetSessionIdFromToken will decode token and return ID of session
+// it was issued for
+func GetSessionIdFromToken(token *jwt.Token) (uint, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, ErrMissingTokenClaims
+	}
+
+	floatId, ok := claims["sessionId"].(float64)
+	if !ok {
+		return 0, ErrMissingTokenClaims
+	}
+
+	return uint(floatId), nil
+}
+
+// CreateRefreshToken will generate a new random refresh token, return its
+// string form and hash that is stored in DB
+func CreateRefreshToken() (token string, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken will return hash of refresh token under which it is
+// stored in DB
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetCurrentUser will return logged user stored in context, user is loaded
+// from DB on every request so his role and state are always current
+func GetCurrentUser(ctx echo.Context) (*models.User, error) {
+	user, ok := ctx.Get(CurrentUserKey).(*models.User)
+	if !ok || user == nil {
+		return nil, ErrMissingTokenClaims
+	}
+
+	return user, nil
+}
+
+// GetUserIdAndRole will return id and current role of logged user
+func GetUserIdAndRole(ctx echo.Context) (id uint, role int, err error) {
+	user, err := GetCurrentUser(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return user.ID, user.Role, nil
 }
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestClaimsAreReadFromToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims jwt.Claims
+		err    error
+	}{
+		{"all claims", jwt.MapClaims{"userId": float64(3), "role": float64(2), "sessionId": float64(5)}, nil},
+		{"without user", jwt.MapClaims{"role": float64(2), "sessionId": float64(5)}, ErrMissingTokenClaims},
+		{"without role", jwt.MapClaims{"userId": float64(3), "sessionId": float64(5)}, ErrMissingTokenClaims},
+		{"role of other type", jwt.MapClaims{"userId": float64(3), "role": "admin", "sessionId": float64(5)},
+			ErrMissingTokenClaims},
+		{"standard claims", &jwt.StandardClaims{}, ErrMissingTokenClaims},
+	}
+	for _, c := range cases {
+		id, role, err := GetUserIdAndRoleFromToken(&jwt.Token{Claims: c.claims})
+		if err != c.err {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.err, err)
+		}
+		if err == nil && (id != 3 || role != 2) {
+			t.Errorf("%s: read user %d with role %d", c.name, id, role)
+		}
+	}
+
+	if _, err := GetSessionIdFromToken(&jwt.Token{Claims: jwt.MapClaims{"userId": float64(3)}}); err != ErrMissingTokenClaims {
+		t.Errorf("token without session: expected error %v, got %v", ErrMissingTokenClaims, err)
+	}
+}
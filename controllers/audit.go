@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+	"github.com/wscherfel/fitlogic-backend/policy"
+)
+
+type AuditControllerConfig struct {
+	AuditDao access.AuditRepository
+}
+
+// AuditController is a controller that handles reading of audit log,
+// entries are written by DAOs with changes made by other controllers
+type AuditController struct {
+	AuditControllerConfig
+}
+
+func NewAuditController(config AuditControllerConfig) *AuditController {
+	return &AuditController{
+		AuditControllerConfig: config,
+	}
+}
+
+// GetAll will return entries of audit log visible to logged user, they can
+// be filtered by query parameters entity, entityId, actor, from and to,
+// times are in RFC 3339 format, and paged like other lists
+func (c *AuditController) GetAll(ctx echo.Context) error {
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.AuditRead, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
+	}
+
+	q, err := common.ParseListQuery(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	entries, total, err := c.AuditDao.List(current, q)
+	if err == common.ErrInvalidQueryParam {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	common.SetTotalCount(ctx, total)
+
+	return ctx.JSON(http.StatusOK, entries)
+}
+
+// auditEntry will return entry of audit log of action made by actor,
+// DAO the entry is given to describes the change and writes the entry
+// in the transaction of the change
+func auditEntry(actor *models.User, action policy.Action) *models.AuditEntry {
+	return &models.AuditEntry{ActorID: actor.ID, Action: string(action)}
+}
+
+// parseTimeParam will parse optional time in query parameter
+func parseTimeParam(param string) (time.Time, error) {
+	if param == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return time.Time{}, common.ErrInvalidQueryParam
+	}
+
+	return t, nil
+}
@@ -6,26 +6,26 @@ import (
 	"github.com/labstack/echo"
 	"github.com/wscherfel/fitlogic-backend/common"
 	"net/http"
-	"github.com/dgrijalva/jwt-go"
 	"strconv"
+	"github.com/wscherfel/fitlogic-backend/policy"
 )
 
 type CmControllerConfig struct {
-	CmDao *access.CounterMeasureDAO
+	CmDao access.CounterMeasureRepository
 }
 
-// CmController is a controller for CounterMeasures
-// it is currently not used, but has simple CRUD implemented
+// CmController is a controller for CounterMeasures, countermeasures
+// are assigned to risks by RiskController
 type CmController struct {
 	CmControllerConfig
 }
 
 type CmAPI struct {
 	ID uint
-	Name string
+	Name string `valid:"required"`
 	Description string
 	Cost int
-	Risks []uint `json:"omitempty"`
+	Risks []uint `json:",omitempty"`
 }
 
 func MapCounterMeasureToAPI(cm models.CounterMeasure) CmAPI {
@@ -60,34 +60,52 @@ func NewCounterMeasureController(c CmControllerConfig) *CmController {
 
 // Create will create a new countermeasure
 func (c *CmController) Create(ctx echo.Context) error {
-	_, _, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.CmCreate, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	req := CmAPI{}
 	err = common.BindAndValid(ctx, &req)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
 	cm := MapAPIToCounterMeasure(req)
-	err = c.CmDao.Create(&cm)
+	cm.OrganizationID = current.OrganizationID
+	err = c.CmDao.Create(&cm, auditEntry(current, policy.CmCreate))
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
 
 	return ctx.JSON(http.StatusOK, cm)
 }
 
-// GetAll will return all countermeasures
+// GetAll will return one page of countermeasures matching query parameters
 func (c *CmController) GetAll(ctx echo.Context) error {
-	_, _, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.CmList, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	cms, err := c.CmDao.GetAll()
+	q, err := common.ParseListQuery(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	cms, total, err := c.CmDao.List(current, q)
+	if err == common.ErrInvalidQueryParam {
+		return common.NewError(http.StatusBadRequest, err)
+	}
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
+	common.SetTotalCount(ctx, total)
 
 	return ctx.JSON(http.StatusOK, cms)
 }
@@ -96,25 +114,25 @@ func (c *CmController) GetAll(ctx echo.Context) error {
 func (c *CmController) ReadByID(ctx echo.Context)error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	_, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
-	}
-	if role >= models.RoleManager { // the >= condition is for possibility of adding new user roles
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	cm, err := c.CmDao.ReadByID(pathID)
+	cm, err := c.CmDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return readError(err)
+	}
+	if err := policy.Authorize(current, policy.CmRead, policy.Resource{OrganizationID: cm.OrganizationID}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	cm.Risks, err = c.CmDao.GetAllAssociatedRisks(cm)
+	cm.Risks, err = c.CmDao.GetAllAssociatedVisibleRisks(current, cm)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
 	return ctx.JSON(http.StatusOK, cm)
@@ -125,27 +143,32 @@ func (c *CmController) ReadByID(ctx echo.Context)error {
 func (c *CmController) UpdateByID(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	_, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	if JWTRole >= models.RoleUser{
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+
+	oldVals, err := c.CmDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	if err := policy.Authorize(current, policy.CmUpdate, policy.Resource{OrganizationID: oldVals.OrganizationID}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	req := CmAPI{}
 	err = common.BindAndValid(ctx, &req)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
 	cm := MapAPIToCounterMeasure(req)
-	newVals, err := c.CmDao.Update(&cm, pathID)
+	newVals, err := c.CmDao.Update(&cm, pathID, auditEntry(current, policy.CmUpdate))
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
 	return ctx.JSON(http.StatusOK, newVals)
@@ -156,25 +179,25 @@ func (c *CmController) UpdateByID(ctx echo.Context) error {
 func (c *CmController) DeleteByID(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	_, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
-	}
-	if JWTRole >= models.RoleUser{
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	cm, err := c.CmDao.ReadByID(pathID)
+	cm, err := c.CmDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, common.CreateError(err))
+		return readError(err)
+	}
+	if err := policy.Authorize(current, policy.CmDelete, policy.Resource{OrganizationID: cm.OrganizationID}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	err = c.CmDao.Delete(cm)
+	err = c.CmDao.Delete(cm, auditEntry(current, policy.CmDelete))
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
 	return ctx.NoContent(http.StatusOK)
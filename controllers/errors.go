@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+)
+
+// readStatus will return HTTP status of error of reading a record, records
+// that do not exist or are not visible to logged user give 404 and other
+// errors of DB give 500
+func readStatus(err error) int {
+	if access.IsNotFound(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// readError will return error of reading a record with status of readStatus
+func readError(err error) error {
+	return common.NewError(readStatus(err), err)
+}
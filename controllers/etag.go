@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/common"
+)
+
+// Responses with a single user, project or risk send its detail, the record
+// with associations visible to logged user like GET of it sends. ETag is
+// a tag of the detail and If-Match is compared with the tag of the current
+// detail, so a tag of any of these responses matches until the record or
+// its associations change
+
+// checkIfMatch will return error with status 412 when request has If-Match
+// without ETag of current detail of record, detail is loaded only when
+// request has If-Match
+func checkIfMatch(ctx echo.Context, version uint, detail func() (interface{}, error)) error {
+	if ctx.Request().Header.Get(common.HeaderIfMatch) == "" {
+		return nil
+	}
+	model, err := detail()
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	err = common.CheckIfMatch(ctx, version, model)
+	if err == common.ErrVersionMismatch {
+		return common.NewError(http.StatusPreconditionFailed, err)
+	}
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
@@ -0,0 +1,628 @@
+package controllers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testSecret signs tokens issued by handlers in tests
+var testSecret = []byte("test secret")
+
+// handlers are all controllers over memory repositories, handlers are
+// called directly without routing and middlewares of router
+type handlers struct {
+	t     *testing.T
+	e     *echo.Echo
+	repos access.Repositories
+
+	users         *UserController
+	organizations *OrganizationController
+	sessions      *SessionController
+	projects      *ProjectController
+	risks         *RiskController
+	cms           *CmController
+	audit         *AuditController
+	search        *SearchController
+
+	// logged user of calls
+	current *models.User
+}
+
+func newHandlers(t *testing.T) *handlers {
+	t.Helper()
+
+	repos := access.NewMemoryRepositories(access.NewMemoryStore())
+	h := &handlers{
+		t:     t,
+		e:     echo.New(),
+		repos: repos,
+		users: NewUserController(UserControllerConfig{
+			UserDao:         repos.Users,
+			SessionDao:      repos.Sessions,
+			OrganizationDao: repos.Organizations,
+			Secret:          testSecret,
+		}),
+		organizations: NewOrganizationController(OrganizationControllerConfig{OrganizationDao: repos.Organizations}),
+		sessions: NewSessionController(SessionControllerConfig{
+			SessionDao: repos.Sessions,
+			UserDao:    repos.Users,
+			Secret:     testSecret,
+		}),
+		projects: NewProjectController(ProjectControllerConfig{
+			ProjectDao:    repos.Projects,
+			UserDao:       repos.Users,
+			RiskDao:       repos.Risks,
+			MembershipDao: repos.Memberships,
+		}),
+		risks: NewRiskController(RiskControllerConfig{
+			RiskDao:        repos.Risks,
+			CmDao:          repos.CounterMeasures,
+			ProjectDao:     repos.Projects,
+			UserDao:        repos.Users,
+			MembershipDao:  repos.Memberships,
+			RiskVersionDao: repos.RiskVersions,
+		}),
+		cms:    NewCounterMeasureController(CmControllerConfig{CmDao: repos.CounterMeasures}),
+		audit:  NewAuditController(AuditControllerConfig{AuditDao: repos.Audit}),
+		search: NewSearchController(SearchControllerConfig{SearchDao: repos.Search}),
+	}
+
+	admins, err := repos.Users.ReadByEmail(DefaultAdmin.Email)
+	if err != nil || len(admins) != 1 {
+		t.Fatalf("default admin was not created: %v", err)
+	}
+	h.current = &admins[0]
+	return h
+}
+
+// call will call handler with body as JSON, params are pairs of names
+// and values of path parameters. Returned error is sent like by router
+func (h *handlers) call(handler echo.HandlerFunc, method, target string, body interface{}, params ...string) *httptest.ResponseRecorder {
+	h.t.Helper()
+
+	raw := ""
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		raw = string(b)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(raw))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	ctx := h.e.NewContext(req, rec)
+	names, values := []string{}, []string{}
+	for i := 0; i+1 < len(params); i += 2 {
+		names = append(names, params[i])
+		values = append(values, params[i+1])
+	}
+	ctx.SetParamNames(names...)
+	ctx.SetParamValues(values...)
+	if h.current != nil {
+		ctx.Set(common.CurrentUserKey, h.current)
+	}
+
+	if err := handler(ctx); err != nil {
+		common.HTTPErrorHandler(err, ctx)
+	}
+	return rec
+}
+
+// expect will call handler and fail test when response has other status,
+// body of response is decoded to out if it is not nil
+func (h *handlers) expect(status int, out interface{}, handler echo.HandlerFunc, method, target string, body interface{}, params ...string) {
+	h.t.Helper()
+
+	rec := h.call(handler, method, target, body, params...)
+	if rec.Code != status {
+		h.t.Fatalf("%s %s %v: expected %d, got %d: %s", method, target, params, status, rec.Code, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			h.t.Fatalf("%s %s: %v: %s", method, target, err, rec.Body.String())
+		}
+	}
+}
+
+// id will format ID of path parameter
+func id(value uint) string {
+	return fmt.Sprint(value)
+}
+
+func TestOrganizationHandlers(t *testing.T) {
+	h := newHandlers(t)
+
+	org := models.Organization{}
+	h.expect(http.StatusOK, &org, h.organizations.Create, http.MethodPost, "/organizations/", OrganizationAPI{Name: "Other"})
+	h.expect(http.StatusBadRequest, nil, h.organizations.Create, http.MethodPost, "/organizations/", OrganizationAPI{})
+	orgs := []models.Organization{}
+	h.expect(http.StatusOK, &orgs, h.organizations.GetAll, http.MethodGet, "/organizations/", nil)
+	if len(orgs) != 2 {
+		t.Errorf("expected default and created organization, got %+v", orgs)
+	}
+	h.expect(http.StatusOK, nil, h.organizations.ReadByID, http.MethodGet, "/organizations/1", nil, "id", id(org.ID))
+	h.expect(http.StatusOK, nil, h.organizations.UpdateByID, http.MethodPut, "/organizations/1",
+		OrganizationAPI{Name: "Renamed"}, "id", id(org.ID))
+	h.expect(http.StatusNotFound, nil, h.organizations.ReadByID, http.MethodGet, "/organizations/999", nil, "id", "999")
+	h.expect(http.StatusOK, nil, h.organizations.DeleteByID, http.MethodDelete, "/organizations/1", nil, "id", id(org.ID))
+	h.expect(http.StatusNotFound, nil, h.organizations.ReadByID, http.MethodGet, "/organizations/1", nil, "id", id(org.ID))
+}
+
+// createUser will create user with role in organization of logged user
+func (h *handlers) createUser(name string, role int) *models.User {
+	h.t.Helper()
+
+	user := &models.User{}
+	h.expect(http.StatusOK, user, h.users.Create, http.MethodPost, "/users/", models.User{
+		Name:     name,
+		Email:    name + "@fitlogic.test",
+		Password: "secret",
+		Role:     role,
+	})
+	stored, err := h.repos.Users.ReadByID(user.ID)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return stored
+}
+
+func TestUserHandlers(t *testing.T) {
+	h := newHandlers(t)
+
+	user := h.createUser("user", models.RoleUser)
+	h.expect(http.StatusOK, nil, h.users.Read, http.MethodGet, "/users/", nil)
+	h.expect(http.StatusOK, nil, h.users.ReadByID, http.MethodGet, "/users/1", nil, "id", id(user.ID))
+	h.expect(http.StatusOK, nil, h.users.UpdateByID, http.MethodPut, "/users/1",
+		UpdateRequest{Name: "renamed", Email: "renamed@fitlogic.test"}, "id", id(user.ID))
+	updated := models.User{}
+	h.expect(http.StatusOK, &updated, h.users.PatchByID, http.MethodPatch, "/users/1",
+		map[string]interface{}{"Skills": "go"}, "id", id(user.ID))
+	if updated.Name != "renamed" || updated.Skills != "go" || updated.Password != "" {
+		t.Errorf("unexpected patched user %+v", updated)
+	}
+
+	// users change only themselves
+	h.current = user
+	h.expect(http.StatusForbidden, nil, h.users.UpdateByID, http.MethodPut, "/users/1",
+		UpdateRequest{Name: "x"}, "id", "1")
+	h.expect(http.StatusOK, nil, h.users.ChangePasswordByID, http.MethodPost, "/users/1/changepassword",
+		ChangePasswordRequest{OldPassword: "secret", NewPassword: "new"}, "id", id(user.ID))
+
+	admins, _ := h.repos.Users.ReadByEmail(DefaultAdmin.Email)
+	h.current = &admins[0]
+	h.expect(http.StatusOK, nil, h.users.DeleteByID, http.MethodDelete, "/users/1", nil, "id", id(user.ID))
+	h.expect(http.StatusNotFound, nil, h.users.ReadByID, http.MethodGet, "/users/1", nil, "id", id(user.ID))
+}
+
+func TestUnknownRoleIsRejected(t *testing.T) {
+	h := newHandlers(t)
+	user := h.createUser("user", models.RoleUser)
+
+	res := common.Error{}
+	h.expect(http.StatusBadRequest, &res, h.users.Create, http.MethodPost, "/users/", models.User{
+		Name: "unknown", Email: "unknown@fitlogic.test", Password: "secret", Role: 99})
+	if res.Code != "UNKNOWN_ROLE" {
+		t.Errorf("create of user with role 99 failed with %s", res.Code)
+	}
+	res = common.Error{}
+	h.expect(http.StatusBadRequest, &res, h.users.UpdateByID, http.MethodPut, "/users/1",
+		UpdateRequest{Name: "user", Email: user.Email, Role: 99}, "id", id(user.ID))
+	if res.Code != "UNKNOWN_ROLE" {
+		t.Errorf("PUT of role 99 failed with %s", res.Code)
+	}
+	res = common.Error{}
+	h.expect(http.StatusBadRequest, &res, h.users.PatchByID, http.MethodPatch, "/users/1",
+		map[string]interface{}{"Role": 99}, "id", id(user.ID))
+	if res.Code != "UNKNOWN_ROLE" {
+		t.Errorf("PATCH of role 99 failed with %s", res.Code)
+	}
+
+	stored, err := h.repos.Users.ReadByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Role != models.RoleUser {
+		t.Errorf("user has role %d after rejected changes", stored.Role)
+	}
+}
+
+func TestUserDetailShowsVisibleRecords(t *testing.T) {
+	h := newHandlers(t)
+	manager := h.createUser("manager", models.RoleManager)
+	other := h.createUser("other", models.RoleManager)
+	user := h.createUser("user", models.RoleUser)
+
+	managed, foreign := models.Project{}, models.Project{}
+	h.expect(http.StatusOK, &managed, h.projects.Create, http.MethodPost, "/projects/", projectRequest("Managed", manager.ID))
+	h.expect(http.StatusOK, &foreign, h.projects.Create, http.MethodPost, "/projects/", projectRequest("Other", other.ID))
+	shared, own := models.Risk{}, models.Risk{}
+	h.expect(http.StatusOK, &shared, h.risks.Create, http.MethodPost, "/risks/", riskRequest("Shared", user.ID))
+	h.expect(http.StatusOK, &own, h.risks.Create, http.MethodPost, "/risks/", riskRequest("Own", user.ID))
+	for _, project := range []models.Project{managed, foreign} {
+		h.expect(http.StatusOK, nil, h.projects.AssignUsers, http.MethodPost, "/projects/1/assignusers",
+			AssignUsersRequest{IDs: []uint{user.ID}, Role: models.ProjectRoleEditor}, "id", id(project.ID))
+	}
+	h.expect(http.StatusOK, nil, h.projects.AssignRisks, http.MethodPost, "/projects/1/assignrisks",
+		common.IDsRequest{IDs: []uint{shared.ID}}, "id", id(managed.ID))
+
+	// manager sees only the managed project and risks in it
+	h.current = manager
+	detail := models.User{}
+	h.expect(http.StatusOK, &detail, h.users.ReadByID, http.MethodGet, "/users/1", nil, "id", id(user.ID))
+	if len(detail.Projects) != 1 || detail.Projects[0].ID != managed.ID {
+		t.Errorf("manager sees projects of user %+v", detail.Projects)
+	}
+	if len(detail.Risks) != 1 || detail.Risks[0].ID != shared.ID {
+		t.Errorf("manager sees risks of user %+v", detail.Risks)
+	}
+}
+
+func TestSessionHandlers(t *testing.T) {
+	h := newHandlers(t)
+	h.createUser("user", models.RoleUser)
+	h.current = nil
+
+	login := LoginResponse{}
+	h.expect(http.StatusOK, &login, h.users.Login, http.MethodPost, "/login",
+		LoginCredentials{Email: "user@fitlogic.test", Password: "secret"})
+	h.expect(http.StatusUnauthorized, nil, h.users.Login, http.MethodPost, "/login",
+		LoginCredentials{Email: "user@fitlogic.test", Password: "wrong"})
+
+	tokens := TokensResponse{}
+	h.expect(http.StatusOK, &tokens, h.sessions.Refresh, http.MethodPost, "/refresh",
+		RefreshRequest{RefreshToken: login.RefreshToken})
+	h.expect(http.StatusUnauthorized, nil, h.sessions.Refresh, http.MethodPost, "/refresh",
+		RefreshRequest{RefreshToken: "garbage"})
+
+	// middlewares of router load user of token and reject revoked sessions
+	token, err := jwt.Parse(tokens.Token, func(*jwt.Token) (interface{}, error) { return testSecret, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	withToken := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set("user", token)
+			return next(ctx)
+		}
+	}
+	logout := withToken(h.sessions.CheckRevoked(h.sessions.LoadUser(h.sessions.Logout)))
+	h.expect(http.StatusOK, nil, logout, http.MethodPost, "/logout", nil)
+	h.expect(http.StatusUnauthorized, nil, logout, http.MethodPost, "/logout", nil)
+}
+
+func TestLegacyPasswordIsUpgradedOnLogin(t *testing.T) {
+	h := newHandlers(t)
+	digest := md5.Sum([]byte("secret"))
+	legacy := &models.User{Name: "legacy", Email: "legacy@fitlogic.test", Password: hex.EncodeToString(digest[:]),
+		Role: models.RoleUser, OrganizationID: h.current.OrganizationID}
+	if err := h.repos.Users.Create(legacy, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.current = nil
+
+	// stored digest is not a password, neither before nor after upgrade
+	for i := 0; i < 2; i++ {
+		h.expect(http.StatusUnauthorized, nil, h.users.Login, http.MethodPost, "/login",
+			LoginCredentials{Email: legacy.Email, Password: legacy.Password})
+		h.expect(http.StatusOK, nil, h.users.Login, http.MethodPost, "/login",
+			LoginCredentials{Email: legacy.Email, Password: "secret"})
+	}
+
+	stored, err := h.repos.Users.ReadByID(legacy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bcrypt.Cost([]byte(stored.Password)); err != nil {
+		t.Errorf("password of user is not upgraded to bcrypt: %v", err)
+	}
+	if stored.Version != legacy.Version {
+		t.Errorf("upgrade of password changed version of user from %d to %d", legacy.Version, stored.Version)
+	}
+}
+
+// projectRequest will return request that creates project managed by user
+func projectRequest(name string, managerID uint) ProjectAPI {
+	return ProjectAPI{
+		Name:      name,
+		Start:     "2020-01-01T00:00:00Z",
+		End:       "2020-12-31T00:00:00Z",
+		ManagerID: managerID,
+	}
+}
+
+// riskRequest will return request that creates risk owned by user
+func riskRequest(name string, ownerID uint) RiskAPI {
+	return RiskAPI{
+		Name:        name,
+		Value:       1000,
+		Cost:        200,
+		Probability: 0.5,
+		Impact:      models.ImpactMedium,
+		Start:       "2020-01-01T00:00:00Z",
+		End:         "2020-12-31T00:00:00Z",
+		UserID:      ownerID,
+	}
+}
+
+func TestRiskScoresAreComputed(t *testing.T) {
+	h := newHandlers(t)
+	user := h.createUser("user", models.RoleUser)
+
+	// sent scores are ignored
+	req := riskRequest("Valued", user.ID)
+	req.Risk, req.Exposure = 7, 7
+	valued := RiskAPI{}
+	h.expect(http.StatusOK, &valued, h.risks.Create, http.MethodPost, "/risks/", req)
+	if valued.Risk != 0.5*models.ImpactMedium || valued.Exposure != 500 {
+		t.Errorf("risk of value 1000 has score %v and exposure %v", valued.Risk, valued.Exposure)
+	}
+
+	req = riskRequest("Costed", user.ID)
+	req.Value = 0
+	costed := RiskAPI{}
+	h.expect(http.StatusOK, &costed, h.risks.Create, http.MethodPost, "/risks/", req)
+	if costed.Exposure != 100 {
+		t.Errorf("risk without value and of cost 200 has exposure %v", costed.Exposure)
+	}
+
+	// zero probability and value of PUT keep the stored ones, scores too
+	req = riskRequest("Valued", user.ID)
+	req.Probability, req.Value = 0, 0
+	updated := RiskAPI{}
+	h.expect(http.StatusOK, &updated, h.risks.UpdateByID, http.MethodPut, "/risks/1", req, "id", id(valued.ID))
+	if updated.Probability != 0.5 || updated.Value != 1000 {
+		t.Errorf("PUT of zero probability and value stored %v and %v", updated.Probability, updated.Value)
+	}
+	if updated.Risk != valued.Risk || updated.Exposure != valued.Exposure {
+		t.Errorf("risk of kept probability and value has score %v and exposure %v", updated.Risk,
+			updated.Exposure)
+	}
+}
+
+func TestProjectHandlers(t *testing.T) {
+	h := newHandlers(t)
+	manager := h.createUser("manager", models.RoleManager)
+	user := h.createUser("user", models.RoleUser)
+
+	project := models.Project{}
+	h.expect(http.StatusOK, &project, h.projects.Create, http.MethodPost, "/projects/", projectRequest("Project", manager.ID))
+	pid := id(project.ID)
+	h.expect(http.StatusOK, nil, h.projects.GetAll, http.MethodGet, "/projects/", nil)
+	h.expect(http.StatusOK, nil, h.projects.ReadByID, http.MethodGet, "/projects/1", nil, "id", pid)
+	h.expect(http.StatusOK, nil, h.projects.UpdateByID, http.MethodPut, "/projects/1",
+		projectRequest("Renamed", manager.ID), "id", pid)
+	patched := models.Project{}
+	h.expect(http.StatusOK, &patched, h.projects.PatchByID, http.MethodPatch, "/projects/1",
+		map[string]interface{}{"Description": "patched"}, "id", pid)
+	if patched.Name != "Renamed" || patched.Description != "patched" {
+		t.Errorf("unexpected patched project %+v", patched)
+	}
+
+	result := AssignResult{}
+	h.expect(http.StatusOK, &result, h.projects.AssignUsers, http.MethodPost, "/projects/1/assignusers",
+		AssignUsersRequest{IDs: []uint{user.ID}, Role: models.ProjectRoleEditor}, "id", pid)
+	if len(result.Applied) != 1 {
+		t.Errorf("user was not assigned: %+v", result)
+	}
+	h.expect(http.StatusOK, nil, h.projects.UnAssignUsers, http.MethodPost, "/projects/1/unassignusers",
+		common.IDsRequest{IDs: []uint{user.ID}}, "id", pid)
+
+	risk := models.Risk{}
+	h.expect(http.StatusOK, &risk, h.risks.Create, http.MethodPost, "/risks/", riskRequest("Risk", user.ID))
+	h.expect(http.StatusOK, nil, h.projects.AssignRisks, http.MethodPost, "/projects/1/assignrisks",
+		common.IDsRequest{IDs: []uint{risk.ID}}, "id", pid)
+	risks := []models.Risk{}
+	h.expect(http.StatusOK, &risks, h.projects.GetRisksOfProjects, http.MethodPost, "/projects/risks",
+		common.IDsRequest{IDs: []uint{project.ID}})
+	if len(risks) != 1 || risks[0].ID != risk.ID {
+		t.Errorf("unexpected risks of project %+v", risks)
+	}
+	h.expect(http.StatusOK, nil, h.projects.UnAssignRisks, http.MethodPost, "/projects/1/unassignrisks",
+		common.IDsRequest{IDs: []uint{risk.ID}}, "id", pid)
+
+	h.expect(http.StatusOK, nil, h.projects.DeleteByID, http.MethodDelete, "/projects/1", nil, "id", pid)
+	h.expect(http.StatusNotFound, nil, h.projects.ReadByID, http.MethodGet, "/projects/1", nil, "id", pid)
+}
+
+func TestProjectManagerIsChangedLikeAtCreation(t *testing.T) {
+	h := newHandlers(t)
+	admin := h.current
+	manager := h.createUser("manager", models.RoleManager)
+	other := h.createUser("other", models.RoleManager)
+	owner := h.createUser("owner", models.RoleUser)
+	user := h.createUser("user", models.RoleUser)
+
+	project := models.Project{}
+	h.expect(http.StatusOK, &project, h.projects.Create, http.MethodPost, "/projects/", projectRequest("Project", manager.ID))
+	pid := id(project.ID)
+	h.expect(http.StatusOK, nil, h.projects.AssignUsers, http.MethodPost, "/projects/1/assignusers",
+		AssignUsersRequest{IDs: []uint{owner.ID}, Role: models.ProjectRoleOwner}, "id", pid)
+
+	expectCode := func(status int, code string, handler echo.HandlerFunc, method string, body interface{}) {
+		t.Helper()
+		res := common.Error{}
+		h.expect(status, &res, handler, method, "/projects/1", body, "id", pid)
+		if res.Code != code {
+			t.Errorf("%s as %s: expected %s, got %s", method, h.current.Name, code, res.Code)
+		}
+	}
+
+	// owner who is not a manager cannot lead the project or hand it over
+	h.current = owner
+	expectCode(http.StatusForbidden, "INSUFFICIENT_PRIVILEGES", h.projects.UpdateByID, http.MethodPut,
+		projectRequest("Project", owner.ID))
+	expectCode(http.StatusForbidden, "CANNOT_HAND_PROJECT_TO_OTHERS", h.projects.PatchByID, http.MethodPatch,
+		map[string]uint{"ManagerID": user.ID})
+
+	// manager cannot hand the project to other manager
+	h.current = manager
+	expectCode(http.StatusForbidden, "CANNOT_HAND_PROJECT_TO_OTHERS", h.projects.UpdateByID, http.MethodPut,
+		projectRequest("Project", other.ID))
+
+	// admin hands it only to managers
+	h.current = admin
+	expectCode(http.StatusForbidden, "INSUFFICIENT_PRIVILEGES", h.projects.UpdateByID, http.MethodPut,
+		projectRequest("Project", user.ID))
+	updated := models.Project{}
+	h.expect(http.StatusOK, &updated, h.projects.UpdateByID, http.MethodPut, "/projects/1",
+		projectRequest("Project", other.ID), "id", pid)
+	if updated.ManagerID != other.ID {
+		t.Errorf("manager was not changed, it is %d", updated.ManagerID)
+	}
+}
+
+func TestRiskHandlers(t *testing.T) {
+	h := newHandlers(t)
+	user := h.createUser("user", models.RoleUser)
+
+	risk := models.Risk{}
+	h.expect(http.StatusOK, &risk, h.risks.Create, http.MethodPost, "/risks/", riskRequest("Risk", user.ID))
+	rid := id(risk.ID)
+	h.expect(http.StatusOK, nil, h.risks.GetAll, http.MethodGet, "/risks/", nil)
+	h.expect(http.StatusOK, nil, h.risks.ReadByID, http.MethodGet, "/risks/1", nil, "id", rid)
+	h.expect(http.StatusOK, nil, h.risks.UpdateByID, http.MethodPut, "/risks/1", riskRequest("Renamed", user.ID), "id", rid)
+	h.expect(http.StatusOK, nil, h.risks.PatchByID, http.MethodPatch, "/risks/1",
+		map[string]interface{}{"Description": "patched"}, "id", rid)
+	h.expect(http.StatusOK, nil, h.risks.Transition, http.MethodPost, "/risks/1/transition",
+		RiskTransitionRequest{Status: models.RiskStatusAnalysed}, "id", rid)
+	h.expect(http.StatusBadRequest, nil, h.risks.Transition, http.MethodPost, "/risks/1/transition",
+		RiskTransitionRequest{Status: models.RiskStatusIdentified}, "id", rid)
+
+	cm := models.CounterMeasure{}
+	h.expect(http.StatusOK, &cm, h.cms.Create, http.MethodPost, "/cms/", CmAPI{Name: "Backup"})
+	result := AssignResult{}
+	h.expect(http.StatusOK, &result, h.risks.AssignCms, http.MethodPost, "/risks/1/assigncms",
+		common.IDsRequest{IDs: []uint{cm.ID}}, "id", rid)
+	if fmt.Sprint(result.Applied) != fmt.Sprint([]uint{cm.ID}) {
+		t.Errorf("countermeasure was not assigned: %+v", result)
+	}
+	h.expect(http.StatusOK, nil, h.risks.UnAssignCms, http.MethodPost, "/risks/1/unassigncms",
+		common.IDsRequest{IDs: []uint{cm.ID}}, "id", rid)
+
+	versions := []models.RiskVersion{}
+	h.expect(http.StatusOK, &versions, h.risks.GetVersions, http.MethodGet, "/risks/1/versions", nil, "id", rid)
+	if len(versions) < 4 {
+		t.Fatalf("expected a version of every change, got %d", len(versions))
+	}
+	h.expect(http.StatusOK, nil, h.risks.ReadVersion, http.MethodGet, "/risks/1/versions/1", nil, "id", rid, "version", "1")
+	diff := RiskVersionsDiff{}
+	h.expect(http.StatusOK, &diff, h.risks.DiffVersions, http.MethodGet, "/risks/1/versions/diff?from=1&to=2", nil, "id", rid)
+	if len(diff.Changes) == 0 {
+		t.Errorf("versions 1 and 2 do not differ")
+	}
+	h.expect(http.StatusOK, nil, h.risks.ReadAsOf, http.MethodGet,
+		"/risks/1/asof?time="+time.Now().Add(time.Minute).Format(time.RFC3339), nil, "id", rid)
+	reverted := models.Risk{}
+	h.expect(http.StatusOK, &reverted, h.risks.RevertToVersion, http.MethodPost, "/risks/1/versions/1/revert", nil,
+		"id", rid, "version", "1")
+	if reverted.Name != "Risk" || reverted.Status != models.RiskStatusAnalysed {
+		t.Errorf("unexpected reverted risk %+v", reverted)
+	}
+
+	h.expect(http.StatusOK, nil, h.risks.DeleteByID, http.MethodDelete, "/risks/1", nil, "id", rid)
+	h.expect(http.StatusNotFound, nil, h.risks.ReadByID, http.MethodGet, "/risks/1", nil, "id", rid)
+}
+
+func TestAssignCmsResults(t *testing.T) {
+	h := newHandlers(t)
+	risk, cm, foreign := models.Risk{}, models.CounterMeasure{}, models.CounterMeasure{}
+	h.expect(http.StatusOK, &risk, h.risks.Create, http.MethodPost, "/risks/", riskRequest("Risk", h.current.ID))
+	h.expect(http.StatusOK, &cm, h.cms.Create, http.MethodPost, "/cms/", CmAPI{Name: "Backup"})
+	org := models.Organization{}
+	h.expect(http.StatusOK, &org, h.organizations.Create, http.MethodPost, "/organizations/", OrganizationAPI{Name: "Other"})
+	foreign.Name, foreign.OrganizationID = "Foreign", org.ID
+	if err := h.repos.CounterMeasures.Create(&foreign, nil); err != nil {
+		t.Fatal(err)
+	}
+	rid := id(risk.ID)
+	assigned := func() int {
+		cms, err := h.repos.Risks.GetAllAssociatedCounterMeasures(&risk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(cms)
+	}
+
+	// unknown and foreign IDs reject the whole request
+	result := AssignResult{}
+	h.expect(http.StatusBadRequest, &result, h.risks.AssignCms, http.MethodPost, "/risks/1/assigncms",
+		common.IDsRequest{IDs: []uint{cm.ID, foreign.ID, 999}}, "id", rid)
+	if fmt.Sprint(result.NotFound, result.OutsideOrganization, result.Applied) != fmt.Sprint([]uint{999}, []uint{foreign.ID}, []uint{}) ||
+		result.Code != "ASSIGNMENT_REJECTED" || assigned() != 0 {
+		t.Errorf("unexpected result of rejected assign %+v", result)
+	}
+
+	result = AssignResult{}
+	h.expect(http.StatusOK, &result, h.risks.AssignCms, http.MethodPost, "/risks/1/assigncms?partial=true",
+		common.IDsRequest{IDs: []uint{cm.ID, 999}}, "id", rid)
+	if fmt.Sprint(result.Applied, result.NotFound) != fmt.Sprint([]uint{cm.ID}, []uint{999}) || assigned() != 1 {
+		t.Errorf("unexpected result of partial assign %+v", result)
+	}
+
+	result = AssignResult{}
+	h.expect(http.StatusBadRequest, &result, h.risks.AssignCms, http.MethodPost, "/risks/1/assigncms",
+		common.IDsRequest{IDs: []uint{cm.ID}}, "id", rid)
+	if fmt.Sprint(result.AlreadyAssigned) != fmt.Sprint([]uint{cm.ID}) {
+		t.Errorf("unexpected result of repeated assign %+v", result)
+	}
+
+	result = AssignResult{}
+	h.expect(http.StatusBadRequest, &result, h.risks.UnAssignCms, http.MethodPost, "/risks/1/unassigncms",
+		common.IDsRequest{IDs: []uint{cm.ID, foreign.ID}}, "id", rid)
+	if fmt.Sprint(result.NotAssigned) != fmt.Sprint([]uint{foreign.ID}) || assigned() != 1 {
+		t.Errorf("unexpected result of rejected unassign %+v", result)
+	}
+	h.expect(http.StatusOK, nil, h.risks.UnAssignCms, http.MethodPost, "/risks/1/unassigncms",
+		common.IDsRequest{IDs: []uint{cm.ID}}, "id", rid)
+	if assigned() != 0 {
+		t.Error("countermeasure was not unassigned")
+	}
+}
+
+func TestCounterMeasureHandlers(t *testing.T) {
+	h := newHandlers(t)
+
+	cm := models.CounterMeasure{}
+	h.expect(http.StatusOK, &cm, h.cms.Create, http.MethodPost, "/cms/", CmAPI{Name: "Backup"})
+	cid := id(cm.ID)
+	h.expect(http.StatusOK, nil, h.cms.GetAll, http.MethodGet, "/cms/", nil)
+	h.expect(http.StatusOK, nil, h.cms.ReadByID, http.MethodGet, "/cms/1", nil, "id", cid)
+	h.expect(http.StatusOK, nil, h.cms.UpdateByID, http.MethodPut, "/cms/1", CmAPI{Name: "Restore"}, "id", cid)
+	h.expect(http.StatusOK, nil, h.cms.DeleteByID, http.MethodDelete, "/cms/1", nil, "id", cid)
+	h.expect(http.StatusNotFound, nil, h.cms.ReadByID, http.MethodGet, "/cms/1", nil, "id", cid)
+}
+
+func TestAuditAndSearchHandlers(t *testing.T) {
+	h := newHandlers(t)
+	user := h.createUser("user", models.RoleUser)
+	h.expect(http.StatusOK, nil, h.risks.Create, http.MethodPost, "/risks/", riskRequest("Flood", user.ID))
+
+	entries := []models.AuditEntry{}
+	h.expect(http.StatusOK, &entries, h.audit.GetAll, http.MethodGet, "/audit/?entity=risk", nil)
+	if len(entries) != 1 || entries[0].Action != "risk:create" {
+		t.Errorf("unexpected audit entries %+v", entries)
+	}
+
+	results := []access.SearchResult{}
+	h.expect(http.StatusOK, &results, h.search.Search, http.MethodGet, "/search?q=flo", nil)
+	if len(results) != 1 || results[0].Type != "risk" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	h.expect(http.StatusBadRequest, nil, h.search.Search, http.MethodGet, "/search", nil)
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+	"github.com/wscherfel/fitlogic-backend/policy"
+)
+
+type OrganizationControllerConfig struct {
+	OrganizationDao access.OrganizationRepository
+}
+
+// OrganizationController is a controller that handles organization endpoints,
+// organizations are managed by super-admins, admins can only update their own
+type OrganizationController struct {
+	OrganizationControllerConfig
+}
+
+// OrganizationAPI is a structure of requests for organization API endpoints
+type OrganizationAPI struct {
+	Name string `valid:"required"`
+	Description string
+}
+
+func NewOrganizationController(config OrganizationControllerConfig) *OrganizationController {
+	return &OrganizationController{
+		OrganizationControllerConfig: config,
+	}
+}
+
+// Create will create a new organization
+func (c *OrganizationController) Create(ctx echo.Context) error {
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.OrganizationCreate, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
+	}
+
+	req := OrganizationAPI{}
+	err = common.BindAndValid(ctx, &req)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	org := models.Organization{
+		Name: req.Name,
+		Description: req.Description,
+	}
+	err = c.OrganizationDao.Create(&org)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	return ctx.JSON(http.StatusOK, org)
+}
+
+// GetAll will return one page of organizations matching query parameters
+func (c *OrganizationController) GetAll(ctx echo.Context) error {
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.OrganizationList, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
+	}
+
+	q, err := common.ParseListQuery(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	orgs, total, err := c.OrganizationDao.List(current, q)
+	if err == common.ErrInvalidQueryParam {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	common.SetTotalCount(ctx, total)
+
+	return ctx.JSON(http.StatusOK, orgs)
+}
+
+// ReadByID will return organization with ID in path
+func (c *OrganizationController) ReadByID(ctx echo.Context) error {
+	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
+	}
+	pathID := uint(pathIDuint64)
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	org, err := c.OrganizationDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	if err := policy.Authorize(current, policy.OrganizationRead, policy.Resource{OrganizationID: org.ID}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
+	}
+
+	return ctx.JSON(http.StatusOK, org)
+}
+
+// UpdateByID will update organization with ID in path
+// to new values sent in request body
+func (c *OrganizationController) UpdateByID(ctx echo.Context) error {
+	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
+	}
+	pathID := uint(pathIDuint64)
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	org, err := c.OrganizationDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	if err := policy.Authorize(current, policy.OrganizationUpdate, policy.Resource{OrganizationID: org.ID}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
+	}
+
+	req := OrganizationAPI{}
+	err = common.BindAndValid(ctx, &req)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	newVals, err := c.OrganizationDao.Update(&models.Organization{
+		Name: req.Name,
+		Description: req.Description,
+	}, pathID)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	return ctx.JSON(http.StatusOK, newVals)
+}
+
+// DeleteByID will delete organization with ID in path,
+// only organizations without users can be deleted
+func (c *OrganizationController) DeleteByID(ctx echo.Context) error {
+	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
+	}
+	pathID := uint(pathIDuint64)
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.OrganizationDelete, policy.Resource{OrganizationID: pathID}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
+	}
+
+	org, err := c.OrganizationDao.ReadByID(pathID)
+	if err != nil {
+		return readError(err)
+	}
+
+	count, err := c.OrganizationDao.CountUsers(org)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if count > 0 {
+		return common.NewError(http.StatusBadRequest, common.ErrOrganizationNotEmpty)
+	}
+
+	err = c.OrganizationDao.Delete(org)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return ctx.NoContent(http.StatusOK)
+}
@@ -4,18 +4,18 @@ import (
 	"github.com/wscherfel/fitlogic-backend/access"
 	"github.com/labstack/echo"
 	"github.com/wscherfel/fitlogic-backend/common"
-	"github.com/dgrijalva/jwt-go"
 	"net/http"
 	"github.com/wscherfel/fitlogic-backend/models"
 	"time"
 	"strconv"
-	"github.com/spf13/viper"
+	"github.com/wscherfel/fitlogic-backend/policy"
 )
 
 type ProjectControllerConfig struct {
-	UserDao *access.UserDAO
-	ProjectDao *access.ProjectDAO
-	RiskDao *access.RiskDAO
+	UserDao access.UserRepository
+	ProjectDao access.ProjectRepository
+	RiskDao access.RiskRepository
+	MembershipDao access.MembershipRepository
 }
 
 // ProjectController is a controller that handles endpoints that are bound
@@ -30,12 +30,75 @@ func NewProjectController(config ProjectControllerConfig) *ProjectController {
 	}
 }
 
+// AssignUsersRequest is a structure of request to assign users to project,
+// all users get the same role in project, editor if role is not sent
+type AssignUsersRequest struct {
+	IDs []uint `valid:"required"`
+	Role string `valid:"in(owner|editor|viewer)"`
+}
+
+// AssignResult is a response of endpoints that assign records to project
+// or risk or unassign them, it tells what happened to each of sent IDs
+type AssignResult struct {
+	// set when nothing was changed because some of IDs were rejected
+	Code string `json:",omitempty"`
+	Error string `json:",omitempty"`
+
+	// IDs that were assigned or unassigned
+	Applied []uint
+	// IDs of records that do not exist or are not visible to logged user
+	NotFound []uint `json:",omitempty"`
+	// IDs of records of other organization than the project or risk
+	OutsideOrganization []uint `json:",omitempty"`
+	// IDs of records that are assigned already (with the same role)
+	AlreadyAssigned []uint `json:",omitempty"`
+	// IDs of records that are not assigned, so cannot be unassigned
+	NotAssigned []uint `json:",omitempty"`
+	// IDs of users that cannot be changed, the manager of project
+	Protected []uint `json:",omitempty"`
+}
+
+// rejected will return whether some of IDs cannot be applied
+func (r *AssignResult) rejected() bool {
+	return len(r.NotFound) + len(r.OutsideOrganization) + len(r.AlreadyAssigned) +
+		len(r.NotAssigned) + len(r.Protected) > 0
+}
+
+// respondAssign will send result of assign or unassign, when some of IDs
+// were rejected and partial mode is off nothing is applied and 400 is sent
+func respondAssign(ctx echo.Context, result *AssignResult, partial bool) error {
+	if result.rejected() && !partial {
+		result.Code = common.ErrorCodes[common.ErrAssignmentRejected].Code
+		result.Error = common.ErrAssignmentRejected.Error()
+		result.Applied = []uint{}
+		return ctx.JSON(http.StatusBadRequest, result)
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// isPartial will return whether request allows to apply only part of IDs,
+// it is set by query parameter partial
+func isPartial(ctx echo.Context) (bool, error) {
+	param := ctx.QueryParam("partial")
+	if param == "" {
+		return false, nil
+	}
+	partial, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, common.ErrInvalidQueryParam
+	}
+	return partial, nil
+}
+
 // ProjectAPI is a structure of requests for project API endpoints
 type ProjectAPI struct {
 	ID uint
 	Name string `valid:"required"`
 	Description string
 
+	// dates are in format selected by dateFormat query parameter,
+	// RFC 3339 by default
 	Start string `valid:"required"`
 	End string `valid:"required"`
 
@@ -49,33 +112,35 @@ type ProjectAPI struct {
 type ProjectDetailAPI struct {
 	ProjectAPI
 
-	Users []models.User `json:"omitempty"`
-	Risks []models.Risk `json:"omitempty"`
+	Users []models.User `json:",omitempty"`
+	Risks []models.Risk `json:",omitempty"`
 }
 
-// MapProjectToAPI will map project to API structure, currently not used
-func MapProjectToAPI(project models.Project) (ProjectAPI) {
+// MapProjectToAPI will map project to API structure, dates are formatted
+// with layout given by parameter
+func MapProjectToAPI(project models.Project, layout string) (ProjectAPI) {
 	return ProjectAPI{
 		ID: project.ID,
 		Name: project.Name,
 		Description: project.Description,
-		Start: project.Start,
-		End: project.End,
+		Start: project.Start.Format(layout),
+		End: project.End.Format(layout),
+		IsFinished: project.IsFinished,
 		ManagerID: project.ManagerID,
 	}
 }
 
-// MapAPIToProject will map request values to DB model
-func MapAPIToProject(req ProjectAPI) (models.Project, error) {
-	format := viper.GetString("TimeFormat")
-	start, err := time.Parse(format, req.Start)
+// MapAPIToProject will map request values to DB model, dates are parsed
+// with layout given by parameter
+func MapAPIToProject(req ProjectAPI, layout string) (models.Project, error) {
+	start, err := time.Parse(layout, req.Start)
 	if err != nil {
 		return models.Project{}, err
 	}
 	if !start.After(common.DateMin) {
 		return models.Project{}, common.ErrDateOutOfRange
 	}
-	end, err := time.Parse(format, req.End)
+	end, err := time.Parse(layout, req.End)
 	if err != nil {
 		return models.Project{}, err
 	}
@@ -92,72 +157,85 @@ func MapAPIToProject(req ProjectAPI) (models.Project, error) {
 		Name: req.Name,
 		Description: req.Description,
 
-		Start: req.Start,
-		End: req.End,
+		Start: start,
+		End: end,
 	}, nil
 }
 
 // Create will create a new project
 func (c *ProjectController) Create(ctx echo.Context) error {
-	userID, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	if role > models.RoleManager {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	if err := policy.Authorize(current, policy.ProjectCreate, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	req := ProjectAPI{}
 	err = common.BindAndValid(ctx, &req)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	manager, err := c.UserDao.ReadByID(req.ManagerID)
+	manager, err := c.UserDao.ReadVisibleByID(current, req.ManagerID)
 
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	if role == models.RoleManager { // role is manager
-		if req.ManagerID != userID {
-			return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrCannotCreateProjectForOthers))
+	if req.ManagerID != current.ID {
+		if !policy.Can(current, policy.ProjectCreateForOthers, policy.Resource{}) {
+			return common.NewError(http.StatusForbidden, common.ErrCannotCreateProjectForOthers)
 		}
-	} else { // role is admin, users were filtered above
-		if req.ManagerID != userID {
-
-			if manager.Role < models.RoleManager {
-				return ctx.JSON(http.StatusInternalServerError, common.CreateError(common.ErrUnsufficientPrivileges))
-			}
+		// only managers and admins can lead a project
+		if manager.Role > models.RoleManager {
+			return common.NewError(http.StatusBadRequest, common.ErrUnsufficientPrivileges)
 		}
 	}
 
-	project, err := MapAPIToProject(req)
+	layout, err := common.DateLayout(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	project, err := MapAPIToProject(req, layout)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
 	}
 	project.IsFinished = false
+	// project belongs to organization of its manager
+	project.OrganizationID = manager.OrganizationID
 
-	c.ProjectDao.Create(&project)
-	c.ProjectDao.AddUsersAssociation(&project, manager)
-	for i := range project.Users {
-		project.Users[i].Password = ""
+	err = c.ProjectDao.Create(&project, auditEntry(current, policy.ProjectCreate))
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	return ctx.JSON(http.StatusOK, project)
+	return c.sendProject(ctx, current, &project)
 }
 
-// GetAll will return all projects
+// GetAll will return one page of projects matching query parameters
 func (c *ProjectController) GetAll(ctx echo.Context) error {
-	_, _, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.ProjectList, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	projects, err := c.ProjectDao.GetAll()
+	q, err := common.ParseListQuery(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	projects, total, err := c.ProjectDao.List(current, q)
+	if err == common.ErrInvalidQueryParam {
+		return common.NewError(http.StatusBadRequest, err)
+	}
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
+	common.SetTotalCount(ctx, total)
 	for i := range projects {
 		for j := range projects[i].Users {
 			projects[i].Users[j].Password = ""
@@ -172,43 +250,65 @@ func (c *ProjectController) GetAll(ctx echo.Context) error {
 func (c *ProjectController) UnAssignUsers(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	userID, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
-	}
-	if role > models.RoleManager {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	project, err := c.ProjectDao.ReadByID(pathID)
+	project, err := c.ProjectDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return readError(err)
 	}
-	if role == models.RoleManager && project.ManagerID != userID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	res, err := c.projectResource(current.ID, project)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.ProjectAssignUsers, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	ids := common.IDsRequest{}
 	err = common.BindAndValid(ctx, &ids)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
+	partial, err := isPartial(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	result := &AssignResult{Applied: []uint{}}
+	memberships := []models.Membership{}
 	for _, id := range ids.IDs {
-		user, err := c.UserDao.ReadByID(id)
-		if err != nil {
+		membership, err := c.MembershipDao.Read(id, project.ID)
+		if access.IsNotFound(err) {
+			result.NotAssigned = append(result.NotAssigned, id)
 			continue
 		}
+		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		// manager always stays owner of his project
 		if project.ManagerID == id {
+			result.Protected = append(result.Protected, id)
 			continue
 		}
-		project, _ = c.ProjectDao.RemoveUsersAssociation(project, user)
+		memberships = append(memberships, *membership)
+		result.Applied = append(result.Applied, id)
+	}
+	if result.rejected() && !partial || len(memberships) == 0 {
+		return respondAssign(ctx, result, partial)
 	}
 
-	return ctx.NoContent(http.StatusOK)
+	if err := c.ProjectDao.RemoveMemberships(project, memberships, auditEntry(current, policy.ProjectAssignUsers)); err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return respondAssign(ctx, result, partial)
 }
 
 // AssignUsers will add association between users with sent IDs
@@ -216,40 +316,81 @@ func (c *ProjectController) UnAssignUsers(ctx echo.Context) error {
 func (c *ProjectController) AssignUsers(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	userID, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	project, err := c.ProjectDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	res, err := c.projectResource(current.ID, project)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
-	if role > models.RoleManager {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrUnsufficientPrivileges))
+	if err := policy.Authorize(current, policy.ProjectAssignUsers, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	project, err := c.ProjectDao.ReadByID(pathID)
+	req := AssignUsersRequest{}
+	err = common.BindAndValid(ctx, &req)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	if role == models.RoleManager && project.ManagerID != userID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	if req.Role == "" {
+		req.Role = models.ProjectRoleEditor
 	}
 
-	ids := common.IDsRequest{}
-	err = common.BindAndValid(ctx, &ids)
+	partial, err := isPartial(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	for _, id := range ids.IDs {
-		user, err := c.UserDao.ReadByID(id)
+	result := &AssignResult{Applied: []uint{}}
+	memberships := []models.Membership{}
+	for _, id := range req.IDs {
+		user, err := c.UserDao.ReadVisibleByID(current, id)
+		if access.IsNotFound(err) {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
 		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		if user.OrganizationID != project.OrganizationID {
+			result.OutsideOrganization = append(result.OutsideOrganization, id)
+			continue
+		}
+		// manager always stays owner of his project
+		if project.ManagerID == id {
+			result.Protected = append(result.Protected, id)
+			continue
+		}
+		// member with other role gets the new one
+		if membership, err := c.MembershipDao.Read(id, project.ID); err == nil && membership.Role == req.Role {
+			result.AlreadyAssigned = append(result.AlreadyAssigned, id)
 			continue
 		}
-		project, _ = c.ProjectDao.AddUsersAssociation(project, user)
+		memberships = append(memberships, models.Membership{
+			UserID: user.ID,
+			ProjectID: project.ID,
+			Role: req.Role,
+		})
+		result.Applied = append(result.Applied, id)
+	}
+	if result.rejected() && !partial || len(memberships) == 0 {
+		return respondAssign(ctx, result, partial)
 	}
 
-	return ctx.NoContent(http.StatusOK)
+	if err := c.ProjectDao.AddMemberships(project, memberships, auditEntry(current, policy.ProjectAssignUsers)); err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return respondAssign(ctx, result, partial)
 }
 
 // AssignRisks will add association between risks with sent IDs
@@ -257,40 +398,73 @@ func (c *ProjectController) AssignUsers(ctx echo.Context) error {
 func (c *ProjectController) AssignRisks(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	userID, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
-	}
-	if role > models.RoleManager {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	project, err := c.ProjectDao.ReadByID(pathID)
+	project, err := c.ProjectDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return readError(err)
 	}
-	if role == models.RoleManager && project.ManagerID != userID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	res, err := c.projectResource(current.ID, project)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.ProjectAssignRisks, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	ids := common.IDsRequest{}
 	err = common.BindAndValid(ctx, &ids)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, err)
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	partial, err := isPartial(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
+	assigned, err := c.assignedRiskIDs(current, project)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	result := &AssignResult{Applied: []uint{}}
+	risks := []models.Risk{}
 	for _, id := range ids.IDs {
-		risk, err := c.RiskDao.ReadByID(id)
+		risk, err := c.RiskDao.ReadVisibleByID(current, id)
+		if access.IsNotFound(err) {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
 		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		if risk.OrganizationID != project.OrganizationID {
+			result.OutsideOrganization = append(result.OutsideOrganization, id)
 			continue
 		}
-		project, _ = c.ProjectDao.AddRisksAssociation(project, risk)
+		if assigned[id] {
+			result.AlreadyAssigned = append(result.AlreadyAssigned, id)
+			continue
+		}
+		risks = append(risks, *risk)
+		result.Applied = append(result.Applied, id)
+	}
+	if result.rejected() && !partial || len(risks) == 0 {
+		return respondAssign(ctx, result, partial)
 	}
 
-	return ctx.NoContent(http.StatusOK)
+	if err := c.ProjectDao.AddRisksAssociations(project, risks, auditEntry(current, policy.ProjectAssignRisks)); err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return respondAssign(ctx, result, partial)
 }
 
 // UnAssignRisks will remove association between risks with sent IDs
@@ -298,77 +472,183 @@ func (c *ProjectController) AssignRisks(ctx echo.Context) error {
 func (c *ProjectController) UnAssignRisks(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	userID, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
-	}
-	if role > models.RoleManager {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	project, err := c.ProjectDao.ReadByID(pathID)
+	project, err := c.ProjectDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	res, err := c.projectResource(current.ID, project)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
-	if role == models.RoleManager && project.ManagerID != userID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	if err := policy.Authorize(current, policy.ProjectAssignRisks, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	ids := common.IDsRequest{}
 	err = common.BindAndValid(ctx, &ids)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
+	partial, err := isPartial(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	assigned, err := c.assignedRiskIDs(current, project)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	result := &AssignResult{Applied: []uint{}}
+	risks := []models.Risk{}
 	for _, id := range ids.IDs {
-		risk, err := c.RiskDao.ReadByID(id)
+		risk, err := c.RiskDao.ReadVisibleByID(current, id)
+		if access.IsNotFound(err) {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
 		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		if !assigned[id] {
+			result.NotAssigned = append(result.NotAssigned, id)
 			continue
 		}
-		project, _ = c.ProjectDao.RemoveRisksAssociation(project, risk)
+		risks = append(risks, *risk)
+		result.Applied = append(result.Applied, id)
+	}
+	if result.rejected() && !partial || len(risks) == 0 {
+		return respondAssign(ctx, result, partial)
 	}
 
-	return ctx.NoContent(http.StatusOK)
+	if err := c.ProjectDao.RemoveRisksAssociations(project, risks, auditEntry(current, policy.ProjectAssignRisks)); err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return respondAssign(ctx, result, partial)
 }
 
 // UpdateByID will update project with ID in path
 // to new values sent in request body
 func (c *ProjectController) UpdateByID(ctx echo.Context) error {
-	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	current, projectCheck, err := c.readProjectForUpdate(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return err
 	}
-	pathID := uint(pathIDuint64)
-	jwtID, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+
+	req := ProjectAPI{}
+	err = common.BindAndValid(ctx, &req)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	projectCheck, err := c.ProjectDao.ReadByID(pathID)
-	if JWTRole >= models.RoleManager && jwtID != projectCheck.ManagerID { // the >= condition is for possibility of adding new user roles
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+
+	return c.update(ctx, current, projectCheck, req, c.ProjectDao.Update)
+}
+
+// PatchByID will apply JSON merge patch (RFC 7396) in request body to
+// project with ID in path, zero values are stored as well
+func (c *ProjectController) PatchByID(ctx echo.Context) error {
+	current, projectCheck, err := c.readProjectForUpdate(ctx)
+	if err != nil {
+		return err
 	}
 
-	req := ProjectAPI{}
-	err = common.BindAndValid(ctx, &req)
+	layout, err := common.DateLayout(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	req := MapProjectToAPI(*projectCheck, layout)
+	err = common.BindMergePatch(ctx, &req)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	return c.update(ctx, current, projectCheck, req, c.ProjectDao.UpdateAll)
+}
+
+// readProjectForUpdate will read project with ID in path and check that
+// logged user can update it and that If-Match has its version
+func (c *ProjectController) readProjectForUpdate(ctx echo.Context) (*models.User, *models.Project, error) {
+	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return nil, nil, common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
+	}
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, nil, common.NewError(http.StatusBadRequest, err)
+	}
+	projectCheck, err := c.ProjectDao.ReadVisibleByID(current, uint(pathIDuint64))
+	if err != nil {
+		return nil, nil, readError(err)
+	}
+	res, err := c.projectResource(current.ID, projectCheck)
+	if err != nil {
+		return nil, nil, common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.ProjectUpdate, res); err != nil {
+		return nil, nil, common.NewError(http.StatusForbidden, err)
+	}
+	err = checkIfMatch(ctx, projectCheck.Version, func() (interface{}, error) {
+		return c.projectDetail(current, *projectCheck)
+	})
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return nil, nil, err
 	}
 
-	project, err := MapAPIToProject(req)
+	return current, projectCheck, nil
+}
+
+// update will store values of request to project given by parameter with
+// store function, Update of DAO skips zero values and UpdateAll does not
+func (c *ProjectController) update(ctx echo.Context, current *models.User, projectCheck *models.Project,
+	req ProjectAPI, store func(*models.Project, uint, *models.AuditEntry) (*models.Project, error)) error {
+	layout, err := common.DateLayout(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	project, err := MapAPIToProject(req, layout)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	// new manager has to be from organization of the project and could
+	// be given the project at its creation
+	if project.ManagerID != projectCheck.ManagerID {
+		manager, err := c.UserDao.ReadVisibleByID(current, project.ManagerID)
+		if err != nil {
+			return common.NewError(http.StatusBadRequest, err)
+		}
+		if manager.OrganizationID != projectCheck.OrganizationID {
+			return common.NewError(http.StatusBadRequest, common.ErrAssociationOutsideOrganization)
+		}
+		if project.ManagerID != current.ID &&
+			!policy.Can(current, policy.ProjectCreateForOthers, policy.Resource{}) {
+			return common.NewError(http.StatusForbidden, common.ErrCannotHandProjectToOthers)
+		}
+		// only managers and admins can lead a project
+		if manager.Role > models.RoleManager {
+			return common.NewError(http.StatusForbidden, common.ErrUnsufficientPrivileges)
+		}
 	}
 
-	project.ID = pathID
-	newVals, err := c.ProjectDao.Update(&project, pathID)
+	project.ID = projectCheck.ID
+	project.OrganizationID = projectCheck.OrganizationID
+	project.Version = projectCheck.Version
+	newVals, err := store(&project, projectCheck.ID, auditEntry(current, policy.ProjectUpdate))
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
-	return ctx.JSON(http.StatusOK, *newVals)
+	return c.sendProject(ctx, current, newVals)
 }
 
 // DeleteByID will delete project with id in path if logged user has
@@ -376,46 +656,70 @@ func (c *ProjectController) UpdateByID(ctx echo.Context) error {
 func (c *ProjectController) DeleteByID(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	jwtID, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	projectCheck, err := c.ProjectDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return readError(err)
 	}
-	projectCheck, err := c.ProjectDao.ReadByID(pathID)
-	if JWTRole >= models.RoleManager && jwtID != projectCheck.ManagerID { // the >= condition is for possibility of adding new user roles
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	res, err := c.projectResource(current.ID, projectCheck)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.ProjectDelete, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	project, err := c.ProjectDao.ReadByID(pathID)
+	project := projectCheck
+	err = checkIfMatch(ctx, project.Version, func() (interface{}, error) { return c.projectDetail(current, *project) })
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return err
+	}
+	err = c.ProjectDao.Delete(project, auditEntry(current, policy.ProjectDelete))
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
 	}
-	c.ProjectDao.Delete(project)
 
 	return ctx.NoContent(http.StatusOK)
 }
 
 // GetRisksOfProjects will return risks of projects with sent IDs
 func (c *ProjectController) GetRisksOfProjects(ctx echo.Context) error {
-	_, _, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.RiskList, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	ids := common.IDsRequest{}
 	err = common.BindAndValid(ctx, &ids)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, err)
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
 	ret := []models.Risk{}
 	usedRisksMap := make(map[uint]bool)
 
 	for _, id := range ids.IDs {
-		project, _ := c.ProjectDao.ReadByID(id)
-		risks, _ := c.ProjectDao.GetAllAssociatedRisks(project)
+		// projects not visible to logged user are skipped
+		project, err := c.ProjectDao.ReadVisibleByID(current, id)
+		if access.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		risks, err := c.ProjectDao.GetAllAssociatedVisibleRisks(current, project)
+		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
 
 		for _, risk := range risks {
 			if _, ok := usedRisksMap[risk.ID]; ok {
@@ -433,32 +737,103 @@ func (c *ProjectController) GetRisksOfProjects(ctx echo.Context) error {
 func (c *ProjectController) ReadByID(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	_, _, err = common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	project, err := c.ProjectDao.ReadByID(pathID)
+	project, err := c.ProjectDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return readError(err)
+	}
+	res, err := c.projectResource(current.ID, project)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.ProjectRead, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	project.Users, err = c.ProjectDao.GetAllAssociatedUsers(project)
+	detail, err := c.projectDetail(current, *project)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return common.SendVersioned(ctx, http.StatusOK, detail.Version, detail)
+}
+
+// projectDetail will return project with its users, memberships and risks
+// visible to logged user, responses with a single project send it
+func (c *ProjectController) projectDetail(current *models.User, project models.Project) (*models.Project, error) {
+	var err error
+	project.Users, err = c.ProjectDao.GetAllAssociatedUsers(&project)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return nil, err
 	}
 
 	for i := range project.Users {
 		project.Users[i].Password = ""
 	}
 
-	project.Risks, err = c.ProjectDao.GetAllAssociatedRisks(project)
+	project.Memberships, err = c.MembershipDao.GetAllOfProject(project.ID)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return nil, err
 	}
 
-	return ctx.JSON(http.StatusOK, project)
+	project.Risks, err = c.ProjectDao.GetAllAssociatedVisibleRisks(current, &project)
+	if err != nil {
+		return nil, err
+	}
+
+	return &project, nil
+}
+
+// sendProject will send detail of changed project read again, so it is the
+// same as GET of it sends. Project that is not visible anymore is sent as given
+func (c *ProjectController) sendProject(ctx echo.Context, current *models.User, project *models.Project) error {
+	stored, err := c.ProjectDao.ReadVisibleByID(current, project.ID)
+	if access.IsNotFound(err) {
+		stored = project
+	} else if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	detail, err := c.projectDetail(current, *stored)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return common.SendVersioned(ctx, http.StatusOK, detail.Version, detail)
+}
+
+// projectResource will describe project for policy checks
+// of user with ID given by parameter
+func (c *ProjectController) projectResource(userID uint, project *models.Project) (policy.Resource, error) {
+	role, err := c.MembershipDao.BestRole(userID, []uint{project.ID})
+	if err != nil {
+		return policy.Resource{}, err
+	}
+
+	return policy.Resource{
+		ManagerID: project.ManagerID,
+		ProjectRole: role,
+		OrganizationID: project.OrganizationID,
+	}, nil
+}
+
+// assignedRiskIDs will return IDs of risks assigned to project
+// that are visible to viewer
+func (c *ProjectController) assignedRiskIDs(viewer *models.User, project *models.Project) (map[uint]bool, error) {
+	risks, err := c.ProjectDao.GetAllAssociatedVisibleRisks(viewer, project)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[uint]bool{}
+	for _, risk := range risks {
+		ids[risk.ID] = true
+	}
+	return ids, nil
 }
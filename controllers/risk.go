@@ -7,16 +7,17 @@ import (
 	"time"
 	"github.com/wscherfel/fitlogic-backend/common"
 	"net/http"
-	"github.com/dgrijalva/jwt-go"
 	"strconv"
-	"github.com/spf13/viper"
+	"github.com/wscherfel/fitlogic-backend/policy"
 )
 
 type RiskControllerConfig struct {
-	RiskDao *access.RiskDAO
-	UserDao *access.UserDAO
-	ProjectDao *access.ProjectDAO
-	CmDao *access.CounterMeasureDAO
+	RiskDao access.RiskRepository
+	UserDao access.UserRepository
+	ProjectDao access.ProjectRepository
+	CmDao access.CounterMeasureRepository
+	MembershipDao access.MembershipRepository
+	RiskVersionDao access.RiskVersionRepository
 }
 
 type RiskController struct {
@@ -34,36 +35,46 @@ type RiskAPI struct {
 	Value float64
 	Cost int
 	Probability float64
-	Risk float64
+	// Risk and Exposure are computed by server, sent values are ignored
+	Risk float64 `readonly:"true" doc:"risk score, Probability times Impact"`
+	Exposure float64 `readonly:"true" doc:"expected monetary exposure, Probability times Value (Cost when Value is 0)"`
 
 	Name string
 	Description string
 	Category string
 	Threat string
 	Status string
+	// StatusReason is set by transitions, sent value is ignored
+	StatusReason string
 	Trigger string
 	Impact float64
 
+	// dates are in format selected by dateFormat query parameter,
+	// RFC 3339 by default
 	Start string
 	End string
 
 	UserID uint
+}
 
-	CounterMeasureUsed bool
-	CounterMeasureCost int
-	CounterMeasureDesc string
+// RiskTransitionRequest is a structure of request to move risk
+// to other status of its lifecycle
+type RiskTransitionRequest struct {
+	Status string `valid:"required"`
+	Reason string
 }
 
-func MapAPIToRisk(req RiskAPI) (models.Risk, error){
-	format := viper.GetString("TimeFormat")
-	start, err := time.Parse(format, req.Start)
+// MapAPIToRisk will map request values to DB model, dates are parsed
+// with layout given by parameter
+func MapAPIToRisk(req RiskAPI, layout string) (models.Risk, error){
+	start, err := time.Parse(layout, req.Start)
 	if err != nil {
 		return models.Risk{}, err
 	}
 	if !start.After(common.DateMin) {
 		return models.Risk{}, common.ErrDateOutOfRange
 	}
-	end, err := time.Parse(format, req.End)
+	end, err := time.Parse(layout, req.End)
 	if err != nil {
 		return models.Risk{}, err
 	}
@@ -73,12 +84,17 @@ func MapAPIToRisk(req RiskAPI) (models.Risk, error){
 	if end.Before(start) || end.Equal(start) {
 		return models.Risk{}, common.ErrStartDateAfterEnd
 	}
+	if req.Probability < 0 || req.Probability > 1 {
+		return models.Risk{}, common.ErrProbabilityOutOfRange
+	}
+	if !models.IsValidImpact(req.Impact) {
+		return models.Risk{}, common.ErrInvalidImpact
+	}
 
-	return models.Risk{
+	risk := models.Risk{
 		Value: req.Value,
 		Cost: req.Cost,
 		Probability: req.Probability,
-		Risk: req.Risk,
 		Name: req.Name,
 		Description: req.Description,
 		Category: req.Category,
@@ -87,18 +103,19 @@ func MapAPIToRisk(req RiskAPI) (models.Risk, error){
 		Trigger: req.Trigger,
 		Impact: req.Impact,
 
-		Start: req.Start,
-		End: req.End,
+		Start: start,
+		End: end,
 
 		UserID: req.UserID,
+	}
+	risk.ComputeScores()
 
-		CounterMeasureUsed: req.CounterMeasureUsed,
-		CounterMeasureCost: req.CounterMeasureCost,
-		CounterMeasureDesc: req.CounterMeasureDesc,
-	}, nil
+	return risk, nil
 }
 
-func MapRiskToAPI(r models.Risk) (RiskAPI) {
+// MapRiskToAPI will map DB model to API structure, dates are formatted
+// with layout given by parameter
+func MapRiskToAPI(r models.Risk, layout string) (RiskAPI) {
 	proj := []uint{}
 	for _, p := range r.Projects {
 		proj = append(proj, p.ID)
@@ -110,64 +127,96 @@ func MapRiskToAPI(r models.Risk) (RiskAPI) {
 		Cost: r.Cost,
 		Probability: r.Probability,
 		Risk: r.Risk,
+		Exposure: r.Exposure,
 		Name: r.Name,
 		Description: r.Description,
 		Category: r.Category,
 		Threat: r.Threat,
 		Status: r.Status,
+		StatusReason: r.StatusReason,
 		Trigger: r.Trigger,
 		Impact: r.Impact,
-		Start: r.Start,
-		End: r.End,
+		Start: r.Start.Format(layout),
+		End: r.End.Format(layout),
 		UserID: r.UserID,
 	}
 }
 
 func (c *RiskController) Create(ctx echo.Context) error {
-	userID, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.RiskCreate, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 	req := RiskAPI{}
 	err = common.BindAndValid(ctx, &req)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	risk, err := MapAPIToRisk(req)
+	layout, err := common.DateLayout(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	risk, err := MapAPIToRisk(req, layout)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	// every risk starts its lifecycle as identified
+	if risk.Status == "" {
+		risk.Status = models.RiskStatusIdentified
+	}
+	if !models.IsValidRiskStatus(risk.Status) {
+		return common.NewError(http.StatusBadRequest, common.ErrUnknownRiskStatus)
+	}
+	if risk.Status != models.RiskStatusIdentified {
+		return common.NewError(http.StatusBadRequest, common.ErrInvalidRiskTransition)
 	}
 
-
-	if risk.UserID != userID {
-		if role > models.RoleAdmin {
-			return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	// risk belongs to organization of its owner
+	risk.OrganizationID = current.OrganizationID
+	if risk.UserID != current.ID {
+		if err := policy.Authorize(current, policy.RiskCreateForOthers, policy.Resource{}); err != nil {
+			return common.NewError(http.StatusForbidden, err)
 		}
-		_, err := c.UserDao.ReadByID(risk.UserID)
+		owner, err := c.UserDao.ReadVisibleByID(current, risk.UserID)
 		if err != nil {
-			return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+			return common.NewError(http.StatusBadRequest, err)
 		}
+		risk.OrganizationID = owner.OrganizationID
 	}
 
-	err = c.RiskDao.Create(&risk)
+	err = c.RiskDao.Create(&risk, auditEntry(current, policy.RiskCreate))
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
-	return ctx.JSON(http.StatusOK, risk)
+	return c.sendRisk(ctx, current, &risk)
 }
 
 func (c *RiskController) GetAll(ctx echo.Context) error {
-	_, _, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.RiskList, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	all, err := c.RiskDao.GetAll()
+	q, err := common.ParseListQuery(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	all, total, err := c.RiskDao.List(current, q)
+	if err == common.ErrInvalidQueryParam {
+		return common.NewError(http.StatusBadRequest, err)
+	}
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
+	common.SetTotalCount(ctx, total)
 
 	return ctx.JSON(http.StatusOK, all)
 }
@@ -175,156 +224,467 @@ func (c *RiskController) GetAll(ctx echo.Context) error {
 func (c *RiskController) ReadByID(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
 
-	_, _, err = common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	risk, err := c.RiskDao.ReadByID(pathID)
+	risk, err := c.RiskDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return readError(err)
 	}
-
-	risk.Projects, err = c.RiskDao.GetAllAssociatedProjects(risk)
+	res, err := c.riskResource(current, risk)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.RiskRead, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
+	detail, err := c.riskDetail(current, *risk)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
-	return ctx.JSON(http.StatusOK, risk)
+	return common.SendVersioned(ctx, http.StatusOK, detail.Version, detail)
 }
 
-func (c *RiskController) UpdateByID(ctx echo.Context) error {
-	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+// riskDetail will return risk with its projects visible to logged user and
+// its countermeasures, responses with a single risk send it
+func (c *RiskController) riskDetail(current *models.User, risk models.Risk) (*models.Risk, error) {
+	var err error
+	risk.Projects, err = c.RiskDao.GetAllAssociatedVisibleProjects(current, &risk)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return nil, err
 	}
-	pathID := uint(pathIDuint64)
-	jwtID, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	risk.CounterMeasures, err = c.RiskDao.GetAllAssociatedCounterMeasures(&risk)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return nil, err
 	}
-	riskCheck, err := c.RiskDao.ReadByID(pathID)
-	if JWTRole >= models.RoleManager && jwtID != riskCheck.UserID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+
+	return &risk, nil
+}
+
+// sendRisk will send detail of changed risk read again, so it is the same
+// as GET of it sends. Risk that is not visible anymore (e.g. its owner was
+// changed) is sent as given
+func (c *RiskController) sendRisk(ctx echo.Context, current *models.User, risk *models.Risk) error {
+	stored, err := c.RiskDao.ReadVisibleByID(current, risk.ID)
+	if access.IsNotFound(err) {
+		stored = risk
+	} else if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	detail, err := c.riskDetail(current, *stored)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return common.SendVersioned(ctx, http.StatusOK, detail.Version, detail)
+}
+
+func (c *RiskController) UpdateByID(ctx echo.Context) error {
+	current, riskCheck, res, err := c.readRiskForUpdate(ctx)
+	if err != nil {
+		return err
 	}
 
 	req := RiskAPI{}
 	err = common.BindAndValid(ctx, &req)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	// blank status and owner keep the current ones
+	if req.Status == "" {
+		req.Status = riskCheck.Status
+	}
+	if req.UserID == 0 {
+		req.UserID = riskCheck.UserID
+	}
+
+	return c.update(ctx, current, riskCheck, res, req, c.RiskDao.Update)
+}
+
+// PatchByID will apply JSON merge patch (RFC 7396) in request body to
+// risk with ID in path, zero values are stored as well
+func (c *RiskController) PatchByID(ctx echo.Context) error {
+	current, riskCheck, res, err := c.readRiskForUpdate(ctx)
+	if err != nil {
+		return err
 	}
 
-	risk, err := MapAPIToRisk(req)
+	layout, err := common.DateLayout(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	req := MapRiskToAPI(*riskCheck, layout)
+	err = common.BindMergePatch(ctx, &req)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	risk.ID = pathID
+	return c.update(ctx, current, riskCheck, res, req, c.RiskDao.UpdateAll)
+}
 
-	newVals, err := c.RiskDao.Update(&risk, pathID)
+// readRiskForUpdate will read risk with ID in path and check that
+// logged user can update it and that If-Match has its current tag
+func (c *RiskController) readRiskForUpdate(ctx echo.Context) (*models.User, *models.Risk, policy.Resource, error) {
+	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return nil, nil, policy.Resource{}, common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
+	}
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, nil, policy.Resource{}, common.NewError(http.StatusBadRequest, err)
+	}
+	riskCheck, err := c.RiskDao.ReadVisibleByID(current, uint(pathIDuint64))
+	if err != nil {
+		return nil, nil, policy.Resource{}, readError(err)
+	}
+	res, err := c.riskResource(current, riskCheck)
+	if err != nil {
+		return nil, nil, policy.Resource{}, common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.RiskUpdate, res); err != nil {
+		return nil, nil, policy.Resource{}, common.NewError(http.StatusForbidden, err)
+	}
+	err = checkIfMatch(ctx, riskCheck.Version, func() (interface{}, error) { return c.riskDetail(current, *riskCheck) })
+	if err != nil {
+		return nil, nil, policy.Resource{}, err
+	}
+
+	return current, riskCheck, res, nil
+}
+
+// update will store values of request to risk given by parameter with
+// store function, Update of DAO skips zero values and UpdateAll does not
+func (c *RiskController) update(ctx echo.Context, current *models.User, riskCheck *models.Risk, res policy.Resource,
+	req RiskAPI, store func(*models.Risk, uint, *models.AuditEntry) (*models.Risk, error)) error {
+	layout, err := common.DateLayout(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	risk, err := MapAPIToRisk(req, layout)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	// status can change only by allowed transition, closing needs reason
+	// so it is possible only by transition endpoint
+	if risk.Status != riskCheck.Status {
+		if err := checkTransition(current, res, riskCheck.Status, risk.Status, ""); err != nil {
+			return common.NewError(http.StatusBadRequest, err)
+		}
+	}
+
+	// new owner has to be from organization of the risk
+	if risk.UserID != riskCheck.UserID {
+		owner, err := c.UserDao.ReadVisibleByID(current, risk.UserID)
+		if err != nil {
+			return common.NewError(http.StatusBadRequest, err)
+		}
+		if owner.OrganizationID != riskCheck.OrganizationID {
+			return common.NewError(http.StatusBadRequest, common.ErrAssociationOutsideOrganization)
+		}
+	}
+
+	risk.ID = riskCheck.ID
+	risk.OrganizationID = riskCheck.OrganizationID
+	risk.StatusReason = riskCheck.StatusReason
+	risk.Version = riskCheck.Version
+
+	newVals, err := store(&risk, riskCheck.ID, auditEntry(current, policy.RiskUpdate))
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
-	return ctx.JSON(http.StatusOK, newVals)
+	return c.sendRisk(ctx, current, newVals)
 }
 
 func (c *RiskController) DeleteByID(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	jwtID, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	riskCheck, err := c.RiskDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	res, err := c.riskResource(current, riskCheck)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
-	riskCheck, err := c.RiskDao.ReadByID(pathID)
-	if JWTRole >= models.RoleManager && jwtID != riskCheck.UserID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	if err := policy.Authorize(current, policy.RiskDelete, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	risk, err := c.RiskDao.ReadByID(pathID)
+	risk := riskCheck
+	err = checkIfMatch(ctx, risk.Version, func() (interface{}, error) { return c.riskDetail(current, *risk) })
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, common.CreateError(err))
+		return err
 	}
 
-	err = c.RiskDao.Delete(risk)
+	err = c.RiskDao.Delete(risk, auditEntry(current, policy.RiskDelete))
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
 	return ctx.NoContent(http.StatusOK)
 }
 
-/*func (c *RiskController) AssignCms(ctx echo.Context) error {
+// Transition will move risk with ID in path to status sent in request body
+// if the transition is allowed in lifecycle of risk
+func (c *RiskController) Transition(ctx echo.Context) error {
+	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
+	}
+	pathID := uint(pathIDuint64)
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	risk, err := c.RiskDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	res, err := c.riskResource(current, risk)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	err = checkIfMatch(ctx, risk.Version, func() (interface{}, error) { return c.riskDetail(current, *risk) })
+	if err != nil {
+		return err
+	}
+
+	req := RiskTransitionRequest{}
+	err = common.BindAndValid(ctx, &req)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	if err := checkTransition(current, res, risk.Status, req.Status, req.Reason); err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	risk, err = c.RiskDao.UpdateStatus(risk, req.Status, req.Reason, auditEntry(current, policy.RiskTransition))
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return c.sendRisk(ctx, current, risk)
+}
+
+// checkTransition will check that logged user can move risk described
+// by res from status to status with reason given by parameters
+func checkTransition(current *models.User, res policy.Resource, from, to, reason string) error {
+	if !models.IsValidRiskStatus(to) {
+		return common.ErrUnknownRiskStatus
+	}
+	if !models.CanTransitionRisk(from, to) {
+		return common.ErrInvalidRiskTransition
+	}
+	if err := policy.Authorize(current, policy.RiskTransition, res); err != nil {
+		return err
+	}
+	if to == models.RiskStatusClosed || to == models.RiskStatusOccurred {
+		if err := policy.Authorize(current, policy.RiskClose, res); err != nil {
+			return err
+		}
+	}
+	if to == models.RiskStatusClosed && reason == "" {
+		return common.ErrReasonRequired
+	}
+
+	return nil
+}
+
+// riskResource will describe risk for policy checks of user given
+// by parameter
+func (c *RiskController) riskResource(current *models.User, risk *models.Risk) (policy.Resource, error) {
+	projects, err := c.RiskDao.GetAllAssociatedVisibleProjects(current, risk)
+	if err != nil {
+		return policy.Resource{}, err
+	}
+	projectIDs := []uint{}
+	for i := range projects {
+		projectIDs = append(projectIDs, projects[i].ID)
+	}
+
+	role, err := c.MembershipDao.BestRole(current.ID, projectIDs)
+	if err != nil {
+		return policy.Resource{}, err
+	}
+
+	return policy.Resource{
+		OwnerID: risk.UserID,
+		ProjectRole: role,
+		OrganizationID: risk.OrganizationID,
+	}, nil
+}
+
+// AssignCms will add association between countermeasures with sent IDs
+// and risk with ID in path
+func (c *RiskController) AssignCms(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	jwtID, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	risk, err := c.RiskDao.ReadByID(pathID)
-	if JWTRole >= models.RoleManager && jwtID != risk.UserID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	risk, err := c.RiskDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	res, err := c.riskResource(current, risk)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.RiskAssignCms, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	ids := common.IDsRequest{}
 	err = common.BindAndValid(ctx, &ids)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
+	partial, err := isPartial(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	assigned, err := c.assignedCmIDs(risk)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	result := &AssignResult{Applied: []uint{}}
+	cms := []models.CounterMeasure{}
 	for _, id := range ids.IDs {
-		cm, err := c.CmDao.ReadByID(id)
+		cm, err := c.CmDao.ReadVisibleByID(current, id)
+		if access.IsNotFound(err) {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
 		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		if cm.OrganizationID != risk.OrganizationID {
+			result.OutsideOrganization = append(result.OutsideOrganization, id)
+			continue
+		}
+		if assigned[id] {
+			result.AlreadyAssigned = append(result.AlreadyAssigned, id)
 			continue
 		}
-		risk, _ = c.RiskDao.AddCounterMeasuresAssociation(risk, cm)
+		cms = append(cms, *cm)
+		result.Applied = append(result.Applied, id)
+	}
+	if result.rejected() && !partial || len(cms) == 0 {
+		return respondAssign(ctx, result, partial)
 	}
 
-	return ctx.NoContent(http.StatusOK)
-}*/
+	if err := c.RiskDao.AddCounterMeasuresAssociations(risk, cms, auditEntry(current, policy.RiskAssignCms)); err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return respondAssign(ctx, result, partial)
+}
 
-/*func (c *RiskController) UnAssignCms(ctx echo.Context) error {
+// UnAssignCms will remove association between countermeasures with sent IDs
+// and risk with ID in path
+func (c *RiskController) UnAssignCms(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	jwtID, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	risk, err := c.RiskDao.ReadByID(pathID)
-	if JWTRole >= models.RoleManager && jwtID != risk.UserID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	risk, err := c.RiskDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return readError(err)
+	}
+	res, err := c.riskResource(current, risk)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if err := policy.Authorize(current, policy.RiskAssignCms, res); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	ids := common.IDsRequest{}
 	err = common.BindAndValid(ctx, &ids)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrUnsufficientPrivileges))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	partial, err := isPartial(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	assigned, err := c.assignedCmIDs(risk)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
+	result := &AssignResult{Applied: []uint{}}
+	cms := []models.CounterMeasure{}
 	for _, id := range ids.IDs {
-		cm, err := c.CmDao.ReadByID(id)
+		cm, err := c.CmDao.ReadVisibleByID(current, id)
+		if access.IsNotFound(err) {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
 		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		if !assigned[id] {
+			result.NotAssigned = append(result.NotAssigned, id)
 			continue
 		}
-		risk, _ = c.RiskDao.RemoveCounterMeasuresAssociation(risk, cm)
+		cms = append(cms, *cm)
+		result.Applied = append(result.Applied, id)
+	}
+	if result.rejected() && !partial || len(cms) == 0 {
+		return respondAssign(ctx, result, partial)
 	}
 
-	return ctx.NoContent(http.StatusOK)
-*/
+	if err := c.RiskDao.RemoveCounterMeasuresAssociations(risk, cms, auditEntry(current, policy.RiskAssignCms)); err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return respondAssign(ctx, result, partial)
+}
+
+// assignedCmIDs will return IDs of countermeasures assigned to risk
+func (c *RiskController) assignedCmIDs(risk *models.Risk) (map[uint]bool, error) {
+	cms, err := c.RiskDao.GetAllAssociatedCounterMeasures(risk)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[uint]bool{}
+	for _, cm := range cms {
+		ids[cm.ID] = true
+	}
+	return ids, nil
+}
+
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+	"github.com/wscherfel/fitlogic-backend/policy"
+)
+
+// RiskVersionsDiff is a structure returned when two versions of risk
+// are compared
+type RiskVersionsDiff struct {
+	From uint
+	To uint
+	Changes models.Changes
+}
+
+// GetVersions will return all versions of risk with ID in path
+func (c *RiskController) GetVersions(ctx echo.Context) error {
+	risk, status, err := c.readRiskForHistory(ctx, policy.RiskRead)
+	if err != nil {
+		return common.NewError(status, err)
+	}
+
+	versions, err := c.RiskVersionDao.GetAllOfRisk(risk.ID)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return ctx.JSON(http.StatusOK, versions)
+}
+
+// ReadVersion will return version in path of risk with ID in path
+func (c *RiskController) ReadVersion(ctx echo.Context) error {
+	risk, status, err := c.readRiskForHistory(ctx, policy.RiskRead)
+	if err != nil {
+		return common.NewError(status, err)
+	}
+	version, err := strconv.ParseUint(ctx.Param("version"), 10, 64)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
+	}
+
+	v, err := c.RiskVersionDao.Read(risk.ID, uint(version))
+	if err != nil {
+		return readError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, v)
+}
+
+// DiffVersions will return changes of risk with ID in path between
+// versions in query parameters from and to
+func (c *RiskController) DiffVersions(ctx echo.Context) error {
+	risk, status, err := c.readRiskForHistory(ctx, policy.RiskRead)
+	if err != nil {
+		return common.NewError(status, err)
+	}
+	from, err := strconv.ParseUint(ctx.QueryParam("from"), 10, 64)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, common.ErrInvalidQueryParam)
+	}
+	to, err := strconv.ParseUint(ctx.QueryParam("to"), 10, 64)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, common.ErrInvalidQueryParam)
+	}
+
+	fromVersion, err := c.RiskVersionDao.Read(risk.ID, uint(from))
+	if err != nil {
+		return readError(err)
+	}
+	toVersion, err := c.RiskVersionDao.Read(risk.ID, uint(to))
+	if err != nil {
+		return readError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, RiskVersionsDiff{
+		From: fromVersion.Version,
+		To: toVersion.Version,
+		Changes: common.Diff(&fromVersion.Data.Risk, &toVersion.Data.Risk),
+	})
+}
+
+// ReadAsOf will return version of risk with ID in path that was current
+// at time in query parameter time, it is in RFC 3339 format
+func (c *RiskController) ReadAsOf(ctx echo.Context) error {
+	risk, status, err := c.readRiskForHistory(ctx, policy.RiskRead)
+	if err != nil {
+		return common.NewError(status, err)
+	}
+	at, err := parseTimeParam(ctx.QueryParam("time"))
+	if err != nil || at.IsZero() {
+		return common.NewError(http.StatusBadRequest, common.ErrInvalidQueryParam)
+	}
+
+	v, err := c.RiskVersionDao.ReadAsOf(risk.ID, at)
+	if err != nil {
+		return readError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, v)
+}
+
+// RevertToVersion will set values of risk with ID in path to values of its
+// version in path. Status is changed only by transitions and owner
+// by update, so they are kept. Revert is stored as a new version
+func (c *RiskController) RevertToVersion(ctx echo.Context) error {
+	risk, status, err := c.readRiskForHistory(ctx, policy.RiskRevert)
+	if err != nil {
+		return common.NewError(status, err)
+	}
+	version, err := strconv.ParseUint(ctx.Param("version"), 10, 64)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
+	}
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	err = checkIfMatch(ctx, risk.Version, func() (interface{}, error) { return c.riskDetail(current, *risk) })
+	if err != nil {
+		return err
+	}
+
+	v, err := c.RiskVersionDao.Read(risk.ID, uint(version))
+	if err != nil {
+		return readError(err)
+	}
+
+	// scores of older versions could be computed differently
+	old := v.Data.Risk
+	old.ComputeScores()
+	risk, err = c.RiskDao.Revert(risk, &old, auditEntry(current, policy.RiskRevert))
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	return c.sendRisk(ctx, current, risk)
+}
+
+// readRiskForHistory will read risk with ID in path and check that logged
+// user can perform action on it, HTTP status is returned with error
+func (c *RiskController) readRiskForHistory(ctx echo.Context, action policy.Action) (*models.Risk, int, error) {
+	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest, common.ErrIdInPathWrongFormat
+	}
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	risk, err := c.RiskDao.ReadVisibleByID(current, uint(pathIDuint64))
+	if err != nil {
+		return nil, readStatus(err), err
+	}
+	res, err := c.riskResource(current, risk)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if err := policy.Authorize(current, action, res); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+
+	return risk, 0, nil
+}
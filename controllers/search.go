@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+	"github.com/wscherfel/fitlogic-backend/policy"
+)
+
+// default and the highest number of search results
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit = 100
+)
+
+type SearchControllerConfig struct {
+	SearchDao access.SearchRepository
+}
+
+// SearchController is a controller that handles search of risks,
+// projects and users
+type SearchController struct {
+	SearchControllerConfig
+}
+
+func NewSearchController(config SearchControllerConfig) *SearchController {
+	return &SearchController{
+		SearchControllerConfig: config,
+	}
+}
+
+// Search will return records matching query parameter q that are visible
+// to logged user, the best matches first. Parameter types is a comma
+// separated list of risk, project and user, limit is number of results
+func (c *SearchController) Search(ctx echo.Context) error {
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.Search, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
+	}
+
+	query := strings.TrimSpace(ctx.QueryParam("q"))
+	if query == "" {
+		return common.NewError(http.StatusBadRequest, common.ErrSearchQueryRequired)
+	}
+
+	types := []string{}
+	if param := ctx.QueryParam("types"); param != "" {
+		for _, t := range strings.Split(param, ",") {
+			t = strings.TrimSpace(t)
+			if t != models.EntityRisk && t != models.EntityProject && t != models.EntityUser {
+				return common.NewError(http.StatusBadRequest, common.ErrInvalidQueryParam)
+			}
+			types = append(types, t)
+		}
+	}
+
+	limit := DefaultSearchLimit
+	if param := ctx.QueryParam("limit"); param != "" {
+		limit, err = strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > MaxSearchLimit {
+			return common.NewError(http.StatusBadRequest, common.ErrInvalidQueryParam)
+		}
+	}
+
+	results, err := c.SearchDao.Search(current, query, types, limit)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return ctx.JSON(http.StatusOK, results)
+}
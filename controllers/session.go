@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+type SessionControllerConfig struct {
+	SessionDao access.SessionRepository
+	UserDao access.UserRepository
+	// Secret signs access tokens, middleware.JWT checks them with it
+	Secret []byte
+}
+
+// SessionController is a controller that handles refreshing of tokens,
+// logging out and checks whether sessions of sent tokens are still valid
+type SessionController struct {
+	SessionControllerConfig
+}
+
+// RefreshRequest is a structure of request to obtain new tokens
+type RefreshRequest struct {
+	RefreshToken string `valid:"required"`
+}
+
+// TokensResponse is a structure of response with newly issued tokens
+type TokensResponse struct {
+	Token string
+	RefreshToken string
+}
+
+func NewSessionController(config SessionControllerConfig) *SessionController {
+	return &SessionController{
+		SessionControllerConfig: config,
+	}
+}
+
+// createSession will create a new session for user and return access
+// token signed with secret and refresh token bound to it
+func createSession(dao access.SessionRepository, secret []byte, user *models.User) (*TokensResponse, error) {
+	refreshToken, hash, err := common.CreateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{
+		UserID: user.ID,
+		RefreshTokenHash: hash,
+		ExpiresAt: time.Now().Add(common.RefreshTokenExpiration),
+	}
+	if err := dao.Create(session); err != nil {
+		return nil, err
+	}
+
+	token, err := common.CreateToken(secret, user.ID, user.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokensResponse{
+		Token: token,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// CheckRevoked is a middleware used after middleware.JWT, it rejects tokens
+// whose session was revoked (logout, change of role or password) or has expired
+func (c *SessionController) CheckRevoked(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		token, ok := ctx.Get("user").(*jwt.Token)
+		if !ok {
+			return common.NewError(http.StatusUnauthorized, common.ErrMissingTokenClaims)
+		}
+		sessionID, err := common.GetSessionIdFromToken(token)
+		if err != nil {
+			return common.NewError(http.StatusUnauthorized, err)
+		}
+
+		session, err := c.SessionDao.ReadByID(sessionID)
+		if err != nil || session.Revoked || session.ExpiresAt.Before(time.Now()) {
+			return common.NewError(http.StatusUnauthorized, common.ErrSessionRevoked)
+		}
+
+		return next(ctx)
+	}
+}
+
+// LoadUser is a middleware used after CheckRevoked, it loads logged user from
+// DB and stores him in context, so handlers use his current role instead of
+// the one in token. Deleted users are rejected
+func (c *SessionController) LoadUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, _, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+		if err != nil {
+			return common.NewError(http.StatusUnauthorized, err)
+		}
+
+		user, err := c.UserDao.ReadByID(userID)
+		if access.IsNotFound(err) {
+			return common.NewError(http.StatusUnauthorized, common.ErrUserNoLongerExists)
+		}
+		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		ctx.Set(common.CurrentUserKey, user)
+
+		return next(ctx)
+	}
+}
+
+// Refresh will exchange refresh token for a new access token, refresh token
+// is rotated so the sent one cannot be used again. A rotated token sent again
+// was stolen (either by the sender or by the one who rotated it), so its
+// session is revoked and neither of them can use it anymore
+func (c *SessionController) Refresh(ctx echo.Context) error {
+	req := RefreshRequest{}
+	err := common.BindAndValid(ctx, &req)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	hash := common.HashRefreshToken(req.RefreshToken)
+	session, err := c.SessionDao.ReadByRefreshTokenHash(hash)
+	if access.IsNotFound(err) {
+		if rotated, err := c.SessionDao.ReadByRotatedRefreshTokenHash(hash); err == nil {
+			return c.revokeReused(rotated)
+		}
+	}
+	if err != nil || session.Revoked || session.ExpiresAt.Before(time.Now()) {
+		return common.NewError(http.StatusUnauthorized, common.ErrInvalidRefreshToken)
+	}
+
+	// user could have been deleted in the meantime
+	user, err := c.UserDao.ReadByID(session.UserID)
+	if err != nil {
+		return common.NewError(http.StatusUnauthorized, common.ErrInvalidRefreshToken)
+	}
+
+	refreshToken, newHash, err := common.CreateRefreshToken()
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	err = c.SessionDao.Rotate(session, hash, newHash, time.Now().Add(common.RefreshTokenExpiration))
+	if err == common.ErrRefreshTokenReused {
+		// the same token was rotated by a concurrent request
+		return c.revokeReused(session)
+	}
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	token, err := common.CreateToken(c.Secret, user.ID, user.Role, session.ID)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return ctx.JSON(http.StatusOK, &TokensResponse{
+		Token: token,
+		RefreshToken: refreshToken,
+	})
+}
+
+// revokeReused will revoke session whose refresh token was used again
+// and return error of the reuse
+func (c *SessionController) revokeReused(session *models.Session) error {
+	if err := c.SessionDao.Revoke(session); err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	return common.NewError(http.StatusUnauthorized, common.ErrRefreshTokenReused)
+}
+
+// Logout will revoke session of sent token, neither access token
+// nor refresh token of this session can be used anymore
+func (c *SessionController) Logout(ctx echo.Context) error {
+	sessionID, err := common.GetSessionIdFromToken(ctx.Get("user").(*jwt.Token))
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	session, err := c.SessionDao.ReadByID(sessionID)
+	if err != nil {
+		return readError(err)
+	}
+
+	err = c.SessionDao.Revoke(session)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return ctx.NoContent(http.StatusOK)
+}
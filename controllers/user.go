@@ -6,23 +6,28 @@ import (
 	"github.com/wscherfel/fitlogic-backend/models"
 	"net/http"
 	"github.com/wscherfel/fitlogic-backend/common"
-	"github.com/dgrijalva/jwt-go"
 	"strconv"
+	"github.com/wscherfel/fitlogic-backend/policy"
 )
 
 var(
 	// DefaultAdmin is a structure of a default admin
-	// password is "qwerty" hashed with md5 (frontend uses this hashing method)
+	// password is hashed with bcrypt before it is stored in DB,
+	// default admin is super-admin in the default organization
 	DefaultAdmin = &models.User{
 		Name: "admin",
 		Email: "admin@admin.com",
-		Password: "d8578edf8458ce06fbc5bb76a58c5ca4",
-		Role: models.RoleAdmin,
+		Password: "qwerty",
+		Role: models.RoleSuperAdmin,
 	}
 )
 
 type UserControllerConfig struct {
-	UserDao *access.UserDAO
+	UserDao access.UserRepository
+	SessionDao access.SessionRepository
+	OrganizationDao access.OrganizationRepository
+	// Secret signs access tokens issued by Login
+	Secret []byte
 }
 
 // UserController is a controller that handles user endpoints
@@ -40,8 +45,10 @@ type LoginCredentials struct {
 type LoginResponse struct {
 	ID uint
 	Token string
+	RefreshToken string
 	Name string
 	Role int
+	OrganizationID uint
 }
 
 // ChangePasswordRequest is a structure of a request to change password
@@ -57,6 +64,19 @@ type UpdateRequest struct {
 	Role int
 	Skills string
 	Status string
+	// only super-admin can move user to other organization
+	OrganizationID uint
+}
+
+// PatchRequest is a structure of user values JSON merge patch is applied
+// to, fields that cannot be cleared are required
+type PatchRequest struct {
+	Name string `valid:"required"`
+	Email string `valid:"email,required"`
+	Role int `valid:"required"`
+	Skills string
+	Status string
+	OrganizationID uint `valid:"required"`
 }
 
 func NewUserController(config UserControllerConfig) *UserController {
@@ -64,8 +84,21 @@ func NewUserController(config UserControllerConfig) *UserController {
 		UserControllerConfig: config,
 	}
 
-	// create the default admin
-	newController.UserDao.Create(DefaultAdmin)
+	// create the default admin if it does not exist yet
+	existing, err := newController.UserDao.ReadByEmail(DefaultAdmin.Email)
+	if err == nil && len(existing) == 0 {
+		admin := *DefaultAdmin
+		org, err := newController.OrganizationDao.ReadByName(models.DefaultOrganizationName)
+		if err != nil {
+			panic(err)
+		}
+		admin.OrganizationID = org.ID
+		admin.Password, err = common.HashPassword(DefaultAdmin.Password)
+		if err != nil {
+			panic(err)
+		}
+		newController.UserDao.Create(&admin, nil)
+	}
 	return newController
 }
 
@@ -74,30 +107,47 @@ func (c *UserController) Login(ctx echo.Context) error {
 	credentials := LoginCredentials{}
 	err := common.BindAndValid(ctx, &credentials)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
 	read, err := c.UserDao.ReadByEmail(credentials.Email)
 	// error during read from DB
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	// no users with given email found or password does not match
-	if len(read) == 0 || read[0].Password != credentials.Password {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrWrongEmailOrPassword))
+	// no users with given email found
+	if len(read) == 0 {
+		return common.NewError(http.StatusUnauthorized, common.ErrWrongEmailOrPassword)
+	}
+	match, needsRehash := common.CheckPassword(read[0].Password, credentials.Password)
+	if !match {
+		return common.NewError(http.StatusUnauthorized, common.ErrWrongEmailOrPassword)
+	}
+	// password is stored in old format, replace it with bcrypt hash
+	if needsRehash {
+		hash, err := common.HashPassword(credentials.Password)
+		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		err = c.UserDao.UpdatePassword(read[0].ID, hash)
+		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
 	}
 
-	tokenString, err := common.CreateToken(read[0].ID, read[0].Role)
+	tokens, err := createSession(c.SessionDao, c.Secret, &read[0])
 	// token generation error - weird stuff happened
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
 	response := &LoginResponse{
 		ID: read[0].ID,
-		Token: tokenString,
+		Token: tokens.Token,
+		RefreshToken: tokens.RefreshToken,
 		Name: read[0].Name,
 		Role: read[0].Role,
+		OrganizationID: read[0].OrganizationID,
 	}
 
 	return ctx.JSON(http.StatusOK, response)
@@ -105,43 +155,76 @@ func (c *UserController) Login(ctx echo.Context) error {
 
 // Create will create a new user in DB
 func (c *UserController) Create(ctx echo.Context) error {
-	_, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	if role != models.RoleAdmin {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	if err := policy.Authorize(current, policy.UserCreate, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 	user := models.User{}
 	err = common.BindAndValid(ctx, &user)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	// user is created in organization of logged user unless super-admin
+	// chooses other one
+	if user.OrganizationID == 0 || !policy.Can(current, policy.UserSetOrganization, policy.Resource{}) {
+		user.OrganizationID = current.OrganizationID
+	}
+	if _, err := c.OrganizationDao.ReadByID(user.OrganizationID); access.IsNotFound(err) {
+		return common.NewError(http.StatusBadRequest, common.ErrOrganizationDoesNotExist)
+	} else if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	if !models.IsValidRole(user.Role) {
+		return common.NewError(http.StatusBadRequest, common.ErrUnknownRole)
+	}
+	// nobody can create user more privileged than himself
+	if user.Role < current.Role {
+		return common.NewError(http.StatusForbidden, common.ErrUnsufficientPrivileges)
 	}
 
 	user.Projects = []models.Project{}
 	user.Risks = []models.Risk{}
+	user.Version = 0
+	user.Password, err = common.HashPassword(user.Password)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
 
-	err = c.UserDao.Create(&user)
+	err = c.UserDao.Create(&user, auditEntry(current, policy.UserCreate))
 	// error during create
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	user.Password = ""
 
-	return ctx.JSON(http.StatusOK, &user)
+	return c.sendUser(ctx, current, &user)
 }
 
-// Read will get all users from DB and return them
+// Read will return one page of users matching query parameters
 func (c *UserController) Read(ctx echo.Context) error {
-	_, _, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err := policy.Authorize(current, policy.UserList, policy.Resource{}); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	users, err := c.UserDao.GetAll()
+	q, err := common.ParseListQuery(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
+	users, total, err := c.UserDao.List(current, q)
+	if err == common.ErrInvalidQueryParam {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	common.SetTotalCount(ctx, total)
 	for i := range users {
 		users[i].Password = ""
 	}
@@ -154,33 +237,62 @@ func (c *UserController) Read(ctx echo.Context) error {
 func (c *UserController) ReadByID(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	jwtID, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	user, err := c.UserDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return readError(err)
 	}
-	if role >= models.RoleUser && jwtID != pathID{ // the >= condition is for possibility of adding new user roles
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	if err := policy.Authorize(current, policy.UserRead, userResource(user)); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
-	user, err := c.UserDao.ReadByID(pathID)
+	detail, err := c.userDetail(current, *user)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
-	user.Projects, err = c.UserDao.GetAllAssociatedProjects(user)
+	return common.SendVersioned(ctx, http.StatusOK, detail.Version, detail)
+}
+
+// userDetail will return user with his projects and risks visible to logged
+// user and without password, responses with a single user send it
+func (c *UserController) userDetail(current *models.User, user models.User) (*models.User, error) {
+	var err error
+	user.Projects, err = c.UserDao.GetAllAssociatedVisibleProjects(current, &user)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return nil, err
 	}
-	user.Risks, err = c.UserDao.GetAllAssociatedRisks(user)
+	user.Risks, err = c.UserDao.GetAllAssociatedVisibleRisks(current, &user)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return nil, err
 	}
 	user.Password = ""
 
-	return ctx.JSON(http.StatusOK, user)
+	return &user, nil
+}
+
+// sendUser will send detail of changed user read again, so it is the same
+// as GET of him sends. User that is not visible anymore is sent as given
+func (c *UserController) sendUser(ctx echo.Context, current *models.User, user *models.User) error {
+	stored, err := c.UserDao.ReadVisibleByID(current, user.ID)
+	if access.IsNotFound(err) {
+		stored = user
+	} else if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	detail, err := c.userDetail(current, *stored)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+
+	return common.SendVersioned(ctx, http.StatusOK, detail.Version, detail)
 }
 
 // DeleteByID will delete user with ID in path if logged user has sufficient
@@ -188,40 +300,48 @@ func (c *UserController) ReadByID(ctx echo.Context) error {
 func (c *UserController) DeleteByID(ctx echo.Context) error {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	_, role, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	user, err := c.UserDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return readError(err)
 	}
-	if role != models.RoleAdmin {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	if err := policy.Authorize(current, policy.UserDelete, userResource(user)); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
-
-	user, err := c.UserDao.ReadByID(pathID)
+	err = checkIfMatch(ctx, user.Version, func() (interface{}, error) { return c.userDetail(current, *user) })
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, common.CreateError(err))
+		return err
 	}
 
-	// check if only admin is going to be deleted
-	others, err := c.UserDao.GetAll()
+	// check if only admin of organization is going to be deleted
+	others, err := c.UserDao.GetAll(current)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 	onlyAdmin := true
 	for i := range others{
-		if others[i].ID != pathID && others[i].Role == models.RoleAdmin {
+		if others[i].ID != pathID && others[i].OrganizationID == user.OrganizationID &&
+			others[i].Role <= models.RoleAdmin {
 			onlyAdmin = false
 		}
 	}
 	if onlyAdmin {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrCannotDeleteOnlyAdmin))
+		return common.NewError(http.StatusBadRequest, common.ErrCannotDeleteOnlyAdmin)
 	}
 
-	err = c.UserDao.Delete(user)
+	err = c.UserDao.Delete(user, auditEntry(current, policy.UserDelete))
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	err = c.SessionDao.RevokeAllOfUser(user.ID)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
 	}
 
 	return ctx.NoContent(http.StatusOK)
@@ -230,95 +350,210 @@ func (c *UserController) DeleteByID(ctx echo.Context) error {
 // UpdateByID will update user with ID in path
 // to new values sent in request body
 func (c *UserController) UpdateByID(ctx echo.Context) error {
+	current, oldVals, err := c.readUserForUpdate(ctx)
+	if err != nil {
+		return err
+	}
+
+	requestValues := UpdateRequest{}
+	err = common.BindAndValid(ctx, &requestValues)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+	// blank role and organization keep the current ones, role is ignored
+	// when logged user cannot change it
+	if requestValues.Role == 0 ||
+		!policy.Can(current, policy.UserChangeRole, userResource(oldVals)) {
+		requestValues.Role = oldVals.Role
+	}
+	if requestValues.OrganizationID == 0 {
+		requestValues.OrganizationID = oldVals.OrganizationID
+	}
+
+	return c.update(ctx, current, oldVals, requestValues, c.UserDao.Update)
+}
+
+// PatchByID will apply JSON merge patch (RFC 7396) in request body to
+// user with ID in path, zero values are stored as well
+func (c *UserController) PatchByID(ctx echo.Context) error {
+	current, oldVals, err := c.readUserForUpdate(ctx)
+	if err != nil {
+		return err
+	}
+
+	requestValues := PatchRequest{
+		Name: oldVals.Name,
+		Email: oldVals.Email,
+		Role: oldVals.Role,
+		Skills: oldVals.Skills,
+		Status: oldVals.Status,
+		OrganizationID: oldVals.OrganizationID,
+	}
+	err = common.BindMergePatch(ctx, &requestValues)
+	if err != nil {
+		return common.NewError(http.StatusBadRequest, err)
+	}
+
+	return c.update(ctx, current, oldVals, UpdateRequest{
+		Name: requestValues.Name,
+		Email: requestValues.Email,
+		Role: requestValues.Role,
+		Skills: requestValues.Skills,
+		Status: requestValues.Status,
+		OrganizationID: requestValues.OrganizationID,
+	}, c.UserDao.UpdateAll)
+}
+
+// readUserForUpdate will read user with ID in path and check that
+// logged user can update him and that If-Match has his current tag
+func (c *UserController) readUserForUpdate(ctx echo.Context) (*models.User, *models.User, error) {
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return nil, nil, common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	jwtID, JWTRole, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return nil, nil, common.NewError(http.StatusBadRequest, err)
 	}
-	if JWTRole >= models.RoleUser && jwtID != pathID{ // the >= condition is for possibility of adding new user roles
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	oldVals, err := c.UserDao.ReadVisibleByID(current, pathID)
+	if err != nil {
+		return nil, nil, readError(err)
 	}
-
-	requestValues := &UpdateRequest{}
-	err = common.BindAndValid(ctx, requestValues)
+	if err := policy.Authorize(current, policy.UserUpdate, userResource(oldVals)); err != nil {
+		return nil, nil, common.NewError(http.StatusForbidden, err)
+	}
+	err = checkIfMatch(ctx, oldVals.Version, func() (interface{}, error) { return c.userDetail(current, *oldVals) })
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return nil, nil, err
 	}
 
+	return current, oldVals, nil
+}
+
+// update will store values of request to user given by parameter with
+// store function, Update of DAO skips zero values and UpdateAll does not
+func (c *UserController) update(ctx echo.Context, current *models.User, oldVals *models.User,
+	requestValues UpdateRequest, store func(*models.User, uint, *models.AuditEntry) (*models.User, error)) error {
 	updatedVals := &models.User{
+		OrganizationID: oldVals.OrganizationID,
 		Name: requestValues.Name,
 		Email: requestValues.Email,
+		Password: oldVals.Password,
+		Role: oldVals.Role,
 		Skills: requestValues.Skills,
 		Status: requestValues.Status,
+		Version: oldVals.Version,
 	}
 
-	if JWTRole == models.RoleAdmin {
-		updatedVals.Role = requestValues.Role
-
-		oldVals, err := c.UserDao.ReadByID(pathID)
-		if err != nil {
-			return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+	roleChanged := requestValues.Role != oldVals.Role
+	if roleChanged {
+		if !models.IsValidRole(requestValues.Role) {
+			return common.NewError(http.StatusBadRequest, common.ErrUnknownRole)
+		}
+		if err := policy.Authorize(current, policy.UserChangeRole, userResource(oldVals)); err != nil {
+			return common.NewError(http.StatusForbidden, err)
+		}
+		// nobody can promote user to be more privileged than himself
+		if requestValues.Role < current.Role {
+			return common.NewError(http.StatusForbidden, common.ErrUnsufficientPrivileges)
 		}
 		// updated user is manager or admin and wants to be downgraded to user
-		if oldVals.Role <= models.RoleManager && updatedVals.Role > models.RoleManager {
-			projects, err := c.UserDao.GetAllAssociatedProjects(oldVals)
+		if oldVals.Role <= models.RoleManager && requestValues.Role > models.RoleManager {
+			// admins changing roles see all projects of the organization
+			projects, err := c.UserDao.GetAllAssociatedVisibleProjects(current, oldVals)
 			if err != nil {
-				return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+				return common.NewError(http.StatusInternalServerError, err)
 			}
 			for i := range projects {
-				if projects[i].ManagerID == pathID {
-					return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrManagerStillLeadsProjects))
+				if projects[i].ManagerID == oldVals.ID {
+					return common.NewError(http.StatusBadRequest, common.ErrManagerStillLeadsProjects)
 				}
 			}
 		}
+		updatedVals.Role = requestValues.Role
+	}
+
+	if requestValues.OrganizationID != oldVals.OrganizationID {
+		if err := policy.Authorize(current, policy.UserSetOrganization, policy.Resource{}); err != nil {
+			return common.NewError(http.StatusForbidden, err)
+		}
+		if _, err := c.OrganizationDao.ReadByID(requestValues.OrganizationID); access.IsNotFound(err) {
+			return common.NewError(http.StatusBadRequest, common.ErrOrganizationDoesNotExist)
+		} else if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
+		updatedVals.OrganizationID = requestValues.OrganizationID
 	}
 
-	newVals, err := c.UserDao.Update(updatedVals, pathID)
+	newVals, err := store(updatedVals, oldVals.ID, auditEntry(current, policy.UserUpdate))
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, common.CreateError(err))
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	// tokens of user still carry the old role
+	if roleChanged {
+		err = c.SessionDao.RevokeAllOfUser(oldVals.ID)
+		if err != nil {
+			return common.NewError(http.StatusInternalServerError, err)
+		}
 	}
-	newVals.Password = ""
 
-	return ctx.JSON(http.StatusOK, newVals)
+	return c.sendUser(ctx, current, newVals)
 }
 
 // ChangePasswordByID will change user's password if he sends correct old password
 func (c *UserController) ChangePasswordByID(ctx echo.Context) error{
 	pathIDuint64, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(common.ErrIdInPathWrongFormat))
+		return common.NewError(http.StatusBadRequest, common.ErrIdInPathWrongFormat)
 	}
 	pathID := uint(pathIDuint64)
-	jwtID, _, err := common.GetUserIdAndRoleFromToken(ctx.Get("user").(*jwt.Token))
+	current, err := common.GetCurrentUser(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
-	user, err := c.UserDao.ReadByID(pathID)
+	user, err := c.UserDao.ReadVisibleByID(current, pathID)
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, common.CreateError(err))
+		return readError(err)
 	}
 
-	if user.ID != jwtID {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrUnsufficientPrivileges))
+	if err := policy.Authorize(current, policy.UserChangePassword, userResource(user)); err != nil {
+		return common.NewError(http.StatusForbidden, err)
 	}
 
 	req := ChangePasswordRequest{}
 	err = common.BindAndValid(ctx, &req)
 	if err != nil {
-		return ctx.JSON(http.StatusNotFound, common.CreateError(err))
+		return common.NewError(http.StatusBadRequest, err)
 	}
 
-	if req.OldPassword != user.Password {
-		return ctx.JSON(http.StatusUnauthorized, common.CreateError(common.ErrWrongPassword))
+	if match, _ := common.CheckPassword(user.Password, req.OldPassword); !match {
+		return common.NewError(http.StatusUnauthorized, common.ErrWrongPassword)
 	}
 
-	user.Password = req.NewPassword
+	hash, err := common.HashPassword(req.NewPassword)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
 
-	user, err = c.UserDao.Update(user, pathID)
+	_, err = c.UserDao.Update(&models.User{Password: hash}, pathID, auditEntry(current, policy.UserChangePassword))
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
+	// log out all sessions, including the current one
+	err = c.SessionDao.RevokeAllOfUser(pathID)
+	if err != nil {
+		return common.NewError(http.StatusInternalServerError, err)
+	}
 
 	return ctx.NoContent(http.StatusOK)
 }
+
+// userResource will return resource of policy that is user given by parameter
+func userResource(user *models.User) policy.Resource {
+	return policy.Resource{
+		OwnerID: user.ID,
+		Role: user.Role,
+		OrganizationID: user.OrganizationID,
+	}
+}
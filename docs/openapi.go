@@ -0,0 +1,594 @@
+package docs
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/wscherfel/fitlogic-backend/access"
+	"github.com/wscherfel/fitlogic-backend/common"
+)
+
+// Version of OpenAPI the document is written in
+const Version = "3.0.3"
+
+// Param is a query parameter of an operation
+type Param struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// Operation describes an endpoint in the table of operations, route table
+// gives its method, path and handler, types of request and response are
+// described by reflection of values given here
+type Operation struct {
+	// name of operation, by default it is given by name of handler method
+	ID      string
+	Summary string
+	// endpoint does not use JWT authentication
+	Public bool
+	// zero value of type of body of request, nil when there is no body
+	Request interface{}
+	// zero value of type of response, nil when only status is sent
+	Response interface{}
+	Query    []Param
+	// query parameters of list endpoint, it is paged and sends X-Total-Count
+	List *access.ListParams
+	// request and response contain dates formatted by dateFormat
+	Dates bool
+	// response is not JSON, e.g. page of docs
+	ContentType string
+	// request is JSON merge patch of Request, its members can be left out
+	Patch bool
+	// response has ETag of version of record, request is conditioned by
+	// If-None-Match when it is GET and by If-Match otherwise
+	Versioned bool
+}
+
+// Operations maps "METHOD path" of routes, as registered in echo,
+// to their operations
+type Operations map[string]Operation
+
+// Key will return key of route in Operations
+func Key(method string, path string) string {
+	return method + " " + path
+}
+
+// Document is OpenAPI document of the API
+type Document struct {
+	OpenAPI    string                               `json:"openapi"`
+	Info       Info                                 `json:"info"`
+	Paths      map[string]map[string]*SpecOperation `json:"paths"`
+	Components Components                           `json:"components"`
+}
+
+// Info is a description of the API
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Components are schemas of types and security schemes referenced
+// by operations
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+// SecurityScheme is a way of authentication
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// SpecOperation is an operation in OpenAPI document
+type SpecOperation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+// Parameter is a path, query or header parameter of operation
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is a body of request of operation
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// Response is a response of operation
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// Header is a header of response
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType is a content of request or response
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema describes a JSON value, empty schema allows any value
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+// ErrUndocumentedRoutes is returned when route table and table
+// of operations differ
+var ErrUndocumentedRoutes = errors.New("routes and documented operations differ")
+
+// RouteError lists routes without operation and operations without route
+type RouteError struct {
+	Undocumented []string
+	Unrouted     []string
+}
+
+func (e *RouteError) Error() string {
+	msg := ErrUndocumentedRoutes.Error()
+	if len(e.Undocumented) > 0 {
+		msg += ", routes without operation: " + strings.Join(e.Undocumented, ", ")
+	}
+	if len(e.Unrouted) > 0 {
+		msg += ", operations without route: " + strings.Join(e.Unrouted, ", ")
+	}
+	return msg
+}
+
+// Check will return *RouteError when a route has no operation or an
+// operation has no route, catch-all routes added by echo to groups
+// are left out
+func Check(routes []*echo.Route, operations Operations) error {
+	routeErr := &RouteError{}
+	routed := map[string]bool{}
+	for _, route := range apiRoutes(routes) {
+		key := Key(route.Method, route.Path)
+		routed[key] = true
+		if _, ok := operations[key]; !ok {
+			routeErr.Undocumented = append(routeErr.Undocumented, key)
+		}
+	}
+	for key := range operations {
+		if !routed[key] {
+			routeErr.Unrouted = append(routeErr.Unrouted, key)
+		}
+	}
+	if len(routeErr.Undocumented)+len(routeErr.Unrouted) > 0 {
+		sort.Strings(routeErr.Undocumented)
+		sort.Strings(routeErr.Unrouted)
+		return routeErr
+	}
+
+	return nil
+}
+
+// apiRoutes will return routes of handlers ordered by path and method,
+// echo adds catch-all routes of its own to groups with middleware
+func apiRoutes(routes []*echo.Route) []*echo.Route {
+	api := []*echo.Route{}
+	for _, route := range routes {
+		if strings.HasPrefix(route.Name, "github.com/labstack/echo.") {
+			continue
+		}
+		api = append(api, route)
+	}
+	sort.Slice(api, func(i, j int) bool {
+		if api[i].Path != api[j].Path {
+			return api[i].Path < api[j].Path
+		}
+		return api[i].Method < api[j].Method
+	})
+	return api
+}
+
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+// handlerName matches name of method value of controller,
+// e.g. github.com/.../controllers.(*UserController).Create-fm
+var handlerName = regexp.MustCompile(`\.\(\*(\w+)\)\.(\w+)(-fm)?$`)
+
+// NewDocument will create OpenAPI document of routes described by
+// operations, it fails when they differ
+func NewDocument(routes []*echo.Route, operations Operations) (*Document, error) {
+	if err := Check(routes, operations); err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:   "FitLogic API",
+			Version: "1",
+		},
+		Paths: map[string]map[string]*SpecOperation{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]*SecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+	schemas := &schemaBuilder{components: doc.Components.Schemas, names: map[string]reflect.Type{}}
+	errorSchema := schemas.schemaOf(reflect.TypeOf(common.Error{}))
+
+	for _, route := range apiRoutes(routes) {
+		op := operations[Key(route.Method, route.Path)]
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*SpecOperation{}
+		}
+
+		operationID := op.ID
+		if match := handlerName.FindStringSubmatch(route.Name); operationID == "" && match != nil {
+			operationID = match[1] + "." + match[2]
+		}
+		if operationID == "" {
+			operationID = route.Name
+		}
+		o := &SpecOperation{
+			OperationID: operationID,
+			Summary:     op.Summary,
+			Tags:        []string{tagOf(route.Path)},
+			Responses:   map[string]*Response{},
+			Security:    []map[string][]string{{"bearerAuth": {}}},
+		}
+		if op.Public {
+			o.Security = []map[string][]string{}
+		}
+
+		for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			o.Parameters = append(o.Parameters, &Parameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "integer", Minimum: zero()},
+			})
+		}
+		for _, param := range op.Query {
+			o.Parameters = append(o.Parameters, queryParameter(param))
+		}
+		if op.List != nil {
+			o.Parameters = append(o.Parameters, listParameters(*op.List)...)
+		}
+		if op.Dates {
+			o.Parameters = append(o.Parameters, &Parameter{
+				Name:        common.DateFormatParam,
+				In:          "query",
+				Description: "format of dates: rfc3339 (default), date or config",
+				Schema: &Schema{
+					Type: "string",
+					Enum: []string{common.DateFormatRFC3339, common.DateFormatDate, common.DateFormatConfig},
+				},
+			})
+		}
+
+		if op.Versioned {
+			o.Parameters = append(o.Parameters, versionParameter(route.Method))
+		}
+
+		if op.Request != nil {
+			o.RequestBody = &RequestBody{
+				Required: true,
+				Content: map[string]*MediaType{
+					echo.MIMEApplicationJSON: {Schema: schemas.schemaOf(reflect.TypeOf(op.Request))},
+				},
+			}
+			if op.Patch {
+				o.RequestBody.Description = "JSON merge patch (RFC 7396), members left out are kept and null clears them"
+				o.RequestBody.Content = map[string]*MediaType{
+					common.MIMEMergePatch: o.RequestBody.Content[echo.MIMEApplicationJSON],
+				}
+			}
+		}
+
+		ok := &Response{Description: "OK"}
+		switch {
+		case op.ContentType != "":
+			ok.Content = map[string]*MediaType{op.ContentType: {Schema: &Schema{Type: "string"}}}
+		case op.Response != nil:
+			ok.Content = map[string]*MediaType{
+				echo.MIMEApplicationJSON: {Schema: schemas.schemaOf(reflect.TypeOf(op.Response))},
+			}
+		}
+		if op.List != nil {
+			ok.Headers = map[string]*Header{
+				common.TotalCountHeader: {
+					Description: "number of all records matching filters",
+					Schema:      &Schema{Type: "integer"},
+				},
+			}
+		}
+		if op.Versioned && op.Response != nil {
+			if ok.Headers == nil {
+				ok.Headers = map[string]*Header{}
+			}
+			ok.Headers[common.HeaderETag] = &Header{
+				Description: "version of record and digest of body",
+				Schema:      &Schema{Type: "string"},
+			}
+		}
+		o.Responses["200"] = ok
+		if op.Versioned && route.Method == http.MethodGet {
+			o.Responses["304"] = &Response{Description: "Not Modified, If-None-Match has ETag of current body"}
+		} else if op.Versioned {
+			o.Responses["412"] = &Response{
+				Description: "Precondition Failed, record was changed since ETag in If-Match was read",
+				Content:     map[string]*MediaType{echo.MIMEApplicationJSON: {Schema: errorSchema}},
+			}
+		}
+		o.Responses["default"] = &Response{
+			Description: "error",
+			Content:     map[string]*MediaType{echo.MIMEApplicationJSON: {Schema: errorSchema}},
+		}
+
+		doc.Paths[path][strings.ToLower(route.Method)] = o
+	}
+
+	return doc, nil
+}
+
+// versionParameter will return header conditioning request of method
+// given by parameter on version of record
+func versionParameter(method string) *Parameter {
+	if method == http.MethodGet {
+		return &Parameter{
+			Name:        common.HeaderIfNoneMatch,
+			In:          "header",
+			Description: "ETag of cached body, 304 is sent when it is current",
+			Schema:      &Schema{Type: "string"},
+		}
+	}
+	return &Parameter{
+		Name:        common.HeaderIfMatch,
+		In:          "header",
+		Description: "ETag of read record, 412 is sent when the record was changed since",
+		Schema:      &Schema{Type: "string"},
+	}
+}
+
+// tagOf will return tag of path, it is its first segment
+func tagOf(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	return segments[0]
+}
+
+func zero() *float64 {
+	min := 0.0
+	return &min
+}
+
+// queryParameter will return parameter in query of type given by param
+func queryParameter(param Param) *Parameter {
+	return &Parameter{
+		Name:        param.Name,
+		In:          "query",
+		Description: param.Description,
+		Required:    param.Required,
+		Schema:      typeSchema(param.Type),
+	}
+}
+
+// typeSchema will return schema of type of parameter, date-time
+// is a string in RFC 3339
+func typeSchema(t string) *Schema {
+	if t == "date-time" {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	return &Schema{Type: t}
+}
+
+// listParameters will return paging, sorting and filters of list endpoint
+func listParameters(list access.ListParams) []*Parameter {
+	params := []*Parameter{
+		{
+			Name:        "limit",
+			In:          "query",
+			Description: "size of page",
+			Schema:      &Schema{Type: "integer", Minimum: zero()},
+		},
+		{
+			Name:        "offset",
+			In:          "query",
+			Description: "number of skipped records",
+			Schema:      &Schema{Type: "integer", Minimum: zero()},
+		},
+		{
+			Name:        "sort",
+			In:          "query",
+			Description: "comma separated fields, prefix - sorts descending: " + strings.Join(list.Sorts, ", "),
+			Schema:      &Schema{Type: "string"},
+		},
+	}
+
+	names := []string{}
+	for name := range list.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		params = append(params, queryParameter(Param{
+			Name:        name,
+			Type:        list.Filters[name],
+			Description: "filter",
+		}))
+	}
+
+	return params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaBuilder creates schemas of types, named structs are added
+// to components and referenced
+type schemaBuilder struct {
+	components map[string]*Schema
+	names      map[string]reflect.Type
+}
+
+// schemaOf will return schema of type t
+func (b *schemaBuilder) schemaOf(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schemaOf(t.Elem())
+		if s.Ref != "" {
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: zero()}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + b.component(t)}
+	}
+
+	// interfaces hold any value
+	return &Schema{}
+}
+
+// component will add schema of named struct to components and return
+// its name, names used by types of other packages get package prefix
+func (b *schemaBuilder) component(t reflect.Type) string {
+	name := t.Name()
+	if other, ok := b.names[name]; ok && other != t {
+		name = strings.Title(pathBase(t.PkgPath())) + name
+	}
+	if _, ok := b.names[name]; ok {
+		return name
+	}
+
+	b.names[name] = t
+	// placeholder stops recursion of types referencing themselves
+	b.components[name] = &Schema{}
+	*b.components[name] = *b.structSchema(t)
+	return name
+}
+
+func pathBase(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// structSchema will return object schema of struct, fields are named like
+// encoding/json names them and fields of embedded structs are flattened.
+// Validator tags give required fields, formats and enums, tag doc gives
+// description of field and readonly:"true" marks fields set only by server
+func (b *schemaBuilder) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	b.addFields(s, t)
+	sort.Strings(s.Required)
+	return s
+}
+
+func (b *schemaBuilder) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, skip := jsonName(field)
+		if skip {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.addFields(s, ft)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := b.schemaOf(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("valid"), ",") {
+			switch {
+			case rule == "required":
+				s.Required = append(s.Required, name)
+			case rule == "email":
+				property.Format = "email"
+			case strings.HasPrefix(rule, "in(") && strings.HasSuffix(rule, ")"):
+				property.Enum = strings.Split(strings.TrimSuffix(strings.TrimPrefix(rule, "in("), ")"), "|")
+			}
+		}
+		if property.Ref == "" {
+			property.Description = field.Tag.Get("doc")
+			property.ReadOnly = field.Tag.Get("readonly") == "true"
+		}
+		s.Properties[name] = property
+	}
+}
+
+// jsonName will return name of field given by json tag, empty when
+// tag does not name it, and whether field is left out of JSON
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	return strings.Split(tag, ",")[0], false
+}
+
+// SpecHandler will return handler sending OpenAPI document, document can
+// be filled after routes are registered
+func SpecHandler(doc *Document) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.JSON(http.StatusOK, doc)
+	}
+}
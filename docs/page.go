@@ -0,0 +1,181 @@
+package docs
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// PageHandler will return handler sending page that reads OpenAPI document
+// from specURL, lists operations and sends requests to them. The page has
+// no dependencies, so it works without access to the internet
+func PageHandler(specURL string) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.HTML(http.StatusOK, page(specURL))
+	}
+}
+
+func page(specURL string) string {
+	return `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>FitLogic API</title>
+<style>
+body { font-family: sans-serif; margin: 2em; max-width: 70em; }
+details { border: 1px solid #ccc; border-radius: 4px; margin: .5em 0; padding: .5em; }
+summary { cursor: pointer; }
+.method { display: inline-block; width: 5em; font-weight: bold; }
+.get { color: #1a7f37; } .post { color: #0969da; } .put { color: #9a6700; } .patch { color: #8250df; } .delete { color: #cf222e; }
+pre { background: #f6f8fa; padding: .5em; overflow: auto; }
+textarea { width: 100%; height: 8em; font-family: monospace; }
+input[type=text] { width: 30em; }
+</style>
+</head>
+<body>
+<h1>FitLogic API</h1>
+<p>Machine readable document: <a href="` + specURL + `">` + specURL + `</a></p>
+<p><label>Access token <input type="text" id="token" placeholder="JWT from POST /login"></label></p>
+<div id="operations"></div>
+<script>
+var spec;
+
+function resolve(schema) {
+	if (schema && schema.$ref) {
+		return spec.components.schemas[schema.$ref.split("/").pop()];
+	}
+	return schema;
+}
+
+function example(schema, depth) {
+	schema = resolve(schema) || {};
+	if (depth > 4) {
+		return null;
+	}
+	if (schema.enum) {
+		return schema.enum[0];
+	}
+	switch (schema.type) {
+	case "object":
+		var obj = {};
+		for (var name in schema.properties || {}) {
+			obj[name] = example(schema.properties[name], depth + 1);
+		}
+		return obj;
+	case "array":
+		return [];
+	case "integer":
+	case "number":
+		return 0;
+	case "boolean":
+		return false;
+	case "string":
+		return schema.format === "date-time" ? new Date().toISOString() : "";
+	}
+	return null;
+}
+
+function el(tag, attrs, text) {
+	var e = document.createElement(tag);
+	for (var name in attrs || {}) {
+		e.setAttribute(name, attrs[name]);
+	}
+	if (text !== undefined) {
+		e.textContent = text;
+	}
+	return e;
+}
+
+function operation(path, method, op) {
+	var details = el("details");
+	var summary = el("summary");
+	summary.appendChild(el("span", {"class": "method " + method}, method.toUpperCase()));
+	summary.appendChild(document.createTextNode(path + "  " + (op.summary || "")));
+	details.appendChild(summary);
+
+	var inputs = {};
+	(op.parameters || []).forEach(function (p) {
+		var label = el("label", {}, p.name + " (" + p.in + (p.required ? ", required" : "") + ") ");
+		var input = el("input", {type: "text", title: p.description || ""});
+		inputs[p.name] = {param: p, input: input};
+		label.appendChild(input);
+		details.appendChild(el("div")).appendChild(label);
+	});
+
+	var body, contentType = "application/json";
+	if (op.requestBody) {
+		contentType = Object.keys(op.requestBody.content)[0];
+		var schema = op.requestBody.content[contentType].schema;
+		body = el("textarea");
+		body.value = JSON.stringify(example(schema, 0), null, 2);
+		details.appendChild(el("div", {}, "Body")).appendChild(body);
+	}
+
+	var send = el("button", {}, "Send");
+	var output = el("pre");
+	send.onclick = function () {
+		var url = path;
+		var query = [];
+		var headers = {"Content-Type": contentType};
+		for (var name in inputs) {
+			var value = inputs[name].input.value;
+			if (inputs[name].param.in === "path") {
+				url = url.replace("{" + name + "}", encodeURIComponent(value));
+			} else if (inputs[name].param.in === "header") {
+				if (value !== "") {
+					headers[name] = value;
+				}
+			} else if (value !== "") {
+				query.push(encodeURIComponent(name) + "=" + encodeURIComponent(value));
+			}
+		}
+		if (query.length) {
+			url += "?" + query.join("&");
+		}
+		var token = document.getElementById("token").value;
+		if (token) {
+			headers["Authorization"] = "Bearer " + token;
+		}
+		fetch(url, {method: method.toUpperCase(), headers: headers, body: body ? body.value : undefined})
+			.then(function (res) {
+				return res.text().then(function (text) {
+					try {
+						text = JSON.stringify(JSON.parse(text), null, 2);
+					} catch (e) {}
+					var etag = res.headers.get("ETag");
+					output.textContent = res.status + " " + res.statusText + (etag ? "\nETag: " + etag : "") + "\n\n" + text;
+				});
+			})
+			.catch(function (err) {
+				output.textContent = err;
+			});
+	};
+	details.appendChild(send);
+	details.appendChild(output);
+	return details;
+}
+
+fetch("` + specURL + `")
+	.then(function (res) { return res.json(); })
+	.then(function (doc) {
+		spec = doc;
+		var container = document.getElementById("operations");
+		var tags = {};
+		Object.keys(spec.paths).sort().forEach(function (path) {
+			Object.keys(spec.paths[path]).forEach(function (method) {
+				var op = spec.paths[path][method];
+				var tag = op.tags[0];
+				if (!tags[tag]) {
+					tags[tag] = el("section");
+					tags[tag].appendChild(el("h2", {}, tag));
+					container.appendChild(tags[tag]);
+				}
+				tags[tag].appendChild(operation(path, method, op));
+			});
+		});
+	});
+</script>
+</body>
+</html>
+`
+}
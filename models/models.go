@@ -1,14 +1,21 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"github.com/jinzhu/gorm"
+	"time"
 )
 
-// constants for Roles and levels of Impact
+// constants for Roles and levels of Impact, super-admin manages all
+// organizations, admin only his own. Roles start at 1, so zero is
+// a role that was not set
 const (
-	RoleAdmin = 1
-	RoleManager = 2
-	RoleUser = 3
+	RoleSuperAdmin = 1
+	RoleAdmin = 2
+	RoleManager = 3
+	RoleUser = 4
 
 	ImpactInsignificant = 0.05
 	ImpactSmall = 0.1
@@ -17,17 +24,119 @@ const (
 	ImpactExtraordinary = 0.8
 )
 
+// Impacts is the scale of levels of Impact a risk can have
+var Impacts = []float64{
+	ImpactInsignificant,
+	ImpactSmall,
+	ImpactMedium,
+	ImpactBig,
+	ImpactExtraordinary,
+}
+
+// IsValidRole will return whether role is one of roles from super-admin
+// to user
+func IsValidRole(role int) bool {
+	return role >= RoleSuperAdmin && role <= RoleUser
+}
+
+// IsValidImpact will return whether impact is one of levels on Impacts scale
+func IsValidImpact(impact float64) bool {
+	for _, level := range Impacts {
+		if impact == level {
+			return true
+		}
+	}
+	return false
+}
+
+// constants for roles of users in projects
+const (
+	ProjectRoleOwner = "owner"
+	ProjectRoleEditor = "editor"
+	ProjectRoleViewer = "viewer"
+)
+
+// ProjectRoleRank will return rank of role in project, higher rank has more
+// privileges, unknown role has rank 0
+func ProjectRoleRank(role string) int {
+	switch role {
+	case ProjectRoleOwner:
+		return 3
+	case ProjectRoleEditor:
+		return 2
+	case ProjectRoleViewer:
+		return 1
+	}
+	return 0
+}
+
+// constants for statuses in lifecycle of a risk
+const (
+	RiskStatusIdentified = "identified"
+	RiskStatusAnalysed = "analysed"
+	RiskStatusMitigating = "mitigating"
+	RiskStatusMonitored = "monitored"
+	RiskStatusClosed = "closed"
+	RiskStatusOccurred = "occurred"
+)
+
+// RiskTransitions maps status of a risk to statuses it can move to,
+// closed is the final status
+var RiskTransitions = map[string][]string{
+	RiskStatusIdentified: {RiskStatusAnalysed, RiskStatusClosed},
+	RiskStatusAnalysed: {RiskStatusMitigating, RiskStatusMonitored, RiskStatusClosed},
+	RiskStatusMitigating: {RiskStatusMonitored, RiskStatusOccurred, RiskStatusClosed},
+	RiskStatusMonitored: {RiskStatusMitigating, RiskStatusOccurred, RiskStatusClosed},
+	RiskStatusOccurred: {RiskStatusClosed},
+	RiskStatusClosed: {},
+}
+
+// IsValidRiskStatus will return whether status is in lifecycle of a risk
+func IsValidRiskStatus(status string) bool {
+	_, ok := RiskTransitions[status]
+	return ok
+}
+
+// CanTransitionRisk will return whether risk can move from status to status
+func CanTransitionRisk(from, to string) bool {
+	for _, status := range RiskTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultOrganizationName is a name of organization that owns records
+// created before there were organizations
+const DefaultOrganizationName = "Default"
+
+// @dao
+// Organization is a DB model of a client company, it owns its users,
+// projects and risks, name is unique in DB
+type Organization struct {
+	gorm.Model
+
+	Name string `valid:"required" gorm:"unique"`
+	Description string
+}
+
 // @dao
 // User is a DB model of a user, email is unique in DB
 type User struct {
 	gorm.Model
 
+	OrganizationID uint `gorm:"index"`
+
 	Name string `valid:"required"`
 	Email string `valid:"email,required" gorm:"unique"`
 	Password string `valid:"required" json:",omitempty"`
 	Role int `valid:"required"`
 	Skills string
 	Status string
+	// Version is incremented by every update of the record, it is
+	// sent in ETag and checked against If-Match
+	Version uint `gorm:"not null;default:1"`
 
 	Projects []Project `gorm:"many2many:user_projects;" json:",omitempty"`
 
@@ -35,25 +144,46 @@ type User struct {
 }
 
 // @dao
-// Project is a DB model of a Project, name is unique in DB
+// Project is a DB model of a Project, name is unique in organization
 type Project struct {
 	gorm.Model
 
-	Start string
-	End string
+	Start time.Time
+	End time.Time
 	IsFinished bool
 	ManagerID uint
 
-	Name string `gorm:"unique"`
+	OrganizationID uint `gorm:"unique_index:idx_projects_organization_name"`
+	Name string `gorm:"unique_index:idx_projects_organization_name"`
 	Description string
+	// Version is incremented by every update of the record, it is
+	// sent in ETag and checked against If-Match
+	Version uint `gorm:"not null;default:1"`
 
 	Users []User `gorm:"many2many:user_projects;" json:",omitempty"`
+	Memberships []Membership `json:",omitempty"`
 
 	Risks []Risk `gorm:"many2many:risk_projects;" json:",omitempty"`
 }
 
 // @dao
-// Risk is a DB model of a Risk, name is unique in DB
+// Membership is a DB model of user's membership in a project with his role
+// in it, it is the join table of many2many association of users and projects
+type Membership struct {
+	UserID uint `gorm:"primary_key;auto_increment:false"`
+	ProjectID uint `gorm:"primary_key;auto_increment:false"`
+	Role string
+	CreatedAt time.Time
+}
+
+// TableName will return name of the join table
+func (Membership) TableName() string {
+	return "user_projects"
+}
+
+// @dao
+// Risk is a DB model of a Risk, name is unique in organization.
+// Risk and Exposure are computed from the other fields by ComputeScores
 type Risk struct {
 	gorm.Model
 
@@ -61,38 +191,192 @@ type Risk struct {
 	Cost int
 	Probability float64
 	Risk float64
+	Exposure float64
 
-	Name string `gorm:"unique"`
+	OrganizationID uint `gorm:"unique_index:idx_risks_organization_name"`
+	Name string `gorm:"unique_index:idx_risks_organization_name"`
 	Description string
 	Category string
 	Threat string
 	Status string
+	// reason of the last transition, required for closing
+	StatusReason string
 	Trigger string
 	Impact float64
 
-	Start string
-	End string
+	Start time.Time
+	End time.Time
 
 	UserID uint
+	// Version is incremented by every update of the record, it is
+	// sent in ETag and checked against If-Match
+	Version uint `gorm:"not null;default:1"`
 
 	Projects []Project `gorm:"many2many:risk_projects;" json:",omitempty"`
 
-	CounterMeasureUsed bool
-	CounterMeasureCost int
-	CounterMeasureDesc string
+	CounterMeasures []CounterMeasure `gorm:"many2many:risk_counter_measures;" json:",omitempty"`
 }
 
-// dao.db.Model(&m).Association("CounterMeasures").Find(&retVal)
+// ComputeScores will compute risk score as probability times impact
+// and expected monetary exposure as probability times value of risk,
+// cost is used instead of value when it is not set
+func (r *Risk) ComputeScores() {
+	r.Risk = r.Probability * r.Impact
+	amount := r.Value
+	if amount == 0 {
+		amount = float64(r.Cost)
+	}
+	r.Exposure = r.Probability * amount
+}
 
-// CounterMeasure is a DB model of a countermeasure to risk,
-// currently deprecated
+// CounterMeasure is a DB model of a countermeasure to risk, one countermeasure
+// can be used for many risks of its organization
 // @dao
 type CounterMeasure struct {
 	gorm.Model
 
-	Name string
+	OrganizationID uint `gorm:"index"`
+
+	Name string `valid:"required"`
 	Description string
 	Cost int
 
-	Risks []Risk `gorm:"many2many:risk_counter_measures;"`
+	Risks []Risk `gorm:"many2many:risk_counter_measures;" json:",omitempty"`
+}
+
+// @dao
+// Session is a DB model of a logged in session of a user. Access tokens carry
+// ID of the session, revoking session invalidates all of them. Refresh token
+// is stored only as a hash
+type Session struct {
+	gorm.Model
+
+	UserID uint
+	RefreshTokenHash string `gorm:"unique_index"`
+	ExpiresAt time.Time
+	Revoked bool
+}
+
+// RotatedRefreshToken is a hash of refresh token that was exchanged for
+// a new one. It is kept, so a token sent again is recognised as stolen
+// and its session is revoked
+type RotatedRefreshToken struct {
+	ID uint `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	SessionID uint `gorm:"index"`
+	Hash string `gorm:"unique_index"`
+}
+
+// constants for types of entities in audit log
+const (
+	EntityUser = "user"
+	EntityProject = "project"
+	EntityRisk = "risk"
+	EntityCounterMeasure = "cm"
+)
+
+// Change is a change of a single field in audit log, Old is empty
+// for created entities and New for deleted ones
+type Change struct {
+	Old interface{} `json:",omitempty"`
+	New interface{} `json:",omitempty"`
+}
+
+// Changes maps names of changed fields to their changes,
+// it is stored in DB as JSON
+type Changes map[string]Change
+
+// Value will encode changes to JSON stored in DB
+func (c Changes) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan will decode changes from JSON stored in DB
+func (c *Changes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = Changes{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case []byte:
+		return json.Unmarshal(v, c)
+	}
+	return fmt.Errorf("cannot scan %T into Changes", src)
+}
+
+// @dao
+// AuditEntry is a DB model of a record in audit log, entries are only
+// created, never updated or deleted
+type AuditEntry struct {
+	ID uint `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+
+	ActorID uint `gorm:"index"`
+	OrganizationID uint `gorm:"index"`
+	Action string
+	EntityType string `gorm:"index:idx_audit_entries_entity"`
+	EntityID uint `gorm:"index:idx_audit_entries_entity"`
+
+	Changes Changes `gorm:"type:text"`
+}
+
+// RiskData is a state of a risk stored in its version, it is stored
+// in DB as JSON
+type RiskData struct {
+	Risk
+}
+
+// Value will encode state of risk to JSON stored in DB
+func (d RiskData) Value() (driver.Value, error) {
+	b, err := json.Marshal(d.Risk)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan will decode state of risk from JSON stored in DB
+func (d *RiskData) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), &d.Risk)
+	case []byte:
+		return json.Unmarshal(v, &d.Risk)
+	}
+	return fmt.Errorf("cannot scan %T into RiskData", src)
+}
+
+// @dao
+// RiskVersion is a DB model of a state of a risk after it was created
+// or changed, versions of a risk are numbered from 1
+type RiskVersion struct {
+	ID uint `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+
+	RiskID uint `gorm:"unique_index:idx_risk_versions_risk_version"`
+	Version uint `gorm:"unique_index:idx_risk_versions_risk_version"`
+	ActorID uint
+
+	Data RiskData `gorm:"type:text"`
+}
+
+// SchemaVersion is a DB model of a migration that was applied to DB
+type SchemaVersion struct {
+	Version uint `gorm:"primary_key;auto_increment:false"`
+	Description string
+	AppliedAt time.Time
+}
+
+// TableName will return name of the table of applied migrations
+func (SchemaVersion) TableName() string {
+	return "schema_version"
 }
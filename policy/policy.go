@@ -0,0 +1,296 @@
+package policy
+
+import (
+	"github.com/wscherfel/fitlogic-backend/common"
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// Action is an operation logged user wants to perform on a resource
+type Action string
+
+// actions that are checked by controllers
+const (
+	OrganizationCreate Action = "organization:create"
+	OrganizationList   Action = "organization:list"
+	OrganizationRead   Action = "organization:read"
+	OrganizationUpdate Action = "organization:update"
+	OrganizationDelete Action = "organization:delete"
+
+	UserCreate          Action = "user:create"
+	UserList            Action = "user:list"
+	UserRead            Action = "user:read"
+	UserUpdate          Action = "user:update"
+	UserChangeRole      Action = "user:change-role"
+	UserChangePassword  Action = "user:change-password"
+	UserDelete          Action = "user:delete"
+	UserSetOrganization Action = "user:set-organization"
+
+	ProjectCreate          Action = "project:create"
+	ProjectCreateForOthers Action = "project:create-for-others"
+	ProjectList            Action = "project:list"
+	ProjectRead            Action = "project:read"
+	ProjectUpdate          Action = "project:update"
+	ProjectDelete          Action = "project:delete"
+	ProjectAssignUsers     Action = "project:assign-users"
+	ProjectAssignRisks     Action = "project:assign-risks"
+
+	RiskCreate          Action = "risk:create"
+	RiskCreateForOthers Action = "risk:create-for-others"
+	RiskList            Action = "risk:list"
+	RiskRead            Action = "risk:read"
+	RiskUpdate          Action = "risk:update"
+	RiskDelete          Action = "risk:delete"
+	RiskAssignCms       Action = "risk:assign-cms"
+	RiskTransition      Action = "risk:transition"
+	RiskClose           Action = "risk:close"
+	RiskRevert          Action = "risk:revert"
+
+	CmCreate Action = "cm:create"
+	CmList   Action = "cm:list"
+	CmRead   Action = "cm:read"
+	CmUpdate Action = "cm:update"
+	CmDelete Action = "cm:delete"
+
+	AuditRead Action = "audit:read"
+
+	Search Action = "search"
+)
+
+// Resource describes the object of an action, only fields relevant
+// to the action have to be filled
+type Resource struct {
+	// ID of user the resource is (for users) or who owns it (for risks)
+	OwnerID uint
+	// ID of manager of the project the resource is
+	ManagerID uint
+	// the most privileged role logged user has in the project (for projects)
+	// or in any project the resource is assigned to (for risks)
+	ProjectRole string
+	// role of the user the resource is (for users)
+	Role int
+	// ID of organization the resource is or belongs to
+	OrganizationID uint
+}
+
+// Condition has to hold for a rule to grant an action
+type Condition func(subject *models.User, res Resource) bool
+
+// Rule grants an action to users with Role or more privileged one,
+// if When is set it has to hold as well
+type Rule struct {
+	Role int
+	When Condition
+}
+
+// Policy maps actions to rules, action is allowed when any of its rules
+// grants it, actions without rules are denied
+type Policy map[Action][]Rule
+
+// IsOwner holds when logged user is the user or owner of the resource
+func IsOwner(subject *models.User, res Resource) bool {
+	return res.OwnerID != 0 && subject.ID == res.OwnerID
+}
+
+// IsProjectManager holds when logged user manages the project
+func IsProjectManager(subject *models.User, res Resource) bool {
+	return res.ManagerID != 0 && subject.ID == res.ManagerID
+}
+
+// IsNotMorePrivileged holds when the user the resource is does not have
+// more privileged role than logged user
+func IsNotMorePrivileged(subject *models.User, res Resource) bool {
+	return res.Role >= subject.Role
+}
+
+// InOrganization holds when the resource belongs to organization
+// of logged user
+func InOrganization(subject *models.User, res Resource) bool {
+	return res.OrganizationID != 0 && subject.OrganizationID == res.OrganizationID
+}
+
+// HasProjectRole returns condition that holds when logged user has at least
+// role given by parameter in the project
+func HasProjectRole(role string) Condition {
+	return func(subject *models.User, res Resource) bool {
+		return models.ProjectRoleRank(res.ProjectRole) >= models.ProjectRoleRank(role)
+	}
+}
+
+// All returns condition that holds when all conditions given by parameter hold
+func All(conditions ...Condition) Condition {
+	return func(subject *models.User, res Resource) bool {
+		for _, condition := range conditions {
+			if !condition(subject, res) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Default is the policy used by controllers. Rules of admins and managers
+// that do not depend on relation to the resource hold only in their
+// organization, only super-admin is granted actions on records of every
+// organization. Actions without a record (creating and listing) are not
+// limited here, DAOs create and list records of organization of logged user
+var Default = Policy{
+	OrganizationCreate: {{Role: models.RoleSuperAdmin}},
+	OrganizationList:   {{Role: models.RoleSuperAdmin}},
+	OrganizationRead:   {{Role: models.RoleSuperAdmin}, {Role: models.RoleUser, When: InOrganization}},
+	OrganizationUpdate: {{Role: models.RoleSuperAdmin}, {Role: models.RoleAdmin, When: InOrganization}},
+	OrganizationDelete: {{Role: models.RoleSuperAdmin}},
+
+	UserCreate: {{Role: models.RoleAdmin}},
+	UserList:   {{Role: models.RoleUser}},
+	UserRead: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleManager, When: InOrganization},
+		{Role: models.RoleUser, When: IsOwner},
+	},
+	// managers change only themselves, admins no super-admin
+	UserUpdate: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: All(InOrganization, IsNotMorePrivileged)},
+		{Role: models.RoleUser, When: IsOwner},
+	},
+	UserChangeRole: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: All(InOrganization, IsNotMorePrivileged)},
+	},
+	UserChangePassword: {{Role: models.RoleUser, When: IsOwner}},
+	UserDelete: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: All(InOrganization, IsNotMorePrivileged)},
+	},
+	UserSetOrganization: {{Role: models.RoleSuperAdmin}},
+
+	ProjectCreate:          {{Role: models.RoleManager}},
+	ProjectCreateForOthers: {{Role: models.RoleAdmin}},
+	ProjectList:            {{Role: models.RoleUser}},
+	ProjectRead: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleManager, When: IsProjectManager},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleViewer)},
+	},
+	ProjectUpdate: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleManager, When: IsProjectManager},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleOwner)},
+	},
+	ProjectDelete: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleManager, When: IsProjectManager},
+	},
+	ProjectAssignUsers: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleManager, When: IsProjectManager},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleOwner)},
+	},
+	ProjectAssignRisks: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleManager, When: IsProjectManager},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleEditor)},
+	},
+
+	RiskCreate:          {{Role: models.RoleUser}},
+	RiskCreateForOthers: {{Role: models.RoleAdmin}},
+	RiskList:            {{Role: models.RoleUser}},
+	RiskRead: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleUser, When: IsOwner},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleViewer)},
+	},
+	RiskUpdate: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleUser, When: IsOwner},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleEditor)},
+	},
+	RiskDelete: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleUser, When: IsOwner},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleOwner)},
+	},
+	RiskAssignCms: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleUser, When: IsOwner},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleEditor)},
+	},
+	RiskTransition: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleUser, When: IsOwner},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleEditor)},
+	},
+	RiskRevert: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleUser, When: IsOwner},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleEditor)},
+	},
+	// moving to closed or occurred, checked together with RiskTransition
+	RiskClose: {
+		{Role: models.RoleSuperAdmin},
+		{Role: models.RoleAdmin, When: InOrganization},
+		{Role: models.RoleUser, When: HasProjectRole(models.ProjectRoleOwner)},
+	},
+
+	CmCreate: {{Role: models.RoleUser}},
+	CmList:   {{Role: models.RoleUser}},
+	CmRead:   {{Role: models.RoleSuperAdmin}, {Role: models.RoleUser, When: InOrganization}},
+	CmUpdate: {{Role: models.RoleSuperAdmin}, {Role: models.RoleManager, When: InOrganization}},
+	CmDelete: {{Role: models.RoleSuperAdmin}, {Role: models.RoleManager, When: InOrganization}},
+
+	// managers see only entries of their projects
+	AuditRead: {{Role: models.RoleManager}},
+
+	// results are limited to records visible to logged user
+	Search: {{Role: models.RoleUser}},
+}
+
+// Can will return whether subject may perform action on resource
+func (p Policy) Can(subject *models.User, action Action, res Resource) bool {
+	if subject == nil {
+		return false
+	}
+
+	for _, rule := range p[action] {
+		// lower role is more privileged
+		if subject.Role > rule.Role {
+			continue
+		}
+		if rule.When == nil || rule.When(subject, res) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorize will return common.ErrUnsufficientPrivileges if subject may not
+// perform action on resource
+func (p Policy) Authorize(subject *models.User, action Action, res Resource) error {
+	if !p.Can(subject, action, res) {
+		return common.ErrUnsufficientPrivileges
+	}
+
+	return nil
+}
+
+// Can checks action against the Default policy
+func Can(subject *models.User, action Action, res Resource) bool {
+	return Default.Can(subject, action, res)
+}
+
+// Authorize checks action against the Default policy
+func Authorize(subject *models.User, action Action, res Resource) error {
+	return Default.Authorize(subject, action, res)
+}
@@ -0,0 +1,228 @@
+package policy
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/wscherfel/fitlogic-backend/models"
+)
+
+// subjects of cases, all of them are in organization 1 and IDs of records
+// they do not own or manage are 9
+var subjects = map[string]*models.User{
+	"superadmin": subject(1, models.RoleSuperAdmin),
+	"admin":      subject(2, models.RoleAdmin),
+	"manager":    subject(3, models.RoleManager),
+	"user":       subject(4, models.RoleUser),
+}
+
+// subject will return user of organization 1
+func subject(id uint, role int) *models.User {
+	user := &models.User{Role: role, OrganizationID: 1}
+	user.ID = id
+	return user
+}
+
+// resources of cases
+var (
+	noResource     = Resource{}
+	ownOrg         = Resource{OrganizationID: 1}
+	foreignOrg     = Resource{OrganizationID: 2}
+	userSelf       = Resource{OwnerID: 4, Role: models.RoleUser, OrganizationID: 1}
+	managerSelf    = Resource{OwnerID: 3, Role: models.RoleManager, OrganizationID: 1}
+	otherUser      = Resource{OwnerID: 9, Role: models.RoleUser, OrganizationID: 1}
+	otherAdmin     = Resource{OwnerID: 9, Role: models.RoleAdmin, OrganizationID: 1}
+	otherSuper     = Resource{OwnerID: 9, Role: models.RoleSuperAdmin, OrganizationID: 1}
+	foreignUser    = Resource{OwnerID: 9, Role: models.RoleUser, OrganizationID: 2}
+	managedProject = Resource{ManagerID: 3, OrganizationID: 1}
+	otherProject   = Resource{ManagerID: 9, OrganizationID: 1}
+	viewedProject  = Resource{ManagerID: 9, ProjectRole: models.ProjectRoleViewer, OrganizationID: 1}
+	editedProject  = Resource{ManagerID: 9, ProjectRole: models.ProjectRoleEditor, OrganizationID: 1}
+	ownedProject   = Resource{ManagerID: 9, ProjectRole: models.ProjectRoleOwner, OrganizationID: 1}
+	foreignProject = Resource{ManagerID: 9, OrganizationID: 2}
+	ownedRisk      = Resource{OwnerID: 4, OrganizationID: 1}
+	otherRisk      = Resource{OwnerID: 9, OrganizationID: 1}
+	viewedRisk     = Resource{OwnerID: 9, ProjectRole: models.ProjectRoleViewer, OrganizationID: 1}
+	editedRisk     = Resource{OwnerID: 9, ProjectRole: models.ProjectRoleEditor, OrganizationID: 1}
+	ownedRiskProj  = Resource{OwnerID: 9, ProjectRole: models.ProjectRoleOwner, OrganizationID: 1}
+	foreignRisk    = Resource{OwnerID: 9, OrganizationID: 2}
+)
+
+// allowed lists subjects that may perform action on resource
+func allowed(names ...string) []string {
+	sort.Strings(names)
+	return names
+}
+
+var (
+	everyone = allowed("superadmin", "admin", "manager", "user")
+	managers = allowed("superadmin", "admin", "manager")
+	admins   = allowed("superadmin", "admin")
+	super    = allowed("superadmin")
+	// user owns the resource
+	owner = allowed("superadmin", "admin", "user")
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	cases := []struct {
+		action Action
+		name   string
+		res    Resource
+		want   []string
+	}{
+		{OrganizationCreate, "none", noResource, super},
+		{OrganizationList, "none", noResource, super},
+		{OrganizationRead, "own", ownOrg, everyone},
+		{OrganizationRead, "foreign", foreignOrg, super},
+		{OrganizationUpdate, "own", ownOrg, admins},
+		{OrganizationUpdate, "foreign", foreignOrg, super},
+		{OrganizationDelete, "own", ownOrg, super},
+
+		{UserCreate, "none", noResource, admins},
+		{UserList, "none", noResource, everyone},
+		{UserRead, "self", userSelf, everyone},
+		{UserRead, "other", otherUser, managers},
+		{UserRead, "foreign", foreignUser, super},
+		{UserUpdate, "self", userSelf, owner},
+		{UserUpdate, "manager self", managerSelf, managers},
+		{UserUpdate, "other", otherUser, admins},
+		{UserUpdate, "super-admin", otherSuper, super},
+		{UserUpdate, "foreign", foreignUser, super},
+		{UserChangeRole, "self", userSelf, admins},
+		{UserChangeRole, "other", otherUser, admins},
+		{UserChangeRole, "admin", otherAdmin, admins},
+		{UserChangeRole, "super-admin", otherSuper, super},
+		{UserChangeRole, "foreign", foreignUser, super},
+		{UserChangePassword, "self", userSelf, allowed("user")},
+		{UserChangePassword, "other", otherUser, nil},
+		{UserDelete, "other", otherUser, admins},
+		{UserDelete, "super-admin", otherSuper, super},
+		{UserDelete, "foreign", foreignUser, super},
+		{UserSetOrganization, "none", noResource, super},
+
+		{ProjectCreate, "none", noResource, managers},
+		{ProjectCreateForOthers, "none", noResource, admins},
+		{ProjectList, "none", noResource, everyone},
+		{ProjectRead, "managed", managedProject, managers},
+		{ProjectRead, "other", otherProject, admins},
+		{ProjectRead, "viewer", viewedProject, everyone},
+		{ProjectRead, "foreign", foreignProject, super},
+		{ProjectUpdate, "managed", managedProject, managers},
+		{ProjectUpdate, "other", otherProject, admins},
+		{ProjectUpdate, "editor", editedProject, admins},
+		{ProjectUpdate, "owner", ownedProject, everyone},
+		{ProjectUpdate, "foreign", foreignProject, super},
+		{ProjectDelete, "managed", managedProject, managers},
+		{ProjectDelete, "owner", ownedProject, admins},
+		{ProjectDelete, "foreign", foreignProject, super},
+		{ProjectAssignUsers, "managed", managedProject, managers},
+		{ProjectAssignUsers, "editor", editedProject, admins},
+		{ProjectAssignUsers, "owner", ownedProject, everyone},
+		{ProjectAssignUsers, "foreign", foreignProject, super},
+		{ProjectAssignRisks, "managed", managedProject, managers},
+		{ProjectAssignRisks, "viewer", viewedProject, admins},
+		{ProjectAssignRisks, "editor", editedProject, everyone},
+		{ProjectAssignRisks, "foreign", foreignProject, super},
+
+		{RiskCreate, "none", noResource, everyone},
+		{RiskCreateForOthers, "none", noResource, admins},
+		{RiskList, "none", noResource, everyone},
+		{RiskRead, "owned", ownedRisk, owner},
+		{RiskRead, "other", otherRisk, admins},
+		{RiskRead, "viewer", viewedRisk, everyone},
+		{RiskRead, "foreign", foreignRisk, super},
+		{RiskUpdate, "owned", ownedRisk, owner},
+		{RiskUpdate, "viewer", viewedRisk, admins},
+		{RiskUpdate, "editor", editedRisk, everyone},
+		{RiskUpdate, "foreign", foreignRisk, super},
+		{RiskDelete, "owned", ownedRisk, owner},
+		{RiskDelete, "editor", editedRisk, admins},
+		{RiskDelete, "owner", ownedRiskProj, everyone},
+		{RiskDelete, "foreign", foreignRisk, super},
+		{RiskAssignCms, "owned", ownedRisk, owner},
+		{RiskAssignCms, "viewer", viewedRisk, admins},
+		{RiskAssignCms, "editor", editedRisk, everyone},
+		{RiskAssignCms, "foreign", foreignRisk, super},
+		{RiskTransition, "owned", ownedRisk, owner},
+		{RiskTransition, "viewer", viewedRisk, admins},
+		{RiskTransition, "editor", editedRisk, everyone},
+		{RiskTransition, "foreign", foreignRisk, super},
+		{RiskRevert, "owned", ownedRisk, owner},
+		{RiskRevert, "viewer", viewedRisk, admins},
+		{RiskRevert, "editor", editedRisk, everyone},
+		{RiskRevert, "foreign", foreignRisk, super},
+		{RiskClose, "owned", ownedRisk, admins},
+		{RiskClose, "editor", editedRisk, admins},
+		{RiskClose, "owner", ownedRiskProj, everyone},
+		{RiskClose, "foreign", foreignRisk, super},
+
+		{CmCreate, "none", noResource, everyone},
+		{CmList, "none", noResource, everyone},
+		{CmRead, "own", ownOrg, everyone},
+		{CmRead, "foreign", foreignOrg, super},
+		{CmUpdate, "own", ownOrg, managers},
+		{CmUpdate, "foreign", foreignOrg, super},
+		{CmDelete, "own", ownOrg, managers},
+		{CmDelete, "foreign", foreignOrg, super},
+
+		{AuditRead, "none", noResource, managers},
+		{Search, "none", noResource, everyone},
+	}
+
+	for _, c := range cases {
+		got := []string{}
+		for name, subject := range subjects {
+			if Can(subject, c.action, c.res) {
+				got = append(got, name)
+			}
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s on %s: expected %v, got %v", c.action, c.name, c.want, got)
+		}
+	}
+}
+
+// actions without a record, DAOs limit them to organization of logged user
+var recordless = map[Action]bool{
+	OrganizationCreate: true, OrganizationList: true,
+	UserCreate: true, UserList: true, UserSetOrganization: true,
+	ProjectCreate: true, ProjectCreateForOthers: true, ProjectList: true,
+	RiskCreate: true, RiskCreateForOthers: true, RiskList: true,
+	CmCreate: true, CmList: true,
+	AuditRead: true, Search: true,
+}
+
+func TestRulesOfRecordsHaveConditions(t *testing.T) {
+	for action, rules := range Default {
+		if recordless[action] {
+			continue
+		}
+		for _, rule := range rules {
+			if rule.Role > models.RoleSuperAdmin && rule.When == nil {
+				t.Errorf("%s is granted to role %d without condition", action, rule.Role)
+			}
+		}
+	}
+}
+
+func TestCanWithoutSubject(t *testing.T) {
+	if Can(nil, Search, noResource) {
+		t.Error("nil subject can search")
+	}
+	if err := Authorize(subjects["user"], OrganizationCreate, noResource); err == nil {
+		t.Error("user can create organization")
+	}
+}
+
+func TestAll(t *testing.T) {
+	subject := subjects["admin"]
+	cond := All(InOrganization, IsNotMorePrivileged)
+	if !cond(subject, otherUser) || cond(subject, otherSuper) || cond(subject, foreignUser) {
+		t.Error("All does not require every condition")
+	}
+	if !All()(subject, noResource) {
+		t.Error("All without conditions does not hold")
+	}
+}